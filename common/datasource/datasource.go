@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -20,11 +21,29 @@ var Config struct {
 	MaxIdle            int
 	MaxConn            int
 	MaxConnLifeTimeMin time.Duration
+
+	Replicas         []*Replica
+	ReplicaMaxLagSec time.Duration
+	ReplicaCheckSec  time.Duration
+
+	Shards          []*Shard
+	ShardRefreshSec time.Duration
+}
+
+//从库 单独连接池 未设置用户密码时沿用主库
+type Replica struct {
+	User     string
+	Password string
+	URL      string
+	MaxIdle  int
+	MaxConn  int
 }
 
 var conn *sql.DB
+var connectOnce sync.Once
 
 func GetConn() *sql.DB {
+	connect()
 	if conn == nil {
 		log.Fatalln("ds nil")
 	}
@@ -34,6 +53,25 @@ func GetConn() *sql.DB {
 func init() {
 	config.GetConfig("config.yaml", &Config)
 
+	if len(Config.Replicas) > 0 {
+		if Config.ReplicaMaxLagSec <= 0 {
+			Config.ReplicaMaxLagSec = 30
+		}
+		if Config.ReplicaCheckSec <= 0 {
+			Config.ReplicaCheckSec = 10
+		}
+	}
+	if len(Config.Shards) > 0 && Config.ShardRefreshSec <= 0 {
+		Config.ShardRefreshSec = 60
+	}
+}
+
+//首次取连接时连接数据库 不访问数据库的包及其测试无需数据库
+func connect() {
+	connectOnce.Do(open)
+}
+
+func open() {
 	dbURL := fmt.Sprintf("%s:%s@%s", Config.User, Config.Password, Config.URL)
 
 	fmt.Println(dbURL)
@@ -60,9 +98,16 @@ func init() {
 		}
 		conn = db
 	}
+
+	startReplicas()
+	startShards()
 }
 
 func Txn(txnFunc func(*sql.Tx), opts ...*sql.TxOptions) (e error) {
+	return runTxn(GetConn(), txnFunc, opts...)
+}
+
+func runTxn(db *sql.DB, txnFunc func(*sql.Tx), opts ...*sql.TxOptions) (e error) {
 
 	ctx, cancel := context.GetContext()
 	defer cancel()
@@ -72,7 +117,7 @@ func Txn(txnFunc func(*sql.Tx), opts ...*sql.TxOptions) (e error) {
 		opt = opts[0]
 	}
 
-	txn, err := GetConn().BeginTx(ctx, opt)
+	txn, err := db.BeginTx(ctx, opt)
 	if err != nil {
 		return err
 	}
@@ -89,8 +134,16 @@ func Txn(txnFunc func(*sql.Tx), opts ...*sql.TxOptions) (e error) {
 				log.Println("recover: ", err)
 				e = errors.New("事务失败")
 			}
+			popAfterCommit(txn)
 		} else {
-			txn.Commit()
+			if err := txn.Commit(); err != nil {
+				log.Println("error txn commit: ", err)
+				popAfterCommit(txn)
+				return
+			}
+			for _, f := range popAfterCommit(txn) {
+				f()
+			}
 		}
 	}()
 
@@ -98,3 +151,28 @@ func Txn(txnFunc func(*sql.Tx), opts ...*sql.TxOptions) (e error) {
 
 	return nil
 }
+
+var afterCommitLock sync.Mutex
+var afterCommits = make(map[*sql.Tx][]func())
+
+//事务提交后执行 回滚则不执行 txn为nil时立即执行
+func AfterCommit(txn *sql.Tx, f func()) {
+	if txn == nil {
+		f()
+		return
+	}
+
+	afterCommitLock.Lock()
+	defer afterCommitLock.Unlock()
+
+	afterCommits[txn] = append(afterCommits[txn], f)
+}
+
+func popAfterCommit(txn *sql.Tx) []func() {
+	afterCommitLock.Lock()
+	defer afterCommitLock.Unlock()
+
+	result := afterCommits[txn]
+	delete(afterCommits, txn)
+	return result
+}
@@ -0,0 +1,150 @@
+package datasource
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"obsessiontech/common/context"
+)
+
+type replicaConn struct {
+	url     string
+	db      *sql.DB
+	healthy int32
+}
+
+var replicas []*replicaConn
+var replicaIndex uint32
+
+func startReplicas() {
+	if len(Config.Replicas) == 0 {
+		return
+	}
+
+	for _, r := range Config.Replicas {
+		user, password := r.User, r.Password
+		if user == "" {
+			user, password = Config.User, Config.Password
+		}
+
+		//从库不可用时不影响启动 读取回退主库
+		db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@%s", user, password, r.URL))
+		if err != nil {
+			log.Println("error open replica: ", r.URL, err)
+			continue
+		}
+		if Config.MaxConnLifeTimeMin > 0 {
+			db.SetConnMaxLifetime(Config.MaxConnLifeTimeMin * time.Minute)
+		}
+		if r.MaxConn > 0 {
+			db.SetMaxOpenConns(r.MaxConn)
+		}
+		if r.MaxIdle > 0 {
+			db.SetMaxIdleConns(r.MaxIdle)
+		}
+
+		replicas = append(replicas, &replicaConn{url: r.URL, db: db})
+	}
+
+	if len(replicas) == 0 {
+		return
+	}
+
+	checkReplicas()
+
+	go func() {
+		ticker := time.NewTicker(Config.ReplicaCheckSec * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkReplicas()
+		}
+	}()
+}
+
+func checkReplicas() {
+	for _, r := range replicas {
+		lag, err := r.lag()
+		healthy := err == nil && lag <= Config.ReplicaMaxLagSec*time.Second
+		if !healthy {
+			log.Println("replica unavailable: ", r.url, lag, err)
+		}
+
+		var flag int32
+		if healthy {
+			flag = 1
+		}
+		if atomic.SwapInt32(&r.healthy, flag) != flag && healthy {
+			log.Println("replica available: ", r.url, lag)
+		}
+	}
+}
+
+var e_replication_stopped = errors.New("replication stopped")
+
+//MySQL 8.0.22起为SHOW REPLICA STATUS及Seconds_Behind_Source 旧版本回退 延迟为NULL时复制已中断
+func (r *replicaConn) lag() (time.Duration, error) {
+	ctx, cancel := context.GetContextWithDeadline(5 * time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		if rows, err = r.db.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if !rows.Next() {
+		//非复制从库 视为无延迟
+		return 0, rows.Err()
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, c := range columns {
+		if c == "Seconds_Behind_Source" || c == "Seconds_Behind_Master" {
+			if values[i] == nil {
+				return 0, e_replication_stopped
+			}
+			seconds, err := strconv.Atoi(string(values[i]))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(seconds) * time.Second, nil
+		}
+	}
+
+	return 0, nil
+}
+
+//只读连接 轮询延迟在允许范围内的从库 均不可用时返回主库
+func GetReadConn() *sql.DB {
+	connect()
+	if len(replicas) > 0 {
+		start := atomic.AddUint32(&replicaIndex, 1)
+		for i := 0; i < len(replicas); i++ {
+			r := replicas[(int(start)+i)%len(replicas)]
+			if atomic.LoadInt32(&r.healthy) == 1 {
+				return r.db
+			}
+		}
+	}
+	return GetConn()
+}
@@ -0,0 +1,254 @@
+package datasource
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+//分库 单独连接池 未设置用户密码时沿用主库
+type Shard struct {
+	Name     string
+	User     string
+	Password string
+	URL      string
+	MaxIdle  int
+	MaxConn  int
+}
+
+//站点与分库对应关系保存在主库 未记录的站点及公共站点c使用主库
+const (
+	SHARD_MAIN  = ""
+	SHARD_TABLE = "c_siteshard"
+	SITE_COMMON = "c"
+)
+
+var e_shard_not_exists = errors.New("分库不存在")
+
+var shardConns = make(map[string]*sql.DB)
+
+var siteShardLock sync.RWMutex
+var siteShards = make(map[string]string)
+
+func startShards() {
+	//未配置分库时仍检查对应关系 避免记录在分库的站点误用主库
+	if len(Config.Shards) == 0 {
+		if err := loadSiteShards(); err != nil {
+			log.Fatalln("error load site shards: ", err)
+		}
+		return
+	}
+
+	for _, s := range Config.Shards {
+		if s.Name == SHARD_MAIN {
+			log.Fatalln("shard name required: ", s.URL)
+		}
+		if _, exists := shardConns[s.Name]; exists {
+			log.Fatalln("duplicate shard name: ", s.Name)
+		}
+
+		user, password := s.User, s.Password
+		if user == "" {
+			user, password = Config.User, Config.Password
+		}
+
+		//分库保存站点全部数据 不可用时无法回退 启动失败
+		db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@%s", user, password, s.URL))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := db.Ping(); err != nil {
+			log.Fatal(err)
+		}
+		if Config.MaxConnLifeTimeMin > 0 {
+			db.SetConnMaxLifetime(Config.MaxConnLifeTimeMin * time.Minute)
+		}
+		if s.MaxConn > 0 {
+			db.SetMaxOpenConns(s.MaxConn)
+		}
+		if s.MaxIdle > 0 {
+			db.SetMaxIdleConns(s.MaxIdle)
+		}
+
+		shardConns[s.Name] = db
+	}
+
+	if _, err := conn.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			site_id VARCHAR(64) NOT NULL,
+			shard VARCHAR(64) NOT NULL,
+			update_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (site_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`, SHARD_TABLE)); err != nil {
+		log.Fatalln("error create site shard table: ", err)
+	}
+
+	if err := loadSiteShards(); err != nil {
+		log.Fatalln("error load site shards: ", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(Config.ShardRefreshSec * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := loadSiteShards(); err != nil {
+				log.Println("error reload site shards: ", err)
+			}
+		}
+	}()
+}
+
+//对应关系表不存在时视为无对应关系 对应到未配置分库时报错 刷新时保留原对应关系
+func loadSiteShards() error {
+	//连接过程中调用 不经GetConn
+	if exists, err := existsShardTable(); err != nil {
+		return err
+	} else if !exists {
+		return nil
+	}
+
+	rows, err := conn.Query(fmt.Sprintf(`
+		SELECT
+			site_id, shard
+		FROM
+			%s
+	`, SHARD_TABLE))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]string)
+	for rows.Next() {
+		var siteID, shard string
+		if err := rows.Scan(&siteID, &shard); err != nil {
+			return err
+		}
+		if _, exists := shardConns[shard]; !exists {
+			log.Println("error site shard not configured: ", siteID, shard)
+			return e_shard_not_exists
+		}
+		loaded[siteID] = shard
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	siteShardLock.Lock()
+	defer siteShardLock.Unlock()
+
+	siteShards = loaded
+
+	return nil
+}
+
+func existsShardTable() (bool, error) {
+	rows, err := conn.Query("SHOW TABLES LIKE ?", strings.ReplaceAll(SHARD_TABLE, "_", `\_`))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	exists := rows.Next()
+	return exists, rows.Err()
+}
+
+func GetSiteShard(siteID string) string {
+	connect()
+
+	siteShardLock.RLock()
+	defer siteShardLock.RUnlock()
+
+	return siteShards[siteID]
+}
+
+func GetShardConn(shard string) (*sql.DB, error) {
+	connect()
+
+	if shard == SHARD_MAIN {
+		return GetConn(), nil
+	}
+	db, exists := shardConns[shard]
+	if !exists {
+		return nil, e_shard_not_exists
+	}
+	return db, nil
+}
+
+//主库及全部分库 用于需遍历所有站点表的任务
+func GetShardConns() map[string]*sql.DB {
+	result := map[string]*sql.DB{SHARD_MAIN: GetConn()}
+	for name, db := range shardConns {
+		result[name] = db
+	}
+	return result
+}
+
+//站点表所在库的连接
+func GetSiteConn(siteID string) *sql.DB {
+	if shard := GetSiteShard(siteID); shard != SHARD_MAIN {
+		return shardConns[shard]
+	}
+	return GetConn()
+}
+
+//分库不设从库 位于分库的站点直接读取分库
+func GetSiteReadConn(siteID string) *sql.DB {
+	if shard := GetSiteShard(siteID); shard != SHARD_MAIN {
+		return shardConns[shard]
+	}
+	return GetReadConn()
+}
+
+//只读查询可走从库 读后写的路径须读主库 避免以滞后数据计算结果
+func GetSiteQueryConn(siteID string, readOnly bool) *sql.DB {
+	if readOnly {
+		return GetSiteReadConn(siteID)
+	}
+	return GetSiteConn(siteID)
+}
+
+//在站点表所在库开启事务 同一事务内只能访问该库的表
+func SiteTxn(siteID string, txnFunc func(*sql.Tx), opts ...*sql.TxOptions) error {
+	return runTxn(GetSiteConn(siteID), txnFunc, opts...)
+}
+
+//更新对应关系 本进程立即生效 其他进程在下次刷新后生效
+func SetSiteShard(siteID, shard string) error {
+	if _, err := GetShardConn(shard); err != nil {
+		return err
+	}
+
+	var err error
+	if shard == SHARD_MAIN {
+		_, err = GetConn().Exec(fmt.Sprintf("DELETE FROM %s WHERE site_id = ?", SHARD_TABLE), siteID)
+	} else {
+		_, err = GetConn().Exec(fmt.Sprintf(`
+			INSERT INTO %s
+				(site_id, shard)
+			VALUES
+				(?, ?)
+			ON DUPLICATE KEY UPDATE
+				shard = VALUES(shard)
+		`, SHARD_TABLE), siteID, shard)
+	}
+	if err != nil {
+		return err
+	}
+
+	siteShardLock.Lock()
+	defer siteShardLock.Unlock()
+
+	if shard == SHARD_MAIN {
+		delete(siteShards, siteID)
+	} else {
+		siteShards[siteID] = shard
+	}
+
+	return nil
+}
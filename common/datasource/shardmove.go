@@ -0,0 +1,639 @@
+package datasource
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const shardMoveBatchSize = 500
+
+//原表可写入期间追平增量的最多轮数
+const shardMoveCatchUpRounds = 10
+
+var e_invalid_move_site = errors.New("站点不可迁移")
+var e_site_already_in_shard = errors.New("站点已位于目标库")
+var e_site_moving = errors.New("站点迁移中")
+var e_moved_table_exists = errors.New("原库存在上次迁移保留的表 需先处理")
+var e_table_name_too_long = errors.New("表名过长 无法保留原表")
+
+const (
+	SHARD_MOVE_RUNNING = "running"
+	SHARD_MOVE_DONE    = "done"
+	SHARD_MOVE_FAILED  = "failed"
+)
+
+//迁移任务 状态仅保存在发起迁移的进程
+type ShardMove struct {
+	SiteID     string            `json:"siteID"`
+	Sources    map[string]string `json:"sources"`
+	Target     string            `json:"target"`
+	DropSource bool              `json:"dropSource"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	BeginTime  time.Time         `json:"beginTime"`
+	FinishTime *time.Time        `json:"finishTime,omitempty"`
+}
+
+var shardMoveLock sync.Mutex
+var shardMoves = make(map[string]*ShardMove)
+
+//下级站点联表查询上级站点的表 上下级站点须位于同一库 迁移时一并迁移
+var siteFamily func(siteID string) ([]string, error)
+
+//由站点模块注册 返回包括自身在内的全部上下级站点
+func RegisterSiteFamily(f func(siteID string) ([]string, error)) {
+	siteFamily = f
+}
+
+func validMoveSite(siteID string) bool {
+	return siteID != "" && siteID != SITE_COMMON && !strings.ContainsAny(siteID, "_%'`\\")
+}
+
+//原表改名后保留 站点号不含下划线 不会被视为站点表
+func movedTableName(table string) string {
+	return "_" + table
+}
+
+//后台执行迁移 同一站点同时只能有一个迁移任务
+func StartMoveSite(siteID, target string, dropSource bool) (*ShardMove, error) {
+	if !validMoveSite(siteID) {
+		return nil, e_invalid_move_site
+	}
+	if _, err := GetShardConn(target); err != nil {
+		return nil, err
+	}
+
+	sites := []string{siteID}
+	if siteFamily != nil {
+		family, err := siteFamily(siteID)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, family...)
+	}
+
+	sources := make(map[string]string)
+	for _, s := range sites {
+		if !validMoveSite(s) {
+			return nil, fmt.Errorf("%s: %s", e_invalid_move_site.Error(), s)
+		}
+		if shard := GetSiteShard(s); shard != target {
+			sources[s] = shard
+		}
+	}
+	if len(sources) == 0 {
+		return nil, e_site_already_in_shard
+	}
+
+	shardMoveLock.Lock()
+	defer shardMoveLock.Unlock()
+
+	for s := range sources {
+		if m, exists := shardMoves[s]; exists && m.Status == SHARD_MOVE_RUNNING {
+			return nil, fmt.Errorf("%s: %s", e_site_moving.Error(), s)
+		}
+	}
+
+	move := &ShardMove{SiteID: siteID, Sources: sources, Target: target, DropSource: dropSource, Status: SHARD_MOVE_RUNNING, BeginTime: time.Now()}
+	for s := range sources {
+		shardMoves[s] = move
+	}
+
+	go func() {
+		err := moveSites(sources, target, dropSource)
+
+		shardMoveLock.Lock()
+		defer shardMoveLock.Unlock()
+
+		now := time.Now()
+		move.FinishTime = &now
+		if err != nil {
+			log.Println("error move site: ", siteID, sources, target, err)
+			move.Status = SHARD_MOVE_FAILED
+			move.Error = err.Error()
+		} else {
+			move.Status = SHARD_MOVE_DONE
+		}
+	}()
+
+	result := *move
+	return &result, nil
+}
+
+func GetShardMove(siteID string) *ShardMove {
+	shardMoveLock.Lock()
+	defer shardMoveLock.Unlock()
+
+	move, exists := shardMoves[siteID]
+	if !exists {
+		return nil
+	}
+	result := *move
+	return &result
+}
+
+type siteTables struct {
+	siteID string
+	source string
+	src    *sql.DB
+	tables []string
+	marks  map[string]*tableMark
+}
+
+//将站点全部表复制到目标库 原表可写入期间按增量追平 之后将原表改名以阻止写入 追平最后的增量并逐表校验后切换对应关系
+//尚未刷新对应关系的进程访问原表将报错 不会写入原库而丢失 原表改名后保留或删除
+func moveSites(sources map[string]string, target string, dropSource bool) error {
+	dst, err := GetShardConn(target)
+	if err != nil {
+		return err
+	}
+
+	//新建站点表以prototype表为模板
+	if err := copyPrototypes(dst); err != nil {
+		log.Println("error copy prototype tables: ", target, err)
+		return err
+	}
+
+	siteIDs := make([]string, 0)
+	for siteID := range sources {
+		siteIDs = append(siteIDs, siteID)
+	}
+	sort.Strings(siteIDs)
+
+	moves := make([]*siteTables, 0)
+	for _, siteID := range siteIDs {
+		src, err := GetShardConn(sources[siteID])
+		if err != nil {
+			return err
+		}
+
+		tables, err := showTables(src, siteID+`\_%`)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			if len(movedTableName(table)) > 64 {
+				return fmt.Errorf("%s: %s", e_table_name_too_long.Error(), table)
+			}
+			moved, err := showTables(src, strings.ReplaceAll(movedTableName(table), "_", `\_`))
+			if err != nil {
+				return err
+			}
+			if len(moved) > 0 {
+				return fmt.Errorf("%s: %s", e_moved_table_exists.Error(), moved[0])
+			}
+		}
+
+		log.Printf("move site [%s] from shard [%s] to [%s]: %d tables", siteID, sources[siteID], target, len(tables))
+
+		moves = append(moves, &siteTables{siteID: siteID, source: sources[siteID], src: src, tables: tables, marks: make(map[string]*tableMark)})
+	}
+
+	for _, m := range moves {
+		for _, table := range m.tables {
+			//复制前记录增量位置 复制期间写入的行在追平时重复写入
+			mark, err := getTableMark(m.src, table)
+			if err != nil {
+				return err
+			}
+			if err := copyTable(m.src, dst, table); err != nil {
+				log.Println("error move site table: ", m.siteID, table, err)
+				return err
+			}
+			m.marks[table] = mark
+		}
+	}
+
+	//持续写入的站点每轮仅追平少量增量 增量不足一批即可改名 缩短阻塞写入时间
+	for round := 0; round < shardMoveCatchUpRounds; round++ {
+		var count int
+		for _, m := range moves {
+			for _, table := range m.tables {
+				n, err := catchUpTable(m.src, dst, table, table, m.marks[table])
+				if err != nil {
+					log.Println("error catch up site table: ", m.siteID, table, err)
+					return err
+				}
+				count += n
+			}
+		}
+		log.Printf("sites %v catch up round %d: %d rows", siteIDs, round+1, count)
+		if count < shardMoveBatchSize {
+			break
+		}
+	}
+
+	renamed := make([]*siteTables, 0)
+	restore := func() {
+		for _, m := range renamed {
+			if err := renameTables(m.src, m.tables, true); err != nil {
+				log.Printf("error site [%s] restore source tables: %v", m.siteID, err)
+			}
+		}
+	}
+
+	//同一语句改名 等待进行中的事务结束 之后原表不可再写入
+	for _, m := range moves {
+		if err := renameTables(m.src, m.tables, false); err != nil {
+			restore()
+			return err
+		}
+		renamed = append(renamed, m)
+	}
+
+	//复制期间新建表或无法追平 恢复原表名放弃迁移
+	for _, m := range moves {
+		if err := verifyMovedTables(m.src, dst, m); err != nil {
+			log.Printf("error site [%s] verify moved tables. restore source tables: %v", m.siteID, err)
+			restore()
+			return err
+		}
+	}
+
+	switched := make([]*siteTables, 0)
+	for _, m := range moves {
+		if err := SetSiteShard(m.siteID, target); err != nil {
+			for _, s := range switched {
+				if e := SetSiteShard(s.siteID, s.source); e != nil {
+					log.Printf("error site [%s] revert shard [%s]: %v", s.siteID, s.source, e)
+				}
+			}
+			restore()
+			return err
+		}
+		switched = append(switched, m)
+	}
+
+	log.Printf("sites %v switched to shard [%s]. other processes fail on these sites until reload", siteIDs, target)
+
+	if dropSource {
+		for _, m := range moves {
+			for _, table := range m.tables {
+				if _, err := m.src.Exec(fmt.Sprintf("DROP TABLE `%s`", movedTableName(table))); err != nil {
+					log.Println("error drop moved source table: ", table, err)
+					return err
+				}
+			}
+		}
+	}
+
+	log.Printf("sites %v moved to shard [%s]", siteIDs, target)
+
+	return nil
+}
+
+//原表改名后不再写入 追平最后的增量后逐表校验
+//增量无法反映删除及早于增量位置的修改 校验不一致的表整表重新复制
+func verifyMovedTables(src, dst *sql.DB, m *siteTables) error {
+	created, err := showTables(src, m.siteID+`\_%`)
+	if err != nil {
+		return err
+	}
+	if len(created) > 0 {
+		return fmt.Errorf("原库迁移期间新建表[%s]", created[0])
+	}
+
+	for _, table := range m.tables {
+		moved := movedTableName(table)
+		if _, err := catchUpTable(src, dst, moved, table, m.marks[table]); err != nil {
+			return err
+		}
+		err := verifyTable(src, dst, moved, table)
+		if err == nil {
+			continue
+		}
+
+		log.Printf("site [%s] table [%s] recopy: %v", m.siteID, table, err)
+		if _, err := dst.Exec(fmt.Sprintf("DELETE FROM `%s`", table)); err != nil {
+			return err
+		}
+		if _, err := copyRows(src, dst, moved, table, "", nil, false); err != nil {
+			return err
+		}
+		if err := verifyTable(src, dst, moved, table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//restore为恢复原表名
+func renameTables(src *sql.DB, tables []string, restore bool) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	renames := make([]string, 0)
+	for _, table := range tables {
+		if restore {
+			renames = append(renames, fmt.Sprintf("`%s` TO `%s`", movedTableName(table), table))
+		} else {
+			renames = append(renames, fmt.Sprintf("`%s` TO `%s`", table, movedTableName(table)))
+		}
+	}
+	_, err := src.Exec("RENAME TABLE " + strings.Join(renames, ","))
+	return err
+}
+
+func showTables(db *sql.DB, like string) ([]string, error) {
+	rows, err := db.Query("SHOW TABLES LIKE ?", like)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		result = append(result, table)
+	}
+	return result, rows.Err()
+}
+
+func showCreateTable(db *sql.DB, table string) (string, error) {
+	var name, create string
+	if err := db.QueryRow(fmt.Sprintf("SHOW CREATE TABLE `%s`", table)).Scan(&name, &create); err != nil {
+		return "", err
+	}
+	return create, nil
+}
+
+func copyPrototypes(dst *sql.DB) error {
+	if dst == GetConn() {
+		return nil
+	}
+
+	prototypes, err := showTables(GetConn(), `prototype\_%`)
+	if err != nil {
+		return err
+	}
+	exists, err := showTables(dst, `prototype\_%`)
+	if err != nil {
+		return err
+	}
+
+	existsMap := make(map[string]bool)
+	for _, t := range exists {
+		existsMap[t] = true
+	}
+
+	for _, t := range prototypes {
+		if existsMap[t] {
+			continue
+		}
+		create, err := showCreateTable(GetConn(), t)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Exec(create); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//目标库已有同名表为之前迁出或中断遗留 重建
+func copyTable(src, dst *sql.DB, table string) error {
+	create, err := showCreateTable(src, table)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.Exec(fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table)); err != nil {
+		return err
+	}
+	if _, err := dst.Exec(create); err != nil {
+		return err
+	}
+
+	count, err := copyRows(src, dst, table, table, "", nil, false)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("table [%s] copied: %d rows", table, count)
+
+	return nil
+}
+
+//where为空时复制全部行 replace用于追平增量 覆盖目标库已有的行
+func copyRows(src, dst *sql.DB, srcTable, table, where string, args []interface{}, replace bool) (int, error) {
+	SQL := fmt.Sprintf("SELECT * FROM `%s`", srcTable)
+	if where != "" {
+		SQL += " WHERE " + where
+	}
+	rows, err := src.Query(SQL, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+
+	insert := "INSERT"
+	if replace {
+		insert = "REPLACE"
+	}
+
+	placeholders := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	flush := func() error {
+		if len(placeholders) == 0 {
+			return nil
+		}
+		if _, err := dst.Exec(fmt.Sprintf("%s INTO `%s` VALUES %s", insert, table, strings.Join(placeholders, ",")), values...); err != nil {
+			return err
+		}
+		placeholders = placeholders[:0]
+		values = values[:0]
+		return nil
+	}
+
+	raw := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+
+	var count int
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		for _, r := range raw {
+			if r == nil {
+				values = append(values, nil)
+			} else {
+				values = append(values, append([]byte{}, r...))
+			}
+		}
+		placeholders = append(placeholders, placeholder)
+		count++
+
+		//单条语句占位符不超过65535
+		if len(placeholders) >= shardMoveBatchSize || len(values)+len(columns) > 65535 {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func verifyTable(src, dst *sql.DB, srcTable, table string) error {
+	srcCount, srcSum, err := tableChecksum(src, srcTable)
+	if err != nil {
+		return err
+	}
+	dstCount, dstSum, err := tableChecksum(dst, table)
+	if err != nil {
+		return err
+	}
+	if srcCount != dstCount || srcSum != dstSum {
+		return fmt.Errorf("表[%s]校验不一致 原库%d行[%d] 目标库%d行[%d]", table, srcCount, srcSum, dstCount, dstSum)
+	}
+	return nil
+}
+
+//增量位置 自增id及时间字段 有update_time时优先 否则按data_time
+type tableMark struct {
+	idColumn   string
+	timeColumn string
+	maxID      int64
+	maxTime    sql.NullString
+}
+
+func getTableMark(db *sql.DB, table string) (*tableMark, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW COLUMNS FROM `%s`", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mark := new(tableMark)
+	for rows.Next() {
+		var field string
+		var extra sql.NullString
+		var t, null, key, def interface{}
+		if err := rows.Scan(&field, &t, &null, &key, &def, &extra); err != nil {
+			return nil, err
+		}
+		switch field {
+		case "id":
+			if strings.Contains(strings.ToLower(extra.String), "auto_increment") {
+				mark.idColumn = field
+			}
+		case "update_time":
+			mark.timeColumn = field
+		case "data_time":
+			if mark.timeColumn == "" {
+				mark.timeColumn = field
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	maxID, maxTime := "0", "NULL"
+	if mark.idColumn != "" {
+		maxID = fmt.Sprintf("IFNULL(MAX(`%s`), 0)", mark.idColumn)
+	}
+	if mark.timeColumn != "" {
+		maxTime = fmt.Sprintf("DATE_FORMAT(MAX(`%s`), '%%Y-%%m-%%d %%H:%%i:%%s')", mark.timeColumn)
+	}
+	if err := db.QueryRow(fmt.Sprintf("SELECT %s, %s FROM `%s`", maxID, maxTime, table)).Scan(&mark.maxID, &mark.maxTime); err != nil {
+		return nil, err
+	}
+
+	return mark, nil
+}
+
+//复制mark之后新增或更新的行并推进mark 无自增id及时间字段的表不追平 由改名后的校验处理
+func catchUpTable(src, dst *sql.DB, srcTable, table string, mark *tableMark) (int, error) {
+	if mark.idColumn == "" && mark.timeColumn == "" {
+		return 0, nil
+	}
+
+	//先取新位置再复制 之间写入的行在下一轮重复写入
+	next, err := getTableMark(src, srcTable)
+	if err != nil {
+		return 0, err
+	}
+
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	if mark.idColumn != "" {
+		conditions = append(conditions, fmt.Sprintf("`%s` > ?", mark.idColumn))
+		args = append(args, mark.maxID)
+	}
+	if mark.timeColumn != "" {
+		if mark.maxTime.Valid {
+			conditions = append(conditions, fmt.Sprintf("`%s` >= ?", mark.timeColumn))
+			args = append(args, mark.maxTime.String)
+		} else {
+			conditions = append(conditions, fmt.Sprintf("`%s` IS NOT NULL", mark.timeColumn))
+		}
+	}
+
+	count, err := copyRows(src, dst, srcTable, table, strings.Join(conditions, " OR "), args, true)
+	if err != nil {
+		return 0, err
+	}
+
+	*mark = *next
+
+	return count, nil
+}
+
+//CHECKSUM TABLE结果与存储格式相关 跨实例不可比较 按行计算
+func tableChecksum(db *sql.DB, table string) (count, sum int64, e error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW COLUMNS FROM `%s`", table))
+	if err != nil {
+		e = err
+		return
+	}
+	defer rows.Close()
+
+	columns := make([]string, 0)
+	for rows.Next() {
+		var field string
+		var t, null, key, def, extra interface{}
+		if err := rows.Scan(&field, &t, &null, &key, &def, &extra); err != nil {
+			e = err
+			return
+		}
+		columns = append(columns, fmt.Sprintf("IFNULL(`%s`, '\\0')", field))
+	}
+	if err := rows.Err(); err != nil {
+		e = err
+		return
+	}
+
+	e = db.QueryRow(fmt.Sprintf("SELECT COUNT(1), IFNULL(SUM(CRC32(CONCAT_WS('#', %s))), 0) FROM `%s`", strings.Join(columns, ","), table)).Scan(&count, &sum)
+	return
+}
@@ -0,0 +1,516 @@
+package expression
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var e_empty_expression = errors.New("表达式为空")
+var e_divide_by_zero = errors.New("除数为0")
+
+type Resolver func(variable string) (float64, error)
+
+type node interface {
+	eval(resolve Resolver) (float64, error)
+}
+
+type Expression struct {
+	source    string
+	root      node
+	variables []string
+}
+
+func (e *Expression) String() string { return e.source }
+
+//表达式引用的变量 按出现顺序去重
+func (e *Expression) Variables() []string { return e.variables }
+
+func (e *Expression) Evaluate(resolve Resolver) (float64, error) {
+	return e.root.eval(resolve)
+}
+
+//支持 + - * / 比较 && || ! 及函数 min max abs if
+func Compile(source string) (*Expression, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, e_empty_expression
+	}
+
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, variables: make(map[string]bool)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("表达式多余内容:%s", p.tokens[p.pos].text)
+	}
+
+	return &Expression{source: source, root: root, variables: p.order}, nil
+}
+
+const (
+	tokenNumber = iota
+	tokenIdent
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+)
+
+type token struct {
+	kind int
+	text string
+	num  float64
+}
+
+func tokenize(source string) ([]*token, error) {
+	tokens := make([]*token, 0)
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			if j < len(runes) && (runes[j] == 'e' || runes[j] == 'E') {
+				k := j + 1
+				if k < len(runes) && (runes[k] == '+' || runes[k] == '-') {
+					k++
+				}
+				if k < len(runes) && unicode.IsDigit(runes[k]) {
+					j = k
+					for j < len(runes) && unicode.IsDigit(runes[j]) {
+						j++
+					}
+				}
+			}
+			num, err := strconv.ParseFloat(string(runes[i:j]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("错误的数值:%s", string(runes[i:j]))
+			}
+			tokens = append(tokens, &token{kind: tokenNumber, text: string(runes[i:j]), num: num})
+			i = j
+		case unicode.IsLetter(r) || r == '_' || r == '$':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '$' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, &token{kind: tokenIdent, text: string(runes[i:j])})
+			i = j
+		case r == '(':
+			tokens = append(tokens, &token{kind: tokenLeftParen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, &token{kind: tokenRightParen, text: ")"})
+			i++
+		case r == ',':
+			tokens = append(tokens, &token{kind: tokenComma, text: ","})
+			i++
+		default:
+			op, width := string(r), 1
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "<=", ">=", "==", "!=", "&&", "||":
+					op, width = two, 2
+				}
+			}
+			switch op {
+			case "+", "-", "*", "/", "<", ">", "<=", ">=", "==", "!=", "&&", "||", "!":
+			case "×":
+				op = "*"
+			case "÷":
+				op = "/"
+			case "−":
+				op = "-"
+			default:
+				return nil, fmt.Errorf("无法识别的字符:%s", op)
+			}
+			tokens = append(tokens, &token{kind: tokenOperator, text: op})
+			i += width
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens    []*token
+	pos       int
+	variables map[string]bool
+	order     []string
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *parser) isOperator(ops ...string) (string, bool) {
+	t := p.peek()
+	if t == nil || t.kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.isOperator("||")
+		if !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.isOperator("&&")
+		if !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.isOperator("<", ">", "<=", ">=", "==", "!=")
+		if !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.isOperator("+", "-")
+		if !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.isOperator("*", "/")
+		if !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if op, ok := p.isOperator("-", "+", "!"); ok {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, errors.New("表达式不完整")
+	}
+
+	switch t.kind {
+	case tokenNumber:
+		p.pos++
+		return &numberNode{value: t.num}, nil
+	case tokenLeftParen:
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next == nil || next.kind != tokenRightParen {
+			return nil, errors.New("缺少右括号")
+		}
+		p.pos++
+		return inner, nil
+	case tokenIdent:
+		p.pos++
+		if next := p.peek(); next != nil && next.kind == tokenLeftParen {
+			return p.parseCall(t.text)
+		}
+		if !p.variables[t.text] {
+			p.variables[t.text] = true
+			p.order = append(p.order, t.text)
+		}
+		return &variableNode{name: t.text}, nil
+	}
+
+	return nil, fmt.Errorf("表达式错误:%s", t.text)
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	p.pos++
+
+	args := make([]node, 0)
+	if next := p.peek(); next != nil && next.kind == tokenRightParen {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			next := p.peek()
+			if next == nil {
+				return nil, errors.New("缺少右括号")
+			}
+			if next.kind == tokenComma {
+				p.pos++
+				continue
+			}
+			if next.kind == tokenRightParen {
+				p.pos++
+				break
+			}
+			return nil, fmt.Errorf("表达式错误:%s", next.text)
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case "min", "max":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("函数%s至少需要一个参数", name)
+		}
+	case "abs":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("函数%s需要一个参数", name)
+		}
+	case "if":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("函数%s需要三个参数", name)
+		}
+	default:
+		return nil, fmt.Errorf("未知函数:%s", name)
+	}
+
+	return &callNode{name: strings.ToLower(name), args: args}, nil
+}
+
+type numberNode struct {
+	value float64
+}
+
+func (n *numberNode) eval(resolve Resolver) (float64, error) { return n.value, nil }
+
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(resolve Resolver) (float64, error) {
+	if resolve == nil {
+		return 0, fmt.Errorf("变量未定义:%s", n.name)
+	}
+	return resolve(n.name)
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(resolve Resolver) (float64, error) {
+	v, err := n.operand.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "-":
+		return -v, nil
+	case "!":
+		return boolValue(v == 0), nil
+	}
+	return v, nil
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n *binaryNode) eval(resolve Resolver) (float64, error) {
+	l, err := n.left.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+
+	//逻辑运算短路
+	switch n.op {
+	case "&&":
+		if l == 0 {
+			return 0, nil
+		}
+	case "||":
+		if l != 0 {
+			return 1, nil
+		}
+	}
+
+	r, err := n.right.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return 0, e_divide_by_zero
+		}
+		return l / r, nil
+	case "<":
+		return boolValue(l < r), nil
+	case ">":
+		return boolValue(l > r), nil
+	case "<=":
+		return boolValue(l <= r), nil
+	case ">=":
+		return boolValue(l >= r), nil
+	case "==":
+		return boolValue(l == r), nil
+	case "!=":
+		return boolValue(l != r), nil
+	case "&&", "||":
+		return boolValue(r != 0), nil
+	}
+
+	return 0, fmt.Errorf("未知运算符:%s", n.op)
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n *callNode) eval(resolve Resolver) (float64, error) {
+	if n.name == "if" {
+		cond, err := n.args[0].eval(resolve)
+		if err != nil {
+			return 0, err
+		}
+		if cond != 0 {
+			return n.args[1].eval(resolve)
+		}
+		return n.args[2].eval(resolve)
+	}
+
+	values := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(resolve)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = v
+	}
+
+	switch n.name {
+	case "abs":
+		return math.Abs(values[0]), nil
+	case "min":
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+		return result, nil
+	case "max":
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+		return result, nil
+	}
+
+	return 0, fmt.Errorf("未知函数:%s", n.name)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
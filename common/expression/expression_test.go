@@ -0,0 +1,73 @@
+package expression_test
+
+import (
+	"log"
+	"testing"
+
+	"obsessiontech/common/expression"
+)
+
+func TestEvaluate(t *testing.T) {
+	vars := map[string]float64{"m1": 3, "m2": 4, "m3.max": -5}
+	resolve := func(name string) (float64, error) {
+		return vars[name], nil
+	}
+
+	cases := map[string]float64{
+		"1 + 2 * 3":                     7,
+		"(1 + 2) * 3":                   9,
+		"m1 × m2 ÷ 2":                   6,
+		"m1 − m2":                       -1,
+		"-m1 + 10":                      7,
+		"abs(m3.max)":                   5,
+		"min(m1, m2, 1)":                1,
+		"max(m1, m2)":                   4,
+		"if(m1 > m2, 1, 2)":             2,
+		"if(m1 < m2 && m2 <= 4, m1, 0)": 3,
+		"!(m1 == 3) || m2 != 4":         0,
+		"1.5e2":                         150,
+	}
+
+	for source, expected := range cases {
+		exp, err := expression.Compile(source)
+		if err != nil {
+			t.Error(source, err)
+			continue
+		}
+		v, err := exp.Evaluate(resolve)
+		if err != nil {
+			t.Error(source, err)
+			continue
+		}
+		log.Println(source, v)
+		if v != expected {
+			t.Errorf("%s expected %v got %v", source, expected, v)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, source := range []string{"", "1 +", "(1 + 2", "foo(1)", "abs(1, 2)", "if(1, 2)", "1 # 2", "1 2"} {
+		if _, err := expression.Compile(source); err == nil {
+			t.Error("should fail: ", source)
+		} else {
+			log.Println(source, err)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	exp, err := expression.Compile("m1 + max(m2, m1) / m3.avg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := exp.Variables()
+	log.Println(vars)
+	if len(vars) != 3 || vars[0] != "m1" || vars[1] != "m2" || vars[2] != "m3.avg" {
+		t.Error("unexpected variables: ", vars)
+	}
+
+	if _, err := exp.Evaluate(func(string) (float64, error) { return 0, nil }); err == nil {
+		t.Error("should fail divide by zero")
+	}
+}
@@ -153,7 +153,7 @@ func GetEmpowerDetails(siteID, empower string, empowerID []string, source string
 		SQL += "\nWHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -291,7 +291,7 @@ func GetEmpowers(siteID, source string, actionAuth ActionAuthSet, actions map[st
 		SQL += "\nWHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -315,7 +315,7 @@ func GetEmpowers(siteID, source string, actionAuth ActionAuthSet, actions map[st
 
 func ClearEmpowers(siteID, source, sourceID string) error {
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -88,7 +88,7 @@ func GetCategories(siteID, source, clientAgent string, actionAuth authority.Acti
 
 	sql += "\n ORDER BY category.sort DESC, category.id DESC"
 
-	rows, err := datasource.GetConn().Query(sql, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(sql, values...)
 	if err != nil {
 		log.Println("error get category: ", err)
 		return nil, err
@@ -158,7 +158,7 @@ func GetObjectCategories(siteID, source string, objectIDs []string, actionAuth a
 		ORDER BY %s.a DESC, category.sort DESC, category.id DESC
 	`, categogyColumn, joinTable, categoryTableName(siteID), joinSQL, where, joinTable)
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get object categories: ", SQL, values, err)
 		return nil, err
@@ -237,7 +237,7 @@ func (c *Category) Validate(siteID string) error {
 }
 
 func (c *Category) Add(siteID string) error {
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := c.AddWithTxn(siteID, txn); err != nil {
 			panic(err)
 		}
@@ -287,7 +287,7 @@ func (c *Category) Update(siteID string) error {
 
 	values = append(values, c.ID)
 
-	if _, err := datasource.GetConn().Exec(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(`
 		UPDATE 
 			`+categoryTableName(siteID)+`
 		SET
@@ -302,7 +302,7 @@ func (c *Category) Update(siteID string) error {
 }
 
 func (c *Category) Delete(siteID string) error {
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := c.DeleteWithTxn(siteID, txn); err != nil {
 			panic(err)
 		}
@@ -423,7 +423,7 @@ func GetCategoriesByTypeWithTxn(siteID string, txn *sql.Tx, categoryType string,
 
 func AddCategoryClientAgentMapping(siteID string, categoryID int, clientAgent string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := AddCategoryClientAgentMappingWithTxn(siteID, txn, categoryID, clientAgent); err != nil {
 			panic(err)
 		}
@@ -443,7 +443,7 @@ func AddCategoryClientAgentMappingWithTxn(siteID string, txn *sql.Tx, categoryID
 
 func DeleteCategoryClientAgentMapping(siteID string, categoryID int, clientAgent string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := DeleteCategoryClientAgentMappingWithTxn(siteID, txn, categoryID, clientAgent); err != nil {
 			panic(err)
 		}
@@ -480,7 +480,7 @@ func GetCategoryClientAgents(siteID string, categoryID ...int) (map[int][]string
 
 func AddCategoryMapping[SourceType relation.RelationID](siteID, source string, sourceID SourceType, cid int) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := AddCategoryMappingWithTxn(siteID, txn, source, sourceID, cid); err != nil {
 			panic(err)
 		}
@@ -526,7 +526,7 @@ func AddCategoryMappingWithTxn[SourceType relation.RelationID](siteID string, tx
 }
 
 func DeleteCategoryMapping[SourceType relation.RelationID](siteID, source string, sourceID SourceType, cid int) error {
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := DeleteCategoryMappingWithTxn(siteID, txn, source, sourceID, cid); err != nil {
 			panic(err)
 		}
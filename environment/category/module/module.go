@@ -54,7 +54,7 @@ func GetModule(siteID string) (*CategoryModule, error) {
 
 func (m *CategoryModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_CATEGORY, true)
 		if err != nil {
 			panic(err)
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +14,9 @@ import (
 	"obsessiontech/environment/authority"
 	"obsessiontech/environment/environment"
 	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/data/export"
 	"obsessiontech/environment/environment/data/operation"
+	"obsessiontech/environment/environment/data/querycache"
 	"obsessiontech/environment/environment/data/recent"
 	"obsessiontech/environment/environment/data/upload"
 	"obsessiontech/environment/environment/dataprocess"
@@ -22,6 +25,8 @@ import (
 	"obsessiontech/environment/environment/ipcclient"
 	"obsessiontech/environment/environment/monitor"
 	"obsessiontech/environment/environment/protocol"
+	"obsessiontech/environment/environment/report"
+	"obsessiontech/environment/environment/sink"
 	"obsessiontech/environment/environment/stats"
 	"obsessiontech/environment/environment/subscription"
 	"obsessiontech/environment/logging"
@@ -48,6 +53,25 @@ func init() {
 		logging.ParseRegistrant("monitorCode", "监测物因子", [2]string{"add", "新增"}, [2]string{"update", "修改"}, [2]string{"delete", "删除"}),
 		logging.ParseRegistrant("monitorLimit", "监测物限值", [2]string{"add", "新增"}, [2]string{"update", "修改"}, [2]string{"delete", "删除"}),
 	)
+
+	logging.Register(data.MODULE_DATA,
+		logging.ParseRegistrant("site_module", "模块设置", [2]string{"save", "修改"}),
+		logging.ParseRegistrant(data.LOGGING_SOURCE_RETENTION, "数据保留", [2]string{data.LOGGING_ACTION_PURGE, "清理"}),
+		logging.ParseRegistrant("retentionHold", "保留锁定", [2]string{"add", "新增"}, [2]string{"delete", "删除"}),
+	)
+
+	logging.Register(report.MODULE_REPORT,
+		logging.ParseRegistrant("template", "报表模板", [2]string{"add", "新增"}, [2]string{"update", "修改"}, [2]string{"delete", "删除"}),
+		logging.ParseRegistrant("report", "报表", [2]string{"delete", "删除"}),
+	)
+
+	logging.Register(sink.MODULE_SINK,
+		logging.ParseRegistrant("site_module", "模块设置", [2]string{"save", "修改"}),
+	)
+
+	logging.Register(externalsource.MODULE_EXTERNALSOURCE,
+		logging.ParseRegistrant("connector", "外部数据源", [2]string{"add", "新增"}, [2]string{"update", "修改"}, [2]string{"delete", "删除"}),
+	)
 }
 
 func loadEnvironment() {
@@ -543,6 +567,10 @@ func loadEnvironment() {
 		}
 	})
 
+	authorized.GET("environment/monitor/unit", func(c *gin.Context) {
+		c.Set("json", map[string]interface{}{"retCode": 0, "unitList": monitor.GetUnits(), "molecularWeight": monitor.GetMolecularWeights(), "referenceCondition": monitor.GetReferenceConditions()})
+	})
+
 	authorized.GET("environment/monitor", func(c *gin.Context) {
 		siteID := c.GetString("site")
 
@@ -907,6 +935,8 @@ func loadEnvironment() {
 				err = param.Add(siteID, actionAuth.(authority.ActionAuthSet))
 			case "update":
 				err = param.Update(siteID, actionAuth.(authority.ActionAuthSet))
+			case "revise":
+				err = param.Revise(siteID, actionAuth.(authority.ActionAuthSet))
 			case "delete":
 				err = param.Delete(siteID, actionAuth.(authority.ActionAuthSet))
 			default:
@@ -923,83 +953,77 @@ func loadEnvironment() {
 			}
 		})
 
-	authorized.GET("environment/data/module", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_VIEW), func(c *gin.Context) {
-		if dataModule, err := data.GetModule(c.GetString("site")); err != nil {
-			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "dataModule": dataModule})
-		}
-	})
+	authorized.POST("environment/monitor/limit/evaluate/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
 
-	authorized.POST("environment/data/module/edit/save", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), logger(data.MODULE_DATA, "site_module", "save"), func(c *gin.Context) {
-		var param data.DataModule
+		actionAuth, _ := c.Get("actionAuth")
 
+		var param monitor.FlagLimit
 		if err := c.ShouldBindJSON(&param); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 			return
 		}
 
-		if err := param.Save(c.GetString("site")); err != nil {
-			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0})
+		stationIDs := make([]int, 0)
+		if param.StationID > 0 {
+			stationIDs = append(stationIDs, param.StationID)
+		} else if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				stationIDs = append(stationIDs, id)
+			}
 		}
-	})
 
-	authorized.GET("environment/data/range/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
-
-		if rangeList, err := data.FetchableTables(c.GetString("site"), c.Param("dataType")); err != nil {
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "rangeList": rangeList})
+			return
 		}
-
-	})
-
-	authorized.POST("environment/data/rotate", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), func(c *gin.Context) {
-		siteID := c.GetString("site")
-
-		data.TriggerRotation(siteID, true)
-		c.Set("json", map[string]interface{}{"retCode": 0})
-
-	})
-
-	authorized.POST("environment/data/activateArchive/:dataType", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), func(c *gin.Context) {
-		siteID := c.GetString("site")
-
-		var param struct {
-			Table string `json:"table"`
+		for _, id := range stationIDs {
+			if !filtered[id] {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+				return
+			}
 		}
 
-		if err := c.ShouldBindJSON(&param); err != nil {
+		beginTime, err := util.ParseDateTime(c.Query("beginTime"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+		endTime, err := util.ParseDateTime(c.Query("endTime"))
+		if err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 			return
 		}
 
-		if err := data.ActivateArchive(siteID, c.Param("dataType"), param.Table); err != nil {
+		if evaluationList, err := monitor.EvaluateFlagLimit(siteID, c.Param("dataType"), &param, stationIDs, beginTime, endTime); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0})
+			c.Set("json", map[string]interface{}{"retCode": 0, "evaluationList": evaluationList})
 		}
 	})
 
-	authorized.GET("environment/data/byTime/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+	authorized.GET("environment/monitor/alarm/event", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
 		siteID := c.GetString("site")
 
 		actionAuth, _ := c.Get("actionAuth")
 
-		dataType := c.Param("dataType")
-
 		stationIDs := make([]int, 0)
 		if idlist := c.Query("stationID"); idlist != "" {
 			parts := strings.Split(idlist, ",")
 			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
 					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 					return
-				} else {
-					stationIDs = append(stationIDs, id)
 				}
+				stationIDs = append(stationIDs, id)
 			}
 		}
 
@@ -1009,197 +1033,121 @@ func loadEnvironment() {
 			return
 		}
 
-		for _, sid := range stationIDs {
-			if !filtered[sid] {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": fmt.Sprintf("无权限查看【%d】", sid)})
-				return
+		stationIDs = make([]int, 0)
+		for sid, ok := range filtered {
+			if ok {
+				stationIDs = append(stationIDs, sid)
 			}
 		}
 
+		if len(stationIDs) == 0 {
+			c.Set("json", map[string]interface{}{"retCode": 0, "alarmEventList": []interface{}{}})
+			return
+		}
+
 		monitorIDs := make([]int, 0)
 		if idlist := c.Query("monitorID"); idlist != "" {
 			parts := strings.Split(idlist, ",")
 			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
 					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 					return
-				} else {
-					monitorIDs = append(monitorIDs, id)
 				}
+				monitorIDs = append(monitorIDs, id)
 			}
 		}
 
-		monitorCodeIDs := make([]int, 0)
-		if idlist := c.Query("monitorCodeID"); idlist != "" {
-			parts := strings.Split(idlist, ",")
-			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				} else {
-					monitorCodeIDs = append(monitorCodeIDs, id)
-				}
-			}
+		rules := make([]string, 0)
+		if list := c.Query("rule"); strings.TrimSpace(list) != "" {
+			rules = strings.Split(list, ",")
 		}
 
-		var beginTime, endTime time.Time
+		var beginTime, endTime *time.Time
 		if c.Query("beginTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			t, err := util.ParseDateTime(c.Query("beginTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			beginTime = ts
+			beginTime = &t
 		}
 		if c.Query("endTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("endTime"))
+			t, err := util.ParseDateTime(c.Query("endTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			endTime = ts
-		}
-
-		var criterias data.Criterias
-		if cs, exists := c.GetQuery("criteria"); exists && cs != "" {
-			if err := json.Unmarshal([]byte(cs), &criterias); err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-				return
-			}
+			endTime = &t
 		}
 
-		pageNo, _ := strconv.Atoi(c.Query("pageNo"))
-		pageSize, _ := strconv.Atoi(c.Query("pageSize"))
-
-		withOriginData, _ := strconv.ParseBool(c.Query("withOriginData"))
-		withReiewed, _ := strconv.ParseBool(c.Query("withReviewed"))
+		ongoing, _ := strconv.ParseBool(c.Query("ongoing"))
 
-		if timeDateList, total, err := data.GetDataByTime(siteID, dataType, stationIDs, criterias, beginTime, endTime, withOriginData, withReiewed, c.Query("order"), pageNo, pageSize, monitorCodeIDs, monitorIDs); err != nil {
+		if alarmEventList, err := monitor.GetAlarmEvents(siteID, stationIDs, monitorIDs, rules, beginTime, endTime, ongoing); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "timeDataList": timeDateList, "total": total})
+			c.Set("json", map[string]interface{}{"retCode": 0, "alarmEventList": alarmEventList})
 		}
 	})
 
-	authorized.GET("environment/data/count/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+	authorized.GET("environment/monitor/alarm/stats", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
 		siteID := c.GetString("site")
 
 		actionAuth, _ := c.Get("actionAuth")
 
-		dataType := c.Param("dataType")
-
 		stationIDs := make([]int, 0)
 		if idlist := c.Query("stationID"); idlist != "" {
 			parts := strings.Split(idlist, ",")
 			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				} else {
-					stationIDs = append(stationIDs, id)
-				}
-			}
-		}
-
-		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
-		if err != nil {
-			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-			return
-		}
-
-		for _, sid := range stationIDs {
-			if !filtered[sid] {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
-				return
-			}
-		}
-
-		monitorCodeIDs := make([]int, 0)
-		if idlist := c.Query("monitorCodeID"); idlist != "" {
-			parts := strings.Split(idlist, ",")
-			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				} else {
-					monitorCodeIDs = append(monitorCodeIDs, id)
-				}
-			}
-		}
-
-		monitorIDs := make([]int, 0)
-		if idlist := c.Query("monitorID"); idlist != "" {
-			parts := strings.Split(idlist, ",")
-			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
 					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 					return
-				} else {
-					monitorIDs = append(monitorIDs, id)
 				}
+				stationIDs = append(stationIDs, id)
 			}
 		}
 
-		var beginTime, endTime time.Time
+		var beginTime, endTime *time.Time
 		if c.Query("beginTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			t, err := util.ParseDateTime(c.Query("beginTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			beginTime = ts
+			beginTime = &t
 		}
 		if c.Query("endTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("endTime"))
+			t, err := util.ParseDateTime(c.Query("endTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			endTime = ts
-		}
-
-		var criterias data.Criterias
-		if cs, exists := c.GetQuery("criteria"); exists && cs != "" {
-			if err := json.Unmarshal([]byte(cs), &criterias); err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-				return
-			}
-		}
-
-		groupByTime, _ := strconv.ParseBool(c.Query("groupByTime"))
-		groupByStation, _ := strconv.ParseBool(c.Query("groupByStation"))
-		groupByMonitor, _ := strconv.ParseBool(c.Query("groupByMonitor"))
-		groupByFlag, _ := strconv.ParseBool(c.Query("groupByFlag"))
-
-		var flags []string
-		if flag, exists := c.GetQuery("flag"); exists {
-			flags = strings.Split(flag, ",")
+			endTime = &t
 		}
 
-		if count, err := data.CountData(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, beginTime, endTime, flags, criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag); err != nil {
+		if alarmStats, err := stats.GetStationAlarmStats(siteID, actionAuth.(authority.ActionAuthSet), beginTime, endTime, stationIDs...); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "count": count})
+			c.Set("json", map[string]interface{}{"retCode": 0, "alarmStats": alarmStats})
 		}
 	})
 
-	authorized.GET("environment/data/list/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+	authorized.GET("environment/monitor/calibration", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
 		siteID := c.GetString("site")
 
 		actionAuth, _ := c.Get("actionAuth")
 
-		dataType := c.Param("dataType")
-
 		stationIDs := make([]int, 0)
 		if idlist := c.Query("stationID"); idlist != "" {
 			parts := strings.Split(idlist, ",")
 			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
 					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 					return
-				} else {
-					stationIDs = append(stationIDs, id)
 				}
+				stationIDs = append(stationIDs, id)
 			}
 		}
 
@@ -1209,234 +1157,259 @@ func loadEnvironment() {
 			return
 		}
 
-		for _, sid := range stationIDs {
-			if !filtered[sid] {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
-				return
+		stationIDs = make([]int, 0)
+		for sid, ok := range filtered {
+			if ok {
+				stationIDs = append(stationIDs, sid)
 			}
 		}
 
-		monitorIDs := make([]int, 0)
-		if idlist := c.Query("monitorID"); idlist != "" {
-			parts := strings.Split(idlist, ",")
-			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				} else {
-					monitorIDs = append(monitorIDs, id)
-				}
-			}
+		if len(stationIDs) == 0 {
+			c.Set("json", map[string]interface{}{"retCode": 0, "calibrationList": []interface{}{}})
+			return
 		}
 
-		monitorCodeIDs := make([]int, 0)
-		if idlist := c.Query("monitorCodeID"); idlist != "" {
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
 			parts := strings.Split(idlist, ",")
 			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
 					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 					return
-				} else {
-					monitorCodeIDs = append(monitorCodeIDs, id)
 				}
+				monitorIDs = append(monitorIDs, id)
 			}
 		}
 
-		var beginTime, endTime time.Time
+		var beginTime, endTime *time.Time
 		if c.Query("beginTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			t, err := util.ParseDateTime(c.Query("beginTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			beginTime = ts
+			beginTime = &t
 		}
 		if c.Query("endTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("endTime"))
+			t, err := util.ParseDateTime(c.Query("endTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			endTime = ts
-		}
-
-		var flags []string
-		if flag, exists := c.GetQuery("flag"); exists {
-			flags = strings.Split(flag, ",")
-		}
-
-		var criterias data.Criterias
-		if cs, exists := c.GetQuery("criteria"); exists && cs != "" {
-			if err := json.Unmarshal([]byte(cs), &criterias); err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-				return
-			}
+			endTime = &t
 		}
 
-		if dataList, err := data.GetData(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, criterias, beginTime, endTime, flags); err != nil {
+		if calibrationList, err := monitor.GetCalibrations(siteID, stationIDs, monitorIDs, c.Query("type"), beginTime, endTime); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "dataList": dataList})
+			c.Set("json", map[string]interface{}{"retCode": 0, "calibrationList": calibrationList})
 		}
 	})
 
-	authorized.GET("environment/data/vacancy/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
-		siteID := c.GetString("site")
+	authorized.POST("environment/monitor/calibration/edit/:method", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT),
+		loggerFunc(func(c *gin.Context) (string, string, string) {
+			return monitor.MODULE_MONITOR, "monitorCalibration", c.Param("method")
+		}),
+		func(c *gin.Context) {
+			siteID := c.GetString("site")
 
-		actionAuth, _ := c.Get("actionAuth")
+			actionAuth, _ := c.Get("actionAuth")
 
-		dataType := c.Param("dataType")
+			var param monitor.Calibration
 
-		stationIDs := make([]int, 0)
-		if idlist := c.Query("stationID"); idlist != "" {
-			parts := strings.Split(idlist, ",")
-			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				} else {
-					stationIDs = append(stationIDs, id)
-				}
+			err := c.ShouldBindJSON(&param)
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
 			}
-		}
-
-		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
-		if err != nil {
-			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-			return
-		}
 
-		for _, sid := range stationIDs {
-			if !filtered[sid] {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+			var prev *monitor.Calibration
+			switch c.Param("method") {
+			case "add":
+				err = param.Add(siteID, actionAuth.(authority.ActionAuthSet))
+			case "update":
+				prev, err = param.Update(siteID, actionAuth.(authority.ActionAuthSet))
+			case "delete":
+				prev, err = param.Delete(siteID, actionAuth.(authority.ActionAuthSet))
+			default:
+				c.AbortWithError(404, errors.New("invalid method"))
 				return
 			}
-		}
 
-		var beginTime, endTime time.Time
-		if c.Query("beginTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("beginTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			beginTime = ts
-		}
-		if c.Query("endTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("endTime"))
-			if err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-				return
+
+			c.Set("loggingID", param.ID)
+			c.Set("loggingPayload", param)
+
+			//指定数据类型时重新校正受影响时段的数据
+			dataTypes := make([]string, 0)
+			if list := c.Query("dataType"); strings.TrimSpace(list) != "" {
+				dataTypes = strings.Split(list, ",")
 			}
-			endTime = ts
+
+			records := []*monitor.Calibration{prev}
+			if c.Param("method") != "delete" {
+				records = append(records, &param)
+			}
+
+			if count, err := operation.Recalibrate(siteID, actionAuth.(authority.ActionAuthSet), dataTypes, records...); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "count": count, "retMsg": err.Error()})
+			} else {
+				c.Set("json", map[string]interface{}{"retCode": 0, "count": count})
+			}
+		})
+
+	authorized.GET("environment/data/module", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		if dataModule, err := data.GetModule(c.GetString("site")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "dataModule": dataModule})
+		}
+	})
+
+	authorized.POST("environment/data/module/edit/save", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), logger(data.MODULE_DATA, "site_module", "save"), func(c *gin.Context) {
+		var param data.DataModule
+
+		if err := c.ShouldBindJSON(&param); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
 		}
 
-		if dataVacancies, err := data.GetDataVacancy(siteID, dataType, stationIDs, beginTime, endTime); err != nil {
+		if err := param.Save(c.GetString("site")); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "dataVacancies": dataVacancies})
+			c.Set("json", map[string]interface{}{"retCode": 0})
 		}
 	})
 
-	authorized.GET("environment/data/recent/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
-		siteID := c.GetString("site")
+	authorized.GET("environment/sink/module", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		if sinkModule, err := sink.GetModule(c.GetString("site")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "sinkModule": sinkModule})
+		}
+	})
 
-		actionAuth, _ := c.Get("actionAuth")
+	authorized.POST("environment/sink/module/edit/save", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), logger(sink.MODULE_SINK, "site_module", "save"), func(c *gin.Context) {
+		var param sink.SinkModule
 
-		dataType := c.Param("dataType")
+		if err := c.ShouldBindJSON(&param); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
 
-		stationIDs := make([]int, 0)
-		if idlist := c.Query("stationID"); idlist != "" {
-			parts := strings.Split(idlist, ",")
-			for _, idstr := range parts {
-				if id, err := strconv.Atoi(idstr); err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				} else {
-					stationIDs = append(stationIDs, id)
-				}
-			}
+		if err := param.Save(c.GetString("site")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			go ipcclient.NotifyModuleChange(c.GetString("site"))
+			c.Set("json", map[string]interface{}{"retCode": 0})
 		}
+	})
 
-		if recentData, err := recent.GetRecentData(siteID, actionAuth.(authority.ActionAuthSet), dataType, stationIDs...); err != nil {
+	authorized.GET("environment/sink/metrics", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		c.Set("json", map[string]interface{}{"retCode": 0, "metrics": ipcclient.RequestSinkMetrics(c.GetString("site"))})
+	})
+
+	authorized.GET("environment/data/range/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+
+		if rangeList, err := data.FetchableTables(c.GetString("site"), c.Param("dataType")); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "recentData": recentData})
+			c.Set("json", map[string]interface{}{"retCode": 0, "rangeList": rangeList})
 		}
+
 	})
 
-	authorized.GET("environment/data/quality/:target", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+	authorized.POST("environment/data/rotate", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		data.TriggerRotation(siteID, true)
+		c.Set("json", map[string]interface{}{"retCode": 0})
 
+	})
+
+	authorized.POST("environment/data/activateArchive/:dataType", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), func(c *gin.Context) {
 		siteID := c.GetString("site")
-		actionAuth, _ := c.Get("actionAuth")
 
-		var beginTime, endTime time.Time
-		if c.Query("beginTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("beginTime"))
-			if err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-				return
-			}
-			beginTime = ts
+		var param struct {
+			Table string `json:"table"`
 		}
-		if c.Query("endTime") != "" {
-			ts, err := util.ParseDateTime(c.Query("endTime"))
-			if err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-				return
-			}
-			endTime = ts
+
+		if err := c.ShouldBindJSON(&param); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
 		}
-		switch c.Param("target") {
-		case "station":
-			stationIDs := make([]int, 0)
-			if idlist := c.Query("stationID"); idlist != "" {
-				parts := strings.Split(idlist, ",")
-				for _, idstr := range parts {
-					if id, err := strconv.Atoi(idstr); err != nil {
-						c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-						return
-					} else {
-						stationIDs = append(stationIDs, id)
-					}
-				}
-			}
-			if stationDataQualities, err := stats.GetStationDataQuality(siteID, actionAuth.(authority.ActionAuthSet), &beginTime, &endTime, stationIDs...); err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-			} else {
-				c.Set("json", map[string]interface{}{"retCode": 0, "stationDataQualities": stationDataQualities})
-			}
-		case "monitor":
-			stationID, _ := strconv.Atoi(c.Query("stationID"))
-			if monitorDataQualities, err := stats.GetMonitorDataQuality(siteID, actionAuth.(authority.ActionAuthSet), stationID, &beginTime, &endTime); err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-			} else {
-				c.Set("json", map[string]interface{}{"retCode": 0, "monitorDataQualities": monitorDataQualities})
-			}
-		case "history":
-			stationIDs := make([]int, 0)
-			if idlist := c.Query("stationID"); idlist != "" {
-				parts := strings.Split(idlist, ",")
-				for _, idstr := range parts {
-					if id, err := strconv.Atoi(idstr); err != nil {
-						c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-						return
-					} else {
-						stationIDs = append(stationIDs, id)
-					}
-				}
-			}
-			if historyStationDataQualities, err := stats.GetHistoryStats(siteID, actionAuth.(authority.ActionAuthSet), stats.HISTORY_STATS_DATA_QUALITY, stats.HISTORY_STATS_INTERVAL_DAILY, beginTime, endTime, stationIDs...); err != nil {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-			} else {
-				c.Set("json", map[string]interface{}{"retCode": 0, "historyStationDataQualities": historyStationDataQualities})
-			}
-		default:
-			c.AbortWithStatus(404)
+
+		if err := data.ActivateArchive(siteID, c.Param("dataType"), param.Table); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0})
 		}
 	})
 
-	authorized.POST("environment/data/massage/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
+	authorized.GET("environment/data/retention/report", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		if reportList, err := data.Purge(c.GetString("site"), c.GetInt("uid"), true); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "reportList": reportList})
+		}
+	})
+
+	authorized.POST("environment/data/retention/purge", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), func(c *gin.Context) {
+		if reportList, err := data.Purge(c.GetString("site"), c.GetInt("uid"), false); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "reportList": reportList, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "reportList": reportList})
+		}
+	})
+
+	authorized.GET("environment/data/retention/hold", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		if holdList, err := data.GetRetentionHolds(c.GetString("site"), c.Query("dataType")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "holdList": holdList})
+		}
+	})
+
+	authorized.POST("environment/data/retention/hold/add", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), logger(data.MODULE_DATA, "retentionHold", "add"), func(c *gin.Context) {
+		var param data.RetentionHold
+
+		if err := c.ShouldBindJSON(&param); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		param.UID = c.GetInt("uid")
+
+		if err := data.AddRetentionHold(c.GetString("site"), &param); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("loggingID", param.ID)
+			c.Set("loggingPayload", param)
+			c.Set("json", map[string]interface{}{"retCode": 0, "ID": param.ID})
+		}
+	})
+
+	authorized.POST("environment/data/retention/hold/delete/:holdID", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_EDIT), logger(data.MODULE_DATA, "retentionHold", "delete"), func(c *gin.Context) {
+		holdID, err := strconv.Atoi(c.Param("holdID"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if err := data.DeleteRetentionHold(c.GetString("site"), holdID); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("loggingID", holdID)
+			c.Set("json", map[string]interface{}{"retCode": 0})
+		}
+	})
+
+	authorized.GET("environment/data/byTime/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
 		siteID := c.GetString("site")
 
 		actionAuth, _ := c.Get("actionAuth")
@@ -1456,6 +1429,19 @@ func loadEnvironment() {
 			}
 		}
 
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		for _, sid := range stationIDs {
+			if !filtered[sid] {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": fmt.Sprintf("无权限查看【%d】", sid)})
+				return
+			}
+		}
+
 		monitorIDs := make([]int, 0)
 		if idlist := c.Query("monitorID"); idlist != "" {
 			parts := strings.Split(idlist, ",")
@@ -1497,182 +1483,1504 @@ func loadEnvironment() {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
-			endTime = ts
+			endTime = ts
+		}
+
+		var criterias data.Criterias
+		if cs, exists := c.GetQuery("criteria"); exists && cs != "" {
+			if err := json.Unmarshal([]byte(cs), &criterias); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+		}
+
+		pageNo, _ := strconv.Atoi(c.Query("pageNo"))
+		pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+
+		withOriginData, _ := strconv.ParseBool(c.Query("withOriginData"))
+		withReiewed, _ := strconv.ParseBool(c.Query("withReviewed"))
+
+		cacheQuery := &querycache.Query{
+			Kind:           "byTime",
+			SiteID:         siteID,
+			DataType:       dataType,
+			StationIDs:     stationIDs,
+			MonitorIDs:     monitorIDs,
+			MonitorCodeIDs: monitorCodeIDs,
+			BeginTime:      beginTime,
+			EndTime:        endTime,
+			Params: map[string]interface{}{
+				"criteria":       criterias,
+				"withOriginData": withOriginData,
+				"withReviewed":   withReiewed,
+				"order":          c.Query("order"),
+				"pageNo":         pageNo,
+				"pageSize":       pageSize,
+			},
+		}
+		cached, seq, hit := querycache.Get(cacheQuery)
+		if hit {
+			c.Set("json", map[string]interface{}{"retCode": 0, "timeDataList": cached["timeDataList"], "total": cached["total"]})
+			return
+		}
+
+		if timeDateList, total, err := data.GetDataByTimeReadOnly(siteID, dataType, stationIDs, criterias, beginTime, endTime, withOriginData, withReiewed, c.Query("order"), pageNo, pageSize, monitorCodeIDs, monitorIDs); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			querycache.Set(cacheQuery, seq, map[string]interface{}{"timeDataList": timeDateList, "total": total})
+			c.Set("json", map[string]interface{}{"retCode": 0, "timeDataList": timeDateList, "total": total})
+		}
+	})
+
+	authorized.GET("environment/data/count/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataType := c.Param("dataType")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					stationIDs = append(stationIDs, id)
+				}
+			}
+		}
+
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		for _, sid := range stationIDs {
+			if !filtered[sid] {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+				return
+			}
+		}
+
+		monitorCodeIDs := make([]int, 0)
+		if idlist := c.Query("monitorCodeID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorCodeIDs = append(monitorCodeIDs, id)
+				}
+			}
+		}
+
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorIDs = append(monitorIDs, id)
+				}
+			}
+		}
+
+		var beginTime, endTime time.Time
+		if c.Query("beginTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = ts
+		}
+		if c.Query("endTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = ts
+		}
+
+		var criterias data.Criterias
+		if cs, exists := c.GetQuery("criteria"); exists && cs != "" {
+			if err := json.Unmarshal([]byte(cs), &criterias); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+		}
+
+		groupByTime, _ := strconv.ParseBool(c.Query("groupByTime"))
+		groupByStation, _ := strconv.ParseBool(c.Query("groupByStation"))
+		groupByMonitor, _ := strconv.ParseBool(c.Query("groupByMonitor"))
+		groupByFlag, _ := strconv.ParseBool(c.Query("groupByFlag"))
+
+		var flags []string
+		if flag, exists := c.GetQuery("flag"); exists {
+			flags = strings.Split(flag, ",")
+		}
+
+		cacheQuery := &querycache.Query{
+			Kind:           "count",
+			SiteID:         siteID,
+			DataType:       dataType,
+			StationIDs:     stationIDs,
+			MonitorIDs:     monitorIDs,
+			MonitorCodeIDs: monitorCodeIDs,
+			BeginTime:      beginTime,
+			EndTime:        endTime,
+			Params: map[string]interface{}{
+				"criteria":       criterias,
+				"flag":           flags,
+				"groupByTime":    groupByTime,
+				"groupByStation": groupByStation,
+				"groupByMonitor": groupByMonitor,
+				"groupByFlag":    groupByFlag,
+			},
+		}
+		cached, seq, hit := querycache.Get(cacheQuery)
+		if hit {
+			c.Set("json", map[string]interface{}{"retCode": 0, "count": cached["count"]})
+			return
+		}
+
+		if count, err := data.CountDataReadOnly(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, beginTime, endTime, flags, criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			querycache.Set(cacheQuery, seq, map[string]interface{}{"count": count})
+			c.Set("json", map[string]interface{}{"retCode": 0, "count": count})
+		}
+	})
+
+	authorized.GET("environment/data/cache/metrics", checkAuth(environment.MODULE_ENVIRONMENT, environment.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		if metrics, err := querycache.GetMetrics(c.GetString("site")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "enabled": querycache.Enabled(), "metrics": metrics})
+		}
+	})
+
+	authorized.GET("environment/data/aggregate/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataType := c.Param("dataType")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					stationIDs = append(stationIDs, id)
+				}
+			}
+		}
+
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		for _, sid := range stationIDs {
+			if !filtered[sid] {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+				return
+			}
+		}
+
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorIDs = append(monitorIDs, id)
+				}
+			}
+		}
+
+		monitorCodeIDs := make([]int, 0)
+		if idlist := c.Query("monitorCodeID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorCodeIDs = append(monitorCodeIDs, id)
+				}
+			}
+		}
+
+		var beginTime, endTime time.Time
+		if c.Query("beginTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = ts
+		}
+		if c.Query("endTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = ts
+		}
+
+		var flags []string
+		if flag, exists := c.GetQuery("flag"); exists {
+			flags = strings.Split(flag, ",")
+		}
+
+		var criterias data.Criterias
+		if cs, exists := c.GetQuery("criteria"); exists && cs != "" {
+			if err := json.Unmarshal([]byte(cs), &criterias); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+		}
+
+		var aggregations []string
+		if agg := c.Query("aggregation"); strings.TrimSpace(agg) != "" {
+			aggregations = strings.Split(agg, ",")
+		}
+
+		groupByStation, _ := strconv.ParseBool(c.Query("groupByStation"))
+
+		if aggregateDataList, err := data.GetAggregateData(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, criterias, beginTime, endTime, flags, c.Query("field"), c.Query("bucket"), aggregations, groupByStation); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "aggregateDataList": aggregateDataList})
+		}
+	})
+
+	authorized.GET("environment/data/list/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataType := c.Param("dataType")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					stationIDs = append(stationIDs, id)
+				}
+			}
+		}
+
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		for _, sid := range stationIDs {
+			if !filtered[sid] {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+				return
+			}
+		}
+
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorIDs = append(monitorIDs, id)
+				}
+			}
+		}
+
+		monitorCodeIDs := make([]int, 0)
+		if idlist := c.Query("monitorCodeID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorCodeIDs = append(monitorCodeIDs, id)
+				}
+			}
+		}
+
+		var beginTime, endTime time.Time
+		if c.Query("beginTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = ts
+		}
+		if c.Query("endTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = ts
+		}
+
+		var flags []string
+		if flag, exists := c.GetQuery("flag"); exists {
+			flags = strings.Split(flag, ",")
+		}
+
+		var criterias data.Criterias
+		if cs, exists := c.GetQuery("criteria"); exists && cs != "" {
+			if err := json.Unmarshal([]byte(cs), &criterias); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+		}
+
+		if dataList, err := data.GetData(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, criterias, beginTime, endTime, flags); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "dataList": dataList})
+		}
+	})
+
+	authorized.POST("environment/data/export", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		var param export.Export
+		if err := c.ShouldBindJSON(&param); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if err := param.Validate(); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if err := param.Authorize(siteID, actionAuth.(authority.ActionAuthSet)); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		async, _ := strconv.ParseBool(c.Query("async"))
+		if !async {
+			large, err := param.IsLarge(siteID)
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			async = large
+		}
+
+		if async {
+			if job, err := export.StartJob(siteID, c.GetInt("uid"), &param); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			} else {
+				c.Set("json", map[string]interface{}{"retCode": 0, "job": job, "downloadLink": fmt.Sprintf("environment/data/export/job/download/%d", job.ID)})
+			}
+			return
+		}
+
+		c.Header("Content-Type", param.ContentType())
+		c.Header("Content-Disposition", "attachment;filename="+param.FileName())
+
+		if _, err := param.Write(siteID, c.Writer); err != nil {
+			log.Println("error export data: ", siteID, err)
+		}
+	})
+
+	authorized.GET("environment/data/export/job", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		if jobList, err := export.GetJobs(c.GetString("site"), c.GetInt("uid")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "jobList": jobList})
+		}
+	})
+
+	authorized.GET("environment/data/export/job/download/:jobID", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		jobID, err := strconv.Atoi(c.Param("jobID"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		job, err := export.GetJob(siteID, jobID)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if job.UID != c.GetInt("uid") {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+			return
+		}
+
+		if job.Status != export.JOB_DONE {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": export.E_job_not_done.Error()})
+			return
+		}
+
+		c.FileAttachment(job.FilePath(siteID), job.File)
+	})
+
+	authorized.GET("environment/report/template", checkAuth(report.MODULE_REPORT, report.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		if templateList, err := report.GetTemplates(c.GetString("site")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "templateList": templateList})
+		}
+	})
+
+	authorized.POST("environment/report/template/edit/:method", checkAuth(report.MODULE_REPORT, report.ACTION_ADMIN_EDIT),
+		loggerFunc(func(c *gin.Context) (string, string, string) {
+			return report.MODULE_REPORT, "template", c.Param("method")
+		}),
+		func(c *gin.Context) {
+			siteID := c.GetString("site")
+
+			var param report.Template
+
+			err := c.ShouldBindJSON(&param)
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			switch c.Param("method") {
+			case "add":
+				err = param.Add(siteID)
+			case "update":
+				err = param.Update(siteID)
+			case "delete":
+				err = param.Delete(siteID)
+			default:
+				c.AbortWithStatus(404)
+				return
+			}
+
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			} else {
+				c.Set("loggingID", param.ID)
+				c.Set("loggingPayload", param)
+				c.Set("json", map[string]interface{}{"retCode": 0, "template": param})
+			}
+		},
+	)
+
+	authorized.POST("environment/report/generate/:templateID", checkAuth(report.MODULE_REPORT, report.ACTION_ADMIN_EDIT), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		templateID, err := strconv.Atoi(c.Param("templateID"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		t, err := report.GetTemplate(siteID, templateID)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		//未指定时间时生成上一个完整周期
+		beginTime, endTime := t.GetPeriod(time.Now())
+		if c.Query("beginTime") != "" {
+			if beginTime, err = util.ParseDateTime(c.Query("beginTime")); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+		}
+		if c.Query("endTime") != "" {
+			if endTime, err = util.ParseDateTime(c.Query("endTime")); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+		}
+
+		r, err := report.GenerateReport(siteID, t, beginTime, endTime, c.GetInt("uid"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		pushed := 0
+		if isPush, _ := strconv.ParseBool(c.Query("push")); isPush {
+			pushed = report.Deliver(siteID, r)
+		}
+
+		c.Set("json", map[string]interface{}{"retCode": 0, "report": r, "pushed": pushed})
+	})
+
+	authorized.GET("environment/report", checkAuth(report.MODULE_REPORT, report.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		templateID, _ := strconv.Atoi(c.Query("templateID"))
+
+		var beginTime, endTime *time.Time
+		if c.Query("beginTime") != "" {
+			t, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = &t
+		}
+		if c.Query("endTime") != "" {
+			t, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = &t
+		}
+
+		pageNo, _ := strconv.Atoi(c.Query("pageNo"))
+		pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+
+		if reportList, total, err := report.GetReports(siteID, templateID, beginTime, endTime, pageNo, pageSize); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "reportList": reportList, "total": total})
+		}
+	})
+
+	authorized.GET("environment/report/download/:reportID", checkAuth(report.MODULE_REPORT, report.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		reportID, err := strconv.Atoi(c.Param("reportID"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		r, err := report.GetReport(siteID, reportID)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		format := c.DefaultQuery("format", report.FORMAT_XLSX)
+		for _, file := range r.Files {
+			if strings.HasSuffix(file, "."+format) {
+				c.FileAttachment(r.FilePath(siteID, file), path.Base(file))
+				return
+			}
+		}
+
+		c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "报表格式不存在"})
+	})
+
+	authorized.POST("environment/report/delete/:reportID", checkAuth(report.MODULE_REPORT, report.ACTION_ADMIN_EDIT), logger(report.MODULE_REPORT, "report", "delete"), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		reportID, err := strconv.Atoi(c.Param("reportID"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		r, err := report.GetReport(siteID, reportID)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if err := r.Delete(siteID); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("loggingID", r.ID)
+			c.Set("loggingPayload", r)
+			c.Set("json", map[string]interface{}{"retCode": 0})
+		}
+	})
+
+	authorized.GET("environment/data/vacancy/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataType := c.Param("dataType")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					stationIDs = append(stationIDs, id)
+				}
+			}
+		}
+
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		for _, sid := range stationIDs {
+			if !filtered[sid] {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+				return
+			}
+		}
+
+		var beginTime, endTime time.Time
+		if c.Query("beginTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = ts
+		}
+		if c.Query("endTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = ts
+		}
+
+		if dataVacancies, err := data.GetDataVacancyReadOnly(siteID, dataType, stationIDs, beginTime, endTime); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "dataVacancies": dataVacancies})
+		}
+	})
+
+	authorized.GET("environment/data/recent/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataType := c.Param("dataType")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					stationIDs = append(stationIDs, id)
+				}
+			}
+		}
+
+		if recentData, err := recent.GetRecentData(siteID, actionAuth.(authority.ActionAuthSet), dataType, stationIDs...); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "recentData": recentData})
+		}
+	})
+
+	authorized.GET("environment/data/quality/:target", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+
+		siteID := c.GetString("site")
+		actionAuth, _ := c.Get("actionAuth")
+
+		var beginTime, endTime time.Time
+		if c.Query("beginTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = ts
+		}
+		if c.Query("endTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = ts
+		}
+		switch c.Param("target") {
+		case "station":
+			stationIDs := make([]int, 0)
+			if idlist := c.Query("stationID"); idlist != "" {
+				parts := strings.Split(idlist, ",")
+				for _, idstr := range parts {
+					if id, err := strconv.Atoi(idstr); err != nil {
+						c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+						return
+					} else {
+						stationIDs = append(stationIDs, id)
+					}
+				}
+			}
+			if stationDataQualities, err := stats.GetStationDataQualityReadOnly(siteID, actionAuth.(authority.ActionAuthSet), &beginTime, &endTime, stationIDs...); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			} else {
+				c.Set("json", map[string]interface{}{"retCode": 0, "stationDataQualities": stationDataQualities})
+			}
+		case "monitor":
+			stationID, _ := strconv.Atoi(c.Query("stationID"))
+			if monitorDataQualities, err := stats.GetMonitorDataQualityReadOnly(siteID, actionAuth.(authority.ActionAuthSet), stationID, &beginTime, &endTime); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			} else {
+				c.Set("json", map[string]interface{}{"retCode": 0, "monitorDataQualities": monitorDataQualities})
+			}
+		case "history":
+			stationIDs := make([]int, 0)
+			if idlist := c.Query("stationID"); idlist != "" {
+				parts := strings.Split(idlist, ",")
+				for _, idstr := range parts {
+					if id, err := strconv.Atoi(idstr); err != nil {
+						c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+						return
+					} else {
+						stationIDs = append(stationIDs, id)
+					}
+				}
+			}
+			if historyStationDataQualities, err := stats.GetHistoryStats(siteID, actionAuth.(authority.ActionAuthSet), stats.HISTORY_STATS_DATA_QUALITY, stats.HISTORY_STATS_INTERVAL_DAILY, beginTime, endTime, stationIDs...); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			} else {
+				c.Set("json", map[string]interface{}{"retCode": 0, "historyStationDataQualities": historyStationDataQualities})
+			}
+		default:
+			c.AbortWithStatus(404)
+		}
+	})
+
+	authorized.POST("environment/data/massage/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataType := c.Param("dataType")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					stationIDs = append(stationIDs, id)
+				}
+			}
+		}
+
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorIDs = append(monitorIDs, id)
+				}
+			}
+		}
+
+		monitorCodeIDs := make([]int, 0)
+		if idlist := c.Query("monitorCodeID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorCodeIDs = append(monitorCodeIDs, id)
+				}
+			}
+		}
+
+		var beginTime, endTime time.Time
+		if c.Query("beginTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = ts
+		}
+		if c.Query("endTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = ts
+		}
+
+		flag := c.Query("flag")
+
+		restoreBeforeProcess, _ := strconv.ParseBool(c.Query("restoreBeforeProcess"))
+		skipNoOrigins, _ := strconv.ParseBool(c.Query("skipNoOrigins"))
+
+		var processor dataprocess.DataProcessors
+		if err := c.ShouldBindJSON(&processor); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if count, err := operation.Massage(siteID, actionAuth.(authority.ActionAuthSet), dataType, stationIDs, monitorIDs, monitorCodeIDs, beginTime, endTime, flag, restoreBeforeProcess, skipNoOrigins, processor, c.Query("reason")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "count": count, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "count": count})
+		}
+	})
+
+	authorized.POST("environment/data/simulate/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataType := c.Param("dataType")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					stationIDs = append(stationIDs, id)
+				}
+			}
+		}
+
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorIDs = append(monitorIDs, id)
+				}
+			}
+		}
+
+		monitorCodeIDs := make([]int, 0)
+		if idlist := c.Query("monitorCodeID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				if id, err := strconv.Atoi(idstr); err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				} else {
+					monitorCodeIDs = append(monitorCodeIDs, id)
+				}
+			}
+		}
+
+		var beginTime, endTime time.Time
+		if c.Query("beginTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = ts
+		}
+		if c.Query("endTime") != "" {
+			ts, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = ts
+		}
+
+		restoreBeforeProcess, _ := strconv.ParseBool(c.Query("restoreBeforeProcess"))
+		sampleSize, _ := strconv.Atoi(c.Query("sampleSize"))
+
+		var processor dataprocess.DataProcessors
+		if err := c.ShouldBindJSON(&processor); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if simulation, err := operation.Simulate(siteID, actionAuth.(authority.ActionAuthSet), dataType, stationIDs, monitorIDs, monitorCodeIDs, beginTime, endTime, restoreBeforeProcess, processor, sampleSize); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "simulation": simulation})
+		}
+	})
+
+	authorized.POST("environment/data/upload/excel", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
+		siteID := c.GetString("site")
+		actionAuth, _ := c.Get("actionAuth")
+
+		save, _ := strconv.ParseBool(c.Query("save"))
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			log.Println("error not multipart-form: ", err)
+			c.String(500, "not multipart-form")
+			return
+		}
+
+		if timeDataList, err := upload.UploadExcel(siteID, actionAuth.(authority.ActionAuthSet), form.File, form.Value); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			if save {
+				for _, td := range timeDataList {
+					for _, list := range td.Data {
+						for _, d := range list {
+							if err := data.AddUpdate(siteID, d); err != nil {
+								c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+							}
+						}
+						operation.MarkLateData(siteID, operation.RECOMPUTE_SOURCE_EXCEL, list...)
+						operation.NotifyAggregation(siteID, list...)
+					}
+				}
+				c.Set("json", map[string]interface{}{"retCode": 0, "count": len(timeDataList)})
+			} else {
+				c.Set("json", map[string]interface{}{"retCode": 0, "timeDataList": timeDataList})
+			}
+		}
+	})
+
+	authorized.GET("environment/data/uploader", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		if dataUploaderList, err := upload.GetDataUploader(siteID, c.Query("q")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "dataUploaderList": dataUploaderList})
+		}
+	})
+
+	authorized.POST("environment/data/uploader/edit/:method", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		var param upload.DataUploader
+
+		err := c.ShouldBindJSON(&param)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+		switch c.Param("method") {
+		case "add":
+			err = param.Add(siteID)
+		case "update":
+			err = param.Update(siteID)
+		case "delete":
+			err = param.Delete(siteID)
+		default:
+			c.AbortWithError(404, errors.New("invalid method"))
+			return
+		}
+
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0})
+		}
+	})
+
+	authorized.POST("environment/data/edit/:method/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT),
+		loggerFunc(func(c *gin.Context) (string, string, string) {
+			return data.MODULE_DATA, c.Param("dataType"), c.Param("method")
+		}),
+		func(c *gin.Context) {
+			siteID := c.GetString("site")
+
+			actionAuth, _ := c.Get("actionAuth")
+
+			var param data.IData
+
+			switch c.Param("dataType") {
+			case data.REAL_TIME:
+				rtd := new(data.RealTimeData)
+				err := c.ShouldBindJSON(&rtd)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				param = rtd
+			case data.MINUTELY:
+				minutely := new(data.MinutelyData)
+				err := c.ShouldBindJSON(&minutely)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				param = minutely
+			case data.HOURLY:
+				hourly := new(data.HourlyData)
+				err := c.ShouldBindJSON(&hourly)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				param = hourly
+			case data.DAILY:
+				daily := new(data.DailyData)
+				err := c.ShouldBindJSON(&daily)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				param = daily
+			default:
+				c.AbortWithError(404, errors.New("invalid data type"))
+				return
+			}
+
+			filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), []int{param.GetStationID()}, entity.ACTION_ENTITY_EDIT)
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+
+			if !filtered[param.GetStationID()] {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
+				return
+			}
+
+			switch c.Param("method") {
+			case "add":
+				if err = data.AddUpdate(siteID, param); err == nil {
+					operation.NotifyAggregation(siteID, param)
+				}
+			case "modify":
+				fields := strings.Split(c.Query("field"), ",")
+				_, err = operation.Modify(siteID, param, fields, c.GetInt("uid"), c.Query("reason"))
+			case "delete":
+				if err = data.Delete(siteID, param); err == nil {
+					operation.NotifyAggregation(siteID, param)
+				}
+			default:
+				c.AbortWithError(404, errors.New("invalid method"))
+				return
+			}
+
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			} else {
+				recent.ClearCache(siteID, param.GetStationID())
+				c.Set("loggingID", param.GetID())
+				c.Set("loggingPayload", param)
+				c.Set("json", map[string]interface{}{"retCode": 0})
+			}
+		},
+	)
+
+	authorized.GET("environment/data/revision/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		dataID, _ := strconv.Atoi(c.Query("dataID"))
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				stationIDs = append(stationIDs, id)
+			}
+		}
+
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		stationIDs = make([]int, 0)
+		for sid, ok := range filtered {
+			if ok {
+				stationIDs = append(stationIDs, sid)
+			}
+		}
+
+		if len(stationIDs) == 0 {
+			c.Set("json", map[string]interface{}{"retCode": 0, "revisionList": []interface{}{}})
+			return
+		}
+
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				monitorIDs = append(monitorIDs, id)
+			}
+		}
+
+		var beginTime, endTime *time.Time
+		if c.Query("beginTime") != "" {
+			t, err := util.ParseDateTime(c.Query("beginTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			beginTime = &t
+		}
+		if c.Query("endTime") != "" {
+			t, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = &t
+		}
+
+		if revisionList, err := data.GetRevisions(siteID, c.Param("dataType"), dataID, stationIDs, monitorIDs, beginTime, endTime); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "revisionList": revisionList})
+		}
+	})
+
+	authorized.POST("environment/data/revision/revert/:revisionID", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT),
+		loggerFunc(func(c *gin.Context) (string, string, string) {
+			return data.MODULE_DATA, "dataRevision", "revert"
+		}),
+		func(c *gin.Context) {
+			siteID := c.GetString("site")
+
+			actionAuth, _ := c.Get("actionAuth")
+
+			revisionID, err := strconv.Atoi(c.Param("revisionID"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+
+			if d, err := operation.RevertRevision(siteID, actionAuth.(authority.ActionAuthSet), revisionID, c.Query("reason")); err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			} else {
+				recent.ClearCache(siteID, d.GetStationID())
+				c.Set("loggingID", revisionID)
+				c.Set("loggingPayload", d)
+				c.Set("json", map[string]interface{}{"retCode": 0, "data": d})
+			}
+		},
+	)
+
+	authorized.GET("environment/data/review/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_REVIEW, entity.ACTION_ENTITY_REVIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				stationIDs = append(stationIDs, id)
+			}
+		}
+
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				monitorIDs = append(monitorIDs, id)
+			}
+		}
+
+		beginTime, err := util.ParseDateTime(c.Query("beginTime"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+		endTime, err := util.ParseDateTime(c.Query("endTime"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		if list, err := operation.GetReviewData(siteID, actionAuth.(authority.ActionAuthSet), c.Param("dataType"), stationIDs, monitorIDs, beginTime, endTime, c.Query("unreviewed") == "true"); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "dataList": list})
+		}
+	})
+
+	authorized.GET("environment/data/review/:dataType/record", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_REVIEW, entity.ACTION_ENTITY_REVIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				stationIDs = append(stationIDs, id)
+			}
 		}
 
-		flag := c.Query("flag")
-
-		restoreBeforeProcess, _ := strconv.ParseBool(c.Query("restoreBeforeProcess"))
-		skipNoOrigins, _ := strconv.ParseBool(c.Query("skipNoOrigins"))
-
-		var processor dataprocess.DataProcessors
-		if err := c.ShouldBindJSON(&processor); err != nil {
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_REVIEW)
+		if err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 			return
 		}
 
-		if count, err := operation.Massage(siteID, actionAuth.(authority.ActionAuthSet), dataType, stationIDs, monitorIDs, monitorCodeIDs, beginTime, endTime, flag, restoreBeforeProcess, skipNoOrigins, processor); err != nil {
-			c.Set("json", map[string]interface{}{"retCode": 500, "count": count, "retMsg": err.Error()})
-		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "count": count})
+		stationIDs = make([]int, 0)
+		for sid, ok := range filtered {
+			if ok {
+				stationIDs = append(stationIDs, sid)
+			}
 		}
-	})
-
-	authorized.POST("environment/data/upload/excel", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
-		siteID := c.GetString("site")
-		actionAuth, _ := c.Get("actionAuth")
-
-		save, _ := strconv.ParseBool(c.Query("save"))
 
-		form, err := c.MultipartForm()
-		if err != nil {
-			log.Println("error not multipart-form: ", err)
-			c.String(500, "not multipart-form")
+		if len(stationIDs) == 0 {
+			c.Set("json", map[string]interface{}{"retCode": 0, "reviewList": []interface{}{}})
 			return
 		}
 
-		if timeDataList, err := upload.UploadExcel(siteID, actionAuth.(authority.ActionAuthSet), form.File, form.Value); err != nil {
-			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-		} else {
-			if save {
-				for _, td := range timeDataList {
-					for _, list := range td.Data {
-						for _, d := range list {
-							if err := data.AddUpdate(siteID, d); err != nil {
-								c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-							}
-						}
-					}
+		monitorIDs := make([]int, 0)
+		if idlist := c.Query("monitorID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
 				}
-				c.Set("json", map[string]interface{}{"retCode": 0, "count": len(timeDataList)})
-			} else {
-				c.Set("json", map[string]interface{}{"retCode": 0, "timeDataList": timeDataList})
+				monitorIDs = append(monitorIDs, id)
 			}
 		}
-	})
 
-	authorized.GET("environment/data/uploader", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
-		siteID := c.GetString("site")
+		beginTime, err := util.ParseDateTime(c.Query("beginTime"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+		endTime, err := util.ParseDateTime(c.Query("endTime"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
 
-		if dataUploaderList, err := upload.GetDataUploader(siteID, c.Query("q")); err != nil {
+		if list, err := data.GetReviewRecords(siteID, c.Param("dataType"), stationIDs, monitorIDs, beginTime, endTime); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0, "dataUploaderList": dataUploaderList})
+			c.Set("json", map[string]interface{}{"retCode": 0, "reviewList": list})
 		}
 	})
 
-	authorized.POST("environment/data/uploader/edit/:method", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT), func(c *gin.Context) {
+	authorized.GET("environment/data/review/:dataType/progress", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_REVIEW, entity.ACTION_ENTITY_REVIEW), func(c *gin.Context) {
 		siteID := c.GetString("site")
 
-		var param upload.DataUploader
+		actionAuth, _ := c.Get("actionAuth")
 
-		err := c.ShouldBindJSON(&param)
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				stationIDs = append(stationIDs, id)
+			}
+		}
+
+		beginTime, err := util.ParseDateTime(c.Query("beginTime"))
 		if err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 			return
 		}
-		switch c.Param("method") {
-		case "add":
-			err = param.Add(siteID)
-		case "update":
-			err = param.Update(siteID)
-		case "delete":
-			err = param.Delete(siteID)
-		default:
-			c.AbortWithError(404, errors.New("invalid method"))
+		endTime, err := util.ParseDateTime(c.Query("endTime"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 			return
 		}
 
-		if err != nil {
+		if progress, err := operation.GetReviewProgress(siteID, actionAuth.(authority.ActionAuthSet), c.Param("dataType"), stationIDs, beginTime, endTime); err != nil {
 			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 		} else {
-			c.Set("json", map[string]interface{}{"retCode": 0})
+			c.Set("json", map[string]interface{}{"retCode": 0, "progress": progress})
 		}
 	})
 
-	authorized.POST("environment/data/edit/:method/:dataType", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_EDIT, entity.ACTION_ENTITY_EDIT),
+	authorized.POST("environment/data/review/:dataType/:action", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_REVIEW, entity.ACTION_ENTITY_REVIEW),
 		loggerFunc(func(c *gin.Context) (string, string, string) {
-			return data.MODULE_DATA, c.Param("dataType"), c.Param("method")
+			return data.MODULE_DATA, "dataReview", c.Param("action")
 		}),
 		func(c *gin.Context) {
 			siteID := c.GetString("site")
 
 			actionAuth, _ := c.Get("actionAuth")
 
-			var param data.IData
+			items := make([]data.IData, 0)
 
 			switch c.Param("dataType") {
-			case data.REAL_TIME:
-				rtd := new(data.RealTimeData)
-				err := c.ShouldBindJSON(&rtd)
-				if err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				}
-				param = rtd
-			case data.MINUTELY:
-				minutely := new(data.MinutelyData)
-				err := c.ShouldBindJSON(&minutely)
-				if err != nil {
-					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-					return
-				}
-				param = minutely
 			case data.HOURLY:
-				hourly := new(data.HourlyData)
-				err := c.ShouldBindJSON(&hourly)
-				if err != nil {
+				param := make([]*data.HourlyData, 0)
+				if err := c.ShouldBindJSON(&param); err != nil {
 					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 					return
 				}
-				param = hourly
+				for _, d := range param {
+					items = append(items, d)
+				}
 			case data.DAILY:
-				daily := new(data.DailyData)
-				err := c.ShouldBindJSON(&daily)
-				if err != nil {
+				param := make([]*data.DailyData, 0)
+				if err := c.ShouldBindJSON(&param); err != nil {
 					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 					return
 				}
-				param = daily
+				for _, d := range param {
+					items = append(items, d)
+				}
 			default:
 				c.AbortWithError(404, errors.New("invalid data type"))
 				return
 			}
 
-			filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), []int{param.GetStationID()}, entity.ACTION_ENTITY_EDIT)
+			fields := make([]string, 0)
+			if c.Query("field") != "" {
+				fields = strings.Split(c.Query("field"), ",")
+			}
+
+			count, err := operation.Review(siteID, actionAuth.(authority.ActionAuthSet), c.Param("dataType"), c.Param("action"), items, fields, c.Query("reason"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
 				return
 			}
 
-			if !filtered[param.GetStationID()] {
-				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": "无权限"})
-				return
+			cleared := make(map[int]bool)
+			for _, d := range items {
+				if !cleared[d.GetStationID()] {
+					cleared[d.GetStationID()] = true
+					recent.ClearCache(siteID, d.GetStationID())
+				}
 			}
 
-			switch c.Param("method") {
-			case "add":
-				err = data.AddUpdate(siteID, param)
-			case "modify":
-				fields := strings.Split(c.Query("field"), ",")
-				_, err = operation.Modify(siteID, param, fields, c.GetInt("uid"))
-			case "delete":
-				err = data.Delete(siteID, param)
-			default:
-				c.AbortWithError(404, errors.New("invalid method"))
-				return
+			c.Set("loggingPayload", items)
+			c.Set("json", map[string]interface{}{"retCode": 0, "count": count})
+		},
+	)
+
+	authorized.GET("environment/data/recompute", checkAuth(entity.MODULE_ENTITY, entity.ACTION_ADMIN_VIEW, entity.ACTION_ENTITY_VIEW), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		actionAuth, _ := c.Get("actionAuth")
+
+		stationIDs := make([]int, 0)
+		if idlist := c.Query("stationID"); idlist != "" {
+			parts := strings.Split(idlist, ",")
+			for _, idstr := range parts {
+				id, err := strconv.Atoi(idstr)
+				if err != nil {
+					c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+					return
+				}
+				stationIDs = append(stationIDs, id)
+			}
+		}
+
+		filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth.(authority.ActionAuthSet), stationIDs, entity.ACTION_ENTITY_VIEW)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		stationIDs = make([]int, 0)
+		for sid, ok := range filtered {
+			if ok {
+				stationIDs = append(stationIDs, sid)
 			}
+		}
+
+		if len(stationIDs) == 0 {
+			c.Set("json", map[string]interface{}{"retCode": 0, "recomputeList": []interface{}{}})
+			return
+		}
+
+		status := make([]string, 0)
+		if c.Query("status") != "" {
+			status = strings.Split(c.Query("status"), ",")
+		}
 
+		var beginTime, endTime *time.Time
+		if c.Query("beginTime") != "" {
+			t, err := util.ParseDateTime(c.Query("beginTime"))
 			if err != nil {
 				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
-			} else {
-				recent.ClearCache(siteID, param.GetStationID())
-				c.Set("loggingID", param.GetID())
-				c.Set("loggingPayload", param)
-				c.Set("json", map[string]interface{}{"retCode": 0})
+				return
 			}
-		},
-	)
+			beginTime = &t
+		}
+		if c.Query("endTime") != "" {
+			t, err := util.ParseDateTime(c.Query("endTime"))
+			if err != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+				return
+			}
+			endTime = &t
+		}
+
+		if list, err := operation.GetRecomputes(siteID, status, stationIDs, beginTime, endTime); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "recomputeList": list})
+		}
+	})
 
 	authorized.GET("environment/subscription/module", checkAuth(subscription.MODULE_SUBSCRIPTION, subscription.ACTION_ADMIN_VIEW), func(c *gin.Context) {
 		siteID := c.GetString("site")
@@ -1862,4 +3170,98 @@ func loadEnvironment() {
 
 	})
 
+	authorized.GET("environment/externalsource/connector", checkAuth(externalsource.MODULE_EXTERNALSOURCE, externalsource.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		if connectorList, err := externalsource.GetConnectors(c.GetString("site")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "connectorList": connectorList})
+		}
+	})
+
+	authorized.POST("environment/externalsource/connector/edit/:method", checkAuth(externalsource.MODULE_EXTERNALSOURCE, externalsource.ACTION_ADMIN_EDIT), loggerFunc(func(c *gin.Context) (string, string, string) {
+		return externalsource.MODULE_EXTERNALSOURCE, "connector", c.Param("method")
+	}), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		var param externalsource.Connector
+
+		err := c.ShouldBindJSON(&param)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		switch c.Param("method") {
+		case "add":
+			err = param.Add(siteID)
+		case "update":
+			err = param.Update(siteID)
+		case "delete":
+			err = param.Delete(siteID)
+		default:
+			c.AbortWithStatus(404)
+			return
+		}
+
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("loggingID", param.ID)
+			c.Set("loggingPayload", param)
+			c.Set("json", map[string]interface{}{"retCode": 0, "connector": param})
+		}
+	})
+
+	authorized.POST("environment/externalsource/connector/sync/:connectorID", checkAuth(externalsource.MODULE_EXTERNALSOURCE, externalsource.ACTION_ADMIN_EDIT), func(c *gin.Context) {
+		siteID := c.GetString("site")
+
+		connectorID, err := strconv.Atoi(c.Param("connectorID"))
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		var param struct {
+			BeginTime *util.Time `json:"beginTime"`
+			EndTime   *util.Time `json:"endTime"`
+		}
+		if err := c.ShouldBindJSON(&param); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		connector, err := externalsource.GetConnector(siteID, connectorID)
+		if err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+			return
+		}
+
+		//未指定时按回溯周期同步
+		beginTime, endTime := connector.GetSyncWindow(time.Now())
+		if param.BeginTime != nil {
+			beginTime = time.Time(*param.BeginTime)
+		}
+		if param.EndTime != nil {
+			endTime = time.Time(*param.EndTime)
+		}
+
+		if syncLog, err := connector.Sync(siteID, beginTime, endTime, c.GetInt("uid")); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error(), "syncLog": syncLog})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "syncLog": syncLog})
+		}
+	})
+
+	authorized.GET("environment/externalsource/connector/synclog", checkAuth(externalsource.MODULE_EXTERNALSOURCE, externalsource.ACTION_ADMIN_VIEW), func(c *gin.Context) {
+		connectorID, _ := strconv.Atoi(c.Query("connectorID"))
+		pageNo, _ := strconv.Atoi(c.Query("pageNo"))
+		pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+
+		if syncLogList, total, err := externalsource.GetSyncLogs(c.GetString("site"), connectorID, pageNo, pageSize); err != nil {
+			c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": err.Error()})
+		} else {
+			c.Set("json", map[string]interface{}{"retCode": 0, "syncLogList": syncLogList, "total": total})
+		}
+	})
+
 }
@@ -0,0 +1,341 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+)
+
+const (
+	BUCKET_HOUR  = "hour"
+	BUCKET_DAY   = "day"
+	BUCKET_WEEK  = "week"
+	BUCKET_MONTH = "month"
+	BUCKET_YEAR  = "year"
+)
+
+const (
+	AGGREGATE_AVG   = "avg"
+	AGGREGATE_MIN   = "min"
+	AGGREGATE_MAX   = "max"
+	AGGREGATE_SUM   = "sum"
+	AGGREGATE_COUNT = "count"
+	AGGREGATE_P50   = "p50"
+	AGGREGATE_P90   = "p90"
+	AGGREGATE_P98   = "p98"
+)
+
+var percentiles = map[string]float64{
+	AGGREGATE_P50: 0.5,
+	AGGREGATE_P90: 0.9,
+	AGGREGATE_P98: 0.98,
+}
+
+var e_invalid_bucket = errors.New("聚合周期不正确")
+var e_invalid_aggregation = errors.New("聚合方式不正确")
+var e_invalid_aggregate_field = errors.New("聚合数据段不正确")
+var e_real_time_aggregate_range_restricted = errors.New("实时数据的聚合时间跨度最多31天")
+var e_minutely_aggregate_range_restricted = errors.New("分钟数据的聚合时间跨度最多31天")
+
+type AggregateData struct {
+	Bucket    util.Time          `json:"bucket"`
+	StationID int                `json:"stationID,omitempty"`
+	MonitorID int                `json:"monitorID"`
+	Values    map[string]float64 `json:"values"`
+}
+
+type aggregator struct {
+	count  int
+	sum    float64
+	min    float64
+	max    float64
+	values []float64
+}
+
+func (a *aggregator) add(value float64, keepValues bool) {
+	if a.count == 0 || value < a.min {
+		a.min = value
+	}
+	if a.count == 0 || value > a.max {
+		a.max = value
+	}
+	a.count++
+	a.sum += value
+	if keepValues {
+		a.values = append(a.values, value)
+	}
+}
+
+func (a *aggregator) result(aggregations []string) map[string]float64 {
+	result := make(map[string]float64)
+
+	if len(a.values) > 0 {
+		sort.Float64s(a.values)
+	}
+
+	for _, agg := range aggregations {
+		switch agg {
+		case AGGREGATE_AVG:
+			result[agg] = a.sum / float64(a.count)
+		case AGGREGATE_MIN:
+			result[agg] = a.min
+		case AGGREGATE_MAX:
+			result[agg] = a.max
+		case AGGREGATE_SUM:
+			result[agg] = a.sum
+		case AGGREGATE_COUNT:
+			result[agg] = float64(a.count)
+		default:
+			if p, exists := percentiles[agg]; exists {
+				result[agg] = percentile(a.values, p)
+			}
+		}
+	}
+
+	return result
+}
+
+//按HJ 663的百分位数计算方法 sorted需已升序排列
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	k := 1 + float64(len(sorted)-1)*p
+	s := math.Floor(k)
+	index := int(s) - 1
+
+	if index+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+
+	return sorted[index] + (sorted[index+1]-sorted[index])*(k-s)
+}
+
+func truncateBucket(t time.Time, bucket string) time.Time {
+	year, month, day := t.Date()
+
+	switch bucket {
+	case BUCKET_HOUR:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case BUCKET_DAY:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case BUCKET_WEEK:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case BUCKET_MONTH:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case BUCKET_YEAR:
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+
+	return t
+}
+
+func validateAggregations(aggregations []string) (keepValues bool, err error) {
+	if len(aggregations) == 0 {
+		return false, e_invalid_aggregation
+	}
+
+	for _, agg := range aggregations {
+		switch agg {
+		case AGGREGATE_AVG:
+		case AGGREGATE_MIN:
+		case AGGREGATE_MAX:
+		case AGGREGATE_SUM:
+		case AGGREGATE_COUNT:
+		default:
+			if _, exists := percentiles[agg]; !exists {
+				return false, e_invalid_aggregation
+			}
+			keepValues = true
+		}
+	}
+
+	return
+}
+
+//每张表查询完即关闭结果集 未上报的字段为NULL 不参与统计
+func collectAggregateRows(siteID, SQL string, values []interface{}, collect func(stationID, monitorID int, dataTime time.Time, value float64)) error {
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
+	if err != nil {
+		log.Println("error get aggregate data: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sid, mid int
+		var dataTime time.Time
+		var value sql.NullFloat64
+
+		if err := rows.Scan(&sid, &mid, &dataTime, &value); err != nil {
+			log.Println("error get aggregate data: ", err)
+			return err
+		}
+		if !value.Valid {
+			continue
+		}
+
+		collect(sid, mid, dataTime, value.Float64)
+	}
+
+	return rows.Err()
+}
+
+func GetAggregateData(siteID, dataType string, stationID, monitorID, monitorCodeID []int, criterias Criterias, beginTime, endTime time.Time, flag []string, field, bucket string, aggregations []string, groupByStation bool) ([]*AggregateData, error) {
+
+	result := make([]*AggregateData, 0)
+
+	if len(stationID) == 0 {
+		return result, nil
+	}
+
+	if beginTime.IsZero() || endTime.IsZero() {
+		return nil, e_need_datatime
+	}
+
+	if beginTime.After(endTime) {
+		return result, nil
+	}
+
+	switch bucket {
+	case BUCKET_HOUR:
+	case BUCKET_DAY:
+	case BUCKET_WEEK:
+	case BUCKET_MONTH:
+	case BUCKET_YEAR:
+	default:
+		return nil, e_invalid_bucket
+	}
+
+	keepValues, err := validateAggregations(aggregations)
+	if err != nil {
+		return nil, err
+	}
+
+	switch dataType {
+	case REAL_TIME:
+		if endTime.Sub(beginTime).Hours() > 24*31 {
+			return nil, e_real_time_aggregate_range_restricted
+		}
+		field = RTD
+	case MINUTELY:
+		if endTime.Sub(beginTime).Hours() > 24*31 {
+			return nil, e_minutely_aggregate_range_restricted
+		}
+		fallthrough
+	case HOURLY:
+		fallthrough
+	case DAILY:
+		if field == "" {
+			field = AVG
+		}
+		valid := false
+		for _, c := range IntervalColumn {
+			if c == field {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, e_invalid_aggregate_field
+		}
+	default:
+		return nil, e_invalid_data_type
+	}
+
+	m, err := GetModule(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	whereStmts, values := buildDataWhere(m, dataType, stationID, monitorID, monitorCodeID, beginTime, endTime, flag, criterias)
+
+	type aggregateKey struct {
+		bucket    time.Time
+		stationID int
+		monitorID int
+	}
+
+	aggregators := make(map[aggregateKey]*aggregator)
+
+	collect := func(sid, mid int, dataTime time.Time, value float64) {
+		key := aggregateKey{bucket: truncateBucket(dataTime, bucket), monitorID: mid}
+		if groupByStation {
+			key.stationID = sid
+		}
+
+		a, exists := aggregators[key]
+		if !exists {
+			a = new(aggregator)
+			aggregators[key] = a
+		}
+		a.add(value, keepValues)
+	}
+
+	var tables []string
+	if m.TSDB.queryable() {
+		list, err := m.TSDB.fetch(siteID, m.MonitorField, dataType, stationID, monitorID, monitorCodeID, flag, beginTime, endTime, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(criterias) > 0 {
+			list = criterias.FilterData(list, false)
+		}
+		for _, d := range list {
+			mid := d.GetMonitorID()
+			if m.MonitorField == MONITOR_CODE_ID {
+				mid = d.GetMonitorCodeID()
+			}
+			collect(d.GetStationID(), mid, time.Time(d.GetDataTime()), tsdbValue(d, field))
+		}
+	} else {
+		tables = FetchTableNames(siteID, dataType, beginTime, endTime)
+	}
+
+	for _, table := range tables {
+		SQL := fmt.Sprintf(`
+			SELECT
+				data.%s, data.%s, data.%s, data.%s
+			FROM
+				%s data
+			WHERE
+				%s
+		`, STATION_ID, m.MonitorField, DATA_TIME, field, table, strings.Join(whereStmts, " AND "))
+
+		if err := collectAggregateRows(siteID, SQL, values, collect); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, a := range aggregators {
+		entry := new(AggregateData)
+		entry.Bucket = util.Time(key.bucket)
+		entry.StationID = key.stationID
+		entry.MonitorID = key.monitorID
+		entry.Values = a.result(aggregations)
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		bi, bj := time.Time(result[i].Bucket), time.Time(result[j].Bucket)
+		if !bi.Equal(bj) {
+			return bi.Before(bj)
+		}
+		if result[i].StationID != result[j].StationID {
+			return result[i].StationID < result[j].StationID
+		}
+		return result[i].MonitorID < result[j].MonitorID
+	})
+
+	return result, nil
+}
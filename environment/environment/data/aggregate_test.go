@@ -0,0 +1,147 @@
+package data
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	cases := []struct {
+		sorted []float64
+		p      float64
+		expect float64
+	}{
+		{nil, 0.5, 0},
+		{[]float64{3}, 0.98, 3},
+		{[]float64{1, 2, 3, 4}, 0.5, 2.5},
+		{[]float64{1, 2, 3, 4, 5}, 0.5, 3},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0.9, 9.1},
+		{[]float64{1, 2}, 1, 2},
+	}
+
+	for _, c := range cases {
+		if v := percentile(c.sorted, c.p); math.Abs(v-c.expect) > 1e-9 {
+			t.Errorf("percentile(%v, %v) = %v, expect %v", c.sorted, c.p, v, c.expect)
+		}
+	}
+}
+
+func TestTruncateBucket(t *testing.T) {
+	//2024-01-03为周三
+	input := time.Date(2024, 1, 3, 15, 42, 10, 0, time.Local)
+
+	cases := []struct {
+		bucket string
+		expect time.Time
+	}{
+		{BUCKET_HOUR, time.Date(2024, 1, 3, 15, 0, 0, 0, time.Local)},
+		{BUCKET_DAY, time.Date(2024, 1, 3, 0, 0, 0, 0, time.Local)},
+		{BUCKET_WEEK, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+		{BUCKET_MONTH, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+		{BUCKET_YEAR, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+	}
+
+	for _, c := range cases {
+		if v := truncateBucket(input, c.bucket); !v.Equal(c.expect) {
+			t.Errorf("truncateBucket(%s) = %v, expect %v", c.bucket, v, c.expect)
+		}
+	}
+
+	//周日归入所在周的周一
+	if v := truncateBucket(time.Date(2024, 1, 7, 1, 0, 0, 0, time.Local), BUCKET_WEEK); !v.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("truncateBucket(sunday) = %v", v)
+	}
+}
+
+func TestAggregatorResult(t *testing.T) {
+	_, err := validateAggregations(nil)
+	if err != e_invalid_aggregation {
+		t.Error("empty aggregations should be invalid")
+	}
+	if _, err := validateAggregations([]string{AGGREGATE_AVG, "p75"}); err != e_invalid_aggregation {
+		t.Error("unknown percentile should be invalid")
+	}
+
+	aggregations := []string{AGGREGATE_AVG, AGGREGATE_MIN, AGGREGATE_MAX, AGGREGATE_SUM, AGGREGATE_COUNT, AGGREGATE_P50}
+	keepValues, err := validateAggregations(aggregations)
+	if err != nil || !keepValues {
+		t.Fatal("percentile aggregation should keep values: ", err)
+	}
+
+	a := new(aggregator)
+	for _, v := range []float64{4, -1, 7, 2} {
+		a.add(v, keepValues)
+	}
+
+	expect := map[string]float64{
+		AGGREGATE_AVG:   3,
+		AGGREGATE_MIN:   -1,
+		AGGREGATE_MAX:   7,
+		AGGREGATE_SUM:   12,
+		AGGREGATE_COUNT: 4,
+		AGGREGATE_P50:   3,
+	}
+	if result := a.result(aggregations); !reflect.DeepEqual(result, expect) {
+		t.Errorf("aggregator result %v, expect %v", result, expect)
+	}
+}
+
+func TestBuildDataWhere(t *testing.T) {
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	end := begin.Add(time.Hour)
+
+	cases := []struct {
+		name          string
+		monitorField  string
+		stationID     []int
+		monitorID     []int
+		monitorCodeID []int
+		beginTime     time.Time
+		flag          []string
+		expectWhere   string
+		expectValues  []interface{}
+	}{
+		{
+			name:         "single station",
+			monitorField: MONITOR_ID,
+			stationID:    []int{1},
+			beginTime:    begin,
+			expectWhere:  "data.station_id = ? AND data.data_time >= ? AND data.data_time <= ?",
+			expectValues: []interface{}{1, begin, end},
+		},
+		{
+			name:         "monitor and flag",
+			monitorField: MONITOR_ID,
+			stationID:    []int{1, 2},
+			monitorID:    []int{3},
+			beginTime:    begin,
+			flag:         []string{"N", "T"},
+			expectWhere:  "data.station_id IN (?,?) AND data.monitor_id = ? AND data.data_time >= ? AND data.data_time <= ? AND data.flag IN (?,?)",
+			expectValues: []interface{}{1, 2, 3, begin, end, "N", "T"},
+		},
+		{
+			name:          "monitor code field ignores monitor id",
+			monitorField:  MONITOR_CODE_ID,
+			stationID:     []int{1},
+			monitorID:     []int{3},
+			monitorCodeID: []int{5, 6},
+			beginTime:     end,
+			expectWhere:   "data.station_id = ? AND data.monitor_code_id IN (?,?) AND data.data_time = ?",
+			expectValues:  []interface{}{1, 5, 6, end},
+		},
+	}
+
+	for _, c := range cases {
+		m := &DataModule{MonitorField: c.monitorField}
+		whereStmts, values := buildDataWhere(m, HOURLY, c.stationID, c.monitorID, c.monitorCodeID, c.beginTime, end, c.flag, nil)
+		if where := strings.Join(whereStmts, " AND "); where != c.expectWhere {
+			t.Errorf("%s: where %s, expect %s", c.name, where, c.expectWhere)
+		}
+		if !reflect.DeepEqual(values, c.expectValues) {
+			t.Errorf("%s: values %v, expect %v", c.name, values, c.expectValues)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package data
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	//设备上传数据优先 仅补缺及覆盖本系统生成的数据
+	AGGREGATION_POLICY_DEVICE = "device"
+	//本系统生成数据优先 设备上传数据将被重新计算覆盖
+	AGGREGATION_POLICY_AGGREGATE = "aggregate"
+	AGGREGATION_POLICY_OFF       = "off"
+
+	//原始数据中标记为本系统聚合生成
+	ORIGIN_AGGREGATED = "aggregated"
+)
+
+var e_invalid_aggregation_policy = errors.New("聚合策略不正确")
+var e_invalid_aggregation_data_type = errors.New("聚合数据类型不正确")
+
+//由下级数据聚合生成分钟/小时/日数据
+type Aggregation struct {
+	DataTypes        []string       `json:"dataTypes"`
+	MinutelyInterval int            `json:"minutelyInterval,omitempty"`
+	DelayMin         int            `json:"delayMin,omitempty"`
+	Policy           string         `json:"policy"`
+	StationPolicies  map[int]string `json:"stationPolicies,omitempty"`
+}
+
+func (a *Aggregation) validate() error {
+	for _, dt := range a.DataTypes {
+		switch dt {
+		case MINUTELY, HOURLY, DAILY:
+		default:
+			return e_invalid_aggregation_data_type
+		}
+	}
+
+	if a.MinutelyInterval < 0 || a.DelayMin < 0 {
+		return errors.New("聚合时长不正确")
+	}
+
+	policies := []string{a.Policy}
+	for _, p := range a.StationPolicies {
+		policies = append(policies, p)
+	}
+	for _, p := range policies {
+		switch p {
+		case "", AGGREGATION_POLICY_DEVICE, AGGREGATION_POLICY_AGGREGATE, AGGREGATION_POLICY_OFF:
+		default:
+			return e_invalid_aggregation_policy
+		}
+	}
+
+	return nil
+}
+
+func (a *Aggregation) GetPolicy(stationID int) string {
+	if p, exists := a.StationPolicies[stationID]; exists && p != "" {
+		return p
+	}
+	if a.Policy == "" {
+		return AGGREGATION_POLICY_DEVICE
+	}
+	return a.Policy
+}
+
+func (a *Aggregation) IsEnabled(dataType string) bool {
+	for _, dt := range a.DataTypes {
+		if dt == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+//数据所属的聚合周期 返回周期起止时间
+func (a *Aggregation) GetPeriod(dataType string, dataTime time.Time) (time.Time, time.Time) {
+	switch dataType {
+	case DAILY:
+		Y, M, D := dataTime.Date()
+		begin := time.Date(Y, M, D, 0, 0, 0, 0, dataTime.Location())
+		return begin, begin.AddDate(0, 0, 1)
+	case MINUTELY:
+		step := getStep(MINUTELY)
+		if a.MinutelyInterval > 0 {
+			step = time.Minute * time.Duration(a.MinutelyInterval)
+		}
+		begin := dataTime.Truncate(step)
+		return begin, begin.Add(step)
+	default:
+		begin := dataTime.Truncate(time.Hour)
+		return begin, begin.Add(time.Hour)
+	}
+}
+
+//聚合来源数据类型
+func GetAggregationSource(dataType string) string {
+	switch dataType {
+	case MINUTELY:
+		return REAL_TIME
+	case HOURLY:
+		return MINUTELY
+	case DAILY:
+		return HOURLY
+	}
+	return ""
+}
+
+//以该类型数据为来源的聚合数据类型
+func GetAggregationTarget(dataType string) string {
+	switch dataType {
+	case REAL_TIME:
+		return MINUTELY
+	case MINUTELY:
+		return HOURLY
+	case HOURLY:
+		return DAILY
+	}
+	return ""
+}
+
+func IsAggregated(d IData) bool {
+	d.RLockOriginData()
+	defer d.RUnlockOriginData()
+
+	if d.GetOriginData() == nil {
+		return false
+	}
+	aggregated, _ := d.GetOriginData()[ORIGIN_AGGREGATED].(bool)
+	return aggregated
+}
+
+func SetAggregated(d IData) {
+	d.LockOriginData()
+	defer d.UnLockOriginData()
+
+	originData := d.GetOriginData()
+	if originData == nil {
+		originData = make(map[string]interface{})
+	}
+	originData[ORIGIN_AGGREGATED] = true
+	d.SetOriginData(originData)
+}
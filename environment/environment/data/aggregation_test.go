@@ -0,0 +1,105 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregationValidate(t *testing.T) {
+	cases := []struct {
+		aggregation *Aggregation
+		expect      bool
+	}{
+		{&Aggregation{DataTypes: []string{MINUTELY, HOURLY, DAILY}}, true},
+		{&Aggregation{DataTypes: []string{REAL_TIME}}, false},
+		{&Aggregation{DataTypes: []string{HOURLY}, DelayMin: -1}, false},
+		{&Aggregation{DataTypes: []string{HOURLY}, Policy: AGGREGATION_POLICY_AGGREGATE}, true},
+		{&Aggregation{DataTypes: []string{HOURLY}, Policy: "unknown"}, false},
+		{&Aggregation{DataTypes: []string{HOURLY}, StationPolicies: map[int]string{1: AGGREGATION_POLICY_OFF}}, true},
+		{&Aggregation{DataTypes: []string{HOURLY}, StationPolicies: map[int]string{1: "unknown"}}, false},
+	}
+
+	for i, c := range cases {
+		if err := c.aggregation.validate(); (err == nil) != c.expect {
+			t.Errorf("case %d: validate = %v, expect valid %v", i, err, c.expect)
+		}
+	}
+}
+
+func TestAggregationGetPolicy(t *testing.T) {
+	cases := []struct {
+		aggregation *Aggregation
+		stationID   int
+		expect      string
+	}{
+		{&Aggregation{}, 1, AGGREGATION_POLICY_DEVICE},
+		{&Aggregation{Policy: AGGREGATION_POLICY_AGGREGATE}, 1, AGGREGATION_POLICY_AGGREGATE},
+		{&Aggregation{Policy: AGGREGATION_POLICY_AGGREGATE, StationPolicies: map[int]string{1: AGGREGATION_POLICY_OFF}}, 1, AGGREGATION_POLICY_OFF},
+		{&Aggregation{Policy: AGGREGATION_POLICY_AGGREGATE, StationPolicies: map[int]string{1: AGGREGATION_POLICY_OFF}}, 2, AGGREGATION_POLICY_AGGREGATE},
+		{&Aggregation{StationPolicies: map[int]string{1: ""}}, 1, AGGREGATION_POLICY_DEVICE},
+	}
+
+	for i, c := range cases {
+		if p := c.aggregation.GetPolicy(c.stationID); p != c.expect {
+			t.Errorf("case %d: GetPolicy(%d) = %s, expect %s", i, c.stationID, p, c.expect)
+		}
+	}
+
+	a := &Aggregation{DataTypes: []string{HOURLY}}
+	if !a.IsEnabled(HOURLY) || a.IsEnabled(DAILY) {
+		t.Error("IsEnabled should follow data types")
+	}
+}
+
+func TestAggregationGetPeriod(t *testing.T) {
+	dataTime := time.Date(2024, 1, 3, 15, 42, 10, 0, time.Local)
+
+	cases := []struct {
+		minutelyInterval int
+		dataType         string
+		begin            time.Time
+		end              time.Time
+	}{
+		{0, MINUTELY, time.Date(2024, 1, 3, 15, 40, 0, 0, time.Local), time.Date(2024, 1, 3, 15, 50, 0, 0, time.Local)},
+		{5, MINUTELY, time.Date(2024, 1, 3, 15, 40, 0, 0, time.Local), time.Date(2024, 1, 3, 15, 45, 0, 0, time.Local)},
+		{0, HOURLY, time.Date(2024, 1, 3, 15, 0, 0, 0, time.Local), time.Date(2024, 1, 3, 16, 0, 0, 0, time.Local)},
+		{0, DAILY, time.Date(2024, 1, 3, 0, 0, 0, 0, time.Local), time.Date(2024, 1, 4, 0, 0, 0, 0, time.Local)},
+	}
+
+	for _, c := range cases {
+		a := &Aggregation{MinutelyInterval: c.minutelyInterval}
+		begin, end := a.GetPeriod(c.dataType, dataTime)
+		if !begin.Equal(c.begin) || !end.Equal(c.end) {
+			t.Errorf("GetPeriod(%s, interval %d) = %v - %v, expect %v - %v", c.dataType, c.minutelyInterval, begin, end, c.begin, c.end)
+		}
+	}
+}
+
+func TestAggregationSourceTarget(t *testing.T) {
+	chain := []string{REAL_TIME, MINUTELY, HOURLY, DAILY}
+	for i := 1; i < len(chain); i++ {
+		if s := GetAggregationSource(chain[i]); s != chain[i-1] {
+			t.Errorf("GetAggregationSource(%s) = %s, expect %s", chain[i], s, chain[i-1])
+		}
+		if target := GetAggregationTarget(chain[i-1]); target != chain[i] {
+			t.Errorf("GetAggregationTarget(%s) = %s, expect %s", chain[i-1], target, chain[i])
+		}
+	}
+	if s := GetAggregationSource(REAL_TIME); s != "" {
+		t.Errorf("real time should have no source: %s", s)
+	}
+	if target := GetAggregationTarget(DAILY); target != "" {
+		t.Errorf("daily should have no target: %s", target)
+	}
+}
+
+func TestAggregated(t *testing.T) {
+	d := new(HourlyData)
+	if IsAggregated(d) {
+		t.Error("data without origin should not be aggregated")
+	}
+	SetAggregated(d)
+	if !IsAggregated(d) {
+		t.Error("data should be aggregated after SetAggregated")
+	}
+}
@@ -0,0 +1,621 @@
+package data
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+)
+
+const (
+	exported = "exported"
+
+	archive_file_ext  = ".csv.gz"
+	archive_index_ext = ".json"
+	archive_file_null = `\N`
+)
+
+var e_archive_file_dir_not_set = errors.New("未配置归档文件目录")
+var e_archive_file_rows_not_match = errors.New("归档文件导出行数不一致")
+
+//归档文件索引 按排放口拆分 记录数据时间范围及因子 查询时据此跳过无关文件
+type archiveFile struct {
+	File      string           `json:"file"`
+	Table     string           `json:"table"`
+	BeginTime time.Time        `json:"beginTime"`
+	EndTime   time.Time        `json:"endTime"`
+	MinTime   time.Time        `json:"minTime"`
+	MaxTime   time.Time        `json:"maxTime"`
+	StationID int              `json:"stationID"`
+	Monitors  map[string][]int `json:"monitors"`
+	Columns   []string         `json:"columns"`
+	Rows      int64            `json:"rows"`
+}
+
+func (f *archiveFile) hasMonitor(field string, ids []int) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	monitors, exists := f.Monitors[field]
+	if !exists {
+		return true
+	}
+	for _, id := range ids {
+		i := sort.SearchInts(monitors, id)
+		if i < len(monitors) && monitors[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+var archiveFilesPool = make(map[string]map[string][]*archiveFile)
+var archiveFilesPoolLock sync.RWMutex
+
+func archiveFileDir(siteID, dataType string) string {
+	return filepath.Join(Config.EnvironmentArchiveFileDir, siteID, dataType)
+}
+
+func clearArchiveFiles(siteID, dataType string) {
+	archiveFilesPoolLock.Lock()
+	defer archiveFilesPoolLock.Unlock()
+
+	if site, exists := archiveFilesPool[siteID]; exists {
+		delete(site, dataType)
+	}
+}
+
+//读取归档文件索引 索引文件在数据文件完整写入后生成
+func getArchiveFiles(siteID, dataType string) []*archiveFile {
+
+	if Config.EnvironmentArchiveFileDir == "" {
+		return nil
+	}
+
+	archiveFilesPoolLock.RLock()
+	if site, exists := archiveFilesPool[siteID]; exists {
+		if result, exists := site[dataType]; exists {
+			archiveFilesPoolLock.RUnlock()
+			return result
+		}
+	}
+	archiveFilesPoolLock.RUnlock()
+
+	result := make([]*archiveFile, 0)
+
+	indexes, err := filepath.Glob(filepath.Join(archiveFileDir(siteID, dataType), "*"+archive_index_ext))
+	if err != nil {
+		log.Println("error list archive files: ", siteID, dataType, err)
+		return result
+	}
+
+	for _, path := range indexes {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Println("error read archive file index: ", path, err)
+			continue
+		}
+		var f archiveFile
+		if err := json.Unmarshal(content, &f); err != nil {
+			log.Println("error parse archive file index: ", path, err)
+			continue
+		}
+		result = append(result, &f)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].MaxTime.After(result[j].MaxTime)
+	})
+
+	archiveFilesPoolLock.Lock()
+	defer archiveFilesPoolLock.Unlock()
+
+	if _, exists := archiveFilesPool[siteID]; !exists {
+		archiveFilesPool[siteID] = make(map[string][]*archiveFile)
+	}
+	archiveFilesPool[siteID][dataType] = result
+
+	log.Println("fetched archive files ", siteID, dataType, len(result))
+
+	return result
+}
+
+//已归档的表导出为压缩文件 导出校验无误后删除归档表
+func exportArchiveTables(siteID, dataType string) error {
+
+	if Config.EnvironmentArchiveFileDir == "" {
+		return e_archive_file_dir_not_set
+	}
+
+	_, archives := getArchiveTables(siteID, dataType)
+
+	defer ClearArchiveTable(siteID, dataType)
+
+	for _, a := range archives {
+		if a.Status != archived {
+			continue
+		}
+
+		files, err := exportArchiveTable(siteID, dataType, a)
+		if err != nil {
+			log.Println("error export archive table: ", a.TableName, err)
+			return err
+		}
+
+		if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf("DROP TABLE `%s`", a.TableName)); err != nil {
+			log.Println("error drop exported archive table: ", a.TableName, err)
+			return err
+		}
+
+		log.Printf("archive table [%s] exported to %d files", a.TableName, len(files))
+	}
+
+	return nil
+}
+
+type archiveFileWriter struct {
+	file     *os.File
+	gz       *gzip.Writer
+	csv      *csv.Writer
+	index    *archiveFile
+	monitors map[string]map[int]bool
+}
+
+func newArchiveFileWriter(dir, name string, columns []string) (*archiveFileWriter, error) {
+	file, err := os.Create(filepath.Join(dir, name+archive_file_ext))
+	if err != nil {
+		return nil, err
+	}
+
+	w := new(archiveFileWriter)
+	w.file = file
+	w.gz = gzip.NewWriter(file)
+	w.csv = csv.NewWriter(w.gz)
+	w.index = &archiveFile{File: name + archive_file_ext, Columns: columns, Monitors: make(map[string][]int)}
+	w.monitors = make(map[string]map[int]bool)
+
+	if err := w.csv.Write(columns); err != nil {
+		w.close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *archiveFileWriter) write(record []string, dataTime time.Time, monitors map[string]int) error {
+	if err := w.csv.Write(record); err != nil {
+		return err
+	}
+
+	if w.index.MinTime.IsZero() || dataTime.Before(w.index.MinTime) {
+		w.index.MinTime = dataTime
+	}
+	if dataTime.After(w.index.MaxTime) {
+		w.index.MaxTime = dataTime
+	}
+	for field, id := range monitors {
+		if _, exists := w.monitors[field]; !exists {
+			w.monitors[field] = make(map[int]bool)
+		}
+		w.monitors[field][id] = true
+	}
+	w.index.Rows++
+
+	return nil
+}
+
+func (w *archiveFileWriter) close() error {
+	w.csv.Flush()
+	err := w.csv.Error()
+	if e := w.gz.Close(); err == nil {
+		err = e
+	}
+	if e := w.file.Close(); err == nil {
+		err = e
+	}
+
+	for field, ids := range w.monitors {
+		list := make([]int, 0, len(ids))
+		for id := range ids {
+			list = append(list, id)
+		}
+		sort.Ints(list)
+		w.index.Monitors[field] = list
+	}
+
+	return err
+}
+
+func formatArchiveValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return archive_file_null
+	case time.Time:
+		return util.FormatDateTime(value.In(time.Local))
+	case []byte:
+		return string(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func exportArchiveTable(siteID, dataType string, archive *archiveTable) (result []*archiveFile, err error) {
+
+	dir := archiveFileDir(siteID, dataType)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	columns, err := getTableColumns(siteID, nil, fmt.Sprintf("`%s`", archive.TableName))
+	if err != nil {
+		return nil, err
+	}
+
+	stationIndex, timeIndex := -1, -1
+	monitorIndexes := make(map[string]int)
+	for i, c := range columns {
+		switch c {
+		case STATION_ID:
+			stationIndex = i
+		case DATA_TIME:
+			timeIndex = i
+		case MONITOR_ID, MONITOR_CODE_ID:
+			monitorIndexes[c] = i
+		}
+	}
+	if stationIndex < 0 || timeIndex < 0 {
+		return nil, errors.New("invalid archive table columns: " + archive.TableName)
+	}
+
+	result = make([]*archiveFile, 0)
+
+	var writer *archiveFileWriter
+	defer func() {
+		if err != nil {
+			if writer != nil {
+				writer.close()
+				os.Remove(filepath.Join(dir, writer.index.File))
+			}
+			for _, f := range result {
+				os.Remove(filepath.Join(dir, f.File))
+			}
+			result = nil
+		}
+	}()
+
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf("SELECT %s FROM `%s` ORDER BY %s, %s", strings.Join(columns, ","), archive.TableName, STATION_ID, DATA_TIME))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	name := archive.TableName[len(TableName(siteID, dataType)+"_"):]
+	batch := time.Now().Format("20060102150405")
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+
+	var total int64
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		for i, v := range values {
+			record[i] = formatArchiveValue(v)
+		}
+
+		var stationID int
+		var dataTime time.Time
+		if stationID, err = strconv.Atoi(record[stationIndex]); err != nil {
+			return
+		}
+		if dataTime, err = util.ParseDateTime(record[timeIndex]); err != nil {
+			return
+		}
+
+		monitors := make(map[string]int)
+		for field, i := range monitorIndexes {
+			if id, e := strconv.Atoi(record[i]); e == nil {
+				monitors[field] = id
+			}
+		}
+
+		if writer == nil || writer.index.StationID != stationID {
+			if writer != nil {
+				if err = writer.close(); err != nil {
+					return
+				}
+				result = append(result, writer.index)
+			}
+			if writer, err = newArchiveFileWriter(dir, fmt.Sprintf("%s_%d_%s", name, stationID, batch), columns); err != nil {
+				return
+			}
+			writer.index.Table = archive.TableName
+			writer.index.BeginTime = archive.BeginTime
+			writer.index.EndTime = archive.EndTime
+			writer.index.StationID = stationID
+		}
+
+		if err = writer.write(record, dataTime, monitors); err != nil {
+			return
+		}
+		total++
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if writer != nil {
+		if err = writer.close(); err != nil {
+			return
+		}
+		result = append(result, writer.index)
+		writer = nil
+	}
+
+	var count int64
+	if err = datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf("SELECT COUNT(1) FROM `%s`", archive.TableName)).Scan(&count); err != nil {
+		return
+	}
+	if count != total {
+		log.Printf("error export archive table %s: rows not match [%d exported] [%d in table]", archive.TableName, total, count)
+		err = e_archive_file_rows_not_match
+		return
+	}
+
+	for _, f := range result {
+		var content []byte
+		if content, err = json.Marshal(f); err != nil {
+			return
+		}
+		if err = os.WriteFile(filepath.Join(dir, strings.TrimSuffix(f.File, archive_file_ext)+archive_index_ext), content, 0644); err != nil {
+			for _, written := range result {
+				os.Remove(filepath.Join(dir, strings.TrimSuffix(written.File, archive_file_ext)+archive_index_ext))
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func readArchiveFile(siteID, dataType string, f *archiveFile, handle func(header map[string]int, record []string) error) error {
+
+	file, err := os.Open(filepath.Join(archiveFileDir(siteID, dataType), f.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	reader := csv.NewReader(gz)
+	reader.ReuseRecord = true
+
+	columns, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	header := make(map[string]int)
+	for i, c := range columns {
+		header[c] = i
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := handle(header, record); err != nil {
+			return err
+		}
+	}
+}
+
+func scanArchiveRecord(d IData, header map[string]int, record []string, columns []string) error {
+
+	for _, c := range columns {
+		i, exists := header[c]
+		if !exists || record[i] == archive_file_null {
+			continue
+		}
+		v := record[i]
+
+		switch c {
+		case ID, STATION_ID, MONITOR_ID, MONITOR_CODE_ID, FLAG_BIT, REVIEWED:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			switch c {
+			case ID:
+				d.SetID(n)
+			case STATION_ID:
+				d.SetStationID(n)
+			case MONITOR_ID:
+				d.SetMonitorID(n)
+			case MONITOR_CODE_ID:
+				d.SetMonitorCodeID(n)
+			case FLAG_BIT:
+				d.SetFlagBit(n)
+			case REVIEWED:
+				r, ok := d.(IReview)
+				if !ok {
+					return e_invalid_data_interface
+				}
+				r.SetReviewed(n > 0)
+			}
+		case DATA_TIME:
+			t, err := util.ParseDateTime(v)
+			if err != nil {
+				return err
+			}
+			d.SetDataTime(util.Time(t))
+		case FLAG:
+			d.SetFlag(v)
+		case RTD, AVG, MIN, MAX, COU:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			if c == RTD {
+				rtd, ok := d.(IRealTime)
+				if !ok {
+					return e_invalid_data_interface
+				}
+				rtd.SetRtd(f)
+				continue
+			}
+			interval, ok := d.(IInterval)
+			if !ok {
+				return e_invalid_data_interface
+			}
+			switch c {
+			case AVG:
+				interval.SetAvg(f)
+			case MIN:
+				interval.SetMin(f)
+			case MAX:
+				interval.SetMax(f)
+			case COU:
+				interval.SetCou(f)
+			}
+		case ORIGIN_DATA:
+			if v == "" {
+				continue
+			}
+			var origin map[string]interface{}
+			if err := json.Unmarshal([]byte(v), &origin); err != nil {
+				return err
+			}
+			d.LockOriginData()
+			d.SetOriginData(origin)
+			d.UnLockOriginData()
+		}
+	}
+
+	return nil
+}
+
+func newDataInstance(dataType string) func() IData {
+	switch dataType {
+	case REAL_TIME:
+		return func() IData { return new(RealTimeData) }
+	case MINUTELY:
+		return func() IData { return new(MinutelyData) }
+	case HOURLY:
+		return func() IData { return new(HourlyData) }
+	case DAILY:
+		return func() IData { return new(DailyData) }
+	}
+	return nil
+}
+
+//直接读取归档文件中的数据 无需激活归档表
+func fetchArchiveFileData(siteID, dataType, monitorField string, stationID, monitorID, monitorCodeID []int, beginTime, endTime time.Time, flag []string, columns []string) ([]IData, error) {
+
+	result := make([]IData, 0)
+
+	instance := newDataInstance(dataType)
+	if instance == nil {
+		return nil, e_invalid_data_type
+	}
+
+	files := getArchiveFiles(siteID, dataType)
+	if len(files) == 0 {
+		return result, nil
+	}
+
+	stations := make(map[int]bool)
+	for _, id := range stationID {
+		stations[id] = true
+	}
+
+	monitorIDs := monitorID
+	if monitorField == MONITOR_CODE_ID {
+		monitorIDs = monitorCodeID
+	}
+	monitors := make(map[string]bool)
+	for _, id := range monitorIDs {
+		monitors[strconv.Itoa(id)] = true
+	}
+
+	flags := make(map[string]bool)
+	for _, f := range flag {
+		flags[f] = true
+	}
+
+	for _, f := range files {
+		if !stations[f.StationID] || f.MaxTime.Before(beginTime) || f.MinTime.After(endTime) || !f.hasMonitor(monitorField, monitorIDs) {
+			continue
+		}
+
+		if err := readArchiveFile(siteID, dataType, f, func(header map[string]int, record []string) error {
+			if len(monitors) > 0 {
+				if i, exists := header[monitorField]; exists && !monitors[record[i]] {
+					return nil
+				}
+			}
+			if len(flags) > 0 {
+				if i, exists := header[FLAG]; exists && !flags[record[i]] {
+					return nil
+				}
+			}
+
+			i, exists := header[DATA_TIME]
+			if !exists {
+				return nil
+			}
+			dataTime, err := util.ParseDateTime(record[i])
+			if err != nil {
+				return err
+			}
+			if dataTime.Before(beginTime) || dataTime.After(endTime) {
+				return nil
+			}
+
+			d := instance()
+			if err := scanArchiveRecord(d, header, record, columns); err != nil {
+				return err
+			}
+			result = append(result, d)
+			return nil
+		}); err != nil {
+			log.Println("error read archive file: ", f.File, err)
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
@@ -24,6 +24,15 @@ var e_hourly_range_restricted = errors.New("小时数据的查询时间跨度最
 var e_daily_range_restricted = errors.New("日数据查询跨度最多365天")
 
 func GetDataByTime(siteID string, dataType string, stationID []int, criterias Criterias, beginTime, endTime time.Time, withOriginData, withReviewed bool, order string, pageNo, pageSize int, monitorCodeID []int, monitorID []int) ([]*TimeData, int, error) {
+	return getDataByTime(false, siteID, dataType, stationID, criterias, beginTime, endTime, withOriginData, withReviewed, order, pageNo, pageSize, monitorCodeID, monitorID)
+}
+
+//供接口查询 可读从库
+func GetDataByTimeReadOnly(siteID string, dataType string, stationID []int, criterias Criterias, beginTime, endTime time.Time, withOriginData, withReviewed bool, order string, pageNo, pageSize int, monitorCodeID []int, monitorID []int) ([]*TimeData, int, error) {
+	return getDataByTime(true, siteID, dataType, stationID, criterias, beginTime, endTime, withOriginData, withReviewed, order, pageNo, pageSize, monitorCodeID, monitorID)
+}
+
+func getDataByTime(readOnly bool, siteID string, dataType string, stationID []int, criterias Criterias, beginTime, endTime time.Time, withOriginData, withReviewed bool, order string, pageNo, pageSize int, monitorCodeID []int, monitorID []int) ([]*TimeData, int, error) {
 
 	log.Println("get data by time: ", siteID, dataType, stationID, criterias, beginTime, endTime, pageNo, pageSize)
 
@@ -85,7 +94,12 @@ func GetDataByTime(siteID string, dataType string, stationID []int, criterias Cr
 		return nil, 0, e_invalid_data_type
 	}
 
-	effectiveBegin, effectveEnd, total, err := getDataTimes(siteID, dataType, stationID, criterias, beginTime, endTime, order, pageNo, pageSize, monitorCodeID, monitorID)
+	//时序库不存原始数据
+	if m.TSDB.queryable() && !withOriginData {
+		return getDataByTimeTSDB(siteID, m, dataType, stationID, criterias, beginTime, endTime, withReviewed, strings.ToUpper(order), pageNo, pageSize, monitorCodeID, monitorID)
+	}
+
+	effectiveBegin, effectveEnd, total, err := getDataTimes(readOnly, siteID, dataType, stationID, criterias, beginTime, endTime, order, pageNo, pageSize, monitorCodeID, monitorID)
 	if err != nil {
 		return nil, total, err
 	}
@@ -171,7 +185,7 @@ func GetDataByTime(siteID string, dataType string, stationID []int, criterias Cr
 			ORDER BY data.%s %s
 		`, strings.Join(fields, ","), table, strings.Join(whereStmts, " AND "), DATA_TIME, order)
 
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		rows, err := datasource.GetSiteQueryConn(siteID, readOnly).Query(SQL, values...)
 		if err != nil {
 			log.Println("error get data by time: ", err)
 			return nil, total, err
@@ -188,6 +202,12 @@ func GetDataByTime(siteID string, dataType string, stationID []int, criterias Cr
 		}
 	}
 
+	archived, err := fetchArchiveFileData(siteID, dataType, m.MonitorField, stationID, monitorID, monitorCodeID, *effectiveBegin, *effectveEnd, nil, columns)
+	if err != nil {
+		return nil, total, err
+	}
+	filtered = append(filtered, archived...)
+
 	log.Println("to filter: ", siteID, len(filtered))
 
 	dataTimeMapping := make(map[string]*TimeData)
@@ -229,7 +249,7 @@ func GetDataByTime(siteID string, dataType string, stationID []int, criterias Cr
 	return result, total, nil
 }
 
-func getDataTimes(siteID, dataType string, stationID []int, criterias Criterias, beginTime, endTime time.Time, order string, pageNo, pageSize int, monitorCodeID []int, monitorID []int) (*time.Time, *time.Time, int, error) {
+func getDataTimes(readOnly bool, siteID, dataType string, stationID []int, criterias Criterias, beginTime, endTime time.Time, order string, pageNo, pageSize int, monitorCodeID []int, monitorID []int) (*time.Time, *time.Time, int, error) {
 
 	total := 0
 
@@ -303,7 +323,7 @@ func getDataTimes(siteID, dataType string, stationID []int, criterias Criterias,
 			ORDER BY data.%s %s
 		`, DATA_TIME, table, strings.Join(whereStmts, " AND "), DATA_TIME, order)
 
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		rows, err := datasource.GetSiteQueryConn(siteID, readOnly).Query(SQL, values...)
 		if err != nil {
 			log.Println("error get data time slots by time: ", err)
 			return nil, nil, total, err
@@ -321,6 +341,19 @@ func getDataTimes(siteID, dataType string, stationID []int, criterias Criterias,
 		}
 	}
 
+	archived, err := fetchArchiveFileData(siteID, dataType, m.MonitorField, stationID, monitorID, monitorCodeID, beginTime, endTime, nil, []string{DATA_TIME})
+	if err != nil {
+		return nil, nil, total, err
+	}
+	archivedTimes := make(map[time.Time]bool)
+	for _, d := range archived {
+		dataTime := time.Time(d.GetDataTime())
+		if !archivedTimes[dataTime] {
+			archivedTimes[dataTime] = true
+			timeSlots = append(timeSlots, &dataTime)
+		}
+	}
+
 	sort.Slice(timeSlots, func(i, j int) bool {
 		if order == "ASC" {
 			return timeSlots[i].Before(*timeSlots[j])
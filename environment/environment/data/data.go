@@ -114,6 +114,21 @@ func FetchableTables(siteID, dataType string) ([]*DataTable, error) {
 		toShow.Table = archive.TableName[len(siteID+"_"):]
 	}
 
+	exports := make(map[string]*DataTable)
+	for _, f := range getArchiveFiles(siteID, dataType) {
+		toShow, exists := exports[f.Table]
+		if !exists {
+			toShow = new(DataTable)
+			result = append(result, toShow)
+			exports[f.Table] = toShow
+			toShow.BeginTime = util.Time(f.BeginTime)
+			toShow.EndTime = util.Time(f.EndTime)
+			toShow.Status = exported
+			toShow.Name = fmt.Sprintf("%s - %s", f.BeginTime.Format("20060102"), f.EndTime.Format("20060102"))
+			toShow.Table = f.Table[len(siteID+"_"):]
+		}
+	}
+
 	return result, nil
 }
 
@@ -0,0 +1,527 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"obsessiontech/common/config"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+)
+
+const (
+	SOURCE_LIST      = "list"
+	SOURCE_BY_TIME   = "byTime"
+	SOURCE_AGGREGATE = "aggregate"
+)
+
+var e_invalid_format = errors.New("导出格式不正确")
+var e_invalid_source = errors.New("导出数据来源不正确")
+var e_invalid_time = errors.New("导出时间不正确")
+
+var Config struct {
+	EnvironmentExportSyncMaxRows int
+}
+
+func init() {
+	config.GetConfig("config.yaml", &Config)
+
+	if Config.EnvironmentExportSyncMaxRows <= 0 {
+		Config.EnvironmentExportSyncMaxRows = 50000
+	}
+}
+
+//导出参数 对应列表/按时间/聚合三种查询
+type Export struct {
+	DataType       string         `json:"dataType"`
+	Source         string         `json:"source"`
+	Format         string         `json:"format"`
+	StationIDs     []int          `json:"stationIDs"`
+	MonitorIDs     []int          `json:"monitorIDs,omitempty"`
+	MonitorCodeIDs []int          `json:"monitorCodeIDs,omitempty"`
+	BeginTime      util.Time      `json:"beginTime"`
+	EndTime        util.Time      `json:"endTime"`
+	Flags          []string       `json:"flags,omitempty"`
+	Criterias      data.Criterias `json:"criterias,omitempty"`
+	WithOriginData bool           `json:"withOriginData,omitempty"`
+	Field          string         `json:"field,omitempty"`
+	Bucket         string         `json:"bucket,omitempty"`
+	Aggregations   []string       `json:"aggregations,omitempty"`
+	GroupByStation bool           `json:"groupByStation,omitempty"`
+}
+
+func (e *Export) Validate() error {
+	switch e.Format {
+	case FORMAT_CSV, FORMAT_XLSX:
+	default:
+		return e_invalid_format
+	}
+
+	switch e.Source {
+	case SOURCE_LIST, SOURCE_BY_TIME, SOURCE_AGGREGATE:
+	default:
+		return e_invalid_source
+	}
+
+	switch e.DataType {
+	case data.REAL_TIME, data.MINUTELY, data.HOURLY, data.DAILY:
+	default:
+		return errors.New("数据类型不正确")
+	}
+
+	if time.Time(e.BeginTime).IsZero() || time.Time(e.EndTime).IsZero() || time.Time(e.BeginTime).After(time.Time(e.EndTime)) {
+		return e_invalid_time
+	}
+
+	return e.Criterias.Validate()
+}
+
+//按权限过滤排放口 未指定时导出全部有权限的排放口
+func (e *Export) Authorize(siteID string, actionAuth authority.ActionAuthSet) error {
+	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, e.StationIDs, entity.ACTION_ENTITY_VIEW)
+	if err != nil {
+		return err
+	}
+
+	if len(e.StationIDs) == 0 {
+		for sid, ok := range filtered {
+			if ok {
+				e.StationIDs = append(e.StationIDs, sid)
+			}
+		}
+		sort.Ints(e.StationIDs)
+		return nil
+	}
+
+	for _, sid := range e.StationIDs {
+		if !filtered[sid] {
+			return fmt.Errorf("无权限查看【%d】", sid)
+		}
+	}
+
+	return nil
+}
+
+//预估导出行数 用于判断是否转为后台任务
+func (e *Export) Estimate(siteID string) (int, error) {
+	if e.Source == SOURCE_AGGREGATE {
+		return 0, nil
+	}
+	count, err := data.CountData(siteID, e.DataType, e.StationIDs, e.MonitorIDs, e.MonitorCodeIDs, time.Time(e.BeginTime), time.Time(e.EndTime), e.Flags, e.Criterias, false, false, false, false)
+	if err != nil {
+		return 0, err
+	}
+	total, _ := count.(int)
+	return total, nil
+}
+
+func (e *Export) IsLarge(siteID string) (bool, error) {
+	total, err := e.Estimate(siteID)
+	if err != nil {
+		return false, err
+	}
+	return total > Config.EnvironmentExportSyncMaxRows, nil
+}
+
+func (e *Export) FileName() string {
+	return fmt.Sprintf("%s_%s_%s_%s.%s", e.DataType, e.Source, time.Time(e.BeginTime).Format("20060102150405"), time.Time(e.EndTime).Format("20060102150405"), e.Format)
+}
+
+func (e *Export) ContentType() string {
+	if e.Format == FORMAT_XLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+//按时间分段查询并逐行写出 返回写出的数据行数
+func (e *Export) Write(siteID string, w io.Writer) (int, error) {
+
+	rw, err := newRowWriter(e.Format, w)
+	if err != nil {
+		return 0, err
+	}
+
+	l, err := newLabels(siteID, e.StationIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int
+	switch e.Source {
+	case SOURCE_LIST:
+		rows, err = e.writeList(siteID, rw, l)
+	case SOURCE_BY_TIME:
+		rows, err = e.writeByTime(siteID, rw, l)
+	case SOURCE_AGGREGATE:
+		rows, err = e.writeAggregate(siteID, rw, l)
+	default:
+		err = e_invalid_source
+	}
+	if err != nil {
+		return rows, err
+	}
+
+	return rows, rw.Close()
+}
+
+//分段时长 与各数据类型的查询跨度限制一致
+func getWindow(dataType string) time.Duration {
+	switch dataType {
+	case data.REAL_TIME, data.MINUTELY:
+		return time.Hour * 24
+	case data.HOURLY:
+		return time.Hour * 24 * 31
+	}
+	return time.Hour * 24 * 365
+}
+
+func (e *Export) eachWindow(handle func(beginTime, endTime time.Time) error) error {
+	window := getWindow(e.DataType)
+	endTime := time.Time(e.EndTime)
+
+	for beginTime := time.Time(e.BeginTime); !beginTime.After(endTime); beginTime = beginTime.Add(window) {
+		windowEnd := beginTime.Add(window - time.Second)
+		if windowEnd.After(endTime) {
+			windowEnd = endTime
+		}
+		if err := handle(beginTime, windowEnd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type labels struct {
+	siteID   string
+	stations map[int]string
+	flags    map[string]string
+}
+
+func newLabels(siteID string, stationIDs []int) (*labels, error) {
+	l := &labels{siteID: siteID, stations: make(map[int]string), flags: make(map[string]string)}
+
+	stations, err := entity.GetStation(siteID, stationIDs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stations {
+		l.stations[s.ID] = s.Name
+	}
+
+	m, err := monitor.GetModule(siteID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.Flags {
+		l.flags[f.Flag] = f.Name
+	}
+
+	return l, nil
+}
+
+func (l *labels) station(stationID int) string {
+	if name, exists := l.stations[stationID]; exists {
+		return name
+	}
+	return strconv.Itoa(stationID)
+}
+
+func (l *labels) flag(flag string) string {
+	return l.flags[flag]
+}
+
+func (l *labels) monitor(monitorID, monitorCodeID int) *monitor.Monitor {
+	if monitorID <= 0 && monitorCodeID > 0 {
+		if code := monitor.GetMonitorCodeByID(l.siteID, monitorCodeID); code != nil {
+			monitorID = code.MonitorID
+		}
+	}
+	if m := monitor.GetMonitor(l.siteID, monitorID); m != nil {
+		return m
+	}
+	return &monitor.Monitor{ID: monitorID, Name: strconv.Itoa(monitorID)}
+}
+
+func formatCell(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(value)
+	}
+	return fmt.Sprint(v)
+}
+
+func formatOriginData(d data.IData) string {
+	d.RLockOriginData()
+	defer d.RUnlockOriginData()
+
+	if len(d.GetOriginData()) == 0 {
+		return ""
+	}
+	origin, _ := json.Marshal(d.GetOriginData())
+	return string(origin)
+}
+
+func (e *Export) writeList(siteID string, rw rowWriter, l *labels) (int, error) {
+
+	header := []interface{}{"排放口", "监测物", "数据时间"}
+	if e.DataType == data.REAL_TIME {
+		header = append(header, "实时值", "单位")
+	} else {
+		header = append(header, "均值", "最小值", "最大值", "单位", "累计值", "累计值单位")
+	}
+	header = append(header, "标记", "标记名称")
+	if e.WithOriginData {
+		header = append(header, "原始数据")
+	}
+	if err := rw.WriteRow(header); err != nil {
+		return 0, err
+	}
+
+	var extra []string
+	if e.WithOriginData {
+		extra = append(extra, data.ORIGIN_DATA)
+	}
+
+	rows := 0
+
+	err := e.eachWindow(func(beginTime, endTime time.Time) error {
+		list, err := data.GetData(siteID, e.DataType, e.StationIDs, e.MonitorIDs, e.MonitorCodeIDs, e.Criterias, beginTime, endTime, e.Flags, extra...)
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(list, func(i, j int) bool {
+			ti, tj := time.Time(list[i].GetDataTime()), time.Time(list[j].GetDataTime())
+			if !ti.Equal(tj) {
+				return ti.Before(tj)
+			}
+			if list[i].GetStationID() != list[j].GetStationID() {
+				return list[i].GetStationID() < list[j].GetStationID()
+			}
+			return list[i].GetMonitorID()+list[i].GetMonitorCodeID() < list[j].GetMonitorID()+list[j].GetMonitorCodeID()
+		})
+
+		for _, d := range list {
+			m := l.monitor(d.GetMonitorID(), d.GetMonitorCodeID())
+			row := []interface{}{l.station(d.GetStationID()), m.Name, util.FormatDateTime(time.Time(d.GetDataTime()))}
+			if rtd, ok := d.(data.IRealTime); ok {
+				row = append(row, rtd.GetRtd(), m.Unit)
+			} else if interval, ok := d.(data.IInterval); ok {
+				row = append(row, interval.GetAvg(), interval.GetMin(), interval.GetMax(), m.Unit, interval.GetCou(), m.CouUnit)
+			}
+			row = append(row, d.GetFlag(), l.flag(d.GetFlag()))
+			if e.WithOriginData {
+				row = append(row, formatOriginData(d))
+			}
+			if err := rw.WriteRow(row); err != nil {
+				return err
+			}
+			rows++
+		}
+		return nil
+	})
+
+	return rows, err
+}
+
+//按时间导出的监测物列 未指定时取时间范围内有数据的监测物
+func (e *Export) getMonitorColumns(siteID string, l *labels) ([]*monitor.Monitor, error) {
+
+	ids := make(map[int]bool)
+	for _, id := range e.MonitorIDs {
+		ids[id] = true
+	}
+	for _, id := range e.MonitorCodeIDs {
+		ids[l.monitor(0, id).ID] = true
+	}
+
+	if len(ids) == 0 {
+		dm, err := data.GetModule(siteID)
+		if err != nil {
+			return nil, err
+		}
+		counts, err := data.CountData(siteID, e.DataType, e.StationIDs, nil, nil, time.Time(e.BeginTime), time.Time(e.EndTime), nil, nil, false, false, true, false)
+		if err != nil {
+			return nil, err
+		}
+		if grouped, ok := counts.(map[int]interface{}); ok {
+			for id := range grouped {
+				if dm.MonitorField == data.MONITOR_CODE_ID {
+					ids[l.monitor(0, id).ID] = true
+				} else {
+					ids[id] = true
+				}
+			}
+		}
+	}
+
+	sorted := make([]int, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Ints(sorted)
+
+	result := make([]*monitor.Monitor, 0, len(sorted))
+	for _, id := range sorted {
+		result = append(result, l.monitor(id, 0))
+	}
+	return result, nil
+}
+
+func (e *Export) writeByTime(siteID string, rw rowWriter, l *labels) (int, error) {
+
+	monitors, err := e.getMonitorColumns(siteID, l)
+	if err != nil {
+		return 0, err
+	}
+
+	header := []interface{}{"数据时间", "排放口"}
+	for _, m := range monitors {
+		name := m.Name
+		if m.Unit != "" {
+			name = fmt.Sprintf("%s(%s)", m.Name, m.Unit)
+		}
+		header = append(header, name, m.Name+"标记")
+		if e.WithOriginData {
+			header = append(header, m.Name+"原始数据")
+		}
+	}
+	if err := rw.WriteRow(header); err != nil {
+		return 0, err
+	}
+
+	rows := 0
+
+	err = e.eachWindow(func(beginTime, endTime time.Time) error {
+		list, _, err := data.GetDataByTime(siteID, e.DataType, e.StationIDs, e.Criterias, beginTime, endTime, e.WithOriginData, false, "ASC", 0, -1, e.MonitorCodeIDs, e.MonitorIDs)
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(list, func(i, j int) bool {
+			return time.Time(list[i].DataTime).Before(time.Time(list[j].DataTime))
+		})
+
+		for _, td := range list {
+			stationIDs := make([]int, 0, len(td.Data))
+			for sid := range td.Data {
+				stationIDs = append(stationIDs, sid)
+			}
+			sort.Ints(stationIDs)
+
+			for _, sid := range stationIDs {
+				byMonitor := make(map[int]data.IData)
+				for _, d := range td.Data[sid] {
+					byMonitor[l.monitor(d.GetMonitorID(), d.GetMonitorCodeID()).ID] = d
+				}
+
+				row := []interface{}{util.FormatDateTime(time.Time(td.DataTime)), l.station(sid)}
+				for _, m := range monitors {
+					d, exists := byMonitor[m.ID]
+					if !exists {
+						row = append(row, nil, nil)
+						if e.WithOriginData {
+							row = append(row, nil)
+						}
+						continue
+					}
+					if rtd, ok := d.(data.IRealTime); ok {
+						row = append(row, rtd.GetRtd())
+					} else if interval, ok := d.(data.IInterval); ok {
+						row = append(row, interval.GetAvg())
+					} else {
+						row = append(row, nil)
+					}
+					row = append(row, l.flag(d.GetFlag()))
+					if e.WithOriginData {
+						row = append(row, formatOriginData(d))
+					}
+				}
+				if err := rw.WriteRow(row); err != nil {
+					return err
+				}
+				rows++
+			}
+		}
+		return nil
+	})
+
+	return rows, err
+}
+
+func (e *Export) writeAggregate(siteID string, rw rowWriter, l *labels) (int, error) {
+
+	list, err := data.GetAggregateData(siteID, e.DataType, e.StationIDs, e.MonitorIDs, e.MonitorCodeIDs, e.Criterias, time.Time(e.BeginTime), time.Time(e.EndTime), e.Flags, e.Field, e.Bucket, e.Aggregations, e.GroupByStation)
+	if err != nil {
+		return 0, err
+	}
+
+	dm, err := data.GetModule(siteID)
+	if err != nil {
+		return 0, err
+	}
+
+	header := []interface{}{"时间"}
+	if e.GroupByStation {
+		header = append(header, "排放口")
+	}
+	header = append(header, "监测物", "单位")
+	for _, agg := range e.Aggregations {
+		header = append(header, agg)
+	}
+	if err := rw.WriteRow(header); err != nil {
+		return 0, err
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		ti, tj := time.Time(list[i].Bucket), time.Time(list[j].Bucket)
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		if list[i].StationID != list[j].StationID {
+			return list[i].StationID < list[j].StationID
+		}
+		return list[i].MonitorID < list[j].MonitorID
+	})
+
+	rows := 0
+	for _, a := range list {
+		var m *monitor.Monitor
+		if dm.MonitorField == data.MONITOR_CODE_ID {
+			m = l.monitor(0, a.MonitorID)
+		} else {
+			m = l.monitor(a.MonitorID, 0)
+		}
+
+		row := []interface{}{util.FormatDateTime(time.Time(a.Bucket))}
+		if e.GroupByStation {
+			row = append(row, l.station(a.StationID))
+		}
+		row = append(row, m.Name, m.Unit)
+		for _, agg := range e.Aggregations {
+			row = append(row, a.Values[agg])
+		}
+		if err := rw.WriteRow(row); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+
+	return rows, nil
+}
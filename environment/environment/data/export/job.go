@@ -0,0 +1,247 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/resource"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(data.MODULE_DATA, "realtimedata", jobMigration)
+}
+
+const (
+	JOB_PENDING = "pending"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+var E_job_not_exists = errors.New("导出任务不存在")
+var E_job_not_done = errors.New("导出任务未完成")
+
+//后台导出任务 文件存放于站点资源目录下 计入站点存储
+type Job struct {
+	ID         int        `json:"ID"`
+	UID        int        `json:"UID"`
+	Param      *Export    `json:"param"`
+	Status     string     `json:"status"`
+	Rows       int        `json:"rows"`
+	File       string     `json:"file"`
+	Error      string     `json:"error,omitempty"`
+	CreateTime util.Time  `json:"createTime"`
+	FinishTime *util.Time `json:"finishTime,omitempty"`
+}
+
+func jobTableName(siteID string) string {
+	return siteID + "_dataexport"
+}
+
+//数据导出任务表
+var jobMigration = &initialization.Migration{
+	Version:     5,
+	Description: "数据导出任务",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_dataexport (
+			id INT NOT NULL AUTO_INCREMENT,
+			uid INT NOT NULL DEFAULT 0,
+			param TEXT,
+			status VARCHAR(32) NOT NULL,
+			row_count INT NOT NULL DEFAULT 0,
+			file VARCHAR(255) NOT NULL DEFAULT '',
+			error TEXT,
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finish_time DATETIME NULL,
+			PRIMARY KEY (id),
+			KEY (uid)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+func exportFolder(siteID string) string {
+	return filepath.Join(resource.Config.ResourceFolderPath+siteID, "export")
+}
+
+func (j *Job) FilePath(siteID string) string {
+	return filepath.Join(exportFolder(siteID), j.File)
+}
+
+const jobColumns = "job.id, job.uid, job.param, job.status, job.row_count, job.file, job.error, job.create_time, job.finish_time"
+
+func (j *Job) scan(rows *sql.Rows) error {
+	var param string
+	var createTime time.Time
+	var finishTime sql.NullTime
+	if err := rows.Scan(&j.ID, &j.UID, &param, &j.Status, &j.Rows, &j.File, &j.Error, &createTime, &finishTime); err != nil {
+		return err
+	}
+	j.CreateTime = util.Time(createTime)
+	if finishTime.Valid {
+		t := util.Time(finishTime.Time)
+		j.FinishTime = &t
+	}
+	return json.Unmarshal([]byte(param), &j.Param)
+}
+
+//创建后台导出任务 参数需已校验及按权限过滤
+func StartJob(siteID string, uid int, e *Export) (*Job, error) {
+
+	param, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Job{UID: uid, Param: e, Status: JOB_PENDING, CreateTime: util.Time(time.Now())}
+
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(uid, param, status, row_count, file, error)
+		VALUES
+			(?, ?, ?, 0, '', '')
+	`, jobTableName(siteID)), uid, string(param), j.Status)
+	if err != nil {
+		log.Println("error add export job: ", err)
+		return nil, err
+	}
+
+	id, err := ret.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	j.ID = int(id)
+	j.File = fmt.Sprintf("%d_%s", j.ID, e.FileName())
+
+	go j.run(siteID)
+
+	return j, nil
+}
+
+func (j *Job) updateStatus(siteID string) error {
+	var finishTime interface{}
+	if j.FinishTime != nil {
+		finishTime = time.Time(*j.FinishTime)
+	}
+	_, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		UPDATE %s SET
+			status = ?, row_count = ?, file = ?, error = ?, finish_time = ?
+		WHERE
+			id = ?
+	`, jobTableName(siteID)), j.Status, j.Rows, j.File, j.Error, finishTime, j.ID)
+	if err != nil {
+		log.Println("error update export job: ", j.ID, err)
+	}
+	return err
+}
+
+func (j *Job) run(siteID string) {
+
+	j.Status = JOB_RUNNING
+	j.updateStatus(siteID)
+
+	err := func() error {
+		if err := os.MkdirAll(exportFolder(siteID), os.ModePerm); err != nil {
+			return err
+		}
+
+		file, err := os.Create(j.FilePath(siteID))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		j.Rows, err = j.Param.Write(siteID, file)
+		return err
+	}()
+
+	now := util.Time(time.Now())
+	j.FinishTime = &now
+
+	if err != nil {
+		log.Println("error export job: ", siteID, j.ID, err)
+		os.Remove(j.FilePath(siteID))
+		j.Status = JOB_FAILED
+		j.Error = err.Error()
+	} else {
+		log.Printf("export job done [%s] %d: %d rows", siteID, j.ID, j.Rows)
+		j.Status = JOB_DONE
+	}
+
+	j.updateStatus(siteID)
+}
+
+func GetJob(siteID string, jobID int) (*Job, error) {
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s job
+		WHERE
+			job.id = ?
+	`, jobColumns, jobTableName(siteID)), jobID)
+	if err != nil {
+		log.Println("error get export job: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var j Job
+		if err := j.scan(rows); err != nil {
+			log.Println("error get export job: ", err)
+			return nil, err
+		}
+		return &j, nil
+	}
+
+	return nil, E_job_not_exists
+}
+
+//uid为0时返回全部任务
+func GetJobs(siteID string, uid int) ([]*Job, error) {
+
+	whereStmt := "1 = 1"
+	values := make([]interface{}, 0)
+	if uid > 0 {
+		whereStmt = "job.uid = ?"
+		values = append(values, uid)
+	}
+
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s job
+		WHERE
+			%s
+		ORDER BY
+			job.id DESC
+	`, jobColumns, jobTableName(siteID), whereStmt), values...)
+	if err != nil {
+		log.Println("error get export jobs: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Job, 0)
+	for rows.Next() {
+		var j Job
+		if err := j.scan(rows); err != nil {
+			log.Println("error get export jobs: ", err)
+			return nil, err
+		}
+		result = append(result, &j)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_XLSX = "xlsx"
+)
+
+//逐行写出 不在内存中保留已写出的行
+type rowWriter interface {
+	WriteRow(cells []interface{}) error
+	Close() error
+}
+
+func newRowWriter(format string, w io.Writer) (rowWriter, error) {
+	switch format {
+	case FORMAT_CSV:
+		//带BOM以便Excel正确识别中文
+		if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+			return nil, err
+		}
+		return &csvWriter{writer: csv.NewWriter(w)}, nil
+	case FORMAT_XLSX:
+		f := excelize.NewFile()
+		sw, err := f.NewStreamWriter("Sheet1")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxWriter{file: f, stream: sw, out: w}, nil
+	}
+	return nil, e_invalid_format
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+	rows   int
+}
+
+func (w *csvWriter) WriteRow(cells []interface{}) error {
+	record := make([]string, len(cells))
+	for i, c := range cells {
+		record[i] = formatCell(c)
+	}
+	if err := w.writer.Write(record); err != nil {
+		return err
+	}
+	w.rows++
+	if w.rows%1000 == 0 {
+		w.writer.Flush()
+		return w.writer.Error()
+	}
+	return nil
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+//xlsx流式写入 超出缓冲的行暂存于临时文件
+type xlsxWriter struct {
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	out    io.Writer
+	rows   int
+}
+
+func (w *xlsxWriter) WriteRow(cells []interface{}) error {
+	w.rows++
+	axis, err := excelize.CoordinatesToCellName(1, w.rows)
+	if err != nil {
+		return err
+	}
+	return w.stream.SetRow(axis, cells)
+}
+
+func (w *xlsxWriter) Close() error {
+	if err := w.stream.Flush(); err != nil {
+		return err
+	}
+	return w.file.Write(w.out)
+}
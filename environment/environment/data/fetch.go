@@ -64,37 +64,10 @@ func wrapByFlag(result map[string]interface{}, count int, flag string) {
 	result[flag] = entry
 }
 
-func CountData(siteID, dataType string, stationID, monitorID, monitorCodeID []int, beginTime, endTime time.Time, flag []string, criterias Criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag bool) (result interface{}, err error) {
-
-	defer func() {
-		if result == nil {
-			if groupByStation || groupByMonitor || groupByFlag {
-				result = make(map[int]interface{})
-			} else {
-				result = 0
-			}
-		}
-	}()
-
-	if len(stationID) == 0 {
-		return result, nil
-	}
-
-	if beginTime.IsZero() || endTime.IsZero() {
-		return result, e_need_datatime
-	}
-
-	if beginTime.Equal(endTime) || beginTime.After(endTime) {
-		return result, nil
-	}
-
-	m, err := GetModule(siteID)
-	if err != nil {
-		return nil, err
-	}
-
-	whereStmts := make([]string, 0)
-	values := make([]interface{}, 0)
+//站点 监测因子 时间 标记及自定义条件的查询条件 表别名为data
+func buildDataWhere(m *DataModule, dataType string, stationID, monitorID, monitorCodeID []int, beginTime, endTime time.Time, flag []string, criterias Criterias) (whereStmts []string, values []interface{}) {
+	whereStmts = make([]string, 0)
+	values = make([]interface{}, 0)
 
 	if len(stationID) == 1 {
 		whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", STATION_ID))
@@ -136,8 +109,13 @@ func CountData(siteID, dataType string, stationID, monitorID, monitorCodeID []in
 		}
 	}
 
-	whereStmts = append(whereStmts, fmt.Sprintf("data.%s >= ? AND data.%s <= ?", DATA_TIME, DATA_TIME))
-	values = append(values, beginTime, endTime)
+	if beginTime.Equal(endTime) {
+		whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", DATA_TIME))
+		values = append(values, beginTime)
+	} else {
+		whereStmts = append(whereStmts, fmt.Sprintf("data.%s >= ? AND data.%s <= ?", DATA_TIME, DATA_TIME))
+		values = append(values, beginTime, endTime)
+	}
 
 	if len(flag) > 0 {
 		if len(flag) == 1 {
@@ -154,11 +132,56 @@ func CountData(siteID, dataType string, stationID, monitorID, monitorCodeID []in
 	}
 
 	if len(criterias) > 0 {
-		subWhere, subValues := criterias.ParseSQL(dataType, "data")
-		whereStmts = append(whereStmts, subWhere)
-		values = append(values, subValues...)
+		subSQL, subValues := criterias.ParseSQL(dataType, "data")
+		if subSQL != "" {
+			whereStmts = append(whereStmts, subSQL)
+			values = append(values, subValues...)
+		}
 	}
 
+	return
+}
+
+func CountData(siteID, dataType string, stationID, monitorID, monitorCodeID []int, beginTime, endTime time.Time, flag []string, criterias Criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag bool) (result interface{}, err error) {
+	return countData(false, siteID, dataType, stationID, monitorID, monitorCodeID, beginTime, endTime, flag, criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag)
+}
+
+//供接口查询 可读从库
+func CountDataReadOnly(siteID, dataType string, stationID, monitorID, monitorCodeID []int, beginTime, endTime time.Time, flag []string, criterias Criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag bool) (result interface{}, err error) {
+	return countData(true, siteID, dataType, stationID, monitorID, monitorCodeID, beginTime, endTime, flag, criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag)
+}
+
+func countData(readOnly bool, siteID, dataType string, stationID, monitorID, monitorCodeID []int, beginTime, endTime time.Time, flag []string, criterias Criterias, groupByTime, groupByStation, groupByMonitor, groupByFlag bool) (result interface{}, err error) {
+
+	defer func() {
+		if result == nil {
+			if groupByStation || groupByMonitor || groupByFlag {
+				result = make(map[int]interface{})
+			} else {
+				result = 0
+			}
+		}
+	}()
+
+	if len(stationID) == 0 {
+		return result, nil
+	}
+
+	if beginTime.IsZero() || endTime.IsZero() {
+		return result, e_need_datatime
+	}
+
+	if beginTime.Equal(endTime) || beginTime.After(endTime) {
+		return result, nil
+	}
+
+	m, err := GetModule(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	whereStmts, values := buildDataWhere(m, dataType, stationID, monitorID, monitorCodeID, beginTime, endTime, flag, criterias)
+
 	tables := FetchTableNames(siteID, dataType, beginTime, endTime)
 
 	var field string
@@ -195,6 +218,30 @@ func CountData(siteID, dataType string, stationID, monitorID, monitorCodeID []in
 		groupBy += fmt.Sprintf("data.%s", FLAG)
 	}
 
+	wrap := func(count, stationID, mid int, flag string) {
+		if groupByStation {
+			if result == nil {
+				result = make(map[int]interface{})
+			}
+			wrapByStation(result.(map[int]interface{}), count, stationID, mid, flag, groupByMonitor, groupByFlag)
+		} else if groupByMonitor {
+			if result == nil {
+				result = make(map[int]interface{})
+			}
+			wrapByMonitor(result.(map[int]interface{}), count, mid, flag, groupByFlag)
+		} else if groupByFlag {
+			if result == nil {
+				result = make(map[string]interface{})
+			}
+			wrapByFlag(result.(map[string]interface{}), count, flag)
+		} else {
+			if result == nil {
+				result = 0
+			}
+			result = result.(int) + count
+		}
+	}
+
 	for _, table := range tables {
 		SQL := fmt.Sprintf(`
 			SELECT
@@ -206,7 +253,7 @@ func CountData(siteID, dataType string, stationID, monitorID, monitorCodeID []in
 			%s
 		`, field, table, strings.Join(whereStmts, " AND "), groupBy)
 
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		rows, err := datasource.GetSiteQueryConn(siteID, readOnly).Query(SQL, values...)
 		if err != nil {
 			log.Println("error count data: ", err)
 			return 0, err
@@ -233,28 +280,54 @@ func CountData(siteID, dataType string, stationID, monitorID, monitorCodeID []in
 				return result, err
 			}
 
-			if groupByStation {
-				if result == nil {
-					result = make(map[int]interface{})
-				}
-				wrapByStation(result.(map[int]interface{}), count, stationID, mid, flag, groupByMonitor, groupByFlag)
-			} else if groupByMonitor {
-				if result == nil {
-					result = make(map[int]interface{})
-				}
-				wrapByMonitor(result.(map[int]interface{}), count, mid, flag, groupByFlag)
-			} else if groupByFlag {
-				if result == nil {
-					result = make(map[string]interface{})
-				}
-				wrapByFlag(result.(map[string]interface{}), count, flag)
+			wrap(count, stationID, mid, flag)
+		}
+	}
+
+	archived, err := fetchArchiveFileData(siteID, dataType, m.MonitorField, stationID, monitorID, monitorCodeID, beginTime, endTime, flag, append(SelectColumn(siteID), RTD, AVG, MIN, MAX, COU))
+	if err != nil {
+		return result, err
+	}
+	if len(criterias) > 0 {
+		archived = criterias.FilterData(archived, false)
+	}
+
+	//归档文件数据按相同分组计数
+	type countKey struct {
+		stationID int
+		monitorID int
+		flag      string
+	}
+	archivedCounts := make(map[countKey]map[time.Time]int)
+	for _, d := range archived {
+		var key countKey
+		if groupByStation {
+			key.stationID = d.GetStationID()
+		}
+		if groupByMonitor {
+			if m.MonitorField == MONITOR_CODE_ID {
+				key.monitorID = d.GetMonitorCodeID()
 			} else {
-				if result == nil {
-					result = 0
-				}
-				result = result.(int) + count
+				key.monitorID = d.GetMonitorID()
 			}
 		}
+		if groupByFlag {
+			key.flag = d.GetFlag()
+		}
+		if _, exists := archivedCounts[key]; !exists {
+			archivedCounts[key] = make(map[time.Time]int)
+		}
+		archivedCounts[key][time.Time(d.GetDataTime())]++
+	}
+	for key, times := range archivedCounts {
+		count := len(times)
+		if !groupByTime {
+			count = 0
+			for _, c := range times {
+				count += c
+			}
+		}
+		wrap(count, key.stationID, key.monitorID, key.flag)
 	}
 
 	return result, nil
@@ -280,78 +353,7 @@ func GetData(siteID, dataType string, stationID, monitorID, monitorCodeID []int,
 		return nil, err
 	}
 
-	whereStmts := make([]string, 0)
-	values := make([]interface{}, 0)
-
-	if len(stationID) == 1 {
-		whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", STATION_ID))
-		values = append(values, stationID[0])
-	} else {
-		placeholder := make([]string, 0)
-		for _, id := range stationID {
-			placeholder = append(placeholder, "?")
-			values = append(values, id)
-		}
-		whereStmts = append(whereStmts, fmt.Sprintf("data.%s IN (%s)", STATION_ID, strings.Join(placeholder, ",")))
-	}
-
-	if m.MonitorField == MONITOR_CODE_ID && len(monitorCodeID) > 0 {
-		if len(monitorCodeID) == 1 {
-			whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", MONITOR_CODE_ID))
-			values = append(values, monitorCodeID[0])
-		} else {
-			placeholder := make([]string, 0)
-			for _, id := range monitorCodeID {
-				placeholder = append(placeholder, "?")
-				values = append(values, id)
-			}
-			whereStmts = append(whereStmts, fmt.Sprintf("data.%s IN (%s)", MONITOR_CODE_ID, strings.Join(placeholder, ",")))
-		}
-	}
-
-	if m.MonitorField == MONITOR_ID && len(monitorID) > 0 {
-		if len(monitorID) == 1 {
-			whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", MONITOR_ID))
-			values = append(values, monitorID[0])
-		} else {
-			placeholder := make([]string, 0)
-			for _, id := range monitorID {
-				placeholder = append(placeholder, "?")
-				values = append(values, id)
-			}
-			whereStmts = append(whereStmts, fmt.Sprintf("data.%s IN (%s)", MONITOR_ID, strings.Join(placeholder, ",")))
-		}
-	}
-
-	if beginTime.Equal(endTime) {
-		whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", DATA_TIME))
-		values = append(values, beginTime)
-	} else {
-		whereStmts = append(whereStmts, fmt.Sprintf("data.%s >= ? AND data.%s <= ?", DATA_TIME, DATA_TIME))
-		values = append(values, beginTime, endTime)
-	}
-
-	if len(flag) > 0 {
-		if len(flag) == 1 {
-			whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", FLAG))
-			values = append(values, flag[0])
-		} else {
-			placeholder := make([]string, 0)
-			for _, f := range flag {
-				placeholder = append(placeholder, "?")
-				values = append(values, f)
-			}
-			whereStmts = append(whereStmts, fmt.Sprintf("data.%s IN (%s)", FLAG, strings.Join(placeholder, ",")))
-		}
-	}
-
-	if len(criterias) > 0 {
-		subSQL, subValues := criterias.ParseSQL(dataType, "data")
-		if subSQL != "" {
-			whereStmts = append(whereStmts, subSQL)
-			values = append(values, subValues...)
-		}
-	}
+	whereStmts, values := buildDataWhere(m, dataType, stationID, monitorID, monitorCodeID, beginTime, endTime, flag, criterias)
 
 	var columns []string
 	var instance func() IData
@@ -392,7 +394,7 @@ func GetData(siteID, dataType string, stationID, monitorID, monitorCodeID []int,
 				%s
 		`, strings.Join(fields, ","), table, strings.Join(whereStmts, " AND "))
 
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 		if err != nil {
 			log.Println("error get data: ", err)
 			return nil, err
@@ -410,6 +412,15 @@ func GetData(siteID, dataType string, stationID, monitorID, monitorCodeID []int,
 		}
 	}
 
+	archived, err := fetchArchiveFileData(siteID, dataType, m.MonitorField, stationID, monitorID, monitorCodeID, beginTime, endTime, flag, columns)
+	if err != nil {
+		return nil, err
+	}
+	if len(criterias) > 0 {
+		archived = criterias.FilterData(archived, false)
+	}
+	result = append(result, archived...)
+
 	return result, nil
 }
 
@@ -450,6 +461,15 @@ func getDataTimeEntries(dataType string, beginTime, endTime time.Time) []time.Ti
 }
 
 func GetDataVacancy(siteID, dataType string, stationID []int, beginTime, endTime time.Time) (map[int][][2]time.Time, error) {
+	return getDataVacancy(false, siteID, dataType, stationID, beginTime, endTime)
+}
+
+//供接口查询 可读从库
+func GetDataVacancyReadOnly(siteID, dataType string, stationID []int, beginTime, endTime time.Time) (map[int][][2]time.Time, error) {
+	return getDataVacancy(true, siteID, dataType, stationID, beginTime, endTime)
+}
+
+func getDataVacancy(readOnly bool, siteID, dataType string, stationID []int, beginTime, endTime time.Time) (map[int][][2]time.Time, error) {
 
 	result := make(map[int][][2]time.Time)
 
@@ -510,7 +530,7 @@ func GetDataVacancy(siteID, dataType string, stationID []int, beginTime, endTime
 				%s
 		`, DATA_TIME, STATION_ID, table, strings.Join(whereStmts, " AND "))
 
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		rows, err := datasource.GetSiteQueryConn(siteID, readOnly).Query(SQL, values...)
 		if err != nil {
 			log.Println("error get data: ", err)
 			return nil, err
@@ -580,7 +600,7 @@ func GetDataVacancy(siteID, dataType string, stationID []int, beginTime, endTime
 
 	if len(needTraceBackStationIDs) > 0 {
 
-		traceTimes, err := getMaxDataTime(siteID, dataType, needTraceBackStationIDs, beginTime)
+		traceTimes, err := getMaxDataTime(readOnly, siteID, dataType, needTraceBackStationIDs, beginTime)
 		if err != nil {
 			return nil, err
 		}
@@ -621,7 +641,7 @@ func GetDataVacancy(siteID, dataType string, stationID []int, beginTime, endTime
 	return result, nil
 }
 
-func getMaxDataTime(siteID, dataType string, stationID []int, beforeTime time.Time) (map[int]time.Time, error) {
+func getMaxDataTime(readOnly bool, siteID, dataType string, stationID []int, beforeTime time.Time) (map[int]time.Time, error) {
 
 	result := make(map[int]time.Time)
 
@@ -658,7 +678,7 @@ func getMaxDataTime(siteID, dataType string, stationID []int, beforeTime time.Ti
 			data.%s
 	`, DATA_TIME, STATION_ID, TableName(siteID, dataType), strings.Join(whereStmts, " AND "), STATION_ID)
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteQueryConn(siteID, readOnly).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get max data time: ", err)
 		return nil, err
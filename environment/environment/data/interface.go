@@ -45,6 +45,8 @@ type data struct {
 	OriginData    map[string]interface{} `json:"originData,omitempty"`
 
 	OriginDataLock sync.RWMutex `json:"-"`
+
+	revision revisionContext
 }
 
 func (d *data) GetID() int   { return d.ID }
@@ -133,4 +135,4 @@ type review struct {
 }
 
 func (d *review) GetReviewed() bool       { return d.Reviewed }
-func (d *review) SetReview(reviewed bool) { d.Reviewed = reviewed }
+func (d *review) SetReviewed(reviewed bool) { d.Reviewed = reviewed }
@@ -20,6 +20,11 @@ type DataModule struct {
 	RotationBatchSize       int           `json:"rotationBatchSize"`
 	Rotations               []*Rotation   `json:"rotations"`
 	ArchiveActiveTimeoutMin time.Duration `json:"archiveActiveTimeoutMin"`
+	Aggregation             *Aggregation  `json:"aggregation,omitempty"`
+	LateDataThresholdMin    int           `json:"lateDataThresholdMin,omitempty"`
+	RecomputeDelayMin       int           `json:"recomputeDelayMin,omitempty"`
+	Retentions              []*Retention  `json:"retentions,omitempty"`
+	TSDB                    *TSDB         `json:"tsdb,omitempty"`
 }
 
 func GetModule(siteID string) (*DataModule, error) {
@@ -68,6 +73,36 @@ func (m *DataModule) Save(siteID string) error {
 		}
 	}
 
+	if m.LateDataThresholdMin < 0 || m.RecomputeDelayMin < 0 {
+		return errors.New("补传数据时长不正确")
+	}
+
+	if m.Aggregation != nil {
+		if err := m.Aggregation.validate(); err != nil {
+			return err
+		}
+	}
+
+	if m.TSDB != nil {
+		if err := m.TSDB.validate(); err != nil {
+			return err
+		}
+	}
+
+	retentions := make(map[string]byte)
+	for _, r := range m.Retentions {
+		if TableName(siteID, r.DataType) == "" {
+			return e_invalid_data_type
+		}
+		if _, exists := retentions[r.DataType]; exists {
+			return errors.New("重复的数据类型")
+		}
+		if r.Days < 0 {
+			return errors.New("保留期限不正确")
+		}
+		retentions[r.DataType] = 1
+	}
+
 	for _, r := range m.Rotations {
 
 		switch r.DataType {
@@ -104,7 +139,7 @@ func (m *DataModule) Save(siteID string) error {
 		checked[r.DataType] = 1
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_DATA, true)
 		if err != nil {
 			panic(err)
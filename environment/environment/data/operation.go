@@ -11,6 +11,7 @@ import (
 
 	"obsessiontech/common/datasource"
 	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data/querycache"
 )
 
 const (
@@ -31,12 +32,15 @@ const (
 	FLAG     = "flag"
 	FLAG_BIT = "flag_bit"
 	REVIEWED = "reviewed"
+
+	ORIGIN_UNIT = "unit"
 )
 
 var e_invalid_data_type = errors.New("数据类型不正确")
 var e_invalid_data_interface = errors.New("数据接口未实现")
 var e_invalid_data_value = errors.New("数据与类型不吻合")
 var E_data_exists = errors.New("数据已存在")
+var E_data_reviewed = errors.New("数据已审核锁定")
 
 var updateColumn = []string{FLAG, FLAG_BIT, ORIGIN_DATA}
 var IntervalColumn = []string{AVG, MIN, MAX, COU}
@@ -237,6 +241,11 @@ func Scan(siteID string, rows *sql.Rows, d IData, columns []string) (e error) {
 	return rows.Scan(dest...)
 }
 
+//接口查询读从库 缓存按从库滞后窗口判断
+func init() {
+	querycache.ReplicaLag = datasource.Config.ReplicaMaxLagSec * time.Second
+}
+
 func Add(siteID string, d IData) error {
 
 	columns, _, values := insertWrapping(siteID, d)
@@ -248,7 +257,7 @@ func Add(siteID string, d IData) error {
 
 	table := TableName(siteID, d.GetDataType())
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(%s)
 		VALUES
@@ -266,6 +275,9 @@ func Add(siteID string, d IData) error {
 		d.SetID(int(id))
 	}
 
+	mirrorData(siteID, d)
+	querycache.Invalidate(siteID, d.GetDataType(), d.GetStationID(), time.Time(d.GetDataTime()))
+
 	return nil
 }
 
@@ -277,14 +289,17 @@ func AddUpdate(siteID string, d IData) error {
 		placeholder[i] = "?"
 	}
 
-	var updates = []string{"update_time = Now()", "origin_data = VALUES(origin_data)"}
-	for _, v := range valueColumns {
-		updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", v, v))
-	}
-
+	var updates = []string{"update_time = Now()"}
 	if _, ok := d.(IReview); ok {
-		updates = append(updates, fmt.Sprintf("%s = ?", REVIEWED))
-		values = append(values, 0)
+		//已审核数据不被覆盖
+		for _, v := range append([]string{ORIGIN_DATA}, valueColumns...) {
+			updates = append(updates, fmt.Sprintf("%s = IF(%s > 0, %s, VALUES(%s))", v, REVIEWED, v, v))
+		}
+	} else {
+		updates = append(updates, "origin_data = VALUES(origin_data)")
+		for _, v := range valueColumns {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", v, v))
+		}
 	}
 
 	table := TableName(siteID, d.GetDataType())
@@ -298,7 +313,7 @@ func AddUpdate(siteID string, d IData) error {
 			%s
 	`, table, strings.Join(columns, ","), strings.Join(placeholder, ","), strings.Join(updates, ","))
 
-	if ret, err := datasource.GetConn().Exec(SQL, values...); err != nil {
+	if ret, err := datasource.GetSiteConn(siteID).Exec(SQL, values...); err != nil {
 		log.Println("error insert data: ", SQL, values, err)
 		return err
 	} else if id, err := ret.LastInsertId(); err != nil {
@@ -308,6 +323,9 @@ func AddUpdate(siteID string, d IData) error {
 		d.SetID(int(id))
 	}
 
+	mirrorData(siteID, d)
+	querycache.Invalidate(siteID, d.GetDataType(), d.GetStationID(), time.Time(d.GetDataTime()))
+
 	return nil
 }
 
@@ -371,7 +389,6 @@ func update(siteID string, txn *sql.Tx, d IData, field ...string) error {
 				values = append(values, d.GetFlagBit())
 			case REVIEWED:
 				if reviewed, ok := d.(IReview); ok {
-					columns = append(columns, REVIEWED)
 					if reviewed.GetReviewed() {
 						values = append(values, 1)
 					} else {
@@ -412,15 +429,6 @@ func update(siteID string, txn *sql.Tx, d IData, field ...string) error {
 		} else {
 			return e_invalid_data_interface
 		}
-
-		if reviewed, ok := d.(IReview); ok {
-			columns = append(columns, REVIEWED)
-			if reviewed.GetReviewed() {
-				values = append(values, 1)
-			} else {
-				values = append(values, 0)
-			}
-		}
 	}
 
 	for _, v := range columns {
@@ -438,6 +446,21 @@ func update(siteID string, txn *sql.Tx, d IData, field ...string) error {
 
 	values = append(values, whereValues...)
 
+	//已审核数据仅允许通过审核修改
+	locked := false
+	if _, ok := d.(IReview); ok {
+		locked = true
+		for _, f := range field {
+			if f == REVIEWED {
+				locked = false
+				break
+			}
+		}
+		if locked {
+			whereStmts = append(whereStmts, REVIEWED+"=0")
+		}
+	}
+
 	SQL := fmt.Sprintf(`
 		UPDATE
 			%s
@@ -447,11 +470,12 @@ func update(siteID string, txn *sql.Tx, d IData, field ...string) error {
 			%s
 	`, table, strings.Join(updates, ","), strings.Join(whereStmts, " AND "))
 
+	var ret sql.Result
 	var err error
 	if txn != nil {
-		_, err = txn.Exec(SQL, values...)
+		ret, err = txn.Exec(SQL, values...)
 	} else {
-		_, err = datasource.GetConn().Exec(SQL, values...)
+		ret, err = datasource.GetSiteConn(siteID).Exec(SQL, values...)
 	}
 
 	if err != nil {
@@ -459,14 +483,65 @@ func update(siteID string, txn *sql.Tx, d IData, field ...string) error {
 		return err
 	}
 
-	return nil
+	if locked {
+		if affected, err := ret.RowsAffected(); err == nil && affected == 0 {
+			discardRevision(d)
+			return nil
+		}
+	}
+
+	//提交前失效 并发查询可能读到提交前的数据并写入缓存
+	datasource.AfterCommit(txn, func() {
+		mirrorData(siteID, d, field...)
+		querycache.Invalidate(siteID, d.GetDataType(), d.GetStationID(), time.Time(d.GetDataTime()))
+	})
+
+	return flushRevision(siteID, txn, d)
+}
+
+func IsReviewable(dataType string) bool {
+	switch dataType {
+	case HOURLY, DAILY:
+		return true
+	}
+	return false
+}
+
+func IsReviewed(d IData) bool {
+	if review, ok := d.(IReview); ok {
+		return review.GetReviewed()
+	}
+	return false
 }
 
 func Delete(siteID string, d IData) error {
 
 	table := TableName(siteID, d.GetDataType())
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	m, err := GetModule(siteID)
+	if err != nil {
+		return err
+	}
+
+	//以库中记录为准 供同步删除时序库
+	var stationID, monitor int
+	var dataTime time.Time
+	if err := datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf(`
+		SELECT
+			%s, %s, %s
+		FROM
+			%s
+		WHERE
+			id = ?
+	`, STATION_ID, m.MonitorField, DATA_TIME, table), d.GetID()).Scan(&stationID, &monitor, &dataTime); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		log.Println("error get data to delete: ", err)
+		return err
+	}
+
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -476,41 +551,74 @@ func Delete(siteID string, d IData) error {
 		return err
 	}
 
+	del := &tsdbDelete{
+		dataType:  d.GetDataType(),
+		stationID: stationID,
+		beginTime: dataTime,
+		endTime:   dataTime.Add(time.Second),
+	}
+
+	d.SetStationID(stationID)
+	d.SetDataTime(util.Time(dataTime))
+	switch m.MonitorField {
+	case MONITOR_ID:
+		d.SetMonitorID(monitor)
+		del.monitorID = monitor
+	case MONITOR_CODE_ID:
+		d.SetMonitorCodeID(monitor)
+		del.monitorCodeID = monitor
+	}
+
+	mirrorDelete(siteID, del)
+	querycache.Invalidate(siteID, d.GetDataType(), stationID, dataTime)
+
 	return nil
 }
 
 func RestoreValue(d IData, field ...string) bool {
 	restored := false
+	valueRestored := false
 
-	d.RLockOriginData()
-	defer d.RUnlockOriginData()
+	d.LockOriginData()
+	defer d.UnLockOriginData()
 
 	if len(field) == 0 {
 		if rtd, ok := d.(IRealTime); ok {
 			if origin, exists := d.GetOriginData()[RTD]; exists {
+				recordValueRevision(d, RTD, rtd.GetRtd(), origin.(float64), -1)
 				rtd.SetRtd(origin.(float64))
 				restored = true
+				valueRestored = true
 			}
 		} else if interval, ok := d.(IInterval); ok {
 			if origin, exists := d.GetOriginData()[AVG]; exists {
+				recordValueRevision(d, AVG, interval.GetAvg(), origin.(float64), -1)
 				interval.SetAvg(origin.(float64))
 				restored = true
+				valueRestored = true
 			}
 			if origin, exists := d.GetOriginData()[MIN]; exists {
+				recordValueRevision(d, MIN, interval.GetMin(), origin.(float64), -1)
 				interval.SetMin(origin.(float64))
 				restored = true
+				valueRestored = true
 			}
 			if origin, exists := d.GetOriginData()[MAX]; exists {
+				recordValueRevision(d, MAX, interval.GetMax(), origin.(float64), -1)
 				interval.SetMax(origin.(float64))
 				restored = true
+				valueRestored = true
 			}
 			if origin, exists := d.GetOriginData()[COU]; exists {
+				recordValueRevision(d, COU, interval.GetCou(), origin.(float64), -1)
 				interval.SetCou(origin.(float64))
 				restored = true
+				valueRestored = true
 			}
 		}
 
 		if origin, exists := d.GetOriginData()[FLAG]; exists {
+			recordRevision(d, FLAG, nil, nil, d.GetFlag(), origin.(string), -1)
 			d.SetFlag(origin.(string))
 			restored = true
 		}
@@ -527,8 +635,10 @@ func RestoreValue(d IData, field ...string) bool {
 					continue
 				}
 				if origin, exists := d.GetOriginData()[RTD]; exists {
+					recordValueRevision(d, RTD, rtd.GetRtd(), origin.(float64), -1)
 					rtd.SetRtd(origin.(float64))
 					restored = true
+					valueRestored = true
 				}
 			case AVG:
 				interval, ok := d.(IInterval)
@@ -536,8 +646,10 @@ func RestoreValue(d IData, field ...string) bool {
 					continue
 				}
 				if origin, exists := d.GetOriginData()[AVG]; exists {
+					recordValueRevision(d, AVG, interval.GetAvg(), origin.(float64), -1)
 					interval.SetAvg(origin.(float64))
 					restored = true
+					valueRestored = true
 				}
 			case MIN:
 				interval, ok := d.(IInterval)
@@ -545,8 +657,10 @@ func RestoreValue(d IData, field ...string) bool {
 					continue
 				}
 				if origin, exists := d.GetOriginData()[MIN]; exists {
+					recordValueRevision(d, MIN, interval.GetMin(), origin.(float64), -1)
 					interval.SetMin(origin.(float64))
 					restored = true
+					valueRestored = true
 				}
 			case MAX:
 				interval, ok := d.(IInterval)
@@ -554,8 +668,10 @@ func RestoreValue(d IData, field ...string) bool {
 					continue
 				}
 				if origin, exists := d.GetOriginData()[MAX]; exists {
+					recordValueRevision(d, MAX, interval.GetMax(), origin.(float64), -1)
 					interval.SetMax(origin.(float64))
 					restored = true
+					valueRestored = true
 				}
 			case COU:
 				interval, ok := d.(IInterval)
@@ -563,11 +679,14 @@ func RestoreValue(d IData, field ...string) bool {
 					continue
 				}
 				if origin, exists := d.GetOriginData()[COU]; exists {
+					recordValueRevision(d, COU, interval.GetCou(), origin.(float64), -1)
 					interval.SetCou(origin.(float64))
 					restored = true
+					valueRestored = true
 				}
 			case FLAG:
 				if origin, exists := d.GetOriginData()[FLAG]; exists {
+					recordRevision(d, FLAG, nil, nil, d.GetFlag(), origin.(string), -1)
 					d.SetFlag(origin.(string))
 					restored = true
 				}
@@ -580,6 +699,11 @@ func RestoreValue(d IData, field ...string) bool {
 		}
 	}
 
+	//数值已还原为换算前的原值 换算标记随之失效 以便再次换算
+	if valueRestored {
+		delete(d.GetOriginData(), ORIGIN_UNIT)
+	}
+
 	return restored
 }
 
@@ -602,6 +726,7 @@ func ModifyValue(d IData, field string, value interface{}, uid int) error {
 		}
 		origin = rtd.GetRtd()
 		rtd.SetRtd(v)
+		recordValueRevision(d, RTD, origin.(float64), v, uid)
 	case AVG:
 		interval, ok := d.(IInterval)
 		if !ok {
@@ -616,6 +741,7 @@ func ModifyValue(d IData, field string, value interface{}, uid int) error {
 		}
 		origin = interval.GetAvg()
 		interval.SetAvg(v)
+		recordValueRevision(d, AVG, origin.(float64), v, uid)
 	case MIN:
 		interval, ok := d.(IInterval)
 		if !ok {
@@ -630,6 +756,7 @@ func ModifyValue(d IData, field string, value interface{}, uid int) error {
 		}
 		origin = interval.GetMin()
 		interval.SetMin(v)
+		recordValueRevision(d, MIN, origin.(float64), v, uid)
 	case MAX:
 		interval, ok := d.(IInterval)
 		if !ok {
@@ -644,6 +771,7 @@ func ModifyValue(d IData, field string, value interface{}, uid int) error {
 		}
 		origin = interval.GetMax()
 		interval.SetMax(v)
+		recordValueRevision(d, MAX, origin.(float64), v, uid)
 	case COU:
 		interval, ok := d.(IInterval)
 		if !ok {
@@ -658,6 +786,7 @@ func ModifyValue(d IData, field string, value interface{}, uid int) error {
 		}
 		origin = interval.GetCou()
 		interval.SetCou(v)
+		recordValueRevision(d, COU, origin.(float64), v, uid)
 	case FLAG:
 		v, ok := value.(string)
 		if !ok {
@@ -669,6 +798,7 @@ func ModifyValue(d IData, field string, value interface{}, uid int) error {
 		if d.GetFlag() != "" {
 			origin = d.GetFlag()
 		}
+		recordRevision(d, FLAG, nil, nil, d.GetFlag(), v, uid)
 		d.SetFlag(v)
 	case FLAG_BIT:
 		v, ok := value.(int)
@@ -0,0 +1,308 @@
+package operation
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	myContext "obsessiontech/common/context"
+	"obsessiontech/common/datasource"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+	"obsessiontech/environment/environment/monitor"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(data.MODULE_DATA, "realtimedata", aggregationMigration)
+}
+
+type aggregationKey struct {
+	dataType  string
+	stationID int
+	monitorID int
+	beginTime int64
+}
+
+type aggregationPeriod struct {
+	beginTime time.Time
+	endTime   time.Time
+	markCount int
+}
+
+func aggregationTableName(siteID string) string {
+	return siteID + "_dataaggregation"
+}
+
+//待聚合周期表 各写入途径标记 接收进程定时生成 重启后延续
+var aggregationMigration = &initialization.Migration{
+	Version:     6,
+	Description: "待聚合周期",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_dataaggregation (
+			data_type VARCHAR(32) NOT NULL,
+			station_id INT NOT NULL,
+			monitor_id INT NOT NULL,
+			begin_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			mark_count INT NOT NULL DEFAULT 1,
+			PRIMARY KEY (data_type, station_id, monitor_id, begin_time),
+			KEY (end_time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+//迟到数据再次标记时累加次数 生成期间被再次标记的周期保留至下次生成
+func markAggregation(siteID string, pending map[aggregationKey]*aggregationPeriod) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	placeholder := make([]string, 0)
+	values := make([]interface{}, 0)
+	for key, period := range pending {
+		placeholder = append(placeholder, "(?,?,?,?,?)")
+		values = append(values, key.dataType, key.stationID, key.monitorID, period.beginTime, period.endTime)
+	}
+
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(data_type,station_id,monitor_id,begin_time,end_time)
+		VALUES
+			%s
+		ON DUPLICATE KEY UPDATE
+			end_time=VALUES(end_time),mark_count=mark_count+1
+	`, aggregationTableName(siteID), strings.Join(placeholder, ",")), values...); err != nil {
+		log.Println("error mark aggregation: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func getDueAggregation(siteID string, due time.Time) (map[aggregationKey]*aggregationPeriod, error) {
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			data_type, station_id, monitor_id, begin_time, end_time, mark_count
+		FROM
+			%s
+		WHERE
+			end_time <= ?
+	`, aggregationTableName(siteID)), due)
+	if err != nil {
+		log.Println("error get due aggregation: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[aggregationKey]*aggregationPeriod)
+	for rows.Next() {
+		var key aggregationKey
+		var period aggregationPeriod
+		if err := rows.Scan(&key.dataType, &key.stationID, &key.monitorID, &period.beginTime, &period.endTime, &period.markCount); err != nil {
+			log.Println("error get due aggregation: ", err)
+			return nil, err
+		}
+		key.beginTime = period.beginTime.Unix()
+		result[key] = &period
+	}
+
+	return result, rows.Err()
+}
+
+func removeAggregation(siteID string, key aggregationKey, period *aggregationPeriod) error {
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		DELETE FROM
+			%s
+		WHERE
+			data_type = ? AND station_id = ? AND monitor_id = ? AND begin_time = ? AND mark_count = ?
+	`, aggregationTableName(siteID)), key.dataType, key.stationID, key.monitorID, period.beginTime, period.markCount); err != nil {
+		log.Println("error remove aggregation: ", err)
+		return err
+	}
+	return nil
+}
+
+//数据保存后调用 标记以其为来源的聚合周期
+func NotifyAggregation(siteID string, dataset ...data.IData) {
+
+	m, err := data.GetModule(siteID)
+	if err != nil {
+		log.Println("error get data module: ", err)
+		return
+	}
+
+	a := m.Aggregation
+	if a == nil || len(a.DataTypes) == 0 {
+		return
+	}
+
+	if err := markAggregation(siteID, detectAggregation(a, dataset...)); err != nil {
+		log.Println("error notify aggregation: ", siteID, err)
+	}
+}
+
+func detectAggregation(a *data.Aggregation, dataset ...data.IData) map[aggregationKey]*aggregationPeriod {
+	result := make(map[aggregationKey]*aggregationPeriod)
+
+	mark := func(dataType string, stationID, monitorID int, dataTime time.Time) {
+		beginTime, endTime := a.GetPeriod(dataType, dataTime)
+		result[aggregationKey{dataType: dataType, stationID: stationID, monitorID: monitorID, beginTime: beginTime.Unix()}] = &aggregationPeriod{beginTime: beginTime, endTime: endTime}
+	}
+
+	for _, d := range dataset {
+		policy := a.GetPolicy(d.GetStationID())
+		if policy == data.AGGREGATION_POLICY_OFF {
+			continue
+		}
+
+		if target := data.GetAggregationTarget(d.GetDataType()); target != "" && a.IsEnabled(target) {
+			mark(target, d.GetStationID(), d.GetMonitorID(), time.Time(d.GetDataTime()))
+		}
+
+		//聚合优先时 设备上传的同类数据重新计算覆盖
+		if policy == data.AGGREGATION_POLICY_AGGREGATE && a.IsEnabled(d.GetDataType()) && !data.IsAggregated(d) {
+			mark(d.GetDataType(), d.GetStationID(), d.GetMonitorID(), time.Time(d.GetDataTime()))
+		}
+	}
+
+	return result
+}
+
+//定时生成已结束周期的聚合数据 生成的数据经upload保存并处理
+func StartAggregation(siteID string, upload dataprocess.IDataUpload) {
+
+	ctx, cancel := myContext.GetContext()
+	defer cancel()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	log.Println("aggregation started: ", siteID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("aggregation stop: ", siteID)
+			return
+		case <-ticker.C:
+			if err := runAggregation(siteID, upload); err != nil {
+				log.Println("error run aggregation: ", siteID, err)
+			}
+		}
+	}
+}
+
+func runAggregation(siteID string, upload dataprocess.IDataUpload) error {
+
+	m, err := data.GetModule(siteID)
+	if err != nil {
+		return err
+	}
+
+	a := m.Aggregation
+	if a == nil {
+		return nil
+	}
+
+	delay := time.Minute * time.Duration(a.DelayMin)
+
+	due, err := getDueAggregation(siteID, time.Now().Add(-1*delay))
+	if err != nil {
+		return err
+	}
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	generated := make([]data.IData, 0)
+
+	for key, period := range due {
+		d, err := aggregate(siteID, a, key.dataType, key.stationID, key.monitorID, period.beginTime, period.endTime)
+		if err != nil {
+			log.Printf("error aggregate: stationID[%d] dataType[%s] monitorID[%d] dataTime[%v] err[%v]", key.stationID, key.dataType, key.monitorID, period.beginTime, err)
+			continue
+		}
+		if d != nil {
+			generated = append(generated, d)
+		}
+	}
+
+	if len(generated) > 0 {
+		uploader := new(dataprocess.Uploader)
+		if err := uploader.UploadBatchData(siteID, upload, generated...); err != nil {
+			return err
+		}
+		if err := uploader.UploadUnuploaded(siteID, upload); err != nil {
+			return err
+		}
+	}
+
+	//保存成功后移除 失败时保留至下次重试
+	for key, period := range due {
+		if err := removeAggregation(siteID, key, period); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func aggregate(siteID string, a *data.Aggregation, dataType string, stationID, monitorID int, beginTime, endTime time.Time) (data.IData, error) {
+
+	policy := a.GetPolicy(stationID)
+	if policy == data.AGGREGATION_POLICY_OFF || !a.IsEnabled(dataType) {
+		return nil, nil
+	}
+
+	monitorCode := monitor.GetMonitorCodeByStationMonitor(siteID, stationID, monitorID)
+	if monitorCode == nil {
+		log.Println("no monitor code to aggregate: ", stationID, monitorID)
+		return nil, nil
+	}
+
+	columns := []string{data.ORIGIN_DATA}
+	if data.IsReviewable(dataType) {
+		columns = append(columns, data.REVIEWED)
+	}
+
+	exists, err := data.GetData(siteID, dataType, []int{stationID}, []int{monitorID}, []int{monitorCode.ID}, nil, beginTime, beginTime, nil, columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(exists) > 0 {
+		if data.IsReviewed(exists[0]) {
+			return nil, nil
+		}
+		//设备上传数据优先
+		if policy == data.AGGREGATION_POLICY_DEVICE && !data.IsAggregated(exists[0]) {
+			return nil, nil
+		}
+	}
+
+	d, err := generateTargetData(siteID, dataType, monitorID, stationID, monitorCode.Code, beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	d.SetMonitorCodeID(monitorCode.ID)
+
+	if d.GetFlag() == "" {
+		normal, err := monitor.GetFlagByBit(siteID, monitor.FLAG_NORMAL)
+		if err != nil {
+			return nil, err
+		}
+		if normal != nil {
+			if err := monitor.ChangeFlag(siteID, d, normal.Flag, -1); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data.SetAggregated(d)
+
+	return d, nil
+}
@@ -0,0 +1,52 @@
+package operation
+
+import (
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+	"testing"
+	"time"
+)
+
+func TestDetectAggregation(t *testing.T) {
+	hour := time.Date(2024, 1, 3, 12, 0, 0, 0, time.Local)
+
+	newData := func(d data.IData, stationID, monitorID int, dataTime time.Time) data.IData {
+		d.SetStationID(stationID)
+		d.SetMonitorID(monitorID)
+		d.SetDataTime(util.Time(dataTime))
+		return d
+	}
+
+	a := &data.Aggregation{
+		DataTypes:       []string{data.HOURLY, data.DAILY},
+		Policy:          data.AGGREGATION_POLICY_AGGREGATE,
+		StationPolicies: map[int]string{3: data.AGGREGATION_POLICY_OFF},
+	}
+
+	dataset := []data.IData{
+		//同一小时的分钟数据合并为一个周期
+		newData(new(data.MinutelyData), 1, 1, hour.Add(10*time.Minute)),
+		newData(new(data.MinutelyData), 1, 1, hour.Add(20*time.Minute)),
+		//聚合优先时设备上传的小时数据本身也重新计算
+		newData(new(data.HourlyData), 2, 1, hour),
+		//关闭聚合的排放口不标记
+		newData(new(data.MinutelyData), 3, 1, hour),
+	}
+
+	result := detectAggregation(a, dataset...)
+
+	expected := []aggregationKey{
+		{dataType: data.HOURLY, stationID: 1, monitorID: 1, beginTime: hour.Unix()},
+		{dataType: data.DAILY, stationID: 2, monitorID: 1, beginTime: time.Date(2024, 1, 3, 0, 0, 0, 0, time.Local).Unix()},
+		{dataType: data.HOURLY, stationID: 2, monitorID: 1, beginTime: hour.Unix()},
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d periods, got %d", len(expected), len(result))
+	}
+	for _, key := range expected {
+		if _, exists := result[key]; !exists {
+			t.Errorf("missing period: %+v", key)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package operation
+
+import (
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/monitor"
+)
+
+//校准记录变更后 还原受影响时段的数据并按监测物配置的处理器重新校正
+func Recalibrate(siteID string, actionAuth authority.ActionAuthSet, dataTypes []string, records ...*monitor.Calibration) (map[string]int, error) {
+
+	result := make(map[string]int)
+
+	stationMonitors := make(map[int]map[int]bool)
+	for _, c := range records {
+		if c == nil {
+			continue
+		}
+		if _, exists := stationMonitors[c.StationID]; !exists {
+			stationMonitors[c.StationID] = make(map[int]bool)
+		}
+		stationMonitors[c.StationID][c.MonitorID] = true
+	}
+
+	if len(stationMonitors) == 0 || len(dataTypes) == 0 {
+		return result, nil
+	}
+
+	beginTime, endTime, err := monitor.GetCalibrationAffectedRange(siteID, records...)
+	if err != nil {
+		return result, err
+	}
+
+	for _, dataType := range dataTypes {
+		for stationID, monitors := range stationMonitors {
+			monitorIDs := make([]int, 0)
+			for mid := range monitors {
+				monitorIDs = append(monitorIDs, mid)
+			}
+
+			count, err := Massage(siteID, actionAuth, dataType, []int{stationID}, monitorIDs, nil, beginTime, endTime, "", true, false, nil, "校准记录变更")
+			result[dataType] += count
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -154,7 +154,7 @@ func getExistsDataTime(siteID, dataType string, monitorID, stationID int, beginT
 	tables := data.FetchTableNames(siteID, dataType, beginTime, endTime)
 
 	for _, table := range tables {
-		rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+		rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 			SELECT
 				DISTINCT %s
 			FROM
@@ -191,7 +191,7 @@ func generateTargetData(siteID, dataType string, monitorID, stationID int, code
 	effectFlags := make(map[string]byte)
 
 	for _, f := range monitorModule.Flags {
-		if monitor.CheckFlag(monitor.FLAG_EFFECTIVE, f.Bits) {
+		if monitor.IsEffectiveFlag(f.Bits) {
 			effectFlags[f.Flag] = 1
 		}
 	}
@@ -235,7 +235,7 @@ func generateTargetData(siteID, dataType string, monitorID, stationID int, code
 
 	values := []interface{}{stationID, monitorID, beginTime, endTime}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -327,7 +327,38 @@ func generateTargetData(siteID, dataType string, monitorID, stationID int, code
 	result.SetMonitorID(monitorID)
 	result.SetCode(code)
 
-	if threshold, exists := monitorModule.EffectiveIntervalThreshold[dataType]; exists {
+	if rule := monitorModule.ValidityRules[dataType]; rule != nil {
+
+		var validity *monitor.Validity
+		if rule.SourceDataType == fetchDataType {
+			validity = rule.Check(effectiveCount, endTime.Sub(beginTime))
+		} else if validity, err = monitor.EvaluateValidity(siteID, rule, result); err != nil {
+			return nil, err
+		}
+
+		if effectiveCount > 0 {
+			raw := effectiveTotal / float64(effectiveCount)
+			avg = math.Round(raw*math.Pow10(accuracy)) / math.Pow10(accuracy)
+
+			result.(data.IInterval).SetAvg(avg)
+			result.(data.IInterval).SetMax(max)
+			result.(data.IInterval).SetMin(min)
+			result.(data.IInterval).SetCou(cou)
+		}
+
+		if !validity.Valid {
+			log.Printf("时段有效数据时长不足 stationID[%d] dataType[%s] monitorID[%d] dataTime[%v-%v] effectMin[%v / %v]", stationID, fetchDataType, monitorID, beginTime, endTime, validity.EffectiveMin, validity.RequiredMin)
+
+			flag, err := rule.GetFlag(siteID)
+			if err != nil {
+				return nil, err
+			}
+			if err := monitor.ChangeFlag(siteID, result, flag, -1); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+	} else if threshold, exists := monitorModule.EffectiveIntervalThreshold[dataType]; exists {
 
 		slots := stats.CountSlots(fetchDataType, &beginTime, &endTime)
 
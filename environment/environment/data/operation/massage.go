@@ -12,7 +12,7 @@ import (
 	"obsessiontech/environment/environment/monitor"
 )
 
-func Massage(siteID string, actionAuth authority.ActionAuthSet, dataType string, stationIDs, monitorIDs, monitorCodeIDs []int, beginTime, endTime time.Time, flag string, restoreBeforeProcess, skipNoOrigins bool, processor dataprocess.DataProcessors) (int, error) {
+func Massage(siteID string, actionAuth authority.ActionAuthSet, dataType string, stationIDs, monitorIDs, monitorCodeIDs []int, beginTime, endTime time.Time, flag string, restoreBeforeProcess, skipNoOrigins bool, processor dataprocess.DataProcessors, reason string) (int, error) {
 
 	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, stationIDs, entity.ACTION_ENTITY_EDIT)
 	if err != nil {
@@ -25,7 +25,12 @@ func Massage(siteID string, actionAuth authority.ActionAuthSet, dataType string,
 		}
 	}
 
-	dataList, err := data.GetData(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, nil, beginTime, endTime, nil, data.ORIGIN_DATA)
+	columns := []string{data.ORIGIN_DATA}
+	if data.IsReviewable(dataType) {
+		columns = append(columns, data.REVIEWED)
+	}
+
+	dataList, err := data.GetData(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, nil, beginTime, endTime, nil, columns...)
 	if err != nil {
 		return 0, err
 	}
@@ -49,6 +54,12 @@ func Massage(siteID string, actionAuth authority.ActionAuthSet, dataType string,
 	up.Processors = processor
 
 	for _, d := range dataList {
+		if data.IsReviewed(d) {
+			continue
+		}
+
+		data.SetRevisionContext(d, actionAuth.GetUID(), reason)
+
 		if restoreBeforeProcess {
 			if restored := data.RestoreValue(d); !restored && skipNoOrigins {
 				continue
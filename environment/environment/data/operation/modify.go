@@ -7,7 +7,7 @@ import (
 	"time"
 )
 
-func Modify(siteID string, d data.IData, fields []string, uid int) (data.IData, error) {
+func Modify(siteID string, d data.IData, fields []string, uid int, reason string) (data.IData, error) {
 
 	if len(fields) == 0 {
 		if d.GetDataType() == data.REAL_TIME {
@@ -17,11 +17,20 @@ func Modify(siteID string, d data.IData, fields []string, uid int) (data.IData,
 		}
 	}
 
-	list, err := data.GetData(siteID, d.GetDataType(), []int{d.GetStationID()}, []int{d.GetMonitorID()}, []int{d.GetMonitorCodeID()}, nil, time.Time(d.GetDataTime()), time.Time(d.GetDataTime()), nil, data.ORIGIN_DATA)
+	columns := []string{data.ORIGIN_DATA}
+	if data.IsReviewable(d.GetDataType()) {
+		columns = append(columns, data.REVIEWED)
+	}
+
+	list, err := data.GetData(siteID, d.GetDataType(), []int{d.GetStationID()}, []int{d.GetMonitorID()}, []int{d.GetMonitorCodeID()}, nil, time.Time(d.GetDataTime()), time.Time(d.GetDataTime()), nil, columns...)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(list) > 0 && data.IsReviewed(list[0]) {
+		return nil, data.E_data_reviewed
+	}
+
 	var origin data.IData
 	if len(list) == 0 {
 		origin = d
@@ -29,6 +38,8 @@ func Modify(siteID string, d data.IData, fields []string, uid int) (data.IData,
 		origin = list[0]
 	}
 
+	data.SetRevisionContext(origin, uid, reason)
+
 	for _, f := range fields {
 		switch f {
 		case data.RTD:
@@ -80,8 +91,16 @@ func Modify(siteID string, d data.IData, fields []string, uid int) (data.IData,
 	}
 
 	if len(list) == 0 {
-		return origin, data.Add(siteID, origin)
+		err = data.Add(siteID, origin)
+	} else {
+		err = data.Update(siteID, origin, fields...)
+	}
+	if err != nil {
+		return origin, err
 	}
 
-	return origin, data.Update(siteID, origin, fields...)
+	MarkLateData(siteID, RECOMPUTE_SOURCE_MODIFY, origin)
+	NotifyAggregation(siteID, origin)
+
+	return origin, nil
 }
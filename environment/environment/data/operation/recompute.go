@@ -0,0 +1,487 @@
+package operation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	myContext "obsessiontech/common/context"
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/data/recent"
+	"obsessiontech/environment/environment/dataprocess"
+	"obsessiontech/environment/environment/monitor"
+	"obsessiontech/environment/environment/stats"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(data.MODULE_DATA, "realtimedata", recomputeMigration)
+}
+
+const (
+	RECOMPUTE_SOURCE_DEVICE         = "device"
+	RECOMPUTE_SOURCE_EXCEL          = "excel"
+	RECOMPUTE_SOURCE_MODIFY         = "modify"
+	RECOMPUTE_SOURCE_UPLOAD         = "upload"
+	RECOMPUTE_SOURCE_EXTERNALSOURCE = "externalsource"
+
+	RECOMPUTE_PENDING = "pending"
+	RECOMPUTE_RUNNING = "running"
+	RECOMPUTE_DONE    = "done"
+	RECOMPUTE_FAILED  = "failed"
+)
+
+const recomputeBatchSize = 20
+
+//补传数据导致的待重算时段 按排放口/监测物/日合并
+type Recompute struct {
+	ID         int                    `json:"ID"`
+	DataType   string                 `json:"dataType"`
+	StationID  int                    `json:"stationID"`
+	MonitorID  int                    `json:"monitorID"`
+	BeginTime  util.Time              `json:"beginTime"`
+	EndTime    util.Time              `json:"endTime"`
+	Source     string                 `json:"source"`
+	Status     string                 `json:"status"`
+	Count      int                    `json:"count"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	CreateTime util.Time              `json:"createTime"`
+	UpdateTime util.Time              `json:"updateTime"`
+	FinishTime *util.Time             `json:"finishTime,omitempty"`
+}
+
+func recomputeTableName(siteID string) string {
+	return siteID + "_datarecompute"
+}
+
+//数据重算任务表
+var recomputeMigration = &initialization.Migration{
+	Version:     3,
+	Description: "数据重算任务",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_datarecompute (
+			id INT NOT NULL AUTO_INCREMENT,
+			data_type VARCHAR(32) NOT NULL,
+			station_id INT NOT NULL,
+			monitor_id INT NOT NULL,
+			begin_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			source VARCHAR(32) NOT NULL DEFAULT '',
+			status VARCHAR(32) NOT NULL,
+			count INT NOT NULL DEFAULT 0,
+			result TEXT,
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			update_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			finish_time DATETIME NULL,
+			PRIMARY KEY (id),
+			KEY (status, data_type, station_id, monitor_id, begin_time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+const recomputeColumns = "recompute.id, recompute.data_type, recompute.station_id, recompute.monitor_id, recompute.begin_time, recompute.end_time, recompute.source, recompute.status, recompute.count, recompute.result, recompute.create_time, recompute.update_time, recompute.finish_time"
+
+func (r *Recompute) scan(rows *sql.Rows) error {
+	var beginTime, endTime, createTime, updateTime time.Time
+	var finishTime sql.NullTime
+	var result string
+	if err := rows.Scan(&r.ID, &r.DataType, &r.StationID, &r.MonitorID, &beginTime, &endTime, &r.Source, &r.Status, &r.Count, &result, &createTime, &updateTime, &finishTime); err != nil {
+		return err
+	}
+	r.BeginTime = util.Time(beginTime)
+	r.EndTime = util.Time(endTime)
+	r.CreateTime = util.Time(createTime)
+	r.UpdateTime = util.Time(updateTime)
+	if finishTime.Valid {
+		t := util.Time(finishTime.Time)
+		r.FinishTime = &t
+	}
+	if result != "" {
+		if err := json.Unmarshal([]byte(result), &r.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type recomputeKey struct {
+	dataType  string
+	stationID int
+	monitorID int
+	beginTime int64
+}
+
+//写入数据后调用 早于补传阈值的数据标记所在日期待重算
+func MarkLateData(siteID, source string, dataset ...data.IData) {
+
+	if len(dataset) == 0 {
+		return
+	}
+
+	m, err := data.GetModule(siteID)
+	if err != nil {
+		log.Println("error get data module: ", err)
+		return
+	}
+
+	if m.LateDataThresholdMin <= 0 {
+		return
+	}
+
+	threshold := time.Now().Add(-1 * time.Minute * time.Duration(m.LateDataThresholdMin))
+
+	for _, r := range detectLateData(threshold, dataset...) {
+		if err := markRecompute(siteID, source, r.DataType, r.StationID, r.MonitorID, time.Time(r.BeginTime), time.Time(r.EndTime)); err != nil {
+			log.Println("error mark recompute: ", err)
+		}
+	}
+}
+
+//早于阈值的数据按数据类型/排放口/监测物合并为所在日期的待重算时段
+func detectLateData(threshold time.Time, dataset ...data.IData) []*Recompute {
+
+	result := make([]*Recompute, 0)
+	marked := make(map[recomputeKey]bool)
+
+	for _, d := range dataset {
+		dataTime := time.Time(d.GetDataTime())
+		if !dataTime.Before(threshold) {
+			continue
+		}
+
+		beginTime := util.GetDate(dataTime)
+		key := recomputeKey{dataType: d.GetDataType(), stationID: d.GetStationID(), monitorID: d.GetMonitorID(), beginTime: beginTime.Unix()}
+		if marked[key] {
+			continue
+		}
+		marked[key] = true
+
+		result = append(result, &Recompute{
+			DataType:  d.GetDataType(),
+			StationID: d.GetStationID(),
+			MonitorID: d.GetMonitorID(),
+			BeginTime: util.Time(beginTime),
+			EndTime:   util.Time(beginTime.AddDate(0, 0, 1)),
+		})
+	}
+
+	return result
+}
+
+func markRecompute(siteID, source, dataType string, stationID, monitorID int, beginTime, endTime time.Time) error {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		var id int
+		if err := txn.QueryRow(fmt.Sprintf(`
+			SELECT
+				id
+			FROM
+				%s
+			WHERE
+				status = ? AND data_type = ? AND station_id = ? AND monitor_id = ? AND begin_time = ?
+			FOR UPDATE
+		`, recomputeTableName(siteID)), RECOMPUTE_PENDING, dataType, stationID, monitorID, beginTime).Scan(&id); err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+
+		if id > 0 {
+			if _, err := txn.Exec(fmt.Sprintf(`
+				UPDATE
+					%s
+				SET
+					count = count + 1, update_time = Now()
+				WHERE
+					id = ?
+			`, recomputeTableName(siteID)), id); err != nil {
+				panic(err)
+			}
+			return
+		}
+
+		if _, err := txn.Exec(fmt.Sprintf(`
+			INSERT INTO %s
+				(data_type,station_id,monitor_id,begin_time,end_time,source,status,count,result)
+			VALUES
+				(?,?,?,?,?,?,?,1,'')
+		`, recomputeTableName(siteID)), dataType, stationID, monitorID, beginTime, endTime, source, RECOMPUTE_PENDING); err != nil {
+			panic(err)
+		}
+	})
+}
+
+func GetRecomputes(siteID string, status []string, stationID []int, beginTime, endTime *time.Time) ([]*Recompute, error) {
+
+	whereStmts := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	if len(status) > 0 {
+		placeholder := make([]string, 0)
+		for _, s := range status {
+			placeholder = append(placeholder, "?")
+			values = append(values, s)
+		}
+		whereStmts = append(whereStmts, fmt.Sprintf("recompute.status IN (%s)", strings.Join(placeholder, ",")))
+	}
+
+	if len(stationID) > 0 {
+		placeholder := make([]string, 0)
+		for _, id := range stationID {
+			placeholder = append(placeholder, "?")
+			values = append(values, id)
+		}
+		whereStmts = append(whereStmts, fmt.Sprintf("recompute.station_id IN (%s)", strings.Join(placeholder, ",")))
+	}
+
+	if beginTime != nil {
+		whereStmts = append(whereStmts, "recompute.end_time > ?")
+		values = append(values, *beginTime)
+	}
+
+	if endTime != nil {
+		whereStmts = append(whereStmts, "recompute.begin_time <= ?")
+		values = append(values, *endTime)
+	}
+
+	SQL := fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s recompute
+	`, recomputeColumns, recomputeTableName(siteID))
+
+	if len(whereStmts) > 0 {
+		SQL += " WHERE " + strings.Join(whereStmts, " AND ")
+	}
+
+	SQL += " ORDER BY recompute.id DESC"
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
+	if err != nil {
+		log.Println("error get data recompute: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Recompute, 0)
+	for rows.Next() {
+		var r Recompute
+		if err := r.scan(rows); err != nil {
+			log.Println("error get data recompute: ", err)
+			return nil, err
+		}
+		result = append(result, &r)
+	}
+
+	return result, nil
+}
+
+//定时执行待重算时段 补传结束一段时间后执行 避免补传过程中重复计算
+func StartRecompute(siteID string) {
+
+	ctx, cancel := myContext.GetContext()
+	defer cancel()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	log.Println("recompute started: ", siteID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("recompute stop: ", siteID)
+			return
+		case <-ticker.C:
+			if err := runRecompute(siteID); err != nil {
+				log.Println("error run recompute: ", siteID, err)
+			}
+		}
+	}
+}
+
+func runRecompute(siteID string) error {
+
+	m, err := data.GetModule(siteID)
+	if err != nil {
+		return err
+	}
+
+	settled := time.Now().Add(-1 * time.Minute * time.Duration(m.RecomputeDelayMin))
+
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s recompute
+		WHERE
+			recompute.status = ? AND recompute.update_time <= ?
+		ORDER BY
+			recompute.id ASC
+		LIMIT ?
+	`, recomputeColumns, recomputeTableName(siteID)), RECOMPUTE_PENDING, settled, recomputeBatchSize)
+	if err != nil {
+		return err
+	}
+
+	jobs := make([]*Recompute, 0)
+	for rows.Next() {
+		var r Recompute
+		if err := r.scan(rows); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, &r)
+	}
+	rows.Close()
+
+	for _, job := range jobs {
+		if err := job.run(siteID); err != nil {
+			log.Println("error recompute: ", job.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Recompute) setStatus(siteID, status string, result map[string]interface{}) error {
+
+	var resultStr string
+	if result != nil {
+		b, _ := json.Marshal(result)
+		resultStr = string(b)
+	}
+
+	var finishTime interface{}
+	if status == RECOMPUTE_DONE || status == RECOMPUTE_FAILED {
+		finishTime = time.Now()
+	}
+
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		UPDATE
+			%s
+		SET
+			status = ?, result = ?, finish_time = ?
+		WHERE
+			id = ? AND status = ?
+	`, recomputeTableName(siteID)), status, resultStr, finishTime, r.ID, r.Status)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := ret.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errors.New("重算状态已变更")
+	}
+
+	r.Status = status
+	r.Result = result
+
+	return nil
+}
+
+func (r *Recompute) run(siteID string) error {
+
+	if err := r.setStatus(siteID, RECOMPUTE_RUNNING, nil); err != nil {
+		return err
+	}
+
+	result := make(map[string]interface{})
+
+	derived, err := recomputeDerived(siteID, r.DataType, r.StationID, r.MonitorID, time.Time(r.BeginTime), time.Time(r.EndTime))
+	if err != nil {
+		result["error"] = err.Error()
+		return r.setStatus(siteID, RECOMPUTE_FAILED, result)
+	}
+	result["derived"] = derived
+
+	//数据质量按小时数据统计
+	if r.DataType == data.HOURLY {
+		historyStats, err := stats.RecomputeHistoryDataQuality(siteID, r.StationID, time.Time(r.BeginTime), time.Time(r.EndTime))
+		if err != nil {
+			result["error"] = err.Error()
+			return r.setStatus(siteID, RECOMPUTE_FAILED, result)
+		}
+		result["historyStats"] = historyStats
+	}
+
+	recent.ClearCache(siteID, r.StationID)
+
+	return r.setStatus(siteID, RECOMPUTE_DONE, result)
+}
+
+//重新执行依赖该监测物的summary及total处理器
+func recomputeDerived(siteID, dataType string, stationID, monitorID int, beginTime, endTime time.Time) (int, error) {
+
+	if err := monitor.LoadMonitorCode(siteID); err != nil {
+		return 0, err
+	}
+
+	codes, err := monitor.GetMonitorCodes(siteID, 0, "", stationID)
+	if err != nil {
+		return 0, err
+	}
+
+	uper := new(dataprocess.Uploader)
+	up := new(Upload)
+
+	count := 0
+
+	process := func(processors dataprocess.DataProcessors, targetMonitorID, targetMonitorCodeID int) error {
+		if len(processors) == 0 {
+			return nil
+		}
+
+		columns := []string{data.ORIGIN_DATA}
+		if data.IsReviewable(dataType) {
+			columns = append(columns, data.REVIEWED)
+		}
+
+		list, err := data.GetData(siteID, dataType, []int{stationID}, []int{targetMonitorID}, []int{targetMonitorCodeID}, nil, beginTime, endTime.Add(-1*time.Second), nil, columns...)
+		if err != nil {
+			return err
+		}
+
+		if err := processors.Process(siteID, uper, up, list...); err != nil {
+			return err
+		}
+		count += len(list)
+
+		return nil
+	}
+
+	for _, code := range codes {
+		derived := make(dataprocess.DataProcessors, 0)
+
+		for _, p := range code.Processors {
+			switch processor := p.(type) {
+			case *summaryProcessor:
+				if code.MonitorID == monitorID {
+					derived = append(derived, processor)
+				}
+			case *totalProcessor:
+				for _, a := range processor.Adders {
+					if a.MonitorID == monitorID {
+						derived = append(derived, processor)
+						break
+					}
+				}
+			}
+		}
+
+		if err := process(derived, code.MonitorID, code.ID); err != nil {
+			return count, err
+		}
+	}
+
+	if err := uper.UploadUnuploaded(siteID, up); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
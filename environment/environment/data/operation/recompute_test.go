@@ -0,0 +1,57 @@
+package operation
+
+import (
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+	"testing"
+	"time"
+)
+
+func TestDetectLateData(t *testing.T) {
+	threshold := time.Date(2024, 1, 3, 12, 0, 0, 0, time.Local)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.Local) }
+
+	newData := func(d data.IData, stationID, monitorID int, dataTime time.Time) data.IData {
+		d.SetStationID(stationID)
+		d.SetMonitorID(monitorID)
+		d.SetDataTime(util.Time(dataTime))
+		return d
+	}
+
+	dataset := []data.IData{
+		//阈值之后的数据不需重算
+		newData(new(data.HourlyData), 1, 1, threshold),
+		newData(new(data.HourlyData), 1, 1, threshold.Add(time.Hour)),
+		//同日同排放口同监测物合并
+		newData(new(data.HourlyData), 1, 1, threshold.Add(-time.Hour)),
+		newData(new(data.HourlyData), 1, 1, day(3)),
+		//不同日期/监测物/排放口/数据类型分别标记
+		newData(new(data.HourlyData), 1, 1, day(2).Add(23*time.Hour)),
+		newData(new(data.HourlyData), 1, 2, day(3).Add(time.Hour)),
+		newData(new(data.HourlyData), 2, 1, day(3).Add(time.Hour)),
+		newData(new(data.MinutelyData), 1, 1, day(3).Add(10*time.Minute)),
+	}
+
+	expect := []*Recompute{
+		{DataType: data.HOURLY, StationID: 1, MonitorID: 1, BeginTime: util.Time(day(3)), EndTime: util.Time(day(4))},
+		{DataType: data.HOURLY, StationID: 1, MonitorID: 1, BeginTime: util.Time(day(2)), EndTime: util.Time(day(3))},
+		{DataType: data.HOURLY, StationID: 1, MonitorID: 2, BeginTime: util.Time(day(3)), EndTime: util.Time(day(4))},
+		{DataType: data.HOURLY, StationID: 2, MonitorID: 1, BeginTime: util.Time(day(3)), EndTime: util.Time(day(4))},
+		{DataType: data.MINUTELY, StationID: 1, MonitorID: 1, BeginTime: util.Time(day(3)), EndTime: util.Time(day(4))},
+	}
+
+	result := detectLateData(threshold, dataset...)
+	if len(result) != len(expect) {
+		t.Fatalf("detectLateData marked %d periods, expect %d", len(result), len(expect))
+	}
+	for i, r := range result {
+		e := expect[i]
+		if r.DataType != e.DataType || r.StationID != e.StationID || r.MonitorID != e.MonitorID || !time.Time(r.BeginTime).Equal(time.Time(e.BeginTime)) || !time.Time(r.EndTime).Equal(time.Time(e.EndTime)) {
+			t.Errorf("period %d: %s %d %d %v-%v, expect %s %d %d %v-%v", i, r.DataType, r.StationID, r.MonitorID, time.Time(r.BeginTime), time.Time(r.EndTime), e.DataType, e.StationID, e.MonitorID, time.Time(e.BeginTime), time.Time(e.EndTime))
+		}
+	}
+
+	if result := detectLateData(threshold); len(result) != 0 {
+		t.Error("empty dataset should mark nothing")
+	}
+}
@@ -0,0 +1,219 @@
+package operation
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+)
+
+var e_review_reason_required = errors.New("修改或判定无效需填写原因")
+var e_review_action_invalid = errors.New("审核操作不支持")
+var e_review_invalid_flag_not_set = errors.New("未配置审核无效标记")
+
+//待审核数据 未审核或标记非正常的数据
+func GetReviewData(siteID string, actionAuth authority.ActionAuthSet, dataType string, stationIDs, monitorIDs []int, beginTime, endTime time.Time, unreviewedOnly bool) ([]data.IData, error) {
+
+	if !data.IsReviewable(dataType) {
+		return nil, data.E_data_not_reviewable
+	}
+
+	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, stationIDs, entity.ACTION_ENTITY_REVIEW)
+	if err != nil {
+		return nil, err
+	}
+
+	stationIDs = make([]int, 0)
+	for sid, ok := range filtered {
+		if ok {
+			stationIDs = append(stationIDs, sid)
+		}
+	}
+
+	result := make([]data.IData, 0)
+	if len(stationIDs) == 0 {
+		return result, nil
+	}
+
+	list, err := data.GetData(siteID, dataType, stationIDs, monitorIDs, nil, nil, beginTime, endTime, nil, data.REVIEWED)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range list {
+		if !data.IsReviewed(d) {
+			result = append(result, d)
+			continue
+		}
+		if !unreviewedOnly && !monitor.CheckFlag(monitor.FLAG_NORMAL, d.GetFlagBit()) {
+			result = append(result, d)
+		}
+	}
+
+	return result, nil
+}
+
+//审核数据 审核通过后数据锁定 仅可通过重新审核修改
+//approve:审核通过 modify:修改数值后通过 invalid:判定无效 reopen:撤销审核
+func Review(siteID string, actionAuth authority.ActionAuthSet, dataType, action string, items []data.IData, fields []string, reason string) (int, error) {
+
+	if !data.IsReviewable(dataType) {
+		return 0, data.E_data_not_reviewable
+	}
+
+	switch action {
+	case data.REVIEW_APPROVE, data.REVIEW_REOPEN:
+	case data.REVIEW_MODIFY, data.REVIEW_INVALID:
+		if reason == "" {
+			return 0, e_review_reason_required
+		}
+	default:
+		return 0, e_review_action_invalid
+	}
+
+	stationIDs := make([]int, 0)
+	for _, item := range items {
+		stationIDs = append(stationIDs, item.GetStationID())
+	}
+
+	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, stationIDs, entity.ACTION_ENTITY_REVIEW)
+	if err != nil {
+		return 0, err
+	}
+	for _, sid := range stationIDs {
+		if !filtered[sid] {
+			return 0, errors.New("无权限")
+		}
+	}
+
+	var invalidFlag *monitor.Flag
+	if action == data.REVIEW_INVALID {
+		invalidFlag, err = monitor.GetFlagByBit(siteID, monitor.FLAG_REVIEW_INVALID)
+		if err != nil {
+			return 0, err
+		}
+		if invalidFlag == nil {
+			return 0, e_review_invalid_flag_not_set
+		}
+	}
+
+	if action == data.REVIEW_MODIFY && len(fields) == 0 {
+		fields = []string{data.AVG, data.MIN, data.MAX, data.COU}
+	}
+
+	uid := actionAuth.GetUID()
+	count := 0
+
+	if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		for _, item := range items {
+			if item.GetDataType() != dataType {
+				panic(errors.New("数据类型不符"))
+			}
+
+			list, err := data.GetData(siteID, dataType, []int{item.GetStationID()}, []int{item.GetMonitorID()}, nil, nil, time.Time(item.GetDataTime()), time.Time(item.GetDataTime()), nil, data.ORIGIN_DATA, data.REVIEWED)
+			if err != nil {
+				panic(err)
+			}
+			if len(list) == 0 {
+				panic(errors.New("数据不存在"))
+			}
+			d := list[0]
+
+			if action != data.REVIEW_REOPEN && data.IsReviewed(d) {
+				panic(data.E_data_reviewed)
+			}
+
+			data.SetRevisionContext(d, uid, reason)
+
+			updateFields := []string{data.REVIEWED}
+
+			switch action {
+			case data.REVIEW_MODIFY:
+				interval, ok := item.(data.IInterval)
+				if !ok {
+					panic(errors.New("数据字段不符"))
+				}
+				for _, f := range fields {
+					var value float64
+					switch f {
+					case data.AVG:
+						value = interval.GetAvg()
+					case data.MIN:
+						value = interval.GetMin()
+					case data.MAX:
+						value = interval.GetMax()
+					case data.COU:
+						value = interval.GetCou()
+					default:
+						continue
+					}
+					if err := data.ModifyValue(d, f, value, uid); err != nil {
+						panic(err)
+					}
+					updateFields = append(updateFields, f)
+				}
+				if item.GetFlag() != "" {
+					if err := monitor.ChangeFlag(siteID, d, item.GetFlag(), uid); err != nil {
+						panic(err)
+					}
+					updateFields = append(updateFields, data.FLAG, data.FLAG_BIT)
+				}
+			case data.REVIEW_INVALID:
+				if err := monitor.ChangeFlag(siteID, d, invalidFlag.Flag, uid); err != nil {
+					panic(err)
+				}
+				updateFields = append(updateFields, data.FLAG, data.FLAG_BIT)
+			}
+
+			d.(data.IReview).SetReviewed(action != data.REVIEW_REOPEN)
+
+			if err := data.UpdateWithTxn(siteID, txn, d, updateFields...); err != nil {
+				panic(err)
+			}
+
+			if err := data.AddReviewRecordWithTxn(siteID, txn, d, action, uid, reason); err != nil {
+				panic(err)
+			}
+
+			count++
+		}
+	}); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+//按排放口按月统计审核进度
+func GetReviewProgress(siteID string, actionAuth authority.ActionAuthSet, dataType string, stationIDs []int, beginTime, endTime time.Time) ([]*data.ReviewProgress, error) {
+
+	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, stationIDs, entity.ACTION_ENTITY_REVIEW)
+	if err != nil {
+		return nil, err
+	}
+
+	stationIDs = make([]int, 0)
+	for sid, ok := range filtered {
+		if ok {
+			stationIDs = append(stationIDs, sid)
+		}
+	}
+
+	invalidFlags := make([]string, 0)
+	m, err := monitor.GetModule(siteID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.Flags {
+		if monitor.CheckFlag(monitor.FLAG_REVIEW_INVALID, f.Bits) {
+			invalidFlags = append(invalidFlags, f.Flag)
+		}
+	}
+
+	return data.GetReviewProgress(siteID, dataType, stationIDs, beginTime, endTime, invalidFlags)
+}
@@ -0,0 +1,98 @@
+package operation
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+)
+
+//将数据点还原至指定修改记录完成后的状态 还原本身也记为新的修改记录
+func RevertRevision(siteID string, actionAuth authority.ActionAuthSet, revisionID int, reason string) (data.IData, error) {
+
+	revision, err := data.GetRevision(siteID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{revision.StationID}, entity.ACTION_ENTITY_EDIT)
+	if err != nil {
+		return nil, err
+	}
+	if !filtered[revision.StationID] {
+		return nil, errors.New("无权限")
+	}
+
+	dataTime := time.Time(revision.DataTime)
+
+	list, err := data.GetData(siteID, revision.DataType, []int{revision.StationID}, []int{revision.MonitorID}, nil, nil, dataTime, dataTime, nil, data.ORIGIN_DATA)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, errors.New("数据不存在")
+	}
+	d := list[0]
+
+	revisions, err := data.GetRevisions(siteID, revision.DataType, 0, []int{revision.StationID}, []int{revision.MonitorID}, &dataTime, &dataTime)
+	if err != nil {
+		return nil, err
+	}
+
+	//各字段取该记录及之前的最后一次修改后的值 之前未修改过的字段取之后第一次修改前的值
+	targets := make(map[string]*data.Revision)
+	reverted := make(map[string]bool)
+	for _, r := range revisions {
+		if r.ID <= revision.ID {
+			targets[r.Field] = r
+			reverted[r.Field] = false
+		} else if _, exists := targets[r.Field]; !exists {
+			targets[r.Field] = r
+			reverted[r.Field] = true
+		}
+	}
+
+	if reason == "" {
+		reason = fmt.Sprintf("还原至修改记录%d", revision.ID)
+	}
+
+	uid := actionAuth.GetUID()
+	data.SetRevisionContext(d, uid, reason)
+
+	fields := make([]string, 0)
+	for field, r := range targets {
+		if field == data.FLAG {
+			flag := r.NewFlag
+			if reverted[field] {
+				flag = r.OldFlag
+			}
+			if err := monitor.ChangeFlag(siteID, d, flag, uid); err != nil {
+				return nil, err
+			}
+			fields = append(fields, data.FLAG, data.FLAG_BIT)
+			continue
+		}
+
+		value := r.NewValue
+		if reverted[field] {
+			value = r.OldValue
+		}
+		if value == nil {
+			continue
+		}
+		if err := data.ModifyValue(d, field, *value, uid); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+
+	if len(fields) == 0 {
+		return d, nil
+	}
+
+	return d, data.Update(siteID, d, fields...)
+}
@@ -0,0 +1,248 @@
+package operation
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+)
+
+const defaultSimulateSampleSize = 50
+
+var e_simulate_range_restricted = errors.New("实时及分钟数据的模拟时间跨度最多7天")
+
+type SimulateSample struct {
+	StationID     int                `json:"stationID"`
+	MonitorID     int                `json:"monitorID"`
+	MonitorCodeID int                `json:"monitorCodeID"`
+	DataTime      util.Time          `json:"dataTime"`
+	Generated     bool               `json:"generated"`
+	Before        map[string]float64 `json:"before,omitempty"`
+	After         map[string]float64 `json:"after"`
+	BeforeFlag    string             `json:"beforeFlag,omitempty"`
+	AfterFlag     string             `json:"afterFlag"`
+}
+
+type SimulateResult struct {
+	Total           int               `json:"total"`
+	ValueChanged    int               `json:"valueChanged"`
+	FlagChanged     int               `json:"flagChanged"`
+	Generated       int               `json:"generated"`
+	FlagCountBefore map[string]int    `json:"flagCountBefore"`
+	FlagCountAfter  map[string]int    `json:"flagCountAfter"`
+	Samples         []*SimulateSample `json:"samples"`
+}
+
+//模拟上传 生成的数据按其监测物配置的处理器处理 仅记录不保存
+type simulateUpload struct {
+	generated []data.IData
+}
+
+func (u *simulateUpload) UploadBatchData(siteID string, uploader *dataprocess.Uploader, dataset ...data.IData) error {
+	for _, d := range dataset {
+		u.generated = append(u.generated, d)
+
+		monitorCode := monitor.GetMonitorCodeByCode(siteID, d.GetStationID(), d.GetCode())
+		if monitorCode == nil {
+			monitorCode = monitor.GetMonitorCodeByStationMonitor(siteID, d.GetStationID(), d.GetMonitorID())
+		}
+		if monitorCode == nil {
+			log.Println("warn: no monitor code found: ", siteID, d.GetStationID(), d.GetCode())
+			continue
+		}
+		if err := simulateProcess(siteID, monitorCode.Processors, uploader, u, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//每条数据在单独的只读事务中处理 处理器误写库时报错 不长时间占用连接
+func simulateProcess(siteID string, processors dataprocess.DataProcessors, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload, d data.IData) error {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		if err := processors.ProcessWithTxn(siteID, txn, uploader, upload, d); err != nil {
+			panic(err)
+		}
+	}, &sql.TxOptions{ReadOnly: true})
+}
+
+//以拟定处理器处理历史数据并返回差异 只处理查出的数据副本 不保存任何修改
+//处理器读取的是库中原值 不含本次模拟对其他数据的修改
+//processor为空时按各监测物现有配置处理
+func Simulate(siteID string, actionAuth authority.ActionAuthSet, dataType string, stationIDs, monitorIDs, monitorCodeIDs []int, beginTime, endTime time.Time, restoreBeforeProcess bool, processor dataprocess.DataProcessors, sampleSize int) (*SimulateResult, error) {
+
+	switch dataType {
+	case data.REAL_TIME:
+		fallthrough
+	case data.MINUTELY:
+		if endTime.Sub(beginTime).Hours() > 24*7 {
+			return nil, e_simulate_range_restricted
+		}
+	}
+
+	if sampleSize <= 0 {
+		sampleSize = defaultSimulateSampleSize
+	}
+
+	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, stationIDs, entity.ACTION_ENTITY_VIEW)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sid := range stationIDs {
+		if !filtered[sid] {
+			return nil, errors.New("无权限")
+		}
+	}
+
+	if err := processor.Validate(siteID); err != nil {
+		return nil, err
+	}
+
+	columns := []string{data.ORIGIN_DATA}
+	if data.IsReviewable(dataType) {
+		columns = append(columns, data.REVIEWED)
+	}
+
+	dataList, err := data.GetData(siteID, dataType, stationIDs, monitorIDs, monitorCodeIDs, nil, beginTime, endTime, nil, columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := monitor.LoadMonitor(siteID); err != nil {
+		return nil, err
+	}
+	if err := monitor.LoadMonitorCode(siteID); err != nil {
+		return nil, err
+	}
+	if err := monitor.LoadFlagLimit(siteID); err != nil {
+		return nil, err
+	}
+
+	result := &SimulateResult{
+		FlagCountBefore: make(map[string]int),
+		FlagCountAfter:  make(map[string]int),
+		Samples:         make([]*SimulateSample, 0),
+	}
+
+	beforeValues := make([]map[string]float64, len(dataList))
+	beforeFlags := make([]string, len(dataList))
+	for i, d := range dataList {
+		beforeValues[i] = getSimulateValues(d)
+		beforeFlags[i] = d.GetFlag()
+		result.FlagCountBefore[d.GetFlag()]++
+	}
+
+	uper := &dataprocess.Uploader{DryRun: true}
+
+	up := new(simulateUpload)
+
+	for _, d := range dataList {
+		if data.IsReviewed(d) {
+			continue
+		}
+
+		if restoreBeforeProcess {
+			data.RestoreValue(d)
+		}
+
+		processors := processor
+		if len(processors) == 0 {
+			monitorCode := monitor.GetMonitorCodeByID(siteID, d.GetMonitorCodeID())
+			if monitorCode == nil {
+				monitorCode = monitor.GetMonitorCodeByStationMonitor(siteID, d.GetStationID(), d.GetMonitorID())
+			}
+			if monitorCode == nil {
+				log.Println("monitor code not found ", d.GetStationID(), d.GetMonitorCodeID())
+				continue
+			}
+			processors = monitorCode.Processors
+		}
+
+		if err := simulateProcess(siteID, processors, uper, up, d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := uper.UploadUnuploaded(siteID, up); err != nil {
+		return nil, err
+	}
+
+	for i, d := range dataList {
+		result.Total++
+		result.FlagCountAfter[d.GetFlag()]++
+
+		after := getSimulateValues(d)
+		valueChanged := !isSimulateValuesEqual(beforeValues[i], after)
+		flagChanged := beforeFlags[i] != d.GetFlag()
+
+		if valueChanged {
+			result.ValueChanged++
+		}
+		if flagChanged {
+			result.FlagChanged++
+		}
+		if (valueChanged || flagChanged) && len(result.Samples) < sampleSize {
+			result.Samples = append(result.Samples, &SimulateSample{
+				StationID:     d.GetStationID(),
+				MonitorID:     d.GetMonitorID(),
+				MonitorCodeID: d.GetMonitorCodeID(),
+				DataTime:      d.GetDataTime(),
+				Before:        beforeValues[i],
+				After:         after,
+				BeforeFlag:    beforeFlags[i],
+				AfterFlag:     d.GetFlag(),
+			})
+		}
+	}
+
+	for _, d := range up.generated {
+		result.Generated++
+		if len(result.Samples) < sampleSize {
+			result.Samples = append(result.Samples, &SimulateSample{
+				StationID:     d.GetStationID(),
+				MonitorID:     d.GetMonitorID(),
+				MonitorCodeID: d.GetMonitorCodeID(),
+				DataTime:      d.GetDataTime(),
+				Generated:     true,
+				After:         getSimulateValues(d),
+				AfterFlag:     d.GetFlag(),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func getSimulateValues(d data.IData) map[string]float64 {
+	values := make(map[string]float64)
+	if rtd, ok := d.(data.IRealTime); ok {
+		values[data.RTD] = rtd.GetRtd()
+	} else if interval, ok := d.(data.IInterval); ok {
+		values[data.AVG] = interval.GetAvg()
+		values[data.MIN] = interval.GetMin()
+		values[data.MAX] = interval.GetMax()
+		values[data.COU] = interval.GetCou()
+	}
+	return values
+}
+
+func isSimulateValuesEqual(a, b map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -27,10 +27,18 @@ func (u *Upload) UploadBatchData(siteID string, uploader *dataprocess.Uploader,
 				return err
 			}
 
-			d, err = Modify(siteID, d, nil, u.UploaderUID)
+			modified, err := Modify(siteID, d, nil, u.UploaderUID, "重复上传覆盖")
+			if err == data.E_data_reviewed {
+				log.Println("skip reviewed data: ", siteID, d.GetStationID(), d.GetMonitorID(), d.GetDataTime())
+				continue
+			}
 			if err != nil {
 				return err
 			}
+			d = modified
+		} else {
+			MarkLateData(siteID, RECOMPUTE_SOURCE_UPLOAD, d)
+			NotifyAggregation(siteID, d)
 		}
 
 		if len(u.Processors) > 0 {
@@ -0,0 +1,31 @@
+package data
+
+import "testing"
+
+//还原任一数值字段即清除换算标记 仅还原标记时保留
+func TestRestoreValueOriginUnit(t *testing.T) {
+	newData := func() *HourlyData {
+		d := new(HourlyData)
+		d.Avg, d.Max, d.Flag = 1000, 2000, "T"
+		d.OriginData = map[string]interface{}{AVG: 1.0, MAX: 2.0, FLAG: "N", ORIGIN_UNIT: "g/m3"}
+		return d
+	}
+
+	for _, c := range []struct {
+		fields []string
+		keep   bool
+	}{
+		{nil, false},
+		{[]string{AVG}, false},
+		{[]string{MAX}, false},
+		{[]string{FLAG}, true},
+	} {
+		d := newData()
+		if !RestoreValue(d, c.fields...) {
+			t.Errorf("restore %v: nothing restored", c.fields)
+		}
+		if _, exists := d.OriginData[ORIGIN_UNIT]; exists != c.keep {
+			t.Errorf("restore %v: origin unit kept %v, expect %v", c.fields, exists, c.keep)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package querycache
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"obsessiontech/common/ipc"
+)
+
+const (
+	ack = iota
+
+	getReq
+	getRes
+	setReq
+	invalidateReq
+	metricsReq
+	metricsRes
+)
+
+type IMessage interface {
+	GetIPCMessageType() int
+}
+
+type Ack int
+
+func (m *Ack) GetIPCMessageType() int { return ack }
+
+type GetReq struct {
+	Key   string `json:"key"`
+	Query *Query `json:"query"`
+}
+
+func (m *GetReq) GetIPCMessageType() int { return getReq }
+
+type GetRes struct {
+	Found bool                       `json:"found"`
+	Seq   uint64                     `json:"seq"`
+	Value map[string]json.RawMessage `json:"value,omitempty"`
+}
+
+func (m *GetRes) GetIPCMessageType() int { return getRes }
+
+type SetReq struct {
+	Key   string                     `json:"key"`
+	Query *Query                     `json:"query"`
+	Seq   uint64                     `json:"seq"`
+	Value map[string]json.RawMessage `json:"value"`
+}
+
+func (m *SetReq) GetIPCMessageType() int { return setReq }
+
+type InvalidateReq struct {
+	SiteID    string    `json:"siteID"`
+	DataType  string    `json:"dataType"`
+	StationID int       `json:"stationID"`
+	DataTime  time.Time `json:"dataTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+func (m *InvalidateReq) GetIPCMessageType() int { return invalidateReq }
+
+type MetricsReq struct {
+	SiteID string `json:"siteID"`
+}
+
+func (m *MetricsReq) GetIPCMessageType() int { return metricsReq }
+
+type MetricsRes Metrics
+
+func (m *MetricsRes) GetIPCMessageType() int { return metricsRes }
+
+var E_unmarshal_failure = errors.New("json unmarshal failed")
+var E_message_type_unknown = errors.New("message type unknown")
+
+var e_unexpected_res = errors.New("缓存主机响应无效")
+
+func ParseMessage(input []byte) (IMessage, error) {
+	var datagram struct {
+		MessageType int             `json:"type"`
+		Message     json.RawMessage `json:"message"`
+	}
+
+	if err := json.Unmarshal(input, &datagram); err != nil {
+		return nil, E_unmarshal_failure
+	}
+
+	var message IMessage
+
+	switch datagram.MessageType {
+	case ack:
+		message = new(Ack)
+	case getReq:
+		message = new(GetReq)
+	case getRes:
+		message = new(GetRes)
+	case setReq:
+		message = new(SetReq)
+	case invalidateReq:
+		message = new(InvalidateReq)
+	case metricsReq:
+		message = new(MetricsReq)
+	case metricsRes:
+		message = new(MetricsRes)
+	default:
+		return nil, E_message_type_unknown
+	}
+
+	if err := json.Unmarshal(datagram.Message, &message); err != nil {
+		return nil, E_unmarshal_failure
+	}
+
+	return message, nil
+}
+
+func WrapMessage(message IMessage) ([]byte, error) {
+
+	datagram := map[string]interface{}{
+		"type":    message.GetIPCMessageType(),
+		"message": message,
+	}
+
+	return json.Marshal(datagram)
+}
+
+func startHost() {
+	connChan, err := ipc.StartHost(Config.DataQueryCacheHostType, Config.DataQueryCacheHost)
+	if err != nil {
+		log.Println("error establish data query cache host: ", err)
+		panic(err)
+	}
+
+	go func() {
+		for conn := range connChan {
+			go hostListen(conn)
+		}
+		log.Println("establish data query cache host closed")
+	}()
+}
+
+func hostListen(conn *ipc.Connection) {
+	defer conn.Cancel()
+
+	for {
+		select {
+		case <-conn.Ctx.Done():
+			return
+		default:
+		}
+
+		datagrams, _, err := ipc.Receive(conn.Conn)
+		if err != nil {
+			return
+		}
+
+		for _, d := range datagrams {
+			message, err := ParseMessage(d)
+			if err != nil {
+				log.Println("error data query cache ipc message: ", err)
+				continue
+			}
+
+			var res IMessage
+
+			switch req := message.(type) {
+			case *GetReq:
+				if req.Query == nil {
+					continue
+				}
+				value, seq, found := local.get(req.Key, req.Query.bucket(), req.Query.SiteID)
+				res = &GetRes{Found: found, Seq: seq, Value: value}
+			case *SetReq:
+				if req.Query == nil {
+					continue
+				}
+				local.set(req.Key, req.Query, req.Seq, req.Value)
+				res = new(Ack)
+			case *InvalidateReq:
+				local.invalidateRange(req.SiteID, req.DataType, req.StationID, req.DataTime, req.EndTime)
+				res = new(Ack)
+			case *MetricsReq:
+				metrics := MetricsRes(*local.getSiteMetrics(req.SiteID))
+				res = &metrics
+			default:
+				continue
+			}
+
+			data, err := WrapMessage(res)
+			if err != nil {
+				log.Println("error data query cache wrap ipc res: ", res.GetIPCMessageType(), err)
+				return
+			}
+			if err := ipc.Write(conn.Conn, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//每次请求独占一个连接等待响应 用完放回空闲连接
+var remotePool chan *ipc.Connection
+
+func request(req IMessage) (IMessage, error) {
+	var conn *ipc.Connection
+	select {
+	case conn = <-remotePool:
+	default:
+		c, err := ipc.StartClient(Config.DataQueryCacheHostType, Config.DataQueryCacheHost)
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+	}
+
+	res, err := roundTrip(conn, req)
+	if err != nil {
+		conn.Cancel()
+		return nil, err
+	}
+
+	select {
+	case remotePool <- conn:
+	default:
+		conn.Cancel()
+	}
+
+	return res, nil
+}
+
+func roundTrip(conn *ipc.Connection, req IMessage) (IMessage, error) {
+	data, err := WrapMessage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Conn.SetDeadline(time.Now().Add(Config.DataQueryCacheRequestTimeOutSec * time.Second)); err != nil {
+		return nil, err
+	}
+	if err := ipc.Write(conn.Conn, data); err != nil {
+		return nil, err
+	}
+	datagrams, _, err := ipc.Receive(conn.Conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(datagrams) != 1 {
+		return nil, e_unexpected_res
+	}
+	if err := conn.Conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return ParseMessage(datagrams[0])
+}
+
+//缓存主机不可用时视为未命中 直接查询
+func remoteGet(key string, q *Query) (map[string]json.RawMessage, uint64, bool) {
+	res, err := request(&GetReq{Key: key, Query: q})
+	if err != nil {
+		log.Println("error data query cache get: ", err)
+		return nil, 0, false
+	}
+	getRes, ok := res.(*GetRes)
+	if !ok {
+		log.Println("error data query cache get unexpected res type: ", res.GetIPCMessageType())
+		return nil, 0, false
+	}
+	return getRes.Value, getRes.Seq, getRes.Found
+}
+
+func remoteSet(key string, q *Query, seq uint64, value map[string]json.RawMessage) {
+	if _, err := request(&SetReq{Key: key, Query: q, Seq: seq, Value: value}); err != nil {
+		log.Println("error data query cache set: ", err)
+	}
+}
+
+func remoteInvalidate(siteID, dataType string, stationID int, beginTime, endTime time.Time) {
+	if _, err := request(&InvalidateReq{SiteID: siteID, DataType: dataType, StationID: stationID, DataTime: beginTime, EndTime: endTime}); err != nil {
+		log.Println("error data query cache invalidate: ", siteID, dataType, stationID, beginTime, endTime, err)
+	}
+}
+
+func remoteMetrics(siteID string) (*Metrics, error) {
+	res, err := request(&MetricsReq{SiteID: siteID})
+	if err != nil {
+		return nil, err
+	}
+	metrics, ok := res.(*MetricsRes)
+	if !ok {
+		return nil, e_unexpected_res
+	}
+	result := Metrics(*metrics)
+	return &result, nil
+}
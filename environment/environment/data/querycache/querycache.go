@@ -0,0 +1,422 @@
+package querycache
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"obsessiontech/common/config"
+	"obsessiontech/common/ipc"
+)
+
+//数据查询结果缓存 按站点数据类型及查询时段记录 收到对应站点该时段的数据时失效
+//配置缓存主机时各API进程共用主机上的缓存
+var Config struct {
+	DataQueryCacheMaxEntries          int
+	DataQueryCacheMaxEntrySize        int
+	DataQueryCacheExpireSec           time.Duration
+	IsDataQueryCacheHost              bool
+	DataQueryCacheHostType            string
+	DataQueryCacheHost                string
+	DataQueryCacheRequestTimeOutSec   time.Duration
+	DataQueryCacheMaxIdleConn         int
+	DataQueryCacheInvalidationHistory int
+}
+
+func init() {
+	config.GetConfig("config.yaml", &Config)
+
+	if Config.DataQueryCacheMaxEntrySize <= 0 {
+		Config.DataQueryCacheMaxEntrySize = 1 << 20
+	}
+	if Config.DataQueryCacheExpireSec <= 0 {
+		Config.DataQueryCacheExpireSec = 300
+	}
+	if Config.DataQueryCacheRequestTimeOutSec <= 0 {
+		Config.DataQueryCacheRequestTimeOutSec = 3
+	}
+	if Config.DataQueryCacheMaxIdleConn <= 0 {
+		Config.DataQueryCacheMaxIdleConn = 8
+	}
+	if Config.DataQueryCacheInvalidationHistory <= 0 {
+		Config.DataQueryCacheInvalidationHistory = 1024
+	}
+
+	switch Config.DataQueryCacheHostType {
+	case ipc.IPC_TCP:
+	case ipc.IPC_UNIX:
+	default:
+		Config.DataQueryCacheHostType = ipc.IPC_UNIX
+	}
+
+	if Config.DataQueryCacheHost != "" && !Config.IsDataQueryCacheHost {
+		remotePool = make(chan *ipc.Connection, Config.DataQueryCacheMaxIdleConn)
+		return
+	}
+
+	if Config.DataQueryCacheMaxEntries > 0 {
+		local = newCache(Config.DataQueryCacheMaxEntries)
+		if Config.DataQueryCacheHost != "" {
+			startHost()
+		}
+	}
+}
+
+var local *cache
+
+//读从库的查询在从库滞后窗口内可能读到变更前的数据 窗口内有覆盖该查询的变更时不保存 由数据模块按从库配置设置
+var ReplicaLag time.Duration
+
+func Enabled() bool {
+	return local != nil || remotePool != nil
+}
+
+//查询条件 站点列表为空时为全部站点 时间为零值时不限
+type Query struct {
+	Kind           string                 `json:"kind"`
+	SiteID         string                 `json:"siteID"`
+	DataType       string                 `json:"dataType"`
+	StationIDs     []int                  `json:"stationIDs"`
+	MonitorIDs     []int                  `json:"monitorIDs"`
+	MonitorCodeIDs []int                  `json:"monitorCodeIDs"`
+	BeginTime      time.Time              `json:"beginTime"`
+	EndTime        time.Time              `json:"endTime"`
+	Params         map[string]interface{} `json:"params"`
+}
+
+func normalizeIDs(ids []int) []int {
+	result := make([]int, 0)
+	exists := make(map[int]bool)
+	for _, id := range ids {
+		if !exists[id] {
+			exists[id] = true
+			result = append(result, id)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+//编号去重排序 时间统一时区 参数按名称排序 使相同含义的查询得到相同的键
+func (q *Query) normalize() *Query {
+	n := *q
+	n.StationIDs = normalizeIDs(q.StationIDs)
+	n.MonitorIDs = normalizeIDs(q.MonitorIDs)
+	n.MonitorCodeIDs = normalizeIDs(q.MonitorCodeIDs)
+	n.BeginTime = q.BeginTime.UTC()
+	n.EndTime = q.EndTime.UTC()
+	return &n
+}
+
+func (q *Query) key() (string, error) {
+	data, err := json.Marshal(q.normalize())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (q *Query) bucket() string {
+	return q.SiteID + "#" + q.DataType
+}
+
+//stationID为0时为全部站点 beginTime为零值时不限 endTime含
+func (q *Query) overlaps(stationID int, beginTime, endTime time.Time) bool {
+	if stationID != 0 && len(q.StationIDs) > 0 {
+		found := false
+		for _, id := range q.StationIDs {
+			if id == stationID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.BeginTime.IsZero() && endTime.Before(q.BeginTime) {
+		return false
+	}
+	if !q.EndTime.IsZero() && !beginTime.IsZero() && beginTime.After(q.EndTime) {
+		return false
+	}
+	return true
+}
+
+type Metrics struct {
+	Entries       int     `json:"entries"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Stores        int64   `json:"stores"`
+	Stale         int64   `json:"stale"`
+	Evictions     int64   `json:"evictions"`
+	Expirations   int64   `json:"expirations"`
+	Invalidations int64   `json:"invalidations"`
+	HitRate       float64 `json:"hitRate"`
+}
+
+type entry struct {
+	key    string
+	query  *Query
+	value  map[string]json.RawMessage
+	expire time.Time
+	elem   *list.Element
+}
+
+type invalidation struct {
+	seq       uint64
+	stationID int
+	beginTime time.Time
+	endTime   time.Time
+	at        time.Time
+}
+
+//同一站点数据类型的缓存 seq为收到数据的序号 用于判断查询期间是否有数据变更
+type bucket struct {
+	entries map[*entry]bool
+	seq     uint64
+	history []*invalidation
+}
+
+type cache struct {
+	lock       sync.Mutex
+	maxEntries int
+	lru        *list.List
+	entries    map[string]*entry
+	buckets    map[string]*bucket
+	metrics    map[string]*Metrics
+}
+
+func newCache(maxEntries int) *cache {
+	return &cache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*entry),
+		buckets:    make(map[string]*bucket),
+		metrics:    make(map[string]*Metrics),
+	}
+}
+
+func (c *cache) getBucket(name string) *bucket {
+	b, exists := c.buckets[name]
+	if !exists {
+		b = &bucket{entries: make(map[*entry]bool)}
+		c.buckets[name] = b
+	}
+	return b
+}
+
+func (c *cache) getMetrics(siteID string) *Metrics {
+	m, exists := c.metrics[siteID]
+	if !exists {
+		m = new(Metrics)
+		c.metrics[siteID] = m
+	}
+	return m
+}
+
+func (c *cache) remove(e *entry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+	if b, exists := c.buckets[e.query.bucket()]; exists {
+		delete(b.entries, e)
+	}
+	c.getMetrics(e.query.SiteID).Entries--
+}
+
+func (c *cache) get(key, bucketName, siteID string) (map[string]json.RawMessage, uint64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	m := c.getMetrics(siteID)
+	seq := c.getBucket(bucketName).seq
+
+	e, exists := c.entries[key]
+	if exists && time.Now().After(e.expire) {
+		c.remove(e)
+		m.Expirations++
+		exists = false
+	}
+	if !exists {
+		m.Misses++
+		return nil, seq, false
+	}
+
+	c.lru.MoveToFront(e.elem)
+	m.Hits++
+	return e.value, seq, true
+}
+
+//seq为查询前取得的序号 查询期间收到影响该查询的数据时不保存
+func (c *cache) set(key string, q *Query, seq uint64, value map[string]json.RawMessage) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	m := c.getMetrics(q.SiteID)
+	b := c.getBucket(q.bucket())
+
+	if seq > b.seq {
+		m.Stale++
+		return
+	}
+	if seq != b.seq || ReplicaLag > 0 {
+		now := time.Now()
+		checked := false
+		for i := len(b.history) - 1; i >= 0; i-- {
+			inv := b.history[i]
+			if inv.seq <= seq && now.Sub(inv.at) >= ReplicaLag {
+				checked = true
+				break
+			}
+			if q.overlaps(inv.stationID, inv.beginTime, inv.endTime) {
+				m.Stale++
+				return
+			}
+		}
+		//历史已截断 无法判断
+		if !checked && len(b.history) > 0 && b.history[0].seq > 1 {
+			m.Stale++
+			return
+		}
+	}
+
+	if e, exists := c.entries[key]; exists {
+		c.remove(e)
+	}
+
+	e := &entry{key: key, query: q, value: value, expire: time.Now().Add(Config.DataQueryCacheExpireSec * time.Second)}
+	e.elem = c.lru.PushFront(e)
+	c.entries[key] = e
+	b.entries[e] = true
+	m.Entries++
+	m.Stores++
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back().Value.(*entry)
+		c.remove(oldest)
+		c.getMetrics(oldest.query.SiteID).Evictions++
+	}
+}
+
+func (c *cache) invalidate(siteID, dataType string, stationID int, dataTime time.Time) {
+	c.invalidateRange(siteID, dataType, stationID, dataTime, dataTime)
+}
+
+func (c *cache) invalidateRange(siteID, dataType string, stationID int, beginTime, endTime time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	m := c.getMetrics(siteID)
+	b := c.getBucket(siteID + "#" + dataType)
+
+	b.seq++
+	b.history = append(b.history, &invalidation{seq: b.seq, stationID: stationID, beginTime: beginTime, endTime: endTime, at: time.Now()})
+	if len(b.history) > 2*Config.DataQueryCacheInvalidationHistory {
+		b.history = append([]*invalidation{}, b.history[len(b.history)-Config.DataQueryCacheInvalidationHistory:]...)
+	}
+
+	for e := range b.entries {
+		if e.query.overlaps(stationID, beginTime, endTime) {
+			c.remove(e)
+			m.Invalidations++
+		}
+	}
+}
+
+func (c *cache) getSiteMetrics(siteID string) *Metrics {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := *c.getMetrics(siteID)
+	if total := result.Hits + result.Misses; total > 0 {
+		result.HitRate = float64(result.Hits) / float64(total)
+	}
+	return &result
+}
+
+//未命中时返回的seq需在保存结果时传入
+func Get(q *Query) (map[string]json.RawMessage, uint64, bool) {
+	if !Enabled() {
+		return nil, 0, false
+	}
+
+	q = q.normalize()
+	key, err := q.key()
+	if err != nil {
+		log.Println("error data query cache key: ", err)
+		return nil, 0, false
+	}
+
+	if local != nil {
+		return local.get(key, q.bucket(), q.SiteID)
+	}
+
+	return remoteGet(key, q)
+}
+
+func Set(q *Query, seq uint64, result map[string]interface{}) {
+	if !Enabled() {
+		return
+	}
+
+	q = q.normalize()
+	key, err := q.key()
+	if err != nil {
+		log.Println("error data query cache key: ", err)
+		return
+	}
+
+	value := make(map[string]json.RawMessage)
+	size := 0
+	for k, v := range result {
+		data, err := json.Marshal(v)
+		if err != nil {
+			log.Println("error data query cache marshal: ", err)
+			return
+		}
+		value[k] = data
+		size += len(data)
+	}
+	if size > Config.DataQueryCacheMaxEntrySize {
+		return
+	}
+
+	if local != nil {
+		local.set(key, q, seq, value)
+		return
+	}
+
+	remoteSet(key, q, seq, value)
+}
+
+//收到站点新数据或数据变更时调用 使覆盖该数据时间的缓存失效 须在变更提交后调用
+func Invalidate(siteID, dataType string, stationID int, dataTime time.Time) {
+	InvalidateRange(siteID, dataType, stationID, dataTime, dataTime)
+}
+
+//批量删除时调用 stationID为0时为全部站点 beginTime为零值时不限
+func InvalidateRange(siteID, dataType string, stationID int, beginTime, endTime time.Time) {
+	if local != nil {
+		local.invalidateRange(siteID, dataType, stationID, beginTime, endTime)
+		return
+	}
+
+	if remotePool != nil {
+		go remoteInvalidate(siteID, dataType, stationID, beginTime, endTime)
+	}
+}
+
+func GetMetrics(siteID string) (*Metrics, error) {
+	if local != nil {
+		return local.getSiteMetrics(siteID), nil
+	}
+
+	if remotePool != nil {
+		return remoteMetrics(siteID)
+	}
+
+	return new(Metrics), nil
+}
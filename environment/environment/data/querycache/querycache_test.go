@@ -0,0 +1,175 @@
+package querycache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func testQuery(stationIDs []int, begin, end time.Time) *Query {
+	return (&Query{Kind: "byTime", SiteID: "s", DataType: "hourly", StationIDs: stationIDs, BeginTime: begin, EndTime: end}).normalize()
+}
+
+func testSet(c *cache, q *Query, seq uint64) string {
+	key, _ := q.key()
+	c.set(key, q, seq, map[string]json.RawMessage{"total": json.RawMessage("1")})
+	return key
+}
+
+func TestKey(t *testing.T) {
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	k1, _ := testQuery([]int{3, 1, 3}, begin, time.Time{}).key()
+	k2, _ := testQuery([]int{1, 3}, begin.UTC(), time.Time{}).key()
+	if k1 != k2 {
+		t.Error("normalized keys differ: ", k1, k2)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := newCache(10)
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(24 * time.Hour)
+
+	k1 := testSet(c, testQuery([]int{1}, begin, end), 0)
+	k2 := testSet(c, testQuery([]int{2}, begin, end), 0)
+	k3 := testSet(c, testQuery(nil, begin, time.Time{}), 0)
+
+	c.invalidate("s", "hourly", 1, end.Add(time.Hour))
+
+	if _, exists := c.entries[k1]; !exists {
+		t.Error("entry out of range invalidated")
+	}
+	if _, exists := c.entries[k2]; !exists {
+		t.Error("entry of other station invalidated")
+	}
+	if _, exists := c.entries[k3]; exists {
+		t.Error("entry with unbounded end not invalidated")
+	}
+
+	c.invalidate("s", "daily", 1, begin)
+	if _, exists := c.entries[k1]; !exists {
+		t.Error("entry of other data type invalidated")
+	}
+
+	c.invalidate("s", "hourly", 1, begin)
+	if _, exists := c.entries[k1]; exists {
+		t.Error("entry in range not invalidated")
+	}
+}
+
+func TestStaleSet(t *testing.T) {
+	c := newCache(10)
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(24 * time.Hour)
+
+	q1 := testQuery([]int{1}, begin, end)
+	k1, _ := q1.key()
+	_, seq, found := c.get(k1, q1.bucket(), q1.SiteID)
+	if found {
+		t.Fatal("unexpected hit")
+	}
+
+	q2 := testQuery([]int{2}, begin, end)
+	k2, _ := q2.key()
+	c.get(k2, q2.bucket(), q2.SiteID)
+
+	//查询期间收到站点1的数据
+	c.invalidate("s", "hourly", 1, begin)
+
+	testSet(c, q1, seq)
+	testSet(c, q2, seq)
+
+	if _, _, found := c.get(k1, q1.bucket(), q1.SiteID); found {
+		t.Error("stale result stored")
+	}
+	if _, _, found := c.get(k2, q2.bucket(), q2.SiteID); !found {
+		t.Error("unaffected result discarded")
+	}
+
+	m := c.getSiteMetrics("s")
+	if m.Stale != 1 || m.Hits != 1 || m.Misses != 3 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c := newCache(2)
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	k1 := testSet(c, testQuery([]int{1}, begin, time.Time{}), 0)
+	k2 := testSet(c, testQuery([]int{2}, begin, time.Time{}), 0)
+	c.get(k1, "s#hourly", "s")
+	k3 := testSet(c, testQuery([]int{3}, begin, time.Time{}), 0)
+
+	if _, exists := c.entries[k2]; exists {
+		t.Error("least recently used entry not evicted")
+	}
+	if _, exists := c.entries[k1]; !exists {
+		t.Error("recently used entry evicted")
+	}
+	if _, exists := c.entries[k3]; !exists {
+		t.Error("new entry evicted")
+	}
+	if m := c.getSiteMetrics("s"); m.Entries != 2 || m.Evictions != 1 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestInvalidateOrdering(t *testing.T) {
+	c := newCache(10)
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(24 * time.Hour)
+
+	q := testQuery([]int{1}, begin, end)
+	key, _ := q.key()
+	found := func() bool {
+		_, exists := c.entries[key]
+		return exists
+	}
+
+	//变更提交前开始的查询 提交后失效 结果不保存
+	_, seq, _ := c.get(key, q.bucket(), q.SiteID)
+	c.invalidate("s", "hourly", 1, begin)
+	testSet(c, q, seq)
+	if found() {
+		t.Error("result read before commit stored")
+	}
+
+	//提交后开始的查询 读主库时保存
+	_, seq, _ = c.get(key, q.bucket(), q.SiteID)
+	testSet(c, q, seq)
+	if !found() {
+		t.Error("result read after commit discarded")
+	}
+
+	//读从库时提交后开始的查询在滞后窗口内仍可能读到旧数据
+	ReplicaLag = time.Minute
+	defer func() { ReplicaLag = 0 }()
+
+	c.invalidate("s", "hourly", 1, begin)
+	_, seq, _ = c.get(key, q.bucket(), q.SiteID)
+	testSet(c, q, seq)
+	if found() {
+		t.Error("result within replica lag stored")
+	}
+
+	q2 := testQuery([]int{2}, begin, end)
+	testSet(c, q2, seq)
+	if k2, _ := q2.key(); c.entries[k2] == nil {
+		t.Error("unaffected result within replica lag discarded")
+	}
+
+	for _, inv := range c.getBucket(q.bucket()).history {
+		inv.at = inv.at.Add(-time.Minute)
+	}
+	testSet(c, q, seq)
+	if !found() {
+		t.Error("result after replica lag discarded")
+	}
+
+	//批量删除不限站点
+	c.invalidateRange("s", "hourly", 0, time.Time{}, begin)
+	if found() {
+		t.Error("entry in purged range not invalidated")
+	}
+}
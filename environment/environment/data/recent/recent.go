@@ -363,7 +363,7 @@ func fetchRecentData(siteID, dataType string, appendix map[int]map[int]data.IDat
 			%s %s
 	`, strings.Join(fields, ","), table, table, data.DATA_TIME, data.STATION_ID, idField, sm.MonitorField, data.STATION_ID, data.STATION_ID, idField)
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get recent data: ", SQL, values, err)
 		return nil, err
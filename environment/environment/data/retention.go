@@ -0,0 +1,374 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data/querycache"
+	"obsessiontech/environment/logging"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(MODULE_DATA, "realtimedata", retentionHoldMigration)
+}
+
+const (
+	LOGGING_SOURCE_RETENTION = "retention"
+	LOGGING_ACTION_PURGE     = "purge"
+)
+
+var e_retention_hold_not_exists = errors.New("保留锁定不存在")
+var e_retention_hold_time = errors.New("保留锁定时间不正确")
+
+//数据保留期限 超期数据及归档定期清理 Days为0时永久保留
+type Retention struct {
+	DataType string `json:"dataType"`
+	Days     int    `json:"days"`
+}
+
+func (r *Retention) getExpireTime() time.Time {
+	return util.GetDate(time.Now()).AddDate(0, 0, -r.Days)
+}
+
+//保留锁定 锁定范围内的数据不被清理 DataType为空时适用全部数据类型 StationID为0时适用全部排放口
+type RetentionHold struct {
+	ID         int       `json:"ID"`
+	DataType   string    `json:"dataType"`
+	StationID  int       `json:"stationID"`
+	BeginTime  util.Time `json:"beginTime"`
+	EndTime    util.Time `json:"endTime"`
+	Reason     string    `json:"reason"`
+	UID        int       `json:"UID"`
+	CreateTime util.Time `json:"createTime"`
+}
+
+func retentionHoldTableName(siteID string) string {
+	return siteID + "_dataretentionhold"
+}
+
+//数据保留豁免表
+var retentionHoldMigration = &initialization.Migration{
+	Version:     4,
+	Description: "数据保留豁免",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_dataretentionhold (
+			id INT NOT NULL AUTO_INCREMENT,
+			data_type VARCHAR(32) NOT NULL DEFAULT '',
+			station_id INT NOT NULL DEFAULT 0,
+			begin_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			reason VARCHAR(255) NOT NULL DEFAULT '',
+			uid INT NOT NULL DEFAULT 0,
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+func (h *RetentionHold) overlaps(stationID int, beginTime, endTime time.Time) bool {
+	if h.StationID > 0 && stationID > 0 && h.StationID != stationID {
+		return false
+	}
+	return !time.Time(h.BeginTime).After(endTime) && !time.Time(h.EndTime).Before(beginTime)
+}
+
+func AddRetentionHold(siteID string, h *RetentionHold) error {
+
+	if h.DataType != "" && TableName(siteID, h.DataType) == "" {
+		return e_invalid_data_type
+	}
+
+	if time.Time(h.BeginTime).IsZero() || time.Time(h.EndTime).IsZero() || time.Time(h.EndTime).Before(time.Time(h.BeginTime)) {
+		return e_retention_hold_time
+	}
+
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(data_type, station_id, begin_time, end_time, reason, uid)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+	`, retentionHoldTableName(siteID)), h.DataType, h.StationID, time.Time(h.BeginTime), time.Time(h.EndTime), h.Reason, h.UID)
+	if err != nil {
+		log.Println("error add retention hold: ", err)
+		return err
+	}
+
+	id, err := ret.LastInsertId()
+	if err != nil {
+		return err
+	}
+	h.ID = int(id)
+
+	return nil
+}
+
+func DeleteRetentionHold(siteID string, holdID int) error {
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		DELETE FROM %s WHERE id = ?
+	`, retentionHoldTableName(siteID)), holdID)
+	if err != nil {
+		log.Println("error delete retention hold: ", err)
+		return err
+	}
+
+	if affected, _ := ret.RowsAffected(); affected == 0 {
+		return e_retention_hold_not_exists
+	}
+
+	return nil
+}
+
+//dataType为空时返回全部锁定 否则返回适用该数据类型的锁定
+func GetRetentionHolds(siteID, dataType string) ([]*RetentionHold, error) {
+
+	whereStmts := []string{"1 = 1"}
+	values := []interface{}{}
+
+	if dataType != "" {
+		whereStmts = append(whereStmts, "(hold.data_type = '' OR hold.data_type = ?)")
+		values = append(values, dataType)
+	}
+
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			hold.id, hold.data_type, hold.station_id, hold.begin_time, hold.end_time, hold.reason, hold.uid, hold.create_time
+		FROM
+			%s hold
+		WHERE
+			%s
+		ORDER BY
+			hold.id ASC
+	`, retentionHoldTableName(siteID), strings.Join(whereStmts, " AND ")), values...)
+	if err != nil {
+		log.Println("error get retention holds: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*RetentionHold, 0)
+	for rows.Next() {
+		var h RetentionHold
+		var beginTime, endTime, createTime time.Time
+		if err := rows.Scan(&h.ID, &h.DataType, &h.StationID, &beginTime, &endTime, &h.Reason, &h.UID, &createTime); err != nil {
+			log.Println("error get retention holds: ", err)
+			return nil, err
+		}
+		h.BeginTime = util.Time(beginTime)
+		h.EndTime = util.Time(endTime)
+		h.CreateTime = util.Time(createTime)
+		result = append(result, &h)
+	}
+
+	return result, nil
+}
+
+//清理报告 试运行时仅统计不删除
+type PurgeReport struct {
+	DataType   string    `json:"dataType"`
+	ExpireTime util.Time `json:"expireTime"`
+	DryRun     bool      `json:"dryRun"`
+	Rows       int64     `json:"rows"`
+	HeldRows   int64     `json:"heldRows"`
+	Tables     []string  `json:"tables"`
+	HeldTables []string  `json:"heldTables"`
+	Files      []string  `json:"files"`
+	HeldFiles  []string  `json:"heldFiles"`
+}
+
+func parseHoldSQL(holds []*RetentionHold) (string, []interface{}) {
+	ors := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	for _, h := range holds {
+		if h.StationID > 0 {
+			ors = append(ors, fmt.Sprintf("(%s >= ? AND %s <= ? AND %s = ?)", DATA_TIME, DATA_TIME, STATION_ID))
+			values = append(values, time.Time(h.BeginTime), time.Time(h.EndTime), h.StationID)
+		} else {
+			ors = append(ors, fmt.Sprintf("(%s >= ? AND %s <= ?)", DATA_TIME, DATA_TIME))
+			values = append(values, time.Time(h.BeginTime), time.Time(h.EndTime))
+		}
+	}
+
+	if len(ors) == 0 {
+		return "", values
+	}
+
+	return fmt.Sprintf("(%s)", strings.Join(ors, " OR ")), values
+}
+
+//按保留期限清理超期数据 未被锁定的已归档表及归档文件整体删除 uid为0时为定时清理
+func Purge(siteID string, uid int, dryRun bool) ([]*PurgeReport, error) {
+
+	m, err := GetModule(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*PurgeReport, 0)
+
+	for _, r := range m.Retentions {
+		if r.Days <= 0 {
+			continue
+		}
+
+		report, err := purge(siteID, r, m.RotationBatchSize, dryRun)
+		if err != nil {
+			log.Println("error purge: ", siteID, r.DataType, err)
+			return result, err
+		}
+		result = append(result, report)
+
+		if !dryRun {
+			log.Printf("purged [%s] %s: %d rows %d tables %d files", siteID, r.DataType, report.Rows, len(report.Tables), len(report.Files))
+			if err := logging.Log(siteID, uid, MODULE_DATA, LOGGING_SOURCE_RETENTION, r.DataType, LOGGING_ACTION_PURGE, report); err != nil {
+				log.Println("error log purge: ", siteID, r.DataType, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func purge(siteID string, r *Retention, batchSize int, dryRun bool) (*PurgeReport, error) {
+
+	report := &PurgeReport{
+		DataType:   r.DataType,
+		ExpireTime: util.Time(r.getExpireTime()),
+		DryRun:     dryRun,
+		Tables:     make([]string, 0),
+		HeldTables: make([]string, 0),
+		Files:      make([]string, 0),
+		HeldFiles:  make([]string, 0),
+	}
+	expireTime := time.Time(report.ExpireTime)
+
+	holds, err := GetRetentionHolds(siteID, r.DataType)
+	if err != nil {
+		return nil, err
+	}
+
+	_, archives := getArchiveTables(siteID, r.DataType)
+	if !dryRun {
+		for _, a := range archives {
+			if a.Status != archived {
+				return nil, errors.New("archive activation in progress")
+			}
+		}
+	}
+
+	table := TableName(siteID, r.DataType)
+	holdSQL, holdValues := parseHoldSQL(holds)
+
+	whereStmts := []string{fmt.Sprintf("%s < ?", DATA_TIME)}
+	values := []interface{}{expireTime}
+	if holdSQL != "" {
+		whereStmts = append(whereStmts, "NOT "+holdSQL)
+		values = append(values, holdValues...)
+
+		if err := datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf("SELECT COUNT(1) FROM %s WHERE %s < ? AND %s", table, DATA_TIME, holdSQL), append([]interface{}{expireTime}, holdValues...)...).Scan(&report.HeldRows); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		if err := datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf("SELECT COUNT(1) FROM %s WHERE %s", table, strings.Join(whereStmts, " AND ")), values...).Scan(&report.Rows); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT ?", table, strings.Join(whereStmts, " AND ")), append(values, batchSize)...)
+			if err != nil {
+				log.Println("error purge rows: ", table, err)
+				return nil, err
+			}
+			affected, _ := ret.RowsAffected()
+			report.Rows += affected
+			if affected < int64(batchSize) {
+				break
+			}
+		}
+	}
+
+	if !dryRun {
+		for _, del := range tsdbPurgeDeletes(r.DataType, expireTime, holds) {
+			mirrorDelete(siteID, del)
+		}
+	}
+
+	//归档表按日期命名 截止日期当日的数据同在表内
+	for _, a := range archives {
+		if a.Status != archived || a.EndTime.AddDate(0, 0, 1).After(expireTime) {
+			continue
+		}
+
+		held := false
+		for _, h := range holds {
+			if h.overlaps(0, a.BeginTime, a.EndTime.AddDate(0, 0, 1)) {
+				held = true
+				break
+			}
+		}
+		if held {
+			report.HeldTables = append(report.HeldTables, a.TableName)
+			continue
+		}
+
+		if !dryRun {
+			if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf("DROP TABLE `%s`", a.TableName)); err != nil {
+				log.Println("error purge archive table: ", a.TableName, err)
+				return nil, err
+			}
+		}
+		report.Tables = append(report.Tables, a.TableName)
+	}
+
+	for _, f := range getArchiveFiles(siteID, r.DataType) {
+		if !f.MaxTime.Before(expireTime) {
+			continue
+		}
+
+		held := false
+		for _, h := range holds {
+			if h.overlaps(f.StationID, f.MinTime, f.MaxTime) {
+				held = true
+				break
+			}
+		}
+		if held {
+			report.HeldFiles = append(report.HeldFiles, f.File)
+			continue
+		}
+
+		if !dryRun {
+			dir := archiveFileDir(siteID, r.DataType)
+			if err := os.Remove(filepath.Join(dir, strings.TrimSuffix(f.File, archive_file_ext)+archive_index_ext)); err != nil && !os.IsNotExist(err) {
+				log.Println("error purge archive file: ", f.File, err)
+				return nil, err
+			}
+			if err := os.Remove(filepath.Join(dir, f.File)); err != nil && !os.IsNotExist(err) {
+				log.Println("error purge archive file: ", f.File, err)
+				return nil, err
+			}
+		}
+		report.Files = append(report.Files, f.File)
+	}
+
+	if !dryRun && (len(report.Tables) > 0 || len(report.Files) > 0) {
+		ClearArchiveTable(siteID, r.DataType)
+	}
+
+	//清理的行及归档不再可查
+	if !dryRun {
+		querycache.InvalidateRange(siteID, r.DataType, 0, time.Time{}, expireTime)
+	}
+
+	return report, nil
+}
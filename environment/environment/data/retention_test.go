@@ -0,0 +1,79 @@
+package data
+
+import (
+	"obsessiontech/common/util"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHoldSQL(t *testing.T) {
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		name         string
+		holds        []*RetentionHold
+		expectSQL    string
+		expectValues []interface{}
+	}{
+		{"no hold", nil, "", []interface{}{}},
+		{
+			"all stations",
+			[]*RetentionHold{{BeginTime: util.Time(begin), EndTime: util.Time(end)}},
+			"((data_time >= ? AND data_time <= ?))",
+			[]interface{}{begin, end},
+		},
+		{
+			"station and all stations",
+			[]*RetentionHold{
+				{StationID: 3, BeginTime: util.Time(begin), EndTime: util.Time(end)},
+				{BeginTime: util.Time(end), EndTime: util.Time(end.AddDate(0, 1, 0))},
+			},
+			"((data_time >= ? AND data_time <= ? AND station_id = ?) OR (data_time >= ? AND data_time <= ?))",
+			[]interface{}{begin, end, 3, end, end.AddDate(0, 1, 0)},
+		},
+	}
+
+	for _, c := range cases {
+		SQL, values := parseHoldSQL(c.holds)
+		if SQL != c.expectSQL {
+			t.Errorf("%s: sql %s, expect %s", c.name, SQL, c.expectSQL)
+		}
+		if !reflect.DeepEqual(values, c.expectValues) {
+			t.Errorf("%s: values %v, expect %v", c.name, values, c.expectValues)
+		}
+	}
+}
+
+func TestRetentionHoldOverlaps(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.Local) }
+
+	h := &RetentionHold{StationID: 3, BeginTime: util.Time(day(10)), EndTime: util.Time(day(20))}
+	all := &RetentionHold{BeginTime: util.Time(day(10)), EndTime: util.Time(day(20))}
+
+	cases := []struct {
+		name      string
+		hold      *RetentionHold
+		stationID int
+		begin     time.Time
+		end       time.Time
+		expect    bool
+	}{
+		{"inside", h, 3, day(12), day(15), true},
+		{"covers", h, 3, day(1), day(31), true},
+		{"ends at begin", h, 3, day(1), day(10), true},
+		{"begins at end", h, 3, day(20), day(25), true},
+		{"before", h, 3, day(1), day(9), false},
+		{"after", h, 3, day(21), day(25), false},
+		{"other station", h, 4, day(12), day(15), false},
+		{"whole table", h, 0, day(12), day(15), true},
+		{"hold for all stations", all, 4, day(12), day(15), true},
+	}
+
+	for _, c := range cases {
+		if v := c.hold.overlaps(c.stationID, c.begin, c.end); v != c.expect {
+			t.Errorf("%s: overlaps = %v, expect %v", c.name, v, c.expect)
+		}
+	}
+}
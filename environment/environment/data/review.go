@@ -0,0 +1,243 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(MODULE_DATA, "realtimedata", reviewMigration)
+}
+
+const (
+	REVIEW_APPROVE = "approve"
+	REVIEW_MODIFY  = "modify"
+	REVIEW_INVALID = "invalid"
+	REVIEW_REOPEN  = "reopen"
+)
+
+var E_data_not_reviewable = errors.New("仅小时及日数据可审核")
+
+type ReviewRecord struct {
+	ID         int       `json:"ID"`
+	DataType   string    `json:"dataType"`
+	DataID     int       `json:"dataID"`
+	StationID  int       `json:"stationID"`
+	MonitorID  int       `json:"monitorID"`
+	DataTime   util.Time `json:"dataTime"`
+	Action     string    `json:"action"`
+	Flag       string    `json:"flag"`
+	UID        int       `json:"UID"`
+	Reason     string    `json:"reason"`
+	CreateTime util.Time `json:"createTime"`
+}
+
+func reviewTableName(siteID string) string {
+	return siteID + "_datareview"
+}
+
+//数据审核记录表
+var reviewMigration = &initialization.Migration{
+	Version:     2,
+	Description: "数据审核记录",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_datareview (
+			id INT NOT NULL AUTO_INCREMENT,
+			data_type VARCHAR(32) NOT NULL,
+			data_id INT NOT NULL,
+			station_id INT NOT NULL,
+			monitor_id INT NOT NULL,
+			data_time DATETIME NOT NULL,
+			action VARCHAR(32) NOT NULL,
+			flag VARCHAR(32) NOT NULL DEFAULT '',
+			uid INT NOT NULL DEFAULT 0,
+			reason VARCHAR(255) NOT NULL DEFAULT '',
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id),
+			KEY (data_type, station_id, monitor_id, data_time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+func AddReviewRecordWithTxn(siteID string, txn *sql.Tx, d IData, action string, uid int, reason string) error {
+	if _, err := txn.Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(data_type,data_id,station_id,monitor_id,data_time,action,flag,uid,reason)
+		VALUES
+			(?,?,?,?,?,?,?,?,?)
+	`, reviewTableName(siteID)), d.GetDataType(), d.GetID(), d.GetStationID(), d.GetMonitorID(), time.Time(d.GetDataTime()), action, d.GetFlag(), uid, reason); err != nil {
+		log.Println("error insert data review: ", err)
+		return err
+	}
+	return nil
+}
+
+const reviewColumns = "review.id, review.data_type, review.data_id, review.station_id, review.monitor_id, review.data_time, review.action, review.flag, review.uid, review.reason, review.create_time"
+
+func GetReviewRecords(siteID, dataType string, stationID, monitorID []int, beginTime, endTime time.Time) ([]*ReviewRecord, error) {
+
+	whereStmts := []string{"review.data_type = ?", "review.data_time >= ?", "review.data_time <= ?"}
+	values := []interface{}{dataType, beginTime, endTime}
+
+	if len(stationID) > 0 {
+		if len(stationID) == 1 {
+			whereStmts = append(whereStmts, "review.station_id = ?")
+			values = append(values, stationID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range stationID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("review.station_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if len(monitorID) > 0 {
+		if len(monitorID) == 1 {
+			whereStmts = append(whereStmts, "review.monitor_id = ?")
+			values = append(values, monitorID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range monitorID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("review.monitor_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s review
+		WHERE
+			%s
+		ORDER BY
+			review.id ASC
+	`, reviewColumns, reviewTableName(siteID), strings.Join(whereStmts, " AND ")), values...)
+	if err != nil {
+		log.Println("error get data review: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*ReviewRecord, 0)
+	for rows.Next() {
+		var r ReviewRecord
+		var dataTime, createTime time.Time
+		if err := rows.Scan(&r.ID, &r.DataType, &r.DataID, &r.StationID, &r.MonitorID, &dataTime, &r.Action, &r.Flag, &r.UID, &r.Reason, &createTime); err != nil {
+			log.Println("error get data review: ", err)
+			return nil, err
+		}
+		r.DataTime = util.Time(dataTime)
+		r.CreateTime = util.Time(createTime)
+		result = append(result, &r)
+	}
+
+	return result, nil
+}
+
+type ReviewProgress struct {
+	StationID int    `json:"stationID"`
+	Month     string `json:"month"`
+	Total     int    `json:"total"`
+	Reviewed  int    `json:"reviewed"`
+	Invalid   int    `json:"invalid"`
+}
+
+//按排放口按月统计审核进度 invalidFlags为审核无效标记
+func GetReviewProgress(siteID, dataType string, stationID []int, beginTime, endTime time.Time, invalidFlags []string) ([]*ReviewProgress, error) {
+
+	result := make([]*ReviewProgress, 0)
+
+	if !IsReviewable(dataType) {
+		return nil, E_data_not_reviewable
+	}
+
+	if len(stationID) == 0 {
+		return result, nil
+	}
+
+	whereStmts := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	invalidStmt := "0"
+	if len(invalidFlags) > 0 {
+		placeholder := make([]string, 0)
+		for _, f := range invalidFlags {
+			placeholder = append(placeholder, "?")
+			values = append(values, f)
+		}
+		invalidStmt = fmt.Sprintf("data.%s IN (%s)", FLAG, strings.Join(placeholder, ","))
+	}
+
+	if len(stationID) == 1 {
+		whereStmts = append(whereStmts, fmt.Sprintf("data.%s = ?", STATION_ID))
+		values = append(values, stationID[0])
+	} else {
+		placeholder := make([]string, 0)
+		for _, id := range stationID {
+			placeholder = append(placeholder, "?")
+			values = append(values, id)
+		}
+		whereStmts = append(whereStmts, fmt.Sprintf("data.%s IN (%s)", STATION_ID, strings.Join(placeholder, ",")))
+	}
+
+	whereStmts = append(whereStmts, fmt.Sprintf("data.%s >= ? AND data.%s <= ?", DATA_TIME, DATA_TIME))
+	values = append(values, beginTime, endTime)
+
+	progress := make(map[int]map[string]*ReviewProgress)
+
+	for _, table := range FetchTableNames(siteID, dataType, beginTime, endTime) {
+		rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+			SELECT
+				data.%s, DATE_FORMAT(data.%s, '%%Y-%%m'), COUNT(1), SUM(IF(data.%s > 0, 1, 0)), SUM(IF(%s, 1, 0))
+			FROM
+				%s data
+			WHERE
+				%s
+			GROUP BY
+				1, 2
+		`, STATION_ID, DATA_TIME, REVIEWED, invalidStmt, table, strings.Join(whereStmts, " AND ")), values...)
+		if err != nil {
+			log.Println("error get review progress: ", err)
+			return nil, err
+		}
+
+		for rows.Next() {
+			var p ReviewProgress
+			if err := rows.Scan(&p.StationID, &p.Month, &p.Total, &p.Reviewed, &p.Invalid); err != nil {
+				rows.Close()
+				log.Println("error get review progress: ", err)
+				return nil, err
+			}
+
+			months, exists := progress[p.StationID]
+			if !exists {
+				months = make(map[string]*ReviewProgress)
+				progress[p.StationID] = months
+			}
+			if existing, exists := months[p.Month]; exists {
+				existing.Total += p.Total
+				existing.Reviewed += p.Reviewed
+				existing.Invalid += p.Invalid
+			} else {
+				months[p.Month] = &p
+				result = append(result, &p)
+			}
+		}
+		rows.Close()
+	}
+
+	return result, nil
+}
@@ -0,0 +1,332 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(MODULE_DATA, "realtimedata", revisionMigration)
+}
+
+var E_revision_not_exists = errors.New("修改记录不存在")
+
+//数据修改记录 只增不改 数值字段记录新旧数值 标记变更记为flag字段
+type Revision struct {
+	ID         int       `json:"ID"`
+	DataType   string    `json:"dataType"`
+	DataID     int       `json:"dataID"`
+	StationID  int       `json:"stationID"`
+	MonitorID  int       `json:"monitorID"`
+	DataTime   util.Time `json:"dataTime"`
+	Field      string    `json:"field"`
+	OldValue   *float64  `json:"oldValue"`
+	NewValue   *float64  `json:"newValue"`
+	OldFlag    string    `json:"oldFlag"`
+	NewFlag    string    `json:"newFlag"`
+	UID        int       `json:"UID"`
+	Reason     string    `json:"reason"`
+	CreateTime util.Time `json:"createTime"`
+}
+
+//修改上下文 设置后该数据此后的修改均记录 未设置时仅记录带uid的修改
+type revisionContext struct {
+	lock    sync.Mutex
+	active  bool
+	uid     int
+	reason  string
+	pending []*Revision
+}
+
+type iRevision interface {
+	getRevisionContext() *revisionContext
+}
+
+func (d *data) getRevisionContext() *revisionContext { return &d.revision }
+
+func SetRevisionContext(d IData, uid int, reason string) {
+	r, ok := d.(iRevision)
+	if !ok {
+		return
+	}
+	ctx := r.getRevisionContext()
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	ctx.active = true
+	ctx.uid = uid
+	ctx.reason = reason
+}
+
+func revisionTableName(siteID string) string {
+	return siteID + "_datarevision"
+}
+
+//数据修改记录表
+var revisionMigration = &initialization.Migration{
+	Version:     1,
+	Description: "数据修改记录",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_datarevision (
+			id INT NOT NULL AUTO_INCREMENT,
+			data_type VARCHAR(32) NOT NULL,
+			data_id INT NOT NULL,
+			station_id INT NOT NULL,
+			monitor_id INT NOT NULL,
+			data_time DATETIME NOT NULL,
+			field VARCHAR(32) NOT NULL,
+			old_value DOUBLE NULL,
+			new_value DOUBLE NULL,
+			old_flag VARCHAR(32) NOT NULL DEFAULT '',
+			new_flag VARCHAR(32) NOT NULL DEFAULT '',
+			uid INT NOT NULL DEFAULT 0,
+			reason VARCHAR(255) NOT NULL DEFAULT '',
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id),
+			KEY (data_type, station_id, monitor_id, data_time),
+			KEY (data_type, data_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+func recordRevision(d IData, field string, oldValue, newValue *float64, oldFlag, newFlag string, uid int) {
+	r, ok := d.(iRevision)
+	if !ok {
+		return
+	}
+	ctx := r.getRevisionContext()
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if !ctx.active && uid <= 0 {
+		return
+	}
+	if uid <= 0 {
+		uid = ctx.uid
+	}
+
+	//同一次保存内同字段的多次修改合并
+	for i, p := range ctx.pending {
+		if p.Field != field {
+			continue
+		}
+		p.NewValue = newValue
+		p.NewFlag = newFlag
+		p.UID = uid
+		if isSameRevisionValue(p.OldValue, p.NewValue) && p.OldFlag == p.NewFlag {
+			ctx.pending = append(ctx.pending[:i], ctx.pending[i+1:]...)
+		}
+		return
+	}
+
+	ctx.pending = append(ctx.pending, &Revision{
+		Field:    field,
+		OldValue: oldValue,
+		NewValue: newValue,
+		OldFlag:  oldFlag,
+		NewFlag:  newFlag,
+		UID:      uid,
+		Reason:   ctx.reason,
+	})
+}
+
+func recordValueRevision(d IData, field string, oldValue, newValue float64, uid int) {
+	recordRevision(d, field, &oldValue, &newValue, d.GetFlag(), d.GetFlag(), uid)
+}
+
+func isSameRevisionValue(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+//数据保存后写入修改记录
+func flushRevision(siteID string, txn *sql.Tx, d IData) error {
+	r, ok := d.(iRevision)
+	if !ok {
+		return nil
+	}
+	ctx := r.getRevisionContext()
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if len(ctx.pending) == 0 {
+		return nil
+	}
+
+	placeholder := make([]string, 0)
+	values := make([]interface{}, 0)
+	for _, p := range ctx.pending {
+		placeholder = append(placeholder, "(?,?,?,?,?,?,?,?,?,?,?,?)")
+		values = append(values, d.GetDataType(), d.GetID(), d.GetStationID(), d.GetMonitorID(), time.Time(d.GetDataTime()), p.Field, p.OldValue, p.NewValue, p.OldFlag, p.NewFlag, p.UID, p.Reason)
+	}
+
+	SQL := fmt.Sprintf(`
+		INSERT INTO %s
+			(data_type,data_id,station_id,monitor_id,data_time,field,old_value,new_value,old_flag,new_flag,uid,reason)
+		VALUES
+			%s
+	`, revisionTableName(siteID), strings.Join(placeholder, ","))
+
+	var err error
+	if txn != nil {
+		_, err = txn.Exec(SQL, values...)
+	} else {
+		_, err = datasource.GetSiteConn(siteID).Exec(SQL, values...)
+	}
+	if err != nil {
+		log.Println("error insert data revision: ", err)
+		return err
+	}
+
+	ctx.pending = nil
+
+	return nil
+}
+
+//数据未实际保存时丢弃待写入的修改记录
+func discardRevision(d IData) {
+	r, ok := d.(iRevision)
+	if !ok {
+		return
+	}
+	ctx := r.getRevisionContext()
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	ctx.pending = nil
+}
+
+const revisionColumns = "revision.id, revision.data_type, revision.data_id, revision.station_id, revision.monitor_id, revision.data_time, revision.field, revision.old_value, revision.new_value, revision.old_flag, revision.new_flag, revision.uid, revision.reason, revision.create_time"
+
+func (r *Revision) scan(rows *sql.Rows) error {
+	var oldValue, newValue sql.NullFloat64
+	var dataTime, createTime time.Time
+	if err := rows.Scan(&r.ID, &r.DataType, &r.DataID, &r.StationID, &r.MonitorID, &dataTime, &r.Field, &oldValue, &newValue, &r.OldFlag, &r.NewFlag, &r.UID, &r.Reason, &createTime); err != nil {
+		return err
+	}
+	r.DataTime = util.Time(dataTime)
+	r.CreateTime = util.Time(createTime)
+	if oldValue.Valid {
+		r.OldValue = &oldValue.Float64
+	}
+	if newValue.Valid {
+		r.NewValue = &newValue.Float64
+	}
+	return nil
+}
+
+func GetRevision(siteID string, revisionID int) (*Revision, error) {
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s revision
+		WHERE
+			revision.id = ?
+	`, revisionColumns, revisionTableName(siteID)), revisionID)
+	if err != nil {
+		log.Println("error get data revision: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var r Revision
+		if err := r.scan(rows); err != nil {
+			log.Println("error get data revision: ", err)
+			return nil, err
+		}
+		return &r, nil
+	}
+
+	return nil, E_revision_not_exists
+}
+
+//按数据点查询修改记录 dataID为0时按排放口/监测物/数据时间查询
+func GetRevisions(siteID, dataType string, dataID int, stationID []int, monitorID []int, beginTime, endTime *time.Time) ([]*Revision, error) {
+
+	whereStmts := []string{"revision.data_type = ?"}
+	values := []interface{}{dataType}
+
+	if dataID > 0 {
+		whereStmts = append(whereStmts, "revision.data_id = ?")
+		values = append(values, dataID)
+	}
+
+	if len(stationID) > 0 {
+		if len(stationID) == 1 {
+			whereStmts = append(whereStmts, "revision.station_id = ?")
+			values = append(values, stationID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range stationID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("revision.station_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if len(monitorID) > 0 {
+		if len(monitorID) == 1 {
+			whereStmts = append(whereStmts, "revision.monitor_id = ?")
+			values = append(values, monitorID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range monitorID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("revision.monitor_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if beginTime != nil {
+		whereStmts = append(whereStmts, "revision.data_time >= ?")
+		values = append(values, *beginTime)
+	}
+
+	if endTime != nil {
+		whereStmts = append(whereStmts, "revision.data_time <= ?")
+		values = append(values, *endTime)
+	}
+
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s revision
+		WHERE
+			%s
+		ORDER BY
+			revision.id ASC
+	`, revisionColumns, revisionTableName(siteID), strings.Join(whereStmts, " AND ")), values...)
+	if err != nil {
+		log.Println("error get data revision: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Revision, 0)
+	for rows.Next() {
+		var r Revision
+		if err := r.scan(rows); err != nil {
+			log.Println("error get data revision: ", err)
+			return nil, err
+		}
+		result = append(result, &r)
+	}
+
+	return result, nil
+}
@@ -47,6 +47,7 @@ var Config struct {
 	EnvironmentArchiveWorkerHostType            string
 	EnvironmentArchiveWorkerHost                string
 	EnvironmentArchiveWorkerReconnectTimeOutSec time.Duration
+	EnvironmentArchiveFileDir                   string
 }
 
 var rotationPersistentClientLock sync.RWMutex
@@ -170,6 +171,8 @@ type Rotation struct {
 	DataType string `json:"dataType"`
 	Active   string `json:"active"`
 	Archive  string `json:"archive"`
+	//归档表导出为压缩文件 查询时直接读取文件
+	File bool `json:"file,omitempty"`
 }
 
 type archiveTable struct {
@@ -200,6 +203,8 @@ func ClearArchiveTable(siteID, dataType string) {
 		}
 	}
 
+	clearArchiveFiles(siteID, dataType)
+
 	archivesPoolLock.Lock()
 	defer archivesPoolLock.Unlock()
 
@@ -262,7 +267,7 @@ func getArchiveTables(siteID, dataType string) (site *siteArchive, result []*arc
 
 			SQL := "SHOW TABLES LIKE '" + table + "%'"
 
-			rows, err := datasource.GetConn().Query(SQL)
+			rows, err := datasource.GetSiteConn(siteID).Query(SQL)
 			if err != nil {
 				log.Println("error show archive tables: ", err)
 				return
@@ -420,7 +425,17 @@ func Rotate(siteID string) error {
 	for _, r := range dataModule.Rotations {
 		if err := rotate(siteID, r.DataType, r, dataModule.RotationBatchSize); err != nil {
 			log.Println("error rotate: ", siteID, r.DataType, err)
+			continue
 		}
+		if r.File {
+			if err := exportArchiveTables(siteID, r.DataType); err != nil {
+				log.Println("error export archive: ", siteID, r.DataType, err)
+			}
+		}
+	}
+
+	if _, err := Purge(siteID, 0, false); err != nil {
+		log.Println("error purge: ", siteID, err)
 	}
 
 	log.Println("done rotation: ", siteID)
@@ -433,7 +448,7 @@ func rotate(siteID, dataType string, rotation *Rotation, batchSize int) error {
 	activeTable := TableName(siteID, dataType)
 	rotatingTable := activeTable + "_" + rotating
 
-	if rows, err := datasource.GetConn().Query("SHOW TABLES LIKE '" + rotatingTable + "'"); err != nil {
+	if rows, err := datasource.GetSiteConn(siteID).Query("SHOW TABLES LIKE '" + rotatingTable + "'"); err != nil {
 		log.Println("error check rotating table: ", err)
 		return err
 	} else {
@@ -454,7 +469,7 @@ func rotate(siteID, dataType string, rotation *Rotation, batchSize int) error {
 
 	activeTime := rotation.getActiveTime()
 
-	_, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	_, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		CREATE TABLE %s LIKE %s
 	`, rotatingTable, activeTable))
 
@@ -463,7 +478,7 @@ func rotate(siteID, dataType string, rotation *Rotation, batchSize int) error {
 		return err
 	}
 	defer func() {
-		datasource.GetConn().Exec("DROP TABLE " + rotatingTable)
+		datasource.GetSiteConn(siteID).Exec("DROP TABLE " + rotatingTable)
 		ClearArchiveTable(siteID, dataType)
 	}()
 
@@ -484,7 +499,7 @@ func rotate(siteID, dataType string, rotation *Rotation, batchSize int) error {
 
 		log.Println("do archive: ", copySQL, activeTime, id, batchSize)
 
-		copyRet, err := datasource.GetConn().Exec(copySQL, activeTime, id, batchSize)
+		copyRet, err := datasource.GetSiteConn(siteID).Exec(copySQL, activeTime, id, batchSize)
 
 		if err != nil {
 			log.Println("error rotating copy: ", err)
@@ -498,7 +513,7 @@ func rotate(siteID, dataType string, rotation *Rotation, batchSize int) error {
 			return nil
 		}
 
-		maxID, copyMin, copyMax, err := getMinMaxFromRotating(rotatingTable, nil, nil)
+		maxID, copyMin, copyMax, err := getMinMaxFromRotating(siteID, rotatingTable, nil, nil)
 		if err != nil {
 			return err
 		}
@@ -515,7 +530,7 @@ func rotate(siteID, dataType string, rotation *Rotation, batchSize int) error {
 				break
 			}
 
-			_, min, max, err := getMinMaxFromRotating(rotatingTable, &archiveBegin, &archiveEnd)
+			_, min, max, err := getMinMaxFromRotating(siteID, rotatingTable, &archiveBegin, &archiveEnd)
 			if err != nil {
 				log.Println("error archive get min max: ", err)
 				return err
@@ -546,7 +561,7 @@ func rotate(siteID, dataType string, rotation *Rotation, batchSize int) error {
 	}
 }
 
-func getMinMaxFromRotating(rotatingTable string, archiveBegin, archiveEnd *time.Time) (maxID int64, min, max time.Time, e error) {
+func getMinMaxFromRotating(siteID, rotatingTable string, archiveBegin, archiveEnd *time.Time) (maxID int64, min, max time.Time, e error) {
 
 	SQL := fmt.Sprintf(`
 		SELECT
@@ -571,7 +586,7 @@ func getMinMaxFromRotating(rotatingTable string, archiveBegin, archiveEnd *time.
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get min max data_time from rotating: ", err)
 		e = err
@@ -603,11 +618,11 @@ func getMinMaxFromRotating(rotatingTable string, archiveBegin, archiveEnd *time.
 	return
 }
 
-func createArchiveTable(activeTable, archiveTable string) error {
+func createArchiveTable(siteID, activeTable, archiveTable string) error {
 
 	log.Println("create archive table: ", archiveTable)
 
-	rows, err := datasource.GetConn().Query(fmt.Sprintf("SHOW COLUMNS FROM %s", activeTable))
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf("SHOW COLUMNS FROM %s", activeTable))
 	if err != nil {
 		log.Println("error rotating create archive show columns: ", activeTable, archiveTable, err)
 		return err
@@ -633,7 +648,7 @@ func createArchiveTable(activeTable, archiveTable string) error {
 		%s
 	) ENGINE=ARCHIVE DEFAULT CHARSET=utf8`, archiveTable, strings.Join(fields, ",\n"))
 
-	if _, err := datasource.GetConn().Exec(createSyntax); err != nil {
+	if _, err := datasource.GetSiteConn(siteID).Exec(createSyntax); err != nil {
 		log.Println("error rotating create archive: ", activeTable, archiveTable, createSyntax, err)
 		return err
 	}
@@ -663,7 +678,7 @@ func doArchiveOneTrip(siteID, dataType string, archiveBegin, archiveEnd, activeB
 				log.Println("found inconsistent archive: beyond range", exists.TableName, archiveBegin, archiveEnd)
 			}
 
-			doRows, err := doArchive(activeTable, fmt.Sprintf("`%s`", exists.TableName), rotatingTable, archiveBegin, archiveEnd)
+			doRows, err := doArchive(siteID, activeTable, fmt.Sprintf("`%s`", exists.TableName), rotatingTable, archiveBegin, archiveEnd)
 			if err != nil {
 				return 0, err
 			}
@@ -686,7 +701,7 @@ func doArchiveOneTrip(siteID, dataType string, archiveBegin, archiveEnd, activeB
 						%s
 				`, fmt.Sprintf("`%s`", exists.TableName), fmt.Sprintf("`%s`", rename))
 
-				if _, err := datasource.GetConn().Exec(SQL); err != nil {
+				if _, err := datasource.GetSiteConn(siteID).Exec(SQL); err != nil {
 					log.Println("error rename archive table: ", exists.TableName, rename, activeBegin, activeEnd, err)
 					return 0, err
 				}
@@ -701,11 +716,11 @@ func doArchiveOneTrip(siteID, dataType string, archiveBegin, archiveEnd, activeB
 
 	if !found {
 		archiveTable := fmt.Sprintf("`%s_%s_%s`", TableName(siteID, dataType), formatArchiveDate(archiveBegin), formatArchiveDate(activeEnd))
-		if err := createArchiveTable(activeTable, archiveTable); err != nil {
+		if err := createArchiveTable(siteID, activeTable, archiveTable); err != nil {
 			return 0, err
 		}
 
-		doRows, err := doArchive(activeTable, archiveTable, rotatingTable, archiveBegin, archiveEnd)
+		doRows, err := doArchive(siteID, activeTable, archiveTable, rotatingTable, archiveBegin, archiveEnd)
 		if err != nil {
 			return 0, err
 		}
@@ -722,7 +737,7 @@ func doArchiveOneTrip(siteID, dataType string, archiveBegin, archiveEnd, activeB
 	return archiveRows, nil
 }
 
-func getTableColumns(txn *sql.Tx, table string) ([]string, error) {
+func getTableColumns(siteID string, txn *sql.Tx, table string) ([]string, error) {
 	SQL := fmt.Sprintf(`
 		SHOW COLUMNS FROM %s
 	`, table)
@@ -732,7 +747,7 @@ func getTableColumns(txn *sql.Tx, table string) ([]string, error) {
 	if txn != nil {
 		rows, err = txn.Query(SQL)
 	} else {
-		rows, err = datasource.GetConn().Query(SQL)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL)
 	}
 
 	if err != nil {
@@ -755,11 +770,11 @@ func getTableColumns(txn *sql.Tx, table string) ([]string, error) {
 	return result, nil
 }
 
-func doArchive(activeTable, archiveTable, rotatingTable string, archiveBegin, archiveEnd time.Time) (done int64, err error) {
+func doArchive(siteID, activeTable, archiveTable, rotatingTable string, archiveBegin, archiveEnd time.Time) (done int64, err error) {
 
-	err = datasource.Txn(func(txn *sql.Tx) {
+	err = datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
-		columns, err := getTableColumns(txn, archiveTable)
+		columns, err := getTableColumns(siteID, txn, archiveTable)
 		if err != nil {
 			panic(err)
 		}
@@ -943,7 +958,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 
 	log.Println("activate archive: ", siteID, tableName, batchSize)
 
-	if rows, err := datasource.GetConn().Query("SHOW TABLES LIKE '" + tableName + "'"); err != nil {
+	if rows, err := datasource.GetSiteConn(siteID).Query("SHOW TABLES LIKE '" + tableName + "'"); err != nil {
 		log.Println("error check rotating table: ", err)
 		return err
 	} else {
@@ -957,7 +972,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 	activeTable := TableName(siteID, dataType)
 	rotatingTable := activeTable + "_" + rotating
 
-	if rows, err := datasource.GetConn().Query("SHOW TABLES LIKE '" + rotatingTable + "'"); err != nil {
+	if rows, err := datasource.GetSiteConn(siteID).Query("SHOW TABLES LIKE '" + rotatingTable + "'"); err != nil {
 		log.Println("error check rotating table: ", err)
 		return err
 	} else {
@@ -969,7 +984,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 	}
 
 	activatingTable := tableName + "_" + activating
-	if rows, err := datasource.GetConn().Query("SHOW TABLES LIKE '" + activatingTable + "'"); err != nil {
+	if rows, err := datasource.GetSiteConn(siteID).Query("SHOW TABLES LIKE '" + activatingTable + "'"); err != nil {
 		log.Println("error check activating table: ", err)
 		return err
 	} else {
@@ -981,7 +996,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 	}
 
 	activatedTable := tableName + "_" + active
-	if rows, err := datasource.GetConn().Query("SHOW TABLES LIKE '" + activatedTable + "'"); err != nil {
+	if rows, err := datasource.GetSiteConn(siteID).Query("SHOW TABLES LIKE '" + activatedTable + "'"); err != nil {
 		log.Println("error check activating table: ", err)
 		return err
 	} else {
@@ -999,7 +1014,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 			%s
 	`, fmt.Sprintf("`%s`", tableName), fmt.Sprintf("`%s`", activatingTable))
 
-	if _, err := datasource.GetConn().Exec(SQL); err != nil {
+	if _, err := datasource.GetSiteConn(siteID).Exec(SQL); err != nil {
 		log.Println("error rename activating table: ", tableName, activatingTable, err)
 		return err
 	}
@@ -1008,14 +1023,14 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 
 	go func() (e error) {
 
-		sourceColumns, err := getTableColumns(nil, activatingTable)
+		sourceColumns, err := getTableColumns(siteID, nil, activatingTable)
 		if err != nil {
 			return err
 		}
 
 		tmpTable := activatingTable + "target"
 
-		_, err = datasource.GetConn().Exec(fmt.Sprintf(`
+		_, err = datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 			CREATE TABLE %s LIKE %s
 		`, tmpTable, activeTable))
 
@@ -1024,7 +1039,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 			return err
 		}
 
-		targetColumns, err := getTableColumns(nil, tmpTable)
+		targetColumns, err := getTableColumns(siteID, nil, tmpTable)
 		if err != nil {
 			return err
 		}
@@ -1054,7 +1069,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 			}
 		}
 
-		rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+		rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 			SELECT COUNT(1) FROM %s
 		`, activatingTable))
 
@@ -1083,11 +1098,11 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 						%s
 				`, fmt.Sprintf("`%s`", activatingTable), fmt.Sprintf("`%s`", tableName))
 
-				if _, err := datasource.GetConn().Exec(SQL); err != nil {
+				if _, err := datasource.GetSiteConn(siteID).Exec(SQL); err != nil {
 					log.Println("fatal error rename activating table after failure: ", activatingTable, tableName, err)
 				}
 
-				if _, err := datasource.GetConn().Exec(fmt.Sprintf("DROP TABLE %s", tmpTable)); err != nil {
+				if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf("DROP TABLE %s", tmpTable)); err != nil {
 					log.Println("error drop tmp table after failure: ", tmpTable, err)
 				}
 			}
@@ -1105,7 +1120,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 				LIMIT ?, ?
 			`, tmpTable, strings.Join(columns, ","), activatingTable)
 
-			ret, err := datasource.GetConn().Exec(SQL, count*batchSize, batchSize)
+			ret, err := datasource.GetSiteConn(siteID).Exec(SQL, count*batchSize, batchSize)
 			if err != nil {
 				log.Println("error activating: ", activatingTable, SQL, err)
 				e = err
@@ -1132,7 +1147,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 				%s
 		`, fmt.Sprintf("`%s`", tmpTable), fmt.Sprintf("`%s`", activatedTable))
 
-		if _, err := datasource.GetConn().Exec(SQL); err != nil {
+		if _, err := datasource.GetSiteConn(siteID).Exec(SQL); err != nil {
 			log.Println("fatal error rename activated table: ", activatedTable, tableName, err)
 			e = err
 			return
@@ -1145,7 +1160,7 @@ func activateArchive(siteID, dataType, tableName string, batchSize int) error {
 				%s
 		`, fmt.Sprintf("`%s`", activatingTable), fmt.Sprintf("`%s_%s`", tableName, activated))
 
-		if _, err := datasource.GetConn().Exec(SQL); err != nil {
+		if _, err := datasource.GetSiteConn(siteID).Exec(SQL); err != nil {
 			log.Println("fatal error rename activating table: ", activatingTable, tableName, err)
 			e = err
 			return
@@ -1173,7 +1188,7 @@ func rollbackArchive(siteID, dataType string) error {
 
 			activatedTable := archive.TableName
 
-			if _, err := datasource.GetConn().Exec(fmt.Sprintf("DROP TABLE %s", activatedTable)); err != nil {
+			if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf("DROP TABLE %s", activatedTable)); err != nil {
 				log.Println("error drop table: ", activatedTable, err)
 				return err
 			}
@@ -1186,7 +1201,7 @@ func rollbackArchive(siteID, dataType string) error {
 				TO
 					%s
 			`, fmt.Sprintf("`%s_%s`", archiveName, activated), fmt.Sprintf("`%s`", archiveName))
-			if _, err := datasource.GetConn().Exec(SQL); err != nil {
+			if _, err := datasource.GetSiteConn(siteID).Exec(SQL); err != nil {
 				log.Println("fatal error rename activated table: ", activatedTable, archive.TableName, err)
 				return err
 			}
@@ -1200,6 +1215,14 @@ func rollbackArchive(siteID, dataType string) error {
 
 func recoverInterruptedProgress() {
 
+	//主库及各分库均需检查
+	for _, conn := range datasource.GetShardConns() {
+		recoverShardInterruptedProgress(conn)
+	}
+}
+
+func recoverShardInterruptedProgress(conn *sql.DB) {
+
 	for _, dt := range []string{REAL_TIME, MINUTELY, HOURLY, DAILY} {
 
 		for _, suffix := range []string{activating, rotating} {
@@ -1208,7 +1231,7 @@ func recoverInterruptedProgress() {
 
 			log.Println("detecting interrupted table: ", SQL)
 
-			rows, err := datasource.GetConn().Query(SQL)
+			rows, err := conn.Query(SQL)
 			if err != nil {
 				log.Println("error show in progress tables: ", dt, suffix, err)
 				return
@@ -1228,7 +1251,7 @@ func recoverInterruptedProgress() {
 			}
 
 			for _, t := range tables {
-				if err := errorRecoverTable(suffix, t); err != nil {
+				if err := errorRecoverTable(conn, suffix, t); err != nil {
 					log.Println("error error recover: ", t, err)
 				}
 			}
@@ -1237,18 +1260,18 @@ func recoverInterruptedProgress() {
 	}
 }
 
-func errorRecoverTable(suffix, table string) error {
+func errorRecoverTable(conn *sql.DB, suffix, table string) error {
 
 	log.Println("detect interrupted table: ", table)
 
 	switch suffix {
 	case activating:
-		if _, err := datasource.GetConn().Exec(fmt.Sprintf("RENAME TABLE %s TO %s", table, strings.ReplaceAll(table, "_"+activating, ""))); err != nil {
+		if _, err := conn.Exec(fmt.Sprintf("RENAME TABLE %s TO %s", table, strings.ReplaceAll(table, "_"+activating, ""))); err != nil {
 			log.Println("error recover activating table: ", table, strings.ReplaceAll(table, "_"+activating, ""), err)
 			return err
 		}
 	case rotating:
-		if _, err := datasource.GetConn().Exec(fmt.Sprintf("DROP TABLE %s", table)); err != nil {
+		if _, err := conn.Exec(fmt.Sprintf("DROP TABLE %s", table)); err != nil {
 			log.Println("error recover rotating table: ", table, err)
 			return err
 		}
@@ -0,0 +1,978 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"obsessiontech/common/util"
+)
+
+const (
+	TSDB_INFLUXDB = "influxdb"
+	TSDB_TDENGINE = "tdengine"
+)
+
+var e_invalid_tsdb = errors.New("时序库设置不正确")
+var e_tsdb_not_configured = errors.New("时序库未设置")
+
+//时序库镜像 数据写入后以InfluxDB行协议异步写入时序库 TDengine通过其influxdb兼容接口写入
+//Query开启时按时间查询及聚合查询改由时序库提供
+type TSDB struct {
+	Enabled          bool          `json:"enabled"`
+	Dialect          string        `json:"dialect"`
+	URL              string        `json:"url"`
+	Database         string        `json:"database"`
+	Username         string        `json:"username,omitempty"`
+	Password         string        `json:"password,omitempty"`
+	Token            string        `json:"token,omitempty"`
+	Query            bool          `json:"query"`
+	BatchSize        int           `json:"batchSize,omitempty"`
+	FlushIntervalSec time.Duration `json:"flushIntervalSec,omitempty"`
+	TimeoutSec       time.Duration `json:"timeoutSec,omitempty"`
+}
+
+func (t *TSDB) validate() error {
+	switch t.Dialect {
+	case TSDB_INFLUXDB:
+	case TSDB_TDENGINE:
+	default:
+		return e_invalid_tsdb
+	}
+	if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return e_invalid_tsdb
+	}
+	if t.Database == "" {
+		return e_invalid_tsdb
+	}
+	return nil
+}
+
+func (t *TSDB) queryable() bool {
+	return t != nil && t.Enabled && t.Query
+}
+
+func (t *TSDB) batchSize() int {
+	if t.BatchSize <= 0 {
+		return 5000
+	}
+	return t.BatchSize
+}
+
+func (t *TSDB) flushInterval() time.Duration {
+	if t.FlushIntervalSec <= 0 {
+		return 5 * time.Second
+	}
+	return t.FlushIntervalSec * time.Second
+}
+
+func (t *TSDB) request(method, path string, query url.Values, body []byte) ([]byte, error) {
+	timeout := t.TimeoutSec * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	u := strings.TrimRight(t.URL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Token "+t.Token)
+	} else if t.Username != "" {
+		req.SetBasicAuth(t.Username, t.Password)
+	}
+
+	res, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("时序库请求失败[%d]: %s", res.StatusCode, string(content))
+	}
+
+	return content, nil
+}
+
+func (t *TSDB) write(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	path := "/write"
+	if t.Dialect == TSDB_TDENGINE {
+		path = "/influxdb/v1/write"
+	}
+
+	_, err := t.request(http.MethodPost, path, url.Values{"db": {t.Database}, "precision": {"s"}}, []byte(strings.Join(lines, "\n")))
+	return err
+}
+
+//与分表同名 不含站点前缀 站点以tag区分
+func tsdbMeasurement(dataType string) string {
+	switch dataType {
+	case REAL_TIME:
+		return "realtimedata"
+	case MINUTELY:
+		return "minutelydata"
+	case HOURLY:
+		return "hourlydata"
+	case DAILY:
+		return "dailydata"
+	}
+	return ""
+}
+
+var tsdbTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var tsdbStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+//field为空时写入全部字段 否则只写入更新的字段
+func tsdbLine(siteID, monitorField string, d IData, field ...string) string {
+	measurement := tsdbMeasurement(d.GetDataType())
+	if measurement == "" {
+		return ""
+	}
+
+	monitorID := d.GetMonitorID()
+	if monitorField == MONITOR_CODE_ID {
+		monitorID = d.GetMonitorCodeID()
+	}
+
+	includes := func(f string) bool {
+		if len(field) == 0 {
+			return true
+		}
+		for _, ff := range field {
+			if ff == f {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := make([]string, 0)
+	if rtd, ok := d.(IRealTime); ok && includes(RTD) {
+		fields = append(fields, fmt.Sprintf("%s=%s", RTD, strconv.FormatFloat(rtd.GetRtd(), 'f', -1, 64)))
+	}
+	if _, ok := d.(IInterval); ok {
+		for _, c := range IntervalColumn {
+			if includes(c) {
+				fields = append(fields, fmt.Sprintf("%s=%s", c, strconv.FormatFloat(tsdbValue(d, c), 'f', -1, 64)))
+			}
+		}
+	}
+	if includes(FLAG) {
+		fields = append(fields, fmt.Sprintf(`%s="%s"`, FLAG, tsdbStringEscaper.Replace(d.GetFlag())))
+	}
+	if includes(FLAG_BIT) {
+		fields = append(fields, fmt.Sprintf("%s=%di", FLAG_BIT, d.GetFlagBit()))
+	}
+	if review, ok := d.(IReview); ok && includes(REVIEWED) {
+		fields = append(fields, fmt.Sprintf("%s=%t", REVIEWED, review.GetReviewed()))
+	}
+
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s,site=%s,%s=%d,%s=%d %s %d", measurement, tsdbTagEscaper.Replace(siteID), STATION_ID, d.GetStationID(), monitorField, monitorID, strings.Join(fields, ","), time.Time(d.GetDataTime()).Unix())
+}
+
+func tsdbValue(d IData, field string) float64 {
+	switch field {
+	case RTD:
+		if rtd, ok := d.(IRealTime); ok {
+			return rtd.GetRtd()
+		}
+	case AVG:
+		if interval, ok := d.(IInterval); ok {
+			return interval.GetAvg()
+		}
+	case MIN:
+		if interval, ok := d.(IInterval); ok {
+			return interval.GetMin()
+		}
+	case MAX:
+		if interval, ok := d.(IInterval); ok {
+			return interval.GetMax()
+		}
+	case COU:
+		if interval, ok := d.(IInterval); ok {
+			return interval.GetCou()
+		}
+	}
+	return 0
+}
+
+//时序库删除范围 结束时间不含 起始时间为零时不限 排放点/监测物为0时不限 监测物按monitorField取用
+type tsdbDelete struct {
+	dataType         string
+	stationID        int
+	monitorID        int
+	monitorCodeID    int
+	excludeStationID []int
+	beginTime        time.Time
+	endTime          time.Time
+}
+
+//删除须在此前的待写数据写入后执行 否则删除的数据会被再次写入
+type tsdbBatch struct {
+	lines  []string
+	delete *tsdbDelete
+}
+
+//站点镜像写入队列 模块设置每分钟重新读取
+type tsdbMirror struct {
+	lock         sync.Mutex
+	config       *TSDB
+	monitorField string
+	loadTime     time.Time
+	queue        []*tsdbBatch
+	pending      int
+	timer        *time.Timer
+	//写入及删除按入队顺序执行
+	writeLock sync.Mutex
+}
+
+var tsdbMirrorLock sync.Mutex
+var tsdbMirrors = make(map[string]*tsdbMirror)
+
+func getTSDBMirror(siteID string) *tsdbMirror {
+	tsdbMirrorLock.Lock()
+	defer tsdbMirrorLock.Unlock()
+
+	mirror, exists := tsdbMirrors[siteID]
+	if !exists {
+		mirror = new(tsdbMirror)
+		tsdbMirrors[siteID] = mirror
+	}
+
+	return mirror
+}
+
+//调用时需持有mirror.lock
+func (mirror *tsdbMirror) load(siteID string) bool {
+	if time.Since(mirror.loadTime) > time.Minute {
+		mirror.loadTime = time.Now()
+		if m, err := GetModule(siteID); err != nil {
+			log.Println("error get module for tsdb mirror: ", siteID, err)
+		} else {
+			mirror.config = m.TSDB
+			mirror.monitorField = m.MonitorField
+		}
+	}
+
+	return mirror.config != nil && mirror.config.Enabled
+}
+
+//数据写入提交后调用
+func mirrorData(siteID string, d IData, field ...string) {
+	mirror := getTSDBMirror(siteID)
+
+	mirror.lock.Lock()
+	defer mirror.lock.Unlock()
+
+	if !mirror.load(siteID) {
+		return
+	}
+
+	line := tsdbLine(siteID, mirror.monitorField, d, field...)
+	if line == "" {
+		return
+	}
+
+	if len(mirror.queue) == 0 || mirror.queue[len(mirror.queue)-1].delete != nil {
+		mirror.queue = append(mirror.queue, new(tsdbBatch))
+	}
+	last := mirror.queue[len(mirror.queue)-1]
+	last.lines = append(last.lines, line)
+	mirror.pending++
+
+	if mirror.pending >= mirror.config.batchSize() {
+		if mirror.timer != nil {
+			mirror.timer.Stop()
+			mirror.timer = nil
+		}
+		go mirror.flush(siteID)
+	} else if mirror.timer == nil {
+		mirror.timer = time.AfterFunc(mirror.config.flushInterval(), func() { mirror.flush(siteID) })
+	}
+}
+
+//数据删除后调用 在此前入队的数据写入后执行
+func mirrorDelete(siteID string, del *tsdbDelete) {
+	mirror := getTSDBMirror(siteID)
+
+	mirror.lock.Lock()
+	defer mirror.lock.Unlock()
+
+	if !mirror.load(siteID) {
+		return
+	}
+
+	if len(mirror.queue) > 0 && mirror.queue[len(mirror.queue)-1].delete == nil {
+		mirror.queue[len(mirror.queue)-1].delete = del
+	} else {
+		mirror.queue = append(mirror.queue, &tsdbBatch{delete: del})
+	}
+
+	if mirror.timer != nil {
+		mirror.timer.Stop()
+		mirror.timer = nil
+	}
+	go mirror.flush(siteID)
+}
+
+//写入失败时保留待写数据下次重试 积压超过批量的20倍时丢弃最早的数据
+func (mirror *tsdbMirror) flush(siteID string) {
+	mirror.writeLock.Lock()
+	defer mirror.writeLock.Unlock()
+
+	mirror.lock.Lock()
+	queue := mirror.queue
+	config := mirror.config
+	monitorField := mirror.monitorField
+	mirror.queue = nil
+	mirror.pending = 0
+	mirror.timer = nil
+	mirror.lock.Unlock()
+
+	if len(queue) == 0 || config == nil {
+		return
+	}
+
+	for i, batch := range queue {
+		if err := config.write(batch.lines); err != nil {
+			log.Println("error write tsdb mirror: ", siteID, len(batch.lines), err)
+			mirror.retry(siteID, config, queue[i:])
+			return
+		}
+		batch.lines = nil
+
+		if batch.delete != nil {
+			if err := config.delete(siteID, monitorField, batch.delete); err != nil {
+				log.Println("error delete tsdb mirror: ", siteID, batch.delete.dataType, err)
+				mirror.retry(siteID, config, queue[i:])
+				return
+			}
+		}
+	}
+}
+
+func (mirror *tsdbMirror) retry(siteID string, config *TSDB, remaining []*tsdbBatch) {
+	mirror.lock.Lock()
+	defer mirror.lock.Unlock()
+
+	mirror.queue = append(remaining, mirror.queue...)
+
+	mirror.pending = 0
+	for _, batch := range mirror.queue {
+		mirror.pending += len(batch.lines)
+	}
+	if max := config.batchSize() * 20; mirror.pending > max {
+		log.Println("tsdb mirror backlog dropped: ", siteID, mirror.pending-max)
+		for _, batch := range mirror.queue {
+			if mirror.pending <= max {
+				break
+			}
+			drop := mirror.pending - max
+			if drop > len(batch.lines) {
+				drop = len(batch.lines)
+			}
+			batch.lines = batch.lines[drop:]
+			mirror.pending -= drop
+		}
+	}
+
+	if mirror.timer == nil {
+		mirror.timer = time.AfterFunc(config.flushInterval(), func() { mirror.flush(siteID) })
+	}
+}
+
+//将历史数据按天写入时序库 返回写入条数
+func BackfillTSDB(siteID, dataType string, stationID []int, beginTime, endTime time.Time) (int, error) {
+	m, err := GetModule(siteID)
+	if err != nil {
+		return 0, err
+	}
+	if m.TSDB == nil {
+		return 0, e_tsdb_not_configured
+	}
+	if tsdbMeasurement(dataType) == "" {
+		return 0, e_invalid_data_type
+	}
+
+	var extraColumn []string
+	if IsReviewable(dataType) {
+		extraColumn = append(extraColumn, REVIEWED)
+	}
+
+	count := 0
+	for begin := beginTime; begin.Before(endTime); begin = begin.AddDate(0, 0, 1) {
+		end := begin.AddDate(0, 0, 1).Add(-time.Second)
+		if end.After(endTime) {
+			end = endTime
+		}
+
+		list, err := GetData(siteID, dataType, stationID, nil, nil, nil, begin, end, nil, extraColumn...)
+		if err != nil {
+			return count, err
+		}
+
+		lines := make([]string, 0)
+		for _, d := range list {
+			if line := tsdbLine(siteID, m.MonitorField, d); line != "" {
+				lines = append(lines, line)
+			}
+			if len(lines) >= m.TSDB.batchSize() {
+				if err := m.TSDB.write(lines); err != nil {
+					return count, err
+				}
+				count += len(lines)
+				lines = make([]string, 0)
+			}
+		}
+		if err := m.TSDB.write(lines); err != nil {
+			return count, err
+		}
+		count += len(lines)
+
+		log.Printf("tsdb backfill [%s] %s %s: %d", siteID, dataType, util.FormatDate(begin), count)
+	}
+
+	return count, nil
+}
+
+func tsdbInstance(dataType string) IData {
+	switch dataType {
+	case REAL_TIME:
+		return new(RealTimeData)
+	case MINUTELY:
+		return new(MinutelyData)
+	case HOURLY:
+		return new(HourlyData)
+	case DAILY:
+		return new(DailyData)
+	}
+	return nil
+}
+
+func tsdbValueFields(dataType string) []string {
+	if dataType == REAL_TIME {
+		return []string{RTD}
+	}
+	return IntervalColumn
+}
+
+func (t *TSDB) quote(name string) string {
+	if t.Dialect == TSDB_TDENGINE {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
+
+func (t *TSDB) timeColumn() string {
+	if t.Dialect == TSDB_TDENGINE {
+		return "_ts"
+	}
+	return "time"
+}
+
+//InfluxQL中time不加引号
+func (t *TSDB) timeRef() string {
+	if t.Dialect == TSDB_TDENGINE {
+		return t.quote(t.timeColumn())
+	}
+	return t.timeColumn()
+}
+
+func (t *TSDB) timeLiteral(v time.Time) string {
+	if t.Dialect == TSDB_TDENGINE {
+		return tsdbLiteral(v.Format(time.RFC3339))
+	}
+	return strconv.FormatInt(v.UnixNano(), 10)
+}
+
+func tsdbLiteral(v string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), "'", `\'`) + "'"
+}
+
+func (t *TSDB) in(column string, values []int) string {
+	stmts := make([]string, 0)
+	for _, v := range values {
+		stmts = append(stmts, fmt.Sprintf("%s = %s", t.quote(column), tsdbLiteral(strconv.Itoa(v))))
+	}
+	return "(" + strings.Join(stmts, " OR ") + ")"
+}
+
+//站点/排放点/监测物过滤条件 monitorID按monitorField取监测物或监测物编码
+func (t *TSDB) where(siteID, monitorField string, stationID, monitorID []int) []string {
+	whereStmts := []string{fmt.Sprintf("%s = %s", t.quote("site"), tsdbLiteral(siteID))}
+
+	if len(stationID) > 0 {
+		whereStmts = append(whereStmts, t.in(STATION_ID, stationID))
+	}
+	if len(monitorID) > 0 {
+		whereStmts = append(whereStmts, t.in(monitorField, monitorID))
+	}
+
+	return whereStmts
+}
+
+func (t *TSDB) query(q string) ([]map[string]interface{}, error) {
+	if t.Dialect == TSDB_TDENGINE {
+		return t.queryTDengine(q)
+	}
+	return t.queryInfluxDB(http.MethodGet, q)
+}
+
+//从时序库读取数据 flag为空时不过滤
+func (t *TSDB) fetch(siteID, monitorField, dataType string, stationID, monitorID, monitorCodeID []int, flag []string, beginTime, endTime time.Time, withReviewed bool) ([]IData, error) {
+	measurement := tsdbMeasurement(dataType)
+	if measurement == "" {
+		return nil, e_invalid_data_type
+	}
+
+	fields := append([]string{}, tsdbValueFields(dataType)...)
+	fields = append(fields, FLAG, FLAG_BIT)
+	if withReviewed && IsReviewable(dataType) {
+		fields = append(fields, REVIEWED)
+	}
+
+	monitorFilter := monitorID
+	if monitorField == MONITOR_CODE_ID {
+		monitorFilter = monitorCodeID
+	}
+
+	timeColumn := t.timeColumn()
+
+	columns := []string{t.quote(STATION_ID), t.quote(monitorField)}
+	//InfluxQL的SELECT不含time列 结果默认返回time
+	if t.Dialect == TSDB_TDENGINE {
+		columns = append([]string{t.quote(timeColumn)}, columns...)
+	}
+	for _, f := range fields {
+		columns = append(columns, t.quote(f))
+	}
+
+	whereStmts := t.where(siteID, monitorField, stationID, monitorFilter)
+	if len(flag) > 0 {
+		stmts := make([]string, 0)
+		for _, f := range flag {
+			stmts = append(stmts, fmt.Sprintf("%s = %s", t.quote(FLAG), tsdbLiteral(f)))
+		}
+		whereStmts = append(whereStmts, "("+strings.Join(stmts, " OR ")+")")
+	}
+	whereStmts = append(whereStmts, fmt.Sprintf("%s >= %s AND %s <= %s", t.timeRef(), t.timeLiteral(beginTime), t.timeRef(), t.timeLiteral(endTime)))
+
+	rows, err := t.query(fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(columns, ","), t.quote(measurement), strings.Join(whereStmts, " AND ")))
+	if err != nil {
+		log.Println("error fetch tsdb: ", siteID, dataType, err)
+		return nil, err
+	}
+
+	result := make([]IData, 0)
+	for _, row := range rows {
+		d := tsdbInstance(dataType)
+
+		dataTime, err := tsdbTime(row[timeColumn])
+		if err != nil {
+			return nil, err
+		}
+		d.SetDataTime(util.Time(dataTime))
+
+		sid, _ := strconv.Atoi(fmt.Sprint(row[STATION_ID]))
+		d.SetStationID(sid)
+		mid, _ := strconv.Atoi(fmt.Sprint(row[monitorField]))
+		if monitorField == MONITOR_CODE_ID {
+			d.SetMonitorCodeID(mid)
+		} else {
+			d.SetMonitorID(mid)
+		}
+
+		for _, f := range fields {
+			v := row[f]
+			switch f {
+			case FLAG:
+				if s, ok := v.(string); ok {
+					d.SetFlag(s)
+				}
+			case FLAG_BIT:
+				if n, ok := v.(float64); ok {
+					d.SetFlagBit(int(n))
+				}
+			case REVIEWED:
+				if review, ok := d.(IReview); ok {
+					b, _ := v.(bool)
+					review.SetReviewed(b)
+				}
+			default:
+				n, _ := v.(float64)
+				switch f {
+				case RTD:
+					d.(IRealTime).SetRtd(n)
+				case AVG:
+					d.(IInterval).SetAvg(n)
+				case MIN:
+					d.(IInterval).SetMin(n)
+				case MAX:
+					d.(IInterval).SetMax(n)
+				case COU:
+					d.(IInterval).SetCou(n)
+				}
+			}
+		}
+
+		result = append(result, d)
+	}
+
+	return result, nil
+}
+
+//InfluxDB的DELETE仅支持tag及时间条件
+func (t *TSDB) delete(siteID, monitorField string, del *tsdbDelete) error {
+	measurement := tsdbMeasurement(del.dataType)
+	if measurement == "" {
+		return e_invalid_data_type
+	}
+
+	var stationID, monitorID []int
+	if del.stationID > 0 {
+		stationID = []int{del.stationID}
+	}
+	if monitorField == MONITOR_CODE_ID {
+		if del.monitorCodeID > 0 {
+			monitorID = []int{del.monitorCodeID}
+		}
+	} else if del.monitorID > 0 {
+		monitorID = []int{del.monitorID}
+	}
+
+	whereStmts := t.where(siteID, monitorField, stationID, monitorID)
+	for _, id := range del.excludeStationID {
+		whereStmts = append(whereStmts, fmt.Sprintf("%s != %s", t.quote(STATION_ID), tsdbLiteral(strconv.Itoa(id))))
+	}
+
+	timeColumn := t.timeRef()
+	if !del.beginTime.IsZero() {
+		whereStmts = append(whereStmts, fmt.Sprintf("%s >= %s", timeColumn, t.timeLiteral(del.beginTime)))
+	}
+	whereStmts = append(whereStmts, fmt.Sprintf("%s < %s", timeColumn, t.timeLiteral(del.endTime)))
+
+	q := fmt.Sprintf("DELETE FROM %s WHERE %s", t.quote(measurement), strings.Join(whereStmts, " AND "))
+
+	var err error
+	if t.Dialect == TSDB_TDENGINE {
+		_, err = t.queryTDengine(q)
+	} else {
+		_, err = t.queryInfluxDB(http.MethodPost, q)
+	}
+	return err
+}
+
+//清理范围为expireTime之前去除保留锁定的时段 仅锁定部分排放点的时段排除这些排放点
+func tsdbPurgeDeletes(dataType string, expireTime time.Time, holds []*RetentionHold) []*tsdbDelete {
+	bounds := []time.Time{expireTime}
+	for _, h := range holds {
+		for _, b := range []time.Time{time.Time(h.BeginTime), time.Time(h.EndTime).Add(time.Second)} {
+			if b.Before(expireTime) {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+
+	result := make([]*tsdbDelete, 0)
+
+	var begin time.Time
+	for _, end := range bounds {
+		if !begin.IsZero() && !begin.Before(end) {
+			continue
+		}
+
+		held := false
+		exclude := make([]int, 0)
+		for _, h := range holds {
+			if begin.IsZero() || time.Time(h.BeginTime).After(begin) || time.Time(h.EndTime).Add(time.Second).Before(end) {
+				continue
+			}
+			if h.StationID > 0 {
+				exclude = append(exclude, h.StationID)
+			} else {
+				held = true
+			}
+		}
+
+		if !held {
+			result = append(result, &tsdbDelete{dataType: dataType, excludeStationID: exclude, beginTime: begin, endTime: end})
+		}
+		begin = end
+	}
+
+	return result
+}
+
+//分页时段长度 时段内最多一个数据时间
+func tsdbSlotStep(dataType string) time.Duration {
+	switch dataType {
+	case REAL_TIME:
+		return time.Second
+	case MINUTELY:
+		return time.Minute
+	}
+	return getStep(dataType)
+}
+
+//按时段统计有数据的时段 返回页内时段起始时间及总时段数 时段自beginTime起划分
+func (t *TSDB) fetchSlots(siteID, monitorField, dataType string, stationID, monitorID []int, beginTime, endTime time.Time, order string, pageNo, pageSize int) ([]time.Time, int, error) {
+	measurement := tsdbMeasurement(dataType)
+	if measurement == "" {
+		return nil, 0, e_invalid_data_type
+	}
+
+	step := int64(tsdbSlotStep(dataType) / time.Second)
+	offset := beginTime.Unix() % step
+	if offset < 0 {
+		offset += step
+	}
+
+	timeColumn := t.timeRef()
+
+	whereStmts := t.where(siteID, monitorField, stationID, monitorID)
+	whereStmts = append(whereStmts, fmt.Sprintf("%s >= %s AND %s <= %s", timeColumn, t.timeLiteral(beginTime), timeColumn, t.timeLiteral(endTime)))
+
+	var SQL, slotColumn string
+	if t.Dialect == TSDB_TDENGINE {
+		slotColumn = "_wstart"
+		SQL = fmt.Sprintf("SELECT _wstart, COUNT(%s) AS %s FROM %s WHERE %s INTERVAL(%ds,%ds)", t.quote(FLAG_BIT), t.quote("count"), t.quote(measurement), strings.Join(whereStmts, " AND "), step, offset)
+	} else {
+		slotColumn = "time"
+		SQL = fmt.Sprintf("SELECT COUNT(%s) AS %s FROM %s WHERE %s GROUP BY time(%ds,%ds) fill(none)", t.quote(FLAG_BIT), t.quote("count"), t.quote(measurement), strings.Join(whereStmts, " AND "), step, offset)
+	}
+
+	rows, err := t.query(fmt.Sprintf("SELECT COUNT(%s) AS %s FROM (%s)", t.quote("count"), t.quote("total"), SQL))
+	if err != nil {
+		log.Println("error fetch tsdb slot count: ", siteID, dataType, err)
+		return nil, 0, err
+	}
+	total := 0
+	if len(rows) > 0 {
+		if n, ok := rows[0]["total"].(float64); ok {
+			total = int(n)
+		}
+	}
+
+	if (pageNo-1)*pageSize >= total {
+		return nil, total, nil
+	}
+
+	rows, err = t.query(fmt.Sprintf("%s ORDER BY %s %s LIMIT %d OFFSET %d", SQL, slotColumn, order, pageSize, (pageNo-1)*pageSize))
+	if err != nil {
+		log.Println("error fetch tsdb slots: ", siteID, dataType, err)
+		return nil, 0, err
+	}
+
+	result := make([]time.Time, 0)
+	for _, row := range rows {
+		slot, err := tsdbTime(row[slotColumn])
+		if err != nil {
+			return nil, 0, err
+		}
+		result = append(result, slot)
+	}
+
+	return result, total, nil
+}
+
+func tsdbTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case float64:
+		return time.Unix(int64(t), 0), nil
+	case string:
+		if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return parsed.Local(), nil
+		}
+		return time.ParseInLocation("2006-01-02 15:04:05.000", t, time.Local)
+	}
+	return time.Time{}, fmt.Errorf("时序库时间不正确[%v]", v)
+}
+
+//DELETE等写操作需以POST请求
+func (t *TSDB) queryInfluxDB(method, q string) ([]map[string]interface{}, error) {
+	content, err := t.request(method, "/query", url.Values{"db": {t.Database}, "epoch": {"s"}, "q": {q}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Results []struct {
+			Error  string `json:"error"`
+			Series []struct {
+				Columns []string        `json:"columns"`
+				Values  [][]interface{} `json:"values"`
+			} `json:"series"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(content, &res); err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, 0)
+	for _, r := range res.Results {
+		if r.Error != "" {
+			return nil, errors.New(r.Error)
+		}
+		for _, s := range r.Series {
+			for _, values := range s.Values {
+				row := make(map[string]interface{})
+				for i, c := range s.Columns {
+					if i < len(values) {
+						row[c] = values[i]
+					}
+				}
+				result = append(result, row)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (t *TSDB) queryTDengine(SQL string) ([]map[string]interface{}, error) {
+	content, err := t.request(http.MethodPost, "/rest/sql/"+url.PathEscape(t.Database), nil, []byte(SQL))
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Code       int             `json:"code"`
+		Desc       string          `json:"desc"`
+		ColumnMeta [][]interface{} `json:"column_meta"`
+		Data       [][]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(content, &res); err != nil {
+		return nil, err
+	}
+	if res.Code != 0 {
+		return nil, errors.New(res.Desc)
+	}
+
+	result := make([]map[string]interface{}, 0)
+	for _, values := range res.Data {
+		row := make(map[string]interface{})
+		for i, meta := range res.ColumnMeta {
+			if i < len(values) && len(meta) > 0 {
+				row[fmt.Sprint(meta[0])] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+
+	return result, nil
+}
+
+//与getDataTimes一致 按数据时间分页后返回页内数据 分页在时序库中完成 仅读取页内时段的数据
+func getDataByTimeTSDB(siteID string, m *DataModule, dataType string, stationID []int, criterias Criterias, beginTime, endTime time.Time, withReviewed bool, order string, pageNo, pageSize int, monitorCodeID []int, monitorID []int) ([]*TimeData, int, error) {
+	result := make([]*TimeData, 0)
+
+	fetchBegin, fetchEnd := beginTime, endTime
+	total := 0
+
+	if pageSize != -1 {
+		if pageNo <= 0 {
+			pageNo = 1
+		}
+		if pageSize <= 0 {
+			pageSize = 20
+		}
+
+		monitorFilter := monitorID
+		if m.MonitorField == MONITOR_CODE_ID {
+			monitorFilter = monitorCodeID
+		}
+
+		slots, count, err := m.TSDB.fetchSlots(siteID, m.MonitorField, dataType, stationID, monitorFilter, beginTime, endTime, order, pageNo, pageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		total = count
+		if len(slots) == 0 {
+			return result, total, nil
+		}
+
+		fetchBegin, fetchEnd = slots[0], slots[0]
+		for _, s := range slots {
+			if s.Before(fetchBegin) {
+				fetchBegin = s
+			}
+			if s.After(fetchEnd) {
+				fetchEnd = s
+			}
+		}
+		fetchEnd = fetchEnd.Add(tsdbSlotStep(dataType) - time.Second)
+		if fetchBegin.Before(beginTime) {
+			fetchBegin = beginTime
+		}
+		if fetchEnd.After(endTime) {
+			fetchEnd = endTime
+		}
+	}
+
+	list, err := m.TSDB.fetch(siteID, m.MonitorField, dataType, stationID, monitorID, monitorCodeID, nil, fetchBegin, fetchEnd, withReviewed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dataTimeMapping := make(map[time.Time]*TimeData)
+	for _, d := range list {
+		dataTime := time.Time(d.GetDataTime())
+		if _, exists := dataTimeMapping[dataTime]; !exists {
+			dataTimeMapping[dataTime] = &TimeData{DataTime: d.GetDataTime(), Data: make(map[int][]IData)}
+		}
+	}
+
+	if pageSize == -1 {
+		total = len(dataTimeMapping)
+	}
+
+	if len(criterias) > 0 {
+		list = criterias.FilterData(list, true)
+	}
+
+	for _, d := range list {
+		timeData := dataTimeMapping[time.Time(d.GetDataTime())]
+		timeData.Data[d.GetStationID()] = append(timeData.Data[d.GetStationID()], d)
+	}
+
+	for _, d := range dataTimeMapping {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if order == "ASC" {
+			return time.Time(result[i].DataTime).Before(time.Time(result[j].DataTime))
+		}
+		return time.Time(result[j].DataTime).Before(time.Time(result[i].DataTime))
+	})
+
+	return result, total, nil
+}
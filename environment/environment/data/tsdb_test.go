@@ -0,0 +1,148 @@
+package data
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"obsessiontech/common/util"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTSDBPurgeDeletes(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.Local) }
+	hold := func(stationID, begin, end int) *RetentionHold {
+		return &RetentionHold{StationID: stationID, BeginTime: util.Time(day(begin)), EndTime: util.Time(day(end).Add(-time.Second))}
+	}
+
+	type expect struct {
+		begin   time.Time
+		end     time.Time
+		exclude []int
+	}
+
+	cases := []struct {
+		name   string
+		holds  []*RetentionHold
+		expect []expect
+	}{
+		{"no hold", nil, []expect{{time.Time{}, day(20), []int{}}}},
+		{"site hold", []*RetentionHold{hold(0, 5, 10)}, []expect{
+			{time.Time{}, day(5), []int{}},
+			{day(10), day(20), []int{}},
+		}},
+		{"station hold", []*RetentionHold{hold(3, 5, 10)}, []expect{
+			{time.Time{}, day(5), []int{}},
+			{day(5), day(10), []int{3}},
+			{day(10), day(20), []int{}},
+		}},
+		{"hold after expire", []*RetentionHold{hold(0, 18, 25)}, []expect{
+			{time.Time{}, day(18), []int{}},
+		}},
+		{"overlapping holds", []*RetentionHold{hold(3, 5, 10), hold(0, 8, 12), hold(4, 6, 9)}, []expect{
+			{time.Time{}, day(5), []int{}},
+			{day(5), day(6), []int{3}},
+			{day(6), day(8), []int{3, 4}},
+			{day(12), day(20), []int{}},
+		}},
+	}
+
+	for _, c := range cases {
+		result := tsdbPurgeDeletes(HOURLY, day(20), c.holds)
+		if len(result) != len(c.expect) {
+			t.Errorf("%s: %d deletes, expect %d", c.name, len(result), len(c.expect))
+			continue
+		}
+		for i, del := range result {
+			e := c.expect[i]
+			if del.dataType != HOURLY || !del.beginTime.Equal(e.begin) || !del.endTime.Equal(e.end) || !reflect.DeepEqual(del.excludeStationID, e.exclude) {
+				t.Errorf("%s: delete %d %v - %v exclude %v, expect %v - %v exclude %v", c.name, i, del.beginTime, del.endTime, del.excludeStationID, e.begin, e.end, e.exclude)
+			}
+		}
+	}
+}
+
+func TestTSDBDelete(t *testing.T) {
+	var method, q string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		if r.URL.Query().Get("q") != "" {
+			q = r.URL.Query().Get("q")
+			w.Write([]byte(`{"results":[{}]}`))
+		} else {
+			body, _ := ioutil.ReadAll(r.Body)
+			q = string(body)
+			w.Write([]byte(`{"code":0}`))
+		}
+	}))
+	defer server.Close()
+
+	begin := time.Unix(1704038400, 0)
+	del := &tsdbDelete{dataType: HOURLY, stationID: 1, monitorID: 2, monitorCodeID: 5, beginTime: begin, endTime: begin.Add(time.Second)}
+
+	influx := &TSDB{Dialect: TSDB_INFLUXDB, URL: server.URL, Database: "db"}
+	if err := influx.delete("s1", MONITOR_ID, del); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPost || q != `DELETE FROM "hourlydata" WHERE "site" = 's1' AND ("station_id" = '1') AND ("monitor_id" = '2') AND time >= 1704038400000000000 AND time < 1704038401000000000` {
+		t.Errorf("influxdb delete %s %s", method, q)
+	}
+
+	tdengine := &TSDB{Dialect: TSDB_TDENGINE, URL: server.URL, Database: "db"}
+	purge := &tsdbDelete{dataType: HOURLY, excludeStationID: []int{3}, endTime: begin}
+	if err := tdengine.delete("s1", MONITOR_CODE_ID, purge); err != nil {
+		t.Fatal(err)
+	}
+	if q != "DELETE FROM `hourlydata` WHERE `site` = 's1' AND `station_id` != '3' AND `_ts` < '"+begin.Format(time.RFC3339)+"'" {
+		t.Errorf("tdengine delete %s", q)
+	}
+
+	if err := tdengine.delete("s1", MONITOR_CODE_ID, del); err != nil {
+		t.Fatal(err)
+	}
+	if q != "DELETE FROM `hourlydata` WHERE `site` = 's1' AND (`station_id` = '1') AND (`monitor_code_id` = '5') AND `_ts` >= '"+begin.Format(time.RFC3339)+"' AND `_ts` < '"+begin.Add(time.Second).Format(time.RFC3339)+"'" {
+		t.Errorf("tdengine delete by monitor code %s", q)
+	}
+}
+
+func TestTSDBFetchSlots(t *testing.T) {
+	queries := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		queries = append(queries, q)
+		if len(queries) == 1 {
+			w.Write([]byte(`{"results":[{"series":[{"columns":["time","total"],"values":[[0,45]]}]}]}`))
+		} else {
+			w.Write([]byte(`{"results":[{"series":[{"columns":["time","count"],"values":[[1704074400,2],[1704070800,2]]}]}]}`))
+		}
+	}))
+	defer server.Close()
+
+	begin := time.Unix(1704038400, 0)
+	end := begin.Add(48*time.Hour - time.Second)
+
+	influx := &TSDB{Dialect: TSDB_INFLUXDB, URL: server.URL, Database: "db"}
+	slots, total, err := influx.fetchSlots("s1", MONITOR_ID, HOURLY, []int{1}, nil, begin, end, "DESC", 3, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slotSQL := `SELECT COUNT("flag_bit") AS "count" FROM "hourlydata" WHERE "site" = 's1' AND ("station_id" = '1') AND time >= 1704038400000000000 AND time <= 1704211199000000000 GROUP BY time(3600s,0s) fill(none)`
+	expectQueries := []string{
+		`SELECT COUNT("count") AS "total" FROM (` + slotSQL + `)`,
+		slotSQL + ` ORDER BY time DESC LIMIT 20 OFFSET 40`,
+	}
+	if !reflect.DeepEqual(queries, expectQueries) {
+		t.Errorf("queries %v, expect %v", queries, expectQueries)
+	}
+	if total != 45 || len(slots) != 2 || !slots[0].Equal(time.Unix(1704074400, 0)) || !slots[1].Equal(time.Unix(1704070800, 0)) {
+		t.Errorf("slots %v total %d", slots, total)
+	}
+
+	//超出总数不再查询页内时段
+	queries = queries[:0]
+	if slots, total, err := influx.fetchSlots("s1", MONITOR_ID, HOURLY, []int{1}, nil, begin, end, "DESC", 4, 20); err != nil || len(slots) != 0 || total != 45 || len(queries) != 1 {
+		t.Errorf("page out of range: %v %d %v %d", slots, total, err, len(queries))
+	}
+}
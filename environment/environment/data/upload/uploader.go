@@ -44,7 +44,7 @@ func (u *DataUploader) Add(siteID string) error {
 		return err
 	}
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name, uploader)
 		VALUES
@@ -71,7 +71,7 @@ func (u *DataUploader) Update(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -87,7 +87,7 @@ func (u *DataUploader) Update(siteID string) error {
 
 func (u *DataUploader) Delete(siteID string) error {
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -119,7 +119,7 @@ func GetDataUploader(siteID, q string) ([]*DataUploader, error) {
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
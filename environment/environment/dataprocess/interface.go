@@ -29,6 +29,29 @@ type Uploader struct {
 	UploadedCache   map[string]map[int]map[int]map[time.Time]data.IData
 	UnUploadedCache map[string]map[int]map[int]map[time.Time]data.IData
 	UploadCacheLock sync.RWMutex
+	//模拟处理 处理器不应修改事务外的共享状态
+	DryRun bool
+
+	dryRunLock  sync.Mutex
+	dryRunState map[string]interface{}
+}
+
+func (u *Uploader) IsDryRun() bool { return u != nil && u.DryRun }
+
+//模拟处理时有状态处理器的状态 仅在本次模拟内延续 不影响实际处理
+func (u *Uploader) GetDryRunState(key string, init func() interface{}) interface{} {
+	u.dryRunLock.Lock()
+	defer u.dryRunLock.Unlock()
+
+	if u.dryRunState == nil {
+		u.dryRunState = make(map[string]interface{})
+	}
+	state, exists := u.dryRunState[key]
+	if !exists {
+		state = init()
+		u.dryRunState[key] = state
+	}
+	return state
 }
 
 func (u *Uploader) GetUploadCache() (map[string]map[int]map[int]map[time.Time]data.IData, map[string]map[int]map[int]map[time.Time]data.IData, *sync.RWMutex) {
@@ -115,6 +138,11 @@ type IDataProcessor interface {
 	ProcessData(siteID string, txn *sql.Tx, d data.IData, uploader *Uploader, upload IDataUpload) (interrupt bool, err error)
 }
 
+//处理器可选实现 保存配置时校验 避免上传数据时才发现配置错误
+type IDataProcessorValidator interface {
+	Validate(siteID string) error
+}
+
 type BaseDataProcessor struct {
 	Rule string `json:"rule"`
 }
@@ -154,18 +182,28 @@ func (processors *DataProcessors) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (processors DataProcessors) Validate(siteID string) error {
+	for _, p := range processors {
+		if v, ok := p.(IDataProcessorValidator); ok {
+			if err := v.Validate(siteID); err != nil {
+				return fmt.Errorf("处理器[%s]配置错误:%s", p.GetRule(), err.Error())
+			}
+		}
+	}
+	return nil
+}
+
 func (processors *DataProcessors) Process(siteID string, uploader *Uploader, upload IDataUpload, datas ...data.IData) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		for _, d := range datas {
-			for _, p := range *processors {
-				interrupt, err := p.ProcessData(siteID, txn, d, uploader, upload)
-				if err != nil {
-					panic(err)
-				}
-				if interrupt {
-					break
-				}
+			//已审核数据锁定 不再处理
+			if data.IsReviewed(d) {
+				continue
+			}
+
+			if err := processors.ProcessWithTxn(siteID, txn, uploader, upload, d); err != nil {
+				panic(err)
 			}
 
 			if err := data.UpdateWithTxn(siteID, txn, d); err != nil {
@@ -174,3 +212,17 @@ func (processors *DataProcessors) Process(siteID string, uploader *Uploader, upl
 		}
 	})
 }
+
+//在调用方事务内依次执行处理器 不保存数据
+func (processors *DataProcessors) ProcessWithTxn(siteID string, txn *sql.Tx, uploader *Uploader, upload IDataUpload, d data.IData) error {
+	for _, p := range *processors {
+		interrupt, err := p.ProcessData(siteID, txn, d, uploader, upload)
+		if err != nil {
+			return err
+		}
+		if interrupt {
+			break
+		}
+	}
+	return nil
+}
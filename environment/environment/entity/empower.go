@@ -106,7 +106,7 @@ func AddEntityEmpower(siteID string, actionAuth authority.ActionAuthSet, entityI
 		return e_need_entity
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		if err := authority.AddEmpower(siteID, "entity", fmt.Sprintf("%d", entityID), txn, empower, empowerID, authType); err != nil {
 			panic(err)
@@ -137,7 +137,7 @@ func DeleteEntityEmpower(siteID string, actionAuth authority.ActionAuthSet, enti
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := authority.DeleteEmpower(siteID, "entity", fmt.Sprintf("%d", entityID), txn, empower, empowerID, authType...); err != nil {
 			panic(err)
 		}
@@ -64,7 +64,7 @@ func (s *Entity) Add(siteID string, actionAuth authority.ActionAuthSet) error {
 
 	ext, _ := json.Marshal(s.Ext)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name,address,longitude,latitude,geo_type,ext)
 		VALUES
@@ -97,7 +97,7 @@ func (s *Entity) Update(siteID string, actionAuth authority.ActionAuthSet) error
 
 	ext, _ := json.Marshal(s.Ext)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE 
 			%s
 		SET
@@ -131,7 +131,7 @@ func (s *Entity) Delete(siteID string, actionAuth authority.ActionAuthSet) error
 		return errors.New("点位不为空")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -221,7 +221,7 @@ func GetEntityList(siteID string, actionAuth authority.ActionAuthSet, authAction
 
 	SQL += "\nGROUP BY entity.id"
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get entity: ", err)
 		return nil, err
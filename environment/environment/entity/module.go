@@ -6,14 +6,17 @@ const (
 	ACTION_ENTITY_VIEW   = "view"
 	ACTION_ENTITY_EXPORT = "export"
 	ACTION_ENTITY_EDIT   = "edit"
+	ACTION_ENTITY_REVIEW = "review"
 
 	ACTION_ADMIN_VIEW   = "admin_view"
 	ACTION_ADMIN_EXPORT = "admin_export"
 	ACTION_ADMIN_EDIT   = "admin_edit"
+	ACTION_ADMIN_REVIEW = "admin_review"
 )
 
 var AdminActions = map[string]string{
 	ACTION_ENTITY_VIEW:   ACTION_ADMIN_VIEW,
 	ACTION_ENTITY_EXPORT: ACTION_ADMIN_EXPORT,
 	ACTION_ENTITY_EDIT:   ACTION_ADMIN_EDIT,
+	ACTION_ENTITY_REVIEW: ACTION_ADMIN_REVIEW,
 }
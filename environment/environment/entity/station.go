@@ -88,7 +88,7 @@ func (s *Station) validate(siteID string) error {
 
 		s.MN = strings.TrimSpace(s.MN)
 
-		rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+		rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 			SELECT
 				%s
 			FROM
@@ -140,7 +140,7 @@ func (s *Station) Add(siteID string, actionAuth authority.ActionAuthSet) error {
 		return err
 	}
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(entity_id,name,description,online_time,status,mn,protocol, redirect, ext)
 		VALUES
@@ -172,7 +172,7 @@ func (s *Station) Update(siteID string, actionAuth authority.ActionAuthSet) erro
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE 
 			%s
 		SET
@@ -195,7 +195,7 @@ func (s *Station) Delete(siteID string, actionAuth authority.ActionAuthSet) erro
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -307,7 +307,7 @@ func GetStations(siteID string, cids []int, entityID []int, status, protocol, q
 		SQL += "\nWHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get station: ", SQL, values, err)
 		return nil, err
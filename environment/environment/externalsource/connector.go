@@ -0,0 +1,522 @@
+package externalsource
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/event"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(MODULE_EXTERNALSOURCE, "realtimedata", connectorMigration)
+}
+
+const (
+	MODULE_EXTERNALSOURCE = "environment_externalsource"
+
+	EVENT_EXTERNALSOURCE_SYNC = "externalsource_sync"
+)
+
+const (
+	AUTH_NONE   = ""
+	AUTH_COOKIE = "cookie"
+	AUTH_TOKEN  = "token"
+	AUTH_BASIC  = "basic"
+
+	PAGINATION_NONE   = ""
+	PAGINATION_PAGE   = "page"
+	PAGINATION_OFFSET = "offset"
+
+	FORMAT_JSON = "json"
+	FORMAT_XML  = "xml"
+	FORMAT_CSV  = "csv"
+
+	MATCH_MN   = "mn"
+	MATCH_EXT  = "ext"
+	MATCH_NAME = "name"
+
+	STEP_WHOLE = ""
+	STEP_DAY   = "day"
+)
+
+var e_connector_not_exists = errors.New("外部数据源不存在")
+var e_need_connector_name = errors.New("需要外部数据源名称")
+var e_need_connector_url = errors.New("需要请求地址")
+var e_invalid_connector_auth = errors.New("认证方式不正确")
+var e_invalid_connector_pagination = errors.New("分页方式不正确")
+var e_invalid_connector_format = errors.New("数据格式不正确")
+var e_invalid_connector_data_type = errors.New("数据类型不正确")
+var e_need_station_match = errors.New("需要排放口匹配规则")
+var e_invalid_station_match = errors.New("排放口匹配规则不正确")
+var e_need_time_path = errors.New("需要数据时间字段")
+
+//通用外部数据拉取配置 按计划请求外部接口并经uploader写入数据
+type Connector struct {
+	ID         int                  `json:"ID"`
+	Name       string               `json:"name"`
+	Active     bool                 `json:"active"`
+	DataType   string               `json:"dataType"`
+	Request    *ConnectorRequest    `json:"request"`
+	Auth       *ConnectorAuth       `json:"auth,omitempty"`
+	Pagination *ConnectorPagination `json:"pagination,omitempty"`
+	Window     *ConnectorWindow     `json:"window,omitempty"`
+	Mapping    *ConnectorMapping    `json:"mapping"`
+	Schedule   *util.Interval       `json:"schedule,omitempty"`
+	CreateTime util.Time            `json:"createTime"`
+	UpdateTime util.Time            `json:"updateTime"`
+}
+
+//URL Form Body Headers均为模板 可用变量: .BeginTime .EndTime .Stations .Page .Offset .PageSize
+type ConnectorRequest struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Form        map[string]string `json:"form,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	TimeFormat  string            `json:"timeFormat,omitempty"`
+}
+
+type ConnectorAuth struct {
+	Type        string `json:"type"`
+	Cookie      string `json:"cookie,omitempty"`
+	Token       string `json:"token,omitempty"`
+	TokenHeader string `json:"tokenHeader,omitempty"`
+	TokenPrefix string `json:"tokenPrefix,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+}
+
+//StartPage为首页页码 返回记录数少于PageSize时视为最后一页
+type ConnectorPagination struct {
+	Type      string `json:"type"`
+	PageSize  int    `json:"pageSize"`
+	StartPage int    `json:"startPage,omitempty"`
+	MaxPages  int    `json:"maxPages,omitempty"`
+}
+
+//TraceBack为回溯的数据周期数 Step为day时逐日请求
+type ConnectorWindow struct {
+	TraceBack int    `json:"traceBack"`
+	Step      string `json:"step,omitempty"`
+}
+
+type ConnectorMapping struct {
+	Format string `json:"format"`
+	//返回内容为JSON字符串包裹的JSON
+	StringEncoded bool   `json:"stringEncoded,omitempty"`
+	RecordsPath   string `json:"recordsPath,omitempty"`
+	CSVComma      string `json:"csvComma,omitempty"`
+
+	Stations []*StationMatch `json:"stations"`
+	Times    []*TimeMatch    `json:"times"`
+
+	//字段路径至监测物因子代码 为空时记录中与因子代码相同的字段均作为数据
+	Values map[string]string `json:"values,omitempty"`
+	//每条记录仅含一个监测物时使用
+	CodePath  string `json:"codePath,omitempty"`
+	ValuePath string `json:"valuePath,omitempty"`
+
+	PrimaryPath      string `json:"primaryPath,omitempty"`
+	PrimarySeparator string `json:"primarySeparator,omitempty"`
+}
+
+//按顺序尝试 记录中首个存在的字段用于匹配
+type StationMatch struct {
+	Path   string `json:"path"`
+	By     string `json:"by"`
+	ExtKey string `json:"extKey,omitempty"`
+	//记录中的名称至外部标识 如省站名称至ext中的站点编号
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+//Format为Go时间格式 或datetime date unix unixms
+type TimeMatch struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	Split  string `json:"split,omitempty"`
+}
+
+func connectorTableName(siteID string) string {
+	return siteID + "_externalsourceconnector"
+}
+
+//外部数据源表及同步记录表
+var connectorMigration = &initialization.Migration{
+	Version:     1,
+	Description: "外部数据源及同步记录",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_externalsourceconnector (
+			id INT NOT NULL AUTO_INCREMENT,
+			name VARCHAR(64) NOT NULL,
+			active TINYINT NOT NULL DEFAULT 0,
+			data_type VARCHAR(32) NOT NULL,
+			config TEXT,
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			update_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`, `
+		CREATE TABLE IF NOT EXISTS {siteID}_externalsourcesynclog (
+			id INT NOT NULL AUTO_INCREMENT,
+			connector_id INT NOT NULL,
+			begin_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			requests INT NOT NULL DEFAULT 0,
+			records INT NOT NULL DEFAULT 0,
+			saved INT NOT NULL DEFAULT 0,
+			skipped INT NOT NULL DEFAULT 0,
+			error TEXT,
+			uid INT NOT NULL DEFAULT 0,
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finish_time DATETIME NULL,
+			PRIMARY KEY (id),
+			KEY (connector_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+const connectorColumns = "connector.id, connector.name, connector.active, connector.data_type, connector.config, connector.create_time, connector.update_time"
+
+//除基本字段外的配置整体以JSON保存
+type connectorConfig struct {
+	Request    *ConnectorRequest    `json:"request"`
+	Auth       *ConnectorAuth       `json:"auth,omitempty"`
+	Pagination *ConnectorPagination `json:"pagination,omitempty"`
+	Window     *ConnectorWindow     `json:"window,omitempty"`
+	Mapping    *ConnectorMapping    `json:"mapping"`
+	Schedule   *util.Interval       `json:"schedule,omitempty"`
+}
+
+func (c *Connector) scan(rows *sql.Rows) error {
+	var config string
+	var createTime, updateTime time.Time
+	if err := rows.Scan(&c.ID, &c.Name, &c.Active, &c.DataType, &config, &createTime, &updateTime); err != nil {
+		return err
+	}
+	c.CreateTime = util.Time(createTime)
+	c.UpdateTime = util.Time(updateTime)
+
+	var cfg connectorConfig
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		return err
+	}
+	c.Request = cfg.Request
+	c.Auth = cfg.Auth
+	c.Pagination = cfg.Pagination
+	c.Window = cfg.Window
+	c.Mapping = cfg.Mapping
+	c.Schedule = cfg.Schedule
+
+	return nil
+}
+
+func (c *Connector) config() string {
+	config, _ := json.Marshal(&connectorConfig{
+		Request:    c.Request,
+		Auth:       c.Auth,
+		Pagination: c.Pagination,
+		Window:     c.Window,
+		Mapping:    c.Mapping,
+		Schedule:   c.Schedule,
+	})
+	return string(config)
+}
+
+func (c *Connector) Validate() error {
+	if c.Name == "" {
+		return e_need_connector_name
+	}
+
+	switch c.DataType {
+	case data.REAL_TIME:
+	case data.MINUTELY:
+	case data.HOURLY:
+	case data.DAILY:
+	default:
+		return e_invalid_connector_data_type
+	}
+
+	if c.Request == nil || c.Request.URL == "" {
+		return e_need_connector_url
+	}
+	if c.Request.Method == "" {
+		c.Request.Method = "GET"
+	}
+	c.Request.Method = strings.ToUpper(c.Request.Method)
+	if c.Request.TimeFormat == "" {
+		c.Request.TimeFormat = "2006-01-02 15:04:05"
+	}
+
+	templates := []string{c.Request.URL, c.Request.Body}
+	for _, v := range c.Request.Headers {
+		templates = append(templates, v)
+	}
+	for _, v := range c.Request.Form {
+		templates = append(templates, v)
+	}
+	for _, t := range templates {
+		if _, err := parseRequestTemplate(t); err != nil {
+			return err
+		}
+	}
+
+	if c.Auth == nil {
+		c.Auth = new(ConnectorAuth)
+	}
+	switch c.Auth.Type {
+	case AUTH_NONE:
+	case AUTH_COOKIE:
+	case AUTH_TOKEN:
+		if c.Auth.TokenHeader == "" {
+			c.Auth.TokenHeader = "Authorization"
+		}
+	case AUTH_BASIC:
+	default:
+		return e_invalid_connector_auth
+	}
+
+	if c.Pagination == nil {
+		c.Pagination = new(ConnectorPagination)
+	}
+	switch c.Pagination.Type {
+	case PAGINATION_NONE:
+	case PAGINATION_PAGE:
+		fallthrough
+	case PAGINATION_OFFSET:
+		if c.Pagination.PageSize <= 0 {
+			return e_invalid_connector_pagination
+		}
+		if c.Pagination.MaxPages <= 0 {
+			c.Pagination.MaxPages = 100
+		}
+	default:
+		return e_invalid_connector_pagination
+	}
+
+	if c.Window == nil {
+		c.Window = new(ConnectorWindow)
+	}
+	switch c.Window.Step {
+	case STEP_WHOLE:
+	case STEP_DAY:
+	default:
+		return errors.New("请求步长不正确")
+	}
+
+	if c.Mapping == nil {
+		return e_invalid_connector_format
+	}
+	switch c.Mapping.Format {
+	case FORMAT_JSON:
+	case FORMAT_XML:
+	case FORMAT_CSV:
+	default:
+		return e_invalid_connector_format
+	}
+
+	if len(c.Mapping.Stations) == 0 {
+		return e_need_station_match
+	}
+	for _, s := range c.Mapping.Stations {
+		switch s.By {
+		case MATCH_MN:
+		case MATCH_NAME:
+		case MATCH_EXT:
+			if s.ExtKey == "" {
+				return e_invalid_station_match
+			}
+		default:
+			return e_invalid_station_match
+		}
+	}
+
+	if len(c.Mapping.Times) == 0 {
+		return e_need_time_path
+	}
+
+	if c.Schedule != nil {
+		if err := c.Schedule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseRequestTemplate(text string) (*template.Template, error) {
+	return template.New("request").Funcs(template.FuncMap{
+		"join": strings.Join,
+		"quote": func(list []string, q string) []string {
+			result := make([]string, len(list))
+			for i, s := range list {
+				result[i] = q + s + q
+			}
+			return result
+		},
+	}).Parse(text)
+}
+
+func (c *Connector) Add(siteID string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(name, active, data_type, config)
+		VALUES
+			(?, ?, ?, ?)
+	`, connectorTableName(siteID)), c.Name, c.Active, c.DataType, c.config())
+	if err != nil {
+		log.Println("error add external source connector: ", err)
+		return err
+	}
+
+	id, err := ret.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.ID = int(id)
+
+	return c.syncScheduler(siteID)
+}
+
+func (c *Connector) Update(siteID string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := GetConnector(siteID, c.ID); err != nil {
+		return err
+	}
+
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		UPDATE %s SET
+			name = ?, active = ?, data_type = ?, config = ?
+		WHERE
+			id = ?
+	`, connectorTableName(siteID)), c.Name, c.Active, c.DataType, c.config(), c.ID); err != nil {
+		log.Println("error update external source connector: ", err)
+		return err
+	}
+
+	return c.syncScheduler(siteID)
+}
+
+func (c *Connector) Delete(siteID string) error {
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		DELETE FROM %s WHERE id = ?
+	`, connectorTableName(siteID)), c.ID)
+	if err != nil {
+		log.Println("error delete external source connector: ", err)
+		return err
+	}
+
+	if affected, _ := ret.RowsAffected(); affected == 0 {
+		return e_connector_not_exists
+	}
+
+	c.Schedule = nil
+	return c.syncScheduler(siteID)
+}
+
+//同步计划由任务调度执行 一个数据源对应一个调度
+func (c *Connector) syncScheduler(siteID string) error {
+	schedulers, _, err := event.GetSchedulers(siteID, EVENT_EXTERNALSOURCE_SYNC, fmt.Sprintf("%d", c.ID), "", 0, -1)
+	if err != nil {
+		return err
+	}
+
+	if c.Schedule == nil || !c.Active {
+		for _, s := range schedulers {
+			if err := s.Delete(siteID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var s *event.Scheduler
+	if len(schedulers) > 0 {
+		s = schedulers[0]
+	} else {
+		s = new(event.Scheduler)
+		s.Template = &event.Event{
+			Type:               EVENT_EXTERNALSOURCE_SYNC,
+			MainRelateID:       fmt.Sprintf("%d", c.ID),
+			SubRelateID:        make(map[string]string),
+			MaxExecuteDuration: util.Duration("10m"),
+		}
+	}
+	s.Name = "外部数据源同步: " + c.Name
+	s.Schedule = c.Schedule
+
+	if s.ID > 0 {
+		return s.Update(siteID)
+	}
+	return s.Add(siteID)
+}
+
+func GetConnector(siteID string, connectorID int) (*Connector, error) {
+	list, err := GetConnectors(siteID, connectorID)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, e_connector_not_exists
+	}
+	return list[0], nil
+}
+
+func GetConnectors(siteID string, connectorID ...int) ([]*Connector, error) {
+
+	SQL := fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s connector
+	`, connectorColumns, connectorTableName(siteID))
+
+	values := make([]interface{}, 0)
+	if len(connectorID) > 0 {
+		placeholder := make([]string, 0)
+		for _, id := range connectorID {
+			placeholder = append(placeholder, "?")
+			values = append(values, id)
+		}
+		SQL += fmt.Sprintf("WHERE connector.id IN (%s)", strings.Join(placeholder, ","))
+	}
+
+	SQL += " ORDER BY connector.id ASC"
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
+	if err != nil {
+		log.Println("error get external source connectors: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Connector, 0)
+	for rows.Next() {
+		var c Connector
+		if err := c.scan(rows); err != nil {
+			log.Println("error get external source connectors: ", err)
+			return nil, err
+		}
+		result = append(result, &c)
+	}
+
+	return result, nil
+}
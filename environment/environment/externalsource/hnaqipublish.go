@@ -12,9 +12,7 @@ import (
 	"obsessiontech/common/datasource"
 	"obsessiontech/common/util"
 	"obsessiontech/environment/environment/data"
-	"obsessiontech/environment/environment/dataprocess"
 	"obsessiontech/environment/environment/entity"
-	"obsessiontech/environment/environment/monitor"
 	"obsessiontech/environment/event"
 	"obsessiontech/environment/site"
 	"strconv"
@@ -62,7 +60,7 @@ func GetHNAQIPublishModule(siteID string, flags ...bool) (*HNAQIPublishModule, e
 
 func (m *HNAQIPublishModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_HNAQIPUBLISH, true)
 		if err != nil {
 			panic(err)
@@ -371,7 +369,8 @@ func findStnName(stnlist []interface{}, stnid string) string {
 			continue
 		}
 		if stn["id"] == stnid {
-			return stn["text"].(string)
+			name, _ := stn["text"].(string)
+			return name
 		}
 
 		if stn["children"] != nil {
@@ -387,82 +386,44 @@ func findStnName(stnlist []interface{}, stnid string) string {
 	return ""
 }
 
-func SyncHNAQIPublishRptData(siteID, dataType string, syncTime time.Time, traceBackCount int) error {
-	y, m, d := syncTime.Date()
-	var beginTime, endTime time.Time
-
-	var fac func() data.IData
-
-	switch dataType {
-	case data.HOURLY:
-		fac = func() data.IData { return new(data.HourlyData) }
-		hour := syncTime.Hour()
-		beginTime = time.Date(y, m, d, hour, 0, 0, 0, time.Local).Add(-1 * time.Hour * time.Duration(traceBackCount))
-		endTime = time.Date(y, m, d, hour, 0, 0, 0, time.Local)
-	case data.DAILY:
-		beginTime = time.Date(y, m, d, 0, 0, 0, 0, time.Local).AddDate(0, 0, -1*traceBackCount)
-		endTime = time.Date(y, m, d, 23, 59, 59, 0, time.Local)
-		fac = func() data.IData { return new(data.DailyData) }
-	default:
-		return errors.New("不正确的省站数据类型")
-	}
-
-	return syncData(siteID, func(ids []string) (int, string, []byte, error) {
-		return GetHNAQIPublishRptData(siteID, dataType, ids, beginTime, endTime)
-	}, fac)
-}
-
-func SyncHNAQIPublishStatsData(siteID, dataType string, syncTime time.Time, traceBackCount int) error {
-	y, m, d := syncTime.Date()
-	beginTime := time.Date(y, m, d, 0, 0, 0, 0, time.Local).AddDate(0, 0, -1*traceBackCount)
-
-	tick := time.Time(beginTime)
-	for i := 0; i <= traceBackCount; i++ {
-		if err := syncData(siteID, func(ids []string) (int, string, []byte, error) {
-			return GetHNAQIPublishStatsData(siteID, dataType, ids, tick, tick)
-		}, func() data.IData {
-			return new(data.DailyData)
-		}); err != nil {
-			return err
-		}
-
-		tick = tick.AddDate(0, 0, 1)
-	}
-
-	return nil
-}
-
-func syncData(siteID string, fetchFunc func([]string) (int, string, []byte, error), fac func() data.IData) error {
+//省站站点树中的名称至ext中hnAQIPublishStn 按名称返回的记录以此对应排放口
+func GetHNAQIPublishStationNames(siteID string) (map[string]string, error) {
 	stations, err := entity.GetStations(siteID, nil, nil, "", "", "hnAQIPublishStn")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(stations) == 0 {
-		return nil
+		return make(map[string]string), nil
 	}
 
 	status, contentType, res, err := GetHNAQIPublishStationTree(siteID, "isAll")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if status != 200 {
-		return fmt.Errorf("HN AQI Publish status: %d", status)
+		return nil, fmt.Errorf("HN AQI Publish status: %d", status)
 	}
 
 	if !strings.Contains(strings.ToLower(contentType), "application/json") {
-		return fmt.Errorf("HN AQI Publish abnormal response: %s", contentType)
+		return nil, fmt.Errorf("HN AQI Publish abnormal response: %s", contentType)
 	}
 
 	stnlist := make([]interface{}, 0)
 	if err := json.Unmarshal(res, &stnlist); err != nil {
-		return err
+		return nil, err
 	}
 
-	stns := make(map[string]*entity.Station)
-	stnids := make(map[string]*entity.Station)
-	ids := make([]string, 0)
+	names := mapHNAQIPublishStationNames(stnlist, stations)
+
+	log.Println("hnaqi publish name mapping: ", len(names), names)
+
+	return names, nil
+}
+
+func mapHNAQIPublishStationNames(stnlist []interface{}, stations []*entity.Station) map[string]string {
+	result := make(map[string]string)
 
 	for _, s := range stations {
 		stn, ok := s.Ext["hnAQIPublishStn"].(string)
@@ -470,159 +431,122 @@ func syncData(siteID string, fetchFunc func([]string) (int, string, []byte, erro
 			continue
 		}
 
-		stnids[stn] = s
-
-		name := findStnName(stnlist, stn)
-		if name != "" {
-			ids = append(ids, stn)
-			stns[name] = s
+		if name := findStnName(stnlist, stn); name != "" {
+			result[name] = stn
 		}
 	}
 
-	log.Println("hnaqi publish name mapping: ", len(ids), stns)
+	return result
+}
 
-	if len(ids) == 0 {
-		return nil
+//省站数据同步以通用外部数据源配置表达 排放口按ext中hnAQIPublishStn匹配
+//按名称返回的记录经省站站点树names换为站点编号后匹配
+func (m *HNAQIPublishModule) Connector(path, dataType string, traceBackCount int, names map[string]string) *Connector {
+	host := strings.Replace(strings.Replace(m.Host, "http://", "", -1), "https://", "", -1)
+
+	c := &Connector{
+		Name:     "HNAQIPublish",
+		Active:   true,
+		DataType: dataType,
+		Request: &ConnectorRequest{
+			Method: "POST",
+			URL:    m.Host + path,
+			Headers: map[string]string{
+				"Host":          host,
+				"Cache-Control": "nocache",
+				"Referer":       m.Host + "/Index/Home",
+				"User-Agent":    "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/100.0.4896.127 Safari/537.36",
+			},
+			Form: map[string]string{
+				"Stns":       `{{join (quote .Stations "'") ","}}`,
+				"beginTime":  "{{.BeginTime}}",
+				"endTime":    "{{.EndTime}}",
+				"choiceType": "isGK",
+				"sjy":        "false",
+			},
+		},
+		Auth:   &ConnectorAuth{Type: AUTH_COOKIE, Cookie: m.Cookie},
+		Window: &ConnectorWindow{TraceBack: traceBackCount},
+		Mapping: &ConnectorMapping{
+			Format:        FORMAT_JSON,
+			StringEncoded: true,
+			Stations: []*StationMatch{
+				{Path: "SStation", By: MATCH_EXT, ExtKey: "hnAQIPublishStn"},
+				{Path: "StationName", By: MATCH_EXT, ExtKey: "hnAQIPublishStn", Aliases: names},
+				{Path: "SStationName", By: MATCH_EXT, ExtKey: "hnAQIPublishStn", Aliases: names},
+			},
+			Times: []*TimeMatch{
+				{Path: "QueryTime", Format: "datetime"},
+				{Path: "SDatetime", Format: "date"},
+				{Path: "SDateTime", Format: "date", Split: "至"},
+			},
+			PrimaryPath: "PrimaryEP",
+		},
 	}
 
-	if err := monitor.LoadMonitorCode(siteID); err != nil {
-		return err
+	return c
+}
+
+func SyncHNAQIPublishRptData(siteID, dataType string, syncTime time.Time, traceBackCount int) error {
+
+	var rptDataType string
+
+	switch dataType {
+	case data.HOURLY:
+		rptDataType = "Hourly"
+	case data.DAILY:
+		rptDataType = "Daily"
+	default:
+		return errors.New("不正确的省站数据类型")
 	}
-	if err := monitor.LoadFlagLimit(siteID); err != nil {
+
+	m, err := GetHNAQIPublishModule(siteID)
+	if err != nil {
 		return err
 	}
 
-	status, _, res, err = fetchFunc(ids)
+	names, err := GetHNAQIPublishStationNames(siteID)
 	if err != nil {
 		return err
 	}
-	if status != 200 {
-		return fmt.Errorf("HN AQI Publish status: %d", status)
-	}
 
-	list := make([]map[string]string, 0)
-	if err := json.Unmarshal(res, &list); err != nil {
-		var datastring string
-		if err := json.Unmarshal(res, &datastring); err != nil {
-			return err
-		} else {
-			if err := json.Unmarshal([]byte(datastring), &list); err != nil {
-				return err
-			}
-		}
+	c := m.Connector(fmt.Sprintf("/DataQuery/Get%sRpt", rptDataType), dataType, traceBackCount, names)
+	c.Request.Form["dataType"] = "0"
+	if err := c.Validate(); err != nil {
+		return err
 	}
 
-	log.Println("parsing sync: ", len(list))
-
-	uper := new(dataprocess.Uploader)
-	up := new(uploader)
-
-	dataset := make([]data.IData, 0)
-
-	for _, entry := range list {
-		var station *entity.Station
-		if s, exists := entry["SStation"]; exists {
-			station = stnids[s]
-		} else if s, exists = entry["StationName"]; exists {
-			station = stns[s]
-		} else if s, exists = entry["SStationName"]; exists {
-			station = stns[s]
-		} else {
-			log.Println("no station entry")
-			continue
-		}
-		if station == nil {
-			log.Println("no station: ", station)
-			continue
-		}
-
-		var dataTime time.Time
-		var err error
-		if s, exists := entry["QueryTime"]; exists {
-			dataTime, err = util.ParseDateTime(s)
-		} else if s, exists = entry["SDatetime"]; exists {
-			dataTime, err = util.ParseDate(s)
-		} else if s, exists = entry["SDateTime"]; exists {
-			parts := strings.Split(s, "至")
-			dataTime, err = util.ParseDate(parts[0])
-		} else {
-			log.Println("no time entry")
-			continue
-		}
-		if err != nil {
-			return err
-		}
-
-		stationDataSet := make(map[int]data.IData)
-
-		for key, value := range entry {
-
-			if value == "" {
-				continue
-			}
-
-			mc := monitor.GetMonitorCodeByCode(siteID, station.ID, key)
-			if mc == nil {
-				log.Println("no monitor: ", key, value, station.ID)
-				continue
-			}
-			d := fac()
-			d.SetStationID(station.ID)
-			d.SetMonitorID(mc.MonitorID)
-			d.SetDataTime(util.Time(dataTime))
-
-			var err error
-			v, err := strconv.ParseFloat(strings.Trim(value, "\r\n "), 64)
-			if err != nil {
-				log.Println("error parse value: ", value, err)
-				continue
-			}
-			if itv, ok := d.(data.IInterval); ok {
-				itv.SetAvg(v)
-			} else if rtd, ok := d.(data.IRealTime); ok {
-				rtd.SetRtd(v)
-			}
-
-			d.SetCode(key)
+	beginTime, endTime := c.GetSyncWindow(syncTime)
+	_, err = c.Sync(siteID, beginTime, endTime, 0)
+	return err
+}
 
-			stationDataSet[mc.MonitorID] = d
-			dataset = append(dataset, d)
-		}
+func SyncHNAQIPublishStatsData(siteID, dataType string, syncTime time.Time, traceBackCount int) error {
 
-		primary := entry["PrimaryEP"]
-		if primary != "" {
-			log.Println("has PrimaryEP: ", primary)
-			for _, p := range strings.Split(primary, ",") {
-				p = strings.Trim(p, "\n\r ")
-				if p != "" {
-					pmc := monitor.GetMonitorCodeByCode(siteID, station.ID, p)
-					if pmc != nil {
-						pd, exists := stationDataSet[pmc.MonitorID]
-						if exists {
-							pd.SetFlagBit(data.SetFlagBit(pd.GetFlagBit(), monitor.FLAG_PRIMARY_POLLUTANT))
-							log.Println("primary ep data flag bit: ", pd.GetFlagBit())
-						} else {
-							log.Println("no primary ep monitor in dataset: ", p, station.ID, pmc.MonitorID)
-						}
-					} else {
-						log.Println("no primary ep monitor: ", p, station.ID)
-					}
-				}
-			}
-		}
+	m, err := GetHNAQIPublishModule(siteID)
+	if err != nil {
+		return err
 	}
 
-	log.Println("data to sync: ", len(dataset))
-
-	if err := uper.UploadBatchData(siteID, up, dataset...); err != nil {
+	names, err := GetHNAQIPublishStationNames(siteID)
+	if err != nil {
 		return err
 	}
 
-	if err := uper.UploadUnuploaded(siteID, up); err != nil {
+	c := m.Connector(fmt.Sprintf("/ZNStatistics/Get%sRpt", dataType), data.DAILY, traceBackCount, names)
+	c.Request.Headers["Referer"] = m.Host + "/ZNStatistics/CompreehensiveIndex"
+	c.Request.Form["dateType"] = "Sd"
+	c.Request.Form["tcType"] = "afterTC"
+	c.Request.Form["isSCBNew"] = "2"
+	c.Request.TimeFormat = "2006-01-02"
+	c.Window.Step = STEP_DAY
+	if err := c.Validate(); err != nil {
 		return err
 	}
 
-	return nil
+	beginTime, endTime := c.GetSyncWindow(syncTime)
+	_, err = c.Sync(siteID, beginTime, endTime, 0)
+	return err
 }
 
 type HNAQIPublishSync struct{}
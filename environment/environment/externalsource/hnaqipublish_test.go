@@ -0,0 +1,38 @@
+package externalsource
+
+import (
+	"encoding/json"
+	"testing"
+
+	"obsessiontech/environment/environment/entity"
+)
+
+func TestMapHNAQIPublishStationNames(t *testing.T) {
+	tree := `[{"id":"city","text":"长沙市","children":[{"id":"430101","text":"经开区"},{"id":"430102","text":"高开区"}]},{"id":"430201","text":"株洲天元"}]`
+
+	stnlist := make([]interface{}, 0)
+	if err := json.Unmarshal([]byte(tree), &stnlist); err != nil {
+		t.Fatal(err)
+	}
+
+	stations := []*entity.Station{
+		//本地名称与省站名称不同 以ext中站点编号对应
+		{ID: 1, Name: "经济开发区站", Ext: map[string]interface{}{"hnAQIPublishStn": "430101"}},
+		{ID: 2, Name: "天元站", Ext: map[string]interface{}{"hnAQIPublishStn": "430201"}},
+		//站点树中不存在的编号不对应
+		{ID: 3, Name: "高开区", Ext: map[string]interface{}{"hnAQIPublishStn": "439999"}},
+		{ID: 4, Name: "高开区", Ext: map[string]interface{}{}},
+	}
+
+	names := mapHNAQIPublishStationNames(stnlist, stations)
+
+	expected := map[string]string{"经开区": "430101", "株洲天元": "430201"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for name, stn := range expected {
+		if names[name] != stn {
+			t.Errorf("name %s: expected %s, got %s", name, stn, names[name])
+		}
+	}
+}
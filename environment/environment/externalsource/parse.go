@@ -0,0 +1,245 @@
+package externalsource
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"obsessiontech/common/util"
+)
+
+var e_invalid_records_path = errors.New("记录路径不正确")
+
+//解析返回内容为扁平记录 嵌套字段以.连接
+func (m *ConnectorMapping) parseRecords(body []byte) ([]map[string]string, error) {
+	switch m.Format {
+	case FORMAT_JSON:
+		return m.parseJSON(body)
+	case FORMAT_XML:
+		return m.parseXML(body)
+	case FORMAT_CSV:
+		return m.parseCSV(body)
+	}
+	return nil, e_invalid_connector_format
+}
+
+func (m *ConnectorMapping) parseJSON(body []byte) ([]map[string]string, error) {
+	var root interface{}
+	if err := json.Unmarshal(body, &root); err != nil {
+		return nil, err
+	}
+
+	if s, ok := root.(string); ok && m.StringEncoded {
+		if err := json.Unmarshal([]byte(s), &root); err != nil {
+			return nil, err
+		}
+	}
+
+	node := root
+	if m.RecordsPath != "" {
+		for _, key := range strings.Split(m.RecordsPath, ".") {
+			obj, ok := node.(map[string]interface{})
+			if !ok {
+				return nil, e_invalid_records_path
+			}
+			node = obj[key]
+		}
+	}
+
+	if node == nil {
+		return []map[string]string{}, nil
+	}
+
+	list, ok := node.([]interface{})
+	if !ok {
+		return nil, e_invalid_records_path
+	}
+
+	result := make([]map[string]string, 0)
+	for _, entry := range list {
+		record := make(map[string]string)
+		flattenJSON("", entry, record)
+		result = append(result, record)
+	}
+
+	return result, nil
+}
+
+func flattenJSON(prefix string, node interface{}, record map[string]string) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenJSON(key, child, record)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenJSON(fmt.Sprintf("%s.%d", prefix, i), child, record)
+		}
+	case string:
+		record[prefix] = v
+	case float64:
+		record[prefix] = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		record[prefix] = strconv.FormatBool(v)
+	}
+}
+
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	text     string
+	children []*xmlNode
+}
+
+//RecordsPath为自根元素起的元素路径 如Root.Items.Item
+func (m *ConnectorMapping) parseXML(body []byte) ([]map[string]string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local, attrs: t.Attr}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			stack[len(stack)-1].text += string(t)
+		}
+	}
+
+	nodes := root.children
+	if m.RecordsPath != "" {
+		path := strings.Split(m.RecordsPath, ".")
+		for i, name := range path {
+			matched := make([]*xmlNode, 0)
+			for _, n := range nodes {
+				if n.name == name {
+					matched = append(matched, n)
+				}
+			}
+			if i == len(path)-1 {
+				nodes = matched
+				break
+			}
+			nodes = make([]*xmlNode, 0)
+			for _, n := range matched {
+				nodes = append(nodes, n.children...)
+			}
+		}
+	}
+
+	result := make([]map[string]string, 0)
+	for _, n := range nodes {
+		record := make(map[string]string)
+		for _, a := range n.attrs {
+			record["@"+a.Name.Local] = a.Value
+		}
+		for _, child := range n.children {
+			flattenXML("", child, record)
+		}
+		result = append(result, record)
+	}
+
+	return result, nil
+}
+
+func flattenXML(prefix string, n *xmlNode, record map[string]string) {
+	key := n.name
+	if prefix != "" {
+		key = prefix + "." + n.name
+	}
+	for _, a := range n.attrs {
+		record[key+".@"+a.Name.Local] = a.Value
+	}
+	if len(n.children) == 0 {
+		record[key] = strings.TrimSpace(n.text)
+		return
+	}
+	for _, child := range n.children {
+		flattenXML(key, child, record)
+	}
+}
+
+//首行为表头
+func (m *ConnectorMapping) parseCSV(body []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\xEF\xBB\xBF"))))
+	reader.FieldsPerRecord = -1
+	if m.CSVComma != "" {
+		comma, _ := utf8.DecodeRuneInString(m.CSVComma)
+		reader.Comma = comma
+	}
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]string, 0)
+	if len(rows) == 0 {
+		return result, nil
+	}
+
+	header := rows[0]
+	for _, row := range rows[1:] {
+		record := make(map[string]string)
+		for i, v := range row {
+			if i < len(header) {
+				record[strings.TrimSpace(header[i])] = v
+			}
+		}
+		result = append(result, record)
+	}
+
+	return result, nil
+}
+
+func (t *TimeMatch) parse(value string) (time.Time, error) {
+	if t.Split != "" {
+		value = strings.Split(value, t.Split)[0]
+	}
+	value = strings.TrimSpace(value)
+
+	switch t.Format {
+	case "", "datetime":
+		return util.ParseDateTime(value)
+	case "date":
+		return util.ParseDate(value)
+	case "unix", "unixms":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t.Format == "unixms" {
+			return time.UnixMilli(i), nil
+		}
+		return time.Unix(i, 0), nil
+	}
+	return time.ParseInLocation(t.Format, value, time.Local)
+}
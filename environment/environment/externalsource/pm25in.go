@@ -1,24 +0,0 @@
-package externalsource
-
-// var Config struct {
-// 	PM25_InAppKey string
-// }
-
-// func init() {
-// 	config.GetConfig("config.yaml", &Config)
-// }
-
-// const (
-// 	Area             = "area"
-// 	StationCode      = "station_code"
-// 	PositionName     = "position_name"
-// 	TimePoint        = "time_point"
-// 	PrimaryPollutant = "primary_pollutant"
-// 	Quality          = "quality"
-// )
-
-// func GetCityStationList(city string) (map[string]interface{}, error) {
-// 	url := fmt.Sprintf("http://www.pm25.in/api/querys/station_names.json")
-
-// 	return nil, nil
-// }
@@ -0,0 +1,602 @@
+package externalsource
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+	"obsessiontech/environment/event"
+)
+
+const (
+	SYNC_SUCCESS = "success"
+	SYNC_FAIL    = "fail"
+)
+
+var e_connector_inactive = errors.New("外部数据源未启用")
+
+func init() {
+	event.Register(EVENT_EXTERNALSOURCE_SYNC, func() event.IEvent { return new(ConnectorSync) })
+}
+
+//每次同步的记录
+type SyncLog struct {
+	ID          int        `json:"ID"`
+	ConnectorID int        `json:"connectorID"`
+	BeginTime   util.Time  `json:"beginTime"`
+	EndTime     util.Time  `json:"endTime"`
+	Status      string     `json:"status"`
+	Requests    int        `json:"requests"`
+	Records     int        `json:"records"`
+	Saved       int        `json:"saved"`
+	Skipped     int        `json:"skipped"`
+	Error       string     `json:"error,omitempty"`
+	UID         int        `json:"UID"`
+	CreateTime  util.Time  `json:"createTime"`
+	FinishTime  *util.Time `json:"finishTime,omitempty"`
+}
+
+func syncLogTableName(siteID string) string {
+	return siteID + "_externalsourcesynclog"
+}
+
+const syncLogColumns = "synclog.id, synclog.connector_id, synclog.begin_time, synclog.end_time, synclog.status, synclog.requests, synclog.records, synclog.saved, synclog.skipped, synclog.error, synclog.uid, synclog.create_time, synclog.finish_time"
+
+func (l *SyncLog) scan(rows *sql.Rows) error {
+	var beginTime, endTime, createTime time.Time
+	var finishTime sql.NullTime
+	if err := rows.Scan(&l.ID, &l.ConnectorID, &beginTime, &endTime, &l.Status, &l.Requests, &l.Records, &l.Saved, &l.Skipped, &l.Error, &l.UID, &createTime, &finishTime); err != nil {
+		return err
+	}
+	l.BeginTime = util.Time(beginTime)
+	l.EndTime = util.Time(endTime)
+	l.CreateTime = util.Time(createTime)
+	if finishTime.Valid {
+		t := util.Time(finishTime.Time)
+		l.FinishTime = &t
+	}
+	return nil
+}
+
+func (l *SyncLog) add(siteID string) error {
+	var finishTime interface{}
+	if l.FinishTime != nil {
+		finishTime = time.Time(*l.FinishTime)
+	}
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(connector_id, begin_time, end_time, status, requests, records, saved, skipped, error, uid, finish_time)
+		VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, syncLogTableName(siteID)), l.ConnectorID, time.Time(l.BeginTime), time.Time(l.EndTime), l.Status, l.Requests, l.Records, l.Saved, l.Skipped, l.Error, l.UID, finishTime)
+	if err != nil {
+		log.Println("error add external source sync log: ", err)
+		return err
+	}
+	id, err := ret.LastInsertId()
+	if err != nil {
+		return err
+	}
+	l.ID = int(id)
+	return nil
+}
+
+//connectorID为0时返回全部数据源的记录
+func GetSyncLogs(siteID string, connectorID int, pageNo, pageSize int) ([]*SyncLog, int, error) {
+
+	whereStmts := []string{"1 = 1"}
+	values := make([]interface{}, 0)
+
+	if connectorID > 0 {
+		whereStmts = append(whereStmts, "synclog.connector_id = ?")
+		values = append(values, connectorID)
+	}
+
+	var total int
+	if err := datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf(`
+		SELECT
+			COUNT(1)
+		FROM
+			%s synclog
+		WHERE
+			%s
+	`, syncLogTableName(siteID), strings.Join(whereStmts, " AND ")), values...).Scan(&total); err != nil {
+		log.Println("error count external source sync logs: ", err)
+		return nil, 0, err
+	}
+
+	if pageNo <= 0 {
+		pageNo = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s synclog
+		WHERE
+			%s
+		ORDER BY
+			synclog.id DESC
+		LIMIT ?, ?
+	`, syncLogColumns, syncLogTableName(siteID), strings.Join(whereStmts, " AND ")), append(values, (pageNo-1)*pageSize, pageSize)...)
+	if err != nil {
+		log.Println("error get external source sync logs: ", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := make([]*SyncLog, 0)
+	for rows.Next() {
+		var l SyncLog
+		if err := l.scan(rows); err != nil {
+			log.Println("error get external source sync logs: ", err)
+			return nil, 0, err
+		}
+		result = append(result, &l)
+	}
+
+	return result, total, nil
+}
+
+//按回溯周期计算同步区间
+func (c *Connector) GetSyncWindow(syncTime time.Time) (time.Time, time.Time) {
+	y, m, d := syncTime.Date()
+	switch c.DataType {
+	case data.DAILY:
+		beginTime := time.Date(y, m, d, 0, 0, 0, 0, time.Local).AddDate(0, 0, -c.Window.TraceBack)
+		return beginTime, time.Date(y, m, d, 23, 59, 59, 0, time.Local)
+	case data.HOURLY:
+		endTime := time.Date(y, m, d, syncTime.Hour(), 0, 0, 0, time.Local)
+		return endTime.Add(-time.Hour * time.Duration(c.Window.TraceBack)), endTime
+	case data.MINUTELY:
+		endTime := syncTime.Truncate(time.Minute)
+		return endTime.Add(-time.Minute * time.Duration(c.Window.TraceBack)), endTime
+	default:
+		return syncTime.Add(-time.Minute * time.Duration(c.Window.TraceBack)), syncTime
+	}
+}
+
+//同步区间内的数据并记录同步日志 uid为0时为计划同步
+func (c *Connector) Sync(siteID string, beginTime, endTime time.Time, uid int) (*SyncLog, error) {
+
+	if beginTime.After(endTime) {
+		return nil, errors.New("同步时间不正确")
+	}
+
+	l := &SyncLog{ConnectorID: c.ID, BeginTime: util.Time(beginTime), EndTime: util.Time(endTime), UID: uid, CreateTime: util.Time(time.Now())}
+
+	err := c.sync(siteID, beginTime, endTime, l)
+
+	now := util.Time(time.Now())
+	l.FinishTime = &now
+	if err != nil {
+		log.Println("error sync external source: ", siteID, c.ID, err)
+		l.Status = SYNC_FAIL
+		l.Error = err.Error()
+	} else {
+		log.Printf("external source synced [%s] %d: %d records %d saved", siteID, c.ID, l.Records, l.Saved)
+		l.Status = SYNC_SUCCESS
+	}
+
+	if addErr := l.add(siteID); addErr != nil {
+		log.Println("error record external source sync: ", siteID, c.ID, addErr)
+	}
+
+	return l, err
+}
+
+type requestParam struct {
+	BeginTime string
+	EndTime   string
+	Stations  []string
+	Page      int
+	Offset    int
+	PageSize  int
+}
+
+func (c *Connector) sync(siteID string, beginTime, endTime time.Time, l *SyncLog) error {
+
+	stationMap, externalIDs, err := c.loadStations(siteID)
+	if err != nil {
+		return err
+	}
+	if len(externalIDs) == 0 {
+		return nil
+	}
+
+	if err := monitor.LoadMonitorCode(siteID); err != nil {
+		return err
+	}
+	if err := monitor.LoadFlagLimit(siteID); err != nil {
+		return err
+	}
+
+	windows := [][2]time.Time{{beginTime, endTime}}
+	if c.Window.Step == STEP_DAY {
+		windows = make([][2]time.Time, 0)
+		for tick := util.GetDate(beginTime); !tick.After(endTime); tick = tick.AddDate(0, 0, 1) {
+			windows = append(windows, [2]time.Time{tick, tick})
+		}
+	}
+
+	uper := new(dataprocess.Uploader)
+	up := new(uploader)
+
+	for _, w := range windows {
+		param := &requestParam{
+			BeginTime: w[0].Format(c.Request.TimeFormat),
+			EndTime:   w[1].Format(c.Request.TimeFormat),
+			Stations:  externalIDs,
+			PageSize:  c.Pagination.PageSize,
+		}
+
+		for page := 0; ; page++ {
+			param.Page = c.Pagination.StartPage + page
+			param.Offset = page * c.Pagination.PageSize
+
+			body, err := c.fetch(param)
+			l.Requests++
+			if err != nil {
+				return err
+			}
+
+			records, err := c.Mapping.parseRecords(body)
+			if err != nil {
+				return err
+			}
+			l.Records += len(records)
+
+			dataset := make([]data.IData, 0)
+			for _, record := range records {
+				list, err := c.Mapping.mapRecord(siteID, c.DataType, stationMap, record)
+				if err != nil {
+					return err
+				}
+				if len(list) == 0 {
+					l.Skipped++
+					continue
+				}
+				dataset = append(dataset, list...)
+			}
+
+			if err := uper.UploadBatchData(siteID, up, dataset...); err != nil {
+				return err
+			}
+			l.Saved += len(dataset)
+
+			if c.Pagination.Type == PAGINATION_NONE || len(records) < c.Pagination.PageSize || page+1 >= c.Pagination.MaxPages {
+				break
+			}
+		}
+	}
+
+	return uper.UploadUnuploaded(siteID, up)
+}
+
+//返回各匹配规则下外部标识至排放口的映射 及首个规则下的外部标识列表
+func (c *Connector) loadStations(siteID string) (map[string]map[string]*entity.Station, []string, error) {
+	stations, err := entity.GetStations(siteID, nil, nil, entity.ACTIVE, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := make(map[string]map[string]*entity.Station)
+	externalIDs := make([]string, 0)
+
+	for i, match := range c.Mapping.Stations {
+		key := match.key()
+		if _, exists := result[key]; !exists {
+			result[key] = make(map[string]*entity.Station)
+		}
+		for _, s := range stations {
+			id := match.externalID(s)
+			if id == "" {
+				continue
+			}
+			result[key][id] = s
+			if i == 0 {
+				externalIDs = append(externalIDs, id)
+			}
+		}
+	}
+
+	return result, externalIDs, nil
+}
+
+func (m *StationMatch) key() string {
+	return m.By + "#" + m.ExtKey
+}
+
+func (m *StationMatch) externalID(s *entity.Station) string {
+	switch m.By {
+	case MATCH_MN:
+		return s.MN
+	case MATCH_NAME:
+		return s.Name
+	case MATCH_EXT:
+		switch v := s.Ext[m.ExtKey].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+func (c *Connector) fetch(param *requestParam) ([]byte, error) {
+
+	render := func(text string) (string, error) {
+		if text == "" {
+			return "", nil
+		}
+		t, err := parseRequestTemplate(text)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, param); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	URL, err := render(c.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	contentType := c.Request.ContentType
+	if len(c.Request.Form) > 0 {
+		form := make(url.Values)
+		for k, v := range c.Request.Form {
+			value, err := render(v)
+			if err != nil {
+				return nil, err
+			}
+			form.Set(k, value)
+		}
+		if c.Request.Method == "GET" {
+			if strings.Contains(URL, "?") {
+				URL += "&" + form.Encode()
+			} else {
+				URL += "?" + form.Encode()
+			}
+		} else {
+			body = strings.NewReader(form.Encode())
+			if contentType == "" {
+				contentType = "application/x-www-form-urlencoded"
+			}
+		}
+	} else if c.Request.Body != "" {
+		content, err := render(c.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = strings.NewReader(content)
+	}
+
+	req, err := http.NewRequest(c.Request.Method, URL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range c.Request.Headers {
+		value, err := render(v)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(k, value)
+	}
+
+	switch c.Auth.Type {
+	case AUTH_COOKIE:
+		req.Header.Set("Cookie", c.Auth.Cookie)
+	case AUTH_TOKEN:
+		req.Header.Set(c.Auth.TokenHeader, c.Auth.TokenPrefix+c.Auth.Token)
+	case AUTH_BASIC:
+		req.SetBasicAuth(c.Auth.Username, c.Auth.Password)
+	}
+
+	log.Println("request external source: ", c.ID, c.Request.Method, URL)
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("error request external source: ", c.ID, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("error request external source: ", c.ID, err)
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("external source status: %d", resp.StatusCode)
+	}
+
+	return content, nil
+}
+
+//单条记录转为数据 未匹配排放口或时间时返回空
+func (m *ConnectorMapping) mapRecord(siteID, dataType string, stationMap map[string]map[string]*entity.Station, record map[string]string) ([]data.IData, error) {
+
+	var station *entity.Station
+	for _, match := range m.Stations {
+		if id, exists := record[match.Path]; exists {
+			id = strings.TrimSpace(id)
+			if alias, exists := match.Aliases[id]; exists {
+				id = alias
+			}
+			station = stationMap[match.key()][id]
+			break
+		}
+	}
+	if station == nil {
+		return nil, nil
+	}
+
+	var dataTime time.Time
+	found := false
+	for _, t := range m.Times {
+		if value, exists := record[t.Path]; exists {
+			parsed, err := t.parse(value)
+			if err != nil {
+				return nil, err
+			}
+			dataTime = parsed
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	values := make(map[string]string)
+	if m.CodePath != "" {
+		values[record[m.CodePath]] = record[m.ValuePath]
+	} else if len(m.Values) > 0 {
+		for path, code := range m.Values {
+			if v, exists := record[path]; exists {
+				values[code] = v
+			}
+		}
+	} else {
+		values = record
+	}
+
+	result := make([]data.IData, 0)
+	dataByCode := make(map[string]data.IData)
+
+	for code, value := range values {
+		value = strings.Trim(value, "\r\n ")
+		if code == "" || value == "" {
+			continue
+		}
+
+		mc := monitor.GetMonitorCodeByCode(siteID, station.ID, code)
+		if mc == nil {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Println("error parse external source value: ", code, value, err)
+			continue
+		}
+
+		var d data.IData
+		switch dataType {
+		case data.REAL_TIME:
+			d = new(data.RealTimeData)
+		case data.MINUTELY:
+			d = new(data.MinutelyData)
+		case data.HOURLY:
+			d = new(data.HourlyData)
+		case data.DAILY:
+			d = new(data.DailyData)
+		default:
+			return nil, e_invalid_connector_data_type
+		}
+
+		d.SetStationID(station.ID)
+		d.SetMonitorID(mc.MonitorID)
+		d.SetDataTime(util.Time(dataTime))
+		d.SetCode(code)
+		if itv, ok := d.(data.IInterval); ok {
+			itv.SetAvg(v)
+		} else if rtd, ok := d.(data.IRealTime); ok {
+			rtd.SetRtd(v)
+		}
+
+		dataByCode[code] = d
+		result = append(result, d)
+	}
+
+	if m.PrimaryPath != "" && record[m.PrimaryPath] != "" {
+		separator := m.PrimarySeparator
+		if separator == "" {
+			separator = ","
+		}
+		for _, p := range strings.Split(record[m.PrimaryPath], separator) {
+			if d, exists := dataByCode[strings.Trim(p, "\r\n ")]; exists {
+				d.SetFlagBit(data.SetFlagBit(d.GetFlagBit(), monitor.FLAG_PRIMARY_POLLUTANT))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+//MainRelateID为外部数据源ID
+type ConnectorSync struct{}
+
+func (s *ConnectorSync) ValidateEvent(siteID string, e *event.Event) error {
+	connectorID, err := strconv.Atoi(e.MainRelateID)
+	if err != nil {
+		return e_connector_not_exists
+	}
+	_, err = GetConnector(siteID, connectorID)
+	return err
+}
+
+func (s *ConnectorSync) ExecuteEvent(siteID string, txn *sql.Tx, e *event.Event) error {
+	connectorID, err := strconv.Atoi(e.MainRelateID)
+	if err != nil {
+		return e_connector_not_exists
+	}
+
+	c, err := GetConnector(siteID, connectorID)
+	if err != nil {
+		return err
+	}
+	if !c.Active {
+		return e_connector_inactive
+	}
+
+	beginTime, endTime := c.GetSyncWindow(time.Now())
+	l, err := c.Sync(siteID, beginTime, endTime, 0)
+	if err != nil {
+		return err
+	}
+
+	e.Feedback(event.SUCCESS, map[string]interface{}{
+		"at":        util.Time(time.Now()),
+		"syncLogID": l.ID,
+		"records":   l.Records,
+		"saved":     l.Saved,
+	})
+
+	if err := e.UpdateStatusWithTxn(siteID, txn); err != nil {
+		log.Println("error update event after external source sync: ", err)
+	}
+
+	return nil
+}
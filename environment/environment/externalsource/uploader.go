@@ -28,10 +28,18 @@ func (u *uploader) UploadBatchData(siteID string, uploader *dataprocess.Uploader
 				return err
 			}
 
-			d, err = operation.Modify(siteID, d, nil, u.UploaderUID)
+			modified, err := operation.Modify(siteID, d, nil, u.UploaderUID, "外部数据源覆盖")
+			if err == data.E_data_reviewed {
+				log.Println("skip reviewed data: ", siteID, d.GetStationID(), d.GetMonitorID(), d.GetDataTime())
+				continue
+			}
 			if err != nil {
 				return err
 			}
+			d = modified
+		} else {
+			operation.MarkLateData(siteID, operation.RECOMPUTE_SOURCE_EXTERNALSOURCE, d)
+			operation.NotifyAggregation(siteID, d)
 		}
 
 		monitorCode := monitor.GetMonitorCodeByCode(siteID, d.GetStationID(), d.GetCode())
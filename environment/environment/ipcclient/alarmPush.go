@@ -0,0 +1,107 @@
+package ipcclient
+
+import (
+	"log"
+	"time"
+
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+	"obsessiontech/environment/environment/subscription"
+	"obsessiontech/environment/push"
+)
+
+func init() {
+	//本进程内处理的数据(如导入)直接推送 接收进程的报警事件经ipc转来
+	monitor.RegisterAlarmEventHandler(func(siteID string, e *monitor.AlarmEvent, entry data.IData) {
+		PushAlarmEvent(siteID, e, entry)
+	})
+}
+
+//报警事件开始推送触发 结束推送解除 不经数据推送的合并与冷却
+func PushAlarmEvent(siteID string, e *monitor.AlarmEvent, entry data.IData) error {
+	if e == nil {
+		return nil
+	}
+
+	m, err := subscription.GetModule(siteID)
+	if err != nil {
+		return err
+	}
+
+	pushSetting := m.PushSettings[subscription.MONITOR_ALARM]
+	if pushSetting == nil {
+		return nil
+	}
+	if e.IsEnded() && pushSetting.Cease == nil {
+		return nil
+	}
+	if !e.IsEnded() && pushSetting.Trigger == nil {
+		return nil
+	}
+
+	stations, err := entity.GetStation(siteID, e.StationID)
+	if err != nil {
+		log.Println("error push alarm event: ", err)
+		return err
+	}
+	if len(stations) == 0 {
+		log.Println("error push alarm event: station not found ", e.StationID)
+		return nil
+	}
+
+	station := stations[0]
+
+	if station.Status != entity.ACTIVE {
+		log.Println("station not active")
+		return nil
+	}
+
+	entities, err := entity.GetEntities(siteID, station.EntityID)
+	if err != nil {
+		log.Println("error push alarm event: ", err)
+		return err
+	}
+	if len(entities) == 0 {
+		log.Println("error push alarm event: entity not found ", station.EntityID)
+		return nil
+	}
+
+	subscriptionList, err := subscription.GetSubscriptionsToPush(siteID, station.EntityID, station.ID, subscription.MONITOR_ALARM)
+	if err != nil {
+		log.Println("error get subscription list to push alarm event: ", err)
+		return err
+	}
+
+	if len(subscriptionList) == 0 {
+		return nil
+	}
+
+	monitor.LoadMonitor(siteID)
+	monitor.LoadFlagLimit(siteID)
+
+	dataList := make([]data.IData, 0)
+	if entry != nil {
+		dataList = append(dataList, entry)
+	}
+
+	for _, sub := range subscriptionList {
+		monitorSub := new(subscription.MonitorSubscription)
+		monitorSub.Subscription = *sub
+		monitorSub.Entity = entities[0]
+		monitorSub.Station = station
+		monitorSub.DataList = dataList
+		monitorSub.IsCease = e.IsEnded()
+		if monitorSub.IsCease {
+			monitorSub.Time = time.Time(e.EndTime)
+		} else {
+			monitorSub.Time = time.Time(e.BeginTime)
+		}
+
+		if err := push.Push(siteID, monitorSub); err != nil {
+			log.Println("error push alarm event: ", err)
+		}
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"obsessiontech/common/config"
 	"obsessiontech/common/ipc"
 	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/data/querycache"
 	"obsessiontech/environment/environment/data/recent"
 	"obsessiontech/environment/environment/ipcmessage"
 )
@@ -165,6 +166,7 @@ func StartPersistentClient(siteID, connType, addr string) error {
 				case (*ipcmessage.RealTime):
 					rtd := data.RealTimeData(*msg)
 					go data.TriggerRotation(siteID, false)
+					invalidateQueryCache(siteID, &rtd)
 					if isUpdated, _, _, _ := recent.UpdateRecentData(siteID, &rtd); isUpdated {
 						go BroadcastData(siteID, &rtd)
 						go PushData(siteID, &rtd)
@@ -172,6 +174,7 @@ func StartPersistentClient(siteID, connType, addr string) error {
 				case (*ipcmessage.Minutely):
 					minutely := data.MinutelyData(*msg)
 					go data.TriggerRotation(siteID, false)
+					invalidateQueryCache(siteID, &minutely)
 					if isUpdated, _, _, _ := recent.UpdateRecentData(siteID, &minutely); isUpdated {
 						go BroadcastData(siteID, &minutely)
 						go PushData(siteID, &minutely)
@@ -179,6 +182,7 @@ func StartPersistentClient(siteID, connType, addr string) error {
 				case (*ipcmessage.Hourly):
 					hourly := data.HourlyData(*msg)
 					go data.TriggerRotation(siteID, false)
+					invalidateQueryCache(siteID, &hourly)
 					if isUpdated, _, _, _ := recent.UpdateRecentData(siteID, &hourly); isUpdated {
 						go BroadcastData(siteID, &hourly)
 						go PushData(siteID, &hourly)
@@ -186,10 +190,13 @@ func StartPersistentClient(siteID, connType, addr string) error {
 				case (*ipcmessage.Daily):
 					daily := data.DailyData(*msg)
 					go data.TriggerRotation(siteID, false)
+					invalidateQueryCache(siteID, &daily)
 					if isUpdated, _, _, _ := recent.UpdateRecentData(siteID, &daily); isUpdated {
 						go BroadcastData(siteID, &daily)
 						go PushData(siteID, &daily)
 					}
+				case (*ipcmessage.AlarmEvent):
+					go PushAlarmEvent(siteID, msg.Event, msg.GetData())
 				default:
 					continue
 				}
@@ -293,3 +300,8 @@ func StartRequestClient(siteID, connType, addr string, output <-chan ipcmessage.
 
 	return input, nil
 }
+
+//接收端已写入数据 无论是否新于最近数据均使覆盖该数据时间的查询缓存失效
+func invalidateQueryCache(siteID string, d data.IData) {
+	querycache.Invalidate(siteID, d.GetDataType(), d.GetStationID(), time.Time(d.GetDataTime()))
+}
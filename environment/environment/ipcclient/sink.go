@@ -0,0 +1,46 @@
+package ipcclient
+
+import (
+	"log"
+	"time"
+
+	"obsessiontech/environment/environment/ipcmessage"
+	"obsessiontech/environment/environment/sink"
+)
+
+//按接收端地址返回各输出的投递统计 未响应的接收端不在结果中
+func RequestSinkMetrics(siteID string) map[string][]*sink.Metrics {
+	result := make(map[string][]*sink.Metrics)
+
+	for _, hostAddr := range Config.EnvironmentReceiverAddrs {
+		if hostAddr.SiteID == siteID {
+			send := make(chan ipcmessage.IMessage)
+			receive, err := StartRequestClient(siteID, hostAddr.ConnType, hostAddr.Addr, send)
+			if err != nil {
+				log.Println("error request sink metrics start client: ", err)
+				continue
+			}
+
+			send <- new(ipcmessage.SinkMetricsReq)
+
+			select {
+			case res, ok := <-receive:
+				if !ok {
+					log.Println("error request sink metrics receiving closed")
+				} else {
+					if metrics, ok := res.(*ipcmessage.SinkMetricsRes); ok {
+						result[hostAddr.Addr] = []*sink.Metrics(*metrics)
+					} else {
+						log.Println("error request sink metrics unexpected res type: ", res.GetIPCMessageType(), res)
+					}
+				}
+			case <-time.After(Config.EnvironmentReceiverRequestTimeOutSec * time.Second):
+				log.Println("error request sink metrics time out ")
+			}
+
+			close(send)
+		}
+	}
+
+	return result
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 
 	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/monitor"
+	"obsessiontech/environment/environment/sink"
 )
 
 const (
@@ -29,6 +31,11 @@ const (
 	minutely
 	hourly
 	daily
+
+	sinkMetricsReq
+	sinkMetricsRes
+
+	alarmEvent
 )
 
 type IMessage interface {
@@ -99,6 +106,54 @@ type Daily data.DailyData
 
 func (m *Daily) GetIPCMessageType() int { return daily }
 
+type SinkMetricsReq struct{}
+
+func (m *SinkMetricsReq) GetIPCMessageType() int { return sinkMetricsReq }
+
+type SinkMetricsRes []*sink.Metrics
+
+func (m *SinkMetricsRes) GetIPCMessageType() int { return sinkMetricsRes }
+
+// 报警事件及触发数据 数据按类型填入对应字段
+type AlarmEvent struct {
+	Event    *monitor.AlarmEvent `json:"event"`
+	RealTime *data.RealTimeData  `json:"realTime,omitempty"`
+	Minutely *data.MinutelyData  `json:"minutely,omitempty"`
+	Hourly   *data.HourlyData    `json:"hourly,omitempty"`
+	Daily    *data.DailyData     `json:"daily,omitempty"`
+}
+
+func (m *AlarmEvent) GetIPCMessageType() int { return alarmEvent }
+
+func NewAlarmEvent(e *monitor.AlarmEvent, entry data.IData) *AlarmEvent {
+	m := &AlarmEvent{Event: e}
+	switch d := entry.(type) {
+	case *data.RealTimeData:
+		m.RealTime = d
+	case *data.MinutelyData:
+		m.Minutely = d
+	case *data.HourlyData:
+		m.Hourly = d
+	case *data.DailyData:
+		m.Daily = d
+	}
+	return m
+}
+
+func (m *AlarmEvent) GetData() data.IData {
+	switch {
+	case m.RealTime != nil:
+		return m.RealTime
+	case m.Minutely != nil:
+		return m.Minutely
+	case m.Hourly != nil:
+		return m.Hourly
+	case m.Daily != nil:
+		return m.Daily
+	}
+	return nil
+}
+
 var E_unmarshal_failure = errors.New("json unmarshal failed")
 var E_message_type_unknown = errors.New("message type unknown")
 
@@ -147,6 +202,12 @@ func ParseMessage(input []byte) (IMessage, error) {
 		message = new(Hourly)
 	case daily:
 		message = new(Daily)
+	case sinkMetricsReq:
+		message = new(SinkMetricsReq)
+	case sinkMetricsRes:
+		message = new(SinkMetricsRes)
+	case alarmEvent:
+		message = new(AlarmEvent)
 	}
 
 	if err := json.Unmarshal(datagram.Message, &message); err != nil {
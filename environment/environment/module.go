@@ -66,7 +66,7 @@ func (m *EnvironmentModule) Save(siteID string) error {
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_ENVIRONMENT, true)
 		if err != nil {
 			panic(err)
@@ -0,0 +1,561 @@
+package monitor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+	"obsessiontech/environment/site/initialization"
+)
+
+const (
+	ALARM_CONSECUTIVE = "consecutive"
+	ALARM_M_OF_N      = "mOfN"
+	ALARM_DURATION    = "duration"
+)
+
+var e_need_alarm_name = errors.New("需要报警规则名称")
+var e_need_alarm_source_flag = errors.New("需要报警规则判断标记")
+var e_invalid_alarm_type = errors.New("报警规则类型不正确")
+var e_invalid_alarm_count = errors.New("报警规则次数不正确")
+var e_invalid_alarm_duration = errors.New("报警规则持续时长不正确")
+
+func init() {
+	dataprocess.Register("alarm", func() dataprocess.IDataProcessor { return new(alarmProcessor) })
+	initialization.RegisterMigrations(MODULE_MONITOR, "monitor", alarmMigration)
+}
+
+type AlarmRule struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	SourceFlag  string  `json:"sourceFlag"`
+	Flag        string  `json:"flag,omitempty"`
+	N           int     `json:"n,omitempty"`
+	M           int     `json:"m,omitempty"`
+	DurationMin float64 `json:"durationMin,omitempty"`
+	MaxGapMin   float64 `json:"maxGapMin,omitempty"`
+}
+
+func (r *AlarmRule) validate() error {
+	if r.Name == "" {
+		return e_need_alarm_name
+	}
+	if r.SourceFlag == "" {
+		return e_need_alarm_source_flag
+	}
+
+	switch r.Type {
+	case ALARM_CONSECUTIVE:
+		if r.N <= 0 {
+			return e_invalid_alarm_count
+		}
+	case ALARM_M_OF_N:
+		if r.N <= 0 || r.M <= 0 || r.M > r.N {
+			return e_invalid_alarm_count
+		}
+	case ALARM_DURATION:
+		if r.DurationMin <= 0 {
+			return e_invalid_alarm_duration
+		}
+	default:
+		return e_invalid_alarm_type
+	}
+
+	return nil
+}
+
+type alarmOutcome struct {
+	Time util.Time `json:"time"`
+	Hit  bool      `json:"hit"`
+}
+
+//规则判断状态 按规则/数据类型/监测点/监测物持久化 重启后延续
+type alarmState struct {
+	LastTime    util.Time       `json:"lastTime"`
+	StreakBegin util.Time       `json:"streakBegin"`
+	Streak      int             `json:"streak"`
+	LastHit     util.Time       `json:"lastHit"`
+	Window      []*alarmOutcome `json:"window,omitempty"`
+	EventID     int             `json:"eventID"`
+}
+
+func alarmStateTableName(siteID string) string {
+	return siteID + "_monitoralarmstate"
+}
+
+//报警规则状态表及超标事件表
+var alarmMigration = &initialization.Migration{
+	Version:     3,
+	Description: "报警规则状态及超标事件",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_monitoralarmstate (
+			rule VARCHAR(64) NOT NULL,
+			data_type VARCHAR(32) NOT NULL,
+			station_id INT NOT NULL,
+			monitor_id INT NOT NULL,
+			state TEXT,
+			PRIMARY KEY (rule, data_type, station_id, monitor_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`, `
+		CREATE TABLE IF NOT EXISTS {siteID}_monitoralarmevent (
+			id INT NOT NULL AUTO_INCREMENT,
+			rule VARCHAR(64) NOT NULL,
+			data_type VARCHAR(32) NOT NULL,
+			station_id INT NOT NULL,
+			monitor_id INT NOT NULL,
+			flag VARCHAR(32) NOT NULL DEFAULT '',
+			begin_time DATETIME NOT NULL,
+			end_time DATETIME NULL,
+			count INT NOT NULL DEFAULT 0,
+			max_value DOUBLE NOT NULL DEFAULT 0,
+			PRIMARY KEY (id),
+			KEY (station_id, monitor_id, begin_time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+func getAlarmState(siteID string, txn *sql.Tx, rule, dataType string, stationID, monitorID int) (*alarmState, error) {
+	var stateStr string
+
+	if err := txn.QueryRow(fmt.Sprintf(`
+		SELECT
+			state
+		FROM
+			%s
+		WHERE
+			rule = ? AND data_type = ? AND station_id = ? AND monitor_id = ?
+		FOR UPDATE
+	`, alarmStateTableName(siteID)), rule, dataType, stationID, monitorID).Scan(&stateStr); err != nil {
+		if err == sql.ErrNoRows {
+			return new(alarmState), nil
+		}
+		log.Println("error get alarm state: ", err)
+		return nil, err
+	}
+
+	var state alarmState
+	if err := json.Unmarshal([]byte(stateStr), &state); err != nil {
+		log.Println("error unmarshal alarm state: ", err)
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func (s *alarmState) save(siteID string, txn *sql.Tx, rule, dataType string, stationID, monitorID int) error {
+	stateByte, _ := json.Marshal(s)
+
+	if _, err := txn.Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(rule,data_type,station_id,monitor_id,state)
+		VALUES
+			(?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE
+			state=VALUES(state)
+	`, alarmStateTableName(siteID)), rule, dataType, stationID, monitorID, string(stateByte)); err != nil {
+		log.Println("error save alarm state: ", err)
+		return err
+	}
+
+	return nil
+}
+
+//返回当前数据是否处于报警状态 以及报警起始时间和计入的命中次数
+func (s *alarmState) evaluate(r *AlarmRule, dataTime time.Time, hit bool) (bool, time.Time, int) {
+
+	if r.MaxGapMin > 0 && !time.Time(s.LastTime).IsZero() && dataTime.Sub(time.Time(s.LastTime)).Minutes() > r.MaxGapMin {
+		s.Streak = 0
+		s.Window = nil
+	}
+	s.LastTime = util.Time(dataTime)
+
+	if hit {
+		if s.Streak == 0 {
+			s.StreakBegin = util.Time(dataTime)
+		}
+		s.Streak++
+		s.LastHit = util.Time(dataTime)
+	} else {
+		s.Streak = 0
+	}
+
+	switch r.Type {
+	case ALARM_CONSECUTIVE:
+		return s.Streak >= r.N, time.Time(s.StreakBegin), s.Streak
+	case ALARM_DURATION:
+		return s.Streak > 0 && dataTime.Sub(time.Time(s.StreakBegin)).Minutes() >= r.DurationMin, time.Time(s.StreakBegin), s.Streak
+	case ALARM_M_OF_N:
+		s.Window = append(s.Window, &alarmOutcome{Time: util.Time(dataTime), Hit: hit})
+		if len(s.Window) > r.N {
+			s.Window = s.Window[len(s.Window)-r.N:]
+		}
+		count := 0
+		var begin time.Time
+		for _, o := range s.Window {
+			if o.Hit {
+				if count == 0 {
+					begin = time.Time(o.Time)
+				}
+				count++
+			}
+		}
+		return count >= r.M, begin, count
+	}
+
+	return false, time.Time{}, 0
+}
+
+type alarmProcessor struct {
+	dataprocess.BaseDataProcessor
+	DataTypes []string     `json:"dataTypes,omitempty"`
+	Rules     []*AlarmRule `json:"rules"`
+}
+
+func (p *alarmProcessor) Validate(siteID string) error {
+	for _, r := range p.Rules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *alarmProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload) (bool, error) {
+
+	if len(p.DataTypes) > 0 {
+		dtChecked := false
+		for _, dt := range p.DataTypes {
+			if dt == entry.GetDataType() {
+				dtChecked = true
+				break
+			}
+		}
+		if !dtChecked {
+			return false, nil
+		}
+	}
+
+	dataTime := time.Time(entry.GetDataTime())
+	//以进入本处理器时的标记判断 避免前面规则改标记影响后续规则
+	entryFlag := entry.GetFlag()
+
+	for _, r := range p.Rules {
+		if err := r.validate(); err != nil {
+			return false, err
+		}
+
+		var state *alarmState
+		if uploader.IsDryRun() {
+			//模拟处理自空状态开始 不读写状态表及事件表
+			state = uploader.GetDryRunState(fmt.Sprintf("alarm:%s:%s:%d:%d", r.Name, entry.GetDataType(), entry.GetStationID(), entry.GetMonitorID()), func() interface{} { return new(alarmState) }).(*alarmState)
+		} else {
+			var err error
+			state, err = getAlarmState(siteID, txn, r.Name, entry.GetDataType(), entry.GetStationID(), entry.GetMonitorID())
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if !dataTime.After(time.Time(state.LastTime)) {
+			//历史或重复数据不改变报警状态
+			log.Println("alarm skip old data: ", siteID, r.Name, entry.GetStationID(), entry.GetMonitorID(), entry.GetDataTime(), state.LastTime)
+			continue
+		}
+
+		hit := entryFlag == r.SourceFlag
+		value, _ := getProcessValue(entry)
+
+		alarming, begin, hits := state.evaluate(r, dataTime, hit)
+
+		if uploader.IsDryRun() {
+			if alarming && hit && r.Flag != "" {
+				if err := ChangeFlag(siteID, entry, r.Flag, -1); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+
+		if alarming {
+			if state.EventID == 0 {
+				event := &AlarmEvent{
+					Rule:      r.Name,
+					DataType:  entry.GetDataType(),
+					StationID: entry.GetStationID(),
+					MonitorID: entry.GetMonitorID(),
+					Flag:      r.SourceFlag,
+					BeginTime: util.Time(begin),
+					Count:     hits,
+					MaxValue:  value,
+				}
+				if err := event.add(siteID, txn); err != nil {
+					return false, err
+				}
+				state.EventID = event.ID
+				log.Println("alarm event begin: ", siteID, r.Name, entry.GetStationID(), entry.GetMonitorID(), begin)
+				notifyAlarmEvent(siteID, txn, event, entry)
+			} else if hit {
+				if err := updateAlarmEvent(siteID, txn, state.EventID, value, nil); err != nil {
+					return false, err
+				}
+			}
+			//m/n规则窗口内未命中的数据不改标记
+			if r.Flag != "" && hit {
+				if err := ChangeFlag(siteID, entry, r.Flag, -1); err != nil {
+					return false, err
+				}
+			}
+		} else if state.EventID > 0 {
+			end := time.Time(state.LastHit)
+			if err := updateAlarmEvent(siteID, txn, state.EventID, value, &end); err != nil {
+				return false, err
+			}
+			log.Println("alarm event end: ", siteID, r.Name, entry.GetStationID(), entry.GetMonitorID(), end)
+			if event, err := getAlarmEvent(siteID, txn, state.EventID); err != nil {
+				return false, err
+			} else if event != nil {
+				notifyAlarmEvent(siteID, txn, event, entry)
+			}
+			state.EventID = 0
+		}
+
+		if err := state.save(siteID, txn, r.Name, entry.GetDataType(), entry.GetStationID(), entry.GetMonitorID()); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+type AlarmEvent struct {
+	ID        int       `json:"ID"`
+	Rule      string    `json:"rule"`
+	DataType  string    `json:"dataType"`
+	StationID int       `json:"stationID"`
+	MonitorID int       `json:"monitorID"`
+	Flag      string    `json:"flag"`
+	BeginTime util.Time `json:"beginTime"`
+	EndTime   util.Time `json:"endTime"`
+	Count     int       `json:"count"`
+	MaxValue  float64   `json:"maxValue"`
+}
+
+func (e *AlarmEvent) GetStationID() int { return e.StationID }
+
+func (e *AlarmEvent) IsEnded() bool { return !time.Time(e.EndTime).IsZero() }
+
+var alarmEventHandlers = make([]func(siteID string, e *AlarmEvent, entry data.IData), 0)
+
+//报警事件开始及结束时回调 用于推送 须在init中注册
+func RegisterAlarmEventHandler(handler func(siteID string, e *AlarmEvent, entry data.IData)) {
+	alarmEventHandlers = append(alarmEventHandlers, handler)
+}
+
+//事务提交后通知 回滚的事件不推送
+func notifyAlarmEvent(siteID string, txn *sql.Tx, e *AlarmEvent, entry data.IData) {
+	datasource.AfterCommit(txn, func() {
+		for _, handler := range alarmEventHandlers {
+			go handler(siteID, e, entry)
+		}
+	})
+}
+
+func alarmEventTableName(siteID string) string {
+	return siteID + "_monitoralarmevent"
+}
+
+const alarmEventColumns = "alarmEvent.id, alarmEvent.rule, alarmEvent.data_type, alarmEvent.station_id, alarmEvent.monitor_id, alarmEvent.flag, alarmEvent.begin_time, alarmEvent.end_time, alarmEvent.count, alarmEvent.max_value"
+
+func (e *AlarmEvent) scan(rows *sql.Rows) error {
+	var beginTime time.Time
+	var endTime sql.NullTime
+	if err := rows.Scan(&e.ID, &e.Rule, &e.DataType, &e.StationID, &e.MonitorID, &e.Flag, &beginTime, &endTime, &e.Count, &e.MaxValue); err != nil {
+		return err
+	}
+	e.BeginTime = util.Time(beginTime)
+	if endTime.Valid {
+		e.EndTime = util.Time(endTime.Time)
+	}
+	return nil
+}
+
+func (e *AlarmEvent) add(siteID string, txn *sql.Tx) error {
+	if ret, err := txn.Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(rule,data_type,station_id,monitor_id,flag,begin_time,count,max_value)
+		VALUES
+			(?,?,?,?,?,?,?,?)
+	`, alarmEventTableName(siteID)), e.Rule, e.DataType, e.StationID, e.MonitorID, e.Flag, time.Time(e.BeginTime), e.Count, e.MaxValue); err != nil {
+		log.Println("error insert alarm event: ", err)
+		return err
+	} else if id, err := ret.LastInsertId(); err != nil {
+		log.Println("error insert alarm event: ", err)
+		return err
+	} else {
+		e.ID = int(id)
+	}
+
+	return nil
+}
+
+func getAlarmEvent(siteID string, txn *sql.Tx, ID int) (*AlarmEvent, error) {
+	rows, err := txn.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s alarmEvent
+		WHERE
+			alarmEvent.id = ?
+	`, alarmEventColumns, alarmEventTableName(siteID)), ID)
+	if err != nil {
+		log.Println("error get alarm event: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var e AlarmEvent
+		if err := e.scan(rows); err != nil {
+			log.Println("error get alarm event: ", err)
+			return nil, err
+		}
+		return &e, nil
+	}
+
+	return nil, nil
+}
+
+func updateAlarmEvent(siteID string, txn *sql.Tx, ID int, value float64, endTime *time.Time) error {
+	if endTime != nil {
+		if _, err := txn.Exec(fmt.Sprintf(`
+			UPDATE
+				%s
+			SET
+				end_time=?
+			WHERE
+				id = ?
+		`, alarmEventTableName(siteID)), *endTime, ID); err != nil {
+			log.Println("error end alarm event: ", err)
+			return err
+		}
+		return nil
+	}
+
+	if _, err := txn.Exec(fmt.Sprintf(`
+		UPDATE
+			%s
+		SET
+			count=count+1,max_value=GREATEST(max_value,?)
+		WHERE
+			id = ?
+	`, alarmEventTableName(siteID)), value, ID); err != nil {
+		log.Println("error update alarm event: ", err)
+		return err
+	}
+
+	return nil
+}
+
+//ongoing为true时仅返回未结束的报警事件
+func GetAlarmEvents(siteID string, stationID, monitorID []int, rule []string, beginTime, endTime *time.Time, ongoing bool) ([]*AlarmEvent, error) {
+
+	whereStmts := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	if len(stationID) > 0 {
+		if len(stationID) == 1 {
+			whereStmts = append(whereStmts, "alarmEvent.station_id = ?")
+			values = append(values, stationID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range stationID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("alarmEvent.station_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if len(monitorID) > 0 {
+		if len(monitorID) == 1 {
+			whereStmts = append(whereStmts, "alarmEvent.monitor_id = ?")
+			values = append(values, monitorID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range monitorID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("alarmEvent.monitor_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if len(rule) > 0 {
+		if len(rule) == 1 {
+			whereStmts = append(whereStmts, "alarmEvent.rule = ?")
+			values = append(values, rule[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, r := range rule {
+				placeholder = append(placeholder, "?")
+				values = append(values, r)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("alarmEvent.rule IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if endTime != nil {
+		whereStmts = append(whereStmts, "alarmEvent.begin_time < ?")
+		values = append(values, *endTime)
+	}
+
+	if beginTime != nil {
+		whereStmts = append(whereStmts, "(alarmEvent.end_time IS NULL OR alarmEvent.end_time >= ?)")
+		values = append(values, *beginTime)
+	}
+
+	if ongoing {
+		whereStmts = append(whereStmts, "alarmEvent.end_time IS NULL")
+	}
+
+	SQL := fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s alarmEvent
+	`, alarmEventColumns, alarmEventTableName(siteID))
+
+	if len(whereStmts) > 0 {
+		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
+	}
+
+	SQL += "\nORDER BY alarmEvent.begin_time DESC"
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
+	if err != nil {
+		log.Println("error get alarm event: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*AlarmEvent, 0)
+
+	for rows.Next() {
+		var e AlarmEvent
+		if err := e.scan(rows); err != nil {
+			log.Println("error get alarm event: ", err)
+			return nil, err
+		}
+		result = append(result, &e)
+	}
+
+	return result, nil
+}
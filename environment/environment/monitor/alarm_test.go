@@ -0,0 +1,109 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlarmRuleValidate(t *testing.T) {
+	cases := []struct {
+		rule   *AlarmRule
+		expect error
+	}{
+		{&AlarmRule{Type: ALARM_CONSECUTIVE, SourceFlag: "T", N: 3}, e_need_alarm_name},
+		{&AlarmRule{Name: "a", Type: ALARM_CONSECUTIVE, N: 3}, e_need_alarm_source_flag},
+		{&AlarmRule{Name: "a", Type: "unknown", SourceFlag: "T"}, e_invalid_alarm_type},
+		{&AlarmRule{Name: "a", Type: ALARM_CONSECUTIVE, SourceFlag: "T"}, e_invalid_alarm_count},
+		{&AlarmRule{Name: "a", Type: ALARM_CONSECUTIVE, SourceFlag: "T", N: 3}, nil},
+		{&AlarmRule{Name: "a", Type: ALARM_M_OF_N, SourceFlag: "T", M: 4, N: 3}, e_invalid_alarm_count},
+		{&AlarmRule{Name: "a", Type: ALARM_M_OF_N, SourceFlag: "T", M: 2, N: 3}, nil},
+		{&AlarmRule{Name: "a", Type: ALARM_DURATION, SourceFlag: "T"}, e_invalid_alarm_duration},
+		{&AlarmRule{Name: "a", Type: ALARM_DURATION, SourceFlag: "T", DurationMin: 30}, nil},
+	}
+
+	for i, c := range cases {
+		if err := c.rule.validate(); err != c.expect {
+			t.Errorf("case %d: validate = %v, expect %v", i, err, c.expect)
+		}
+	}
+}
+
+func TestAlarmStateEvaluate(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	at := func(min int) time.Time { return base.Add(time.Duration(min) * time.Minute) }
+
+	type step struct {
+		min      int
+		hit      bool
+		alarming bool
+		beginMin int
+		count    int
+	}
+
+	cases := []struct {
+		name  string
+		rule  *AlarmRule
+		steps []step
+	}{
+		{
+			name: "consecutive",
+			rule: &AlarmRule{Type: ALARM_CONSECUTIVE, N: 3},
+			steps: []step{
+				{0, true, false, 0, 1},
+				{10, true, false, 0, 2},
+				{20, false, false, 0, 0},
+				{30, true, false, 30, 1},
+				{40, true, false, 30, 2},
+				{50, true, true, 30, 3},
+				{60, true, true, 30, 4},
+				{70, false, false, 30, 0},
+			},
+		},
+		{
+			name: "consecutive broken by gap",
+			rule: &AlarmRule{Type: ALARM_CONSECUTIVE, N: 2, MaxGapMin: 30},
+			steps: []step{
+				{0, true, false, 0, 1},
+				{60, true, false, 60, 1},
+				{70, true, true, 60, 2},
+			},
+		},
+		{
+			name: "m of n",
+			rule: &AlarmRule{Type: ALARM_M_OF_N, M: 2, N: 3},
+			steps: []step{
+				{0, true, false, 0, 1},
+				{10, false, false, 0, 1},
+				{20, true, true, 0, 2},
+				{30, false, false, 20, 1},
+				{40, true, true, 20, 2},
+				{50, false, false, 40, 1},
+				{60, false, false, 40, 1},
+			},
+		},
+		{
+			name: "duration",
+			rule: &AlarmRule{Type: ALARM_DURATION, DurationMin: 30},
+			steps: []step{
+				{0, true, false, 0, 1},
+				{10, true, false, 0, 2},
+				{20, true, false, 0, 3},
+				{30, true, true, 0, 4},
+				{40, false, false, 0, 0},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		state := new(alarmState)
+		for i, s := range c.steps {
+			alarming, begin, count := state.evaluate(c.rule, at(s.min), s.hit)
+			if alarming != s.alarming || count != s.count {
+				t.Errorf("%s step %d: alarming %v count %d, expect %v %d", c.name, i, alarming, count, s.alarming, s.count)
+			}
+			if count > 0 && !begin.Equal(at(s.beginMin)) {
+				t.Errorf("%s step %d: begin %v, expect %v", c.name, i, begin, at(s.beginMin))
+			}
+		}
+	}
+}
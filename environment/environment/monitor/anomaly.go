@@ -0,0 +1,390 @@
+package monitor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+)
+
+const (
+	ANOMALY_RATE_OF_CHANGE = "rateOfChange"
+	ANOMALY_ZSCORE         = "zscore"
+	ANOMALY_HAMPEL         = "hampel"
+	ANOMALY_CORRELATION    = "correlation"
+)
+
+var e_need_anomaly_flag = errors.New("需要异常标记")
+
+const hampelScale = 1.4826
+const anomalyMinSamples = 3
+
+func init() {
+	dataprocess.Register("anomaly", func() dataprocess.IDataProcessor { return new(anomalyProcessor) })
+}
+
+//滚动窗口状态仅保存在内存 按站点/监测点/监测物/数据类型区分
+var anomalyStates = make(map[string]*anomalySiteState)
+var anomalyStatesLock sync.RWMutex
+
+type anomalyKey struct {
+	dataType  string
+	stationID int
+	monitorID int
+}
+
+type anomalySiteState struct {
+	lock   sync.Mutex
+	states map[anomalyKey]*anomalyState
+}
+
+type anomalyState struct {
+	times  []time.Time
+	values []float64
+	pairs  map[int][][2]float64
+}
+
+func newAnomalyState() *anomalyState {
+	return &anomalyState{pairs: make(map[int][][2]float64)}
+}
+
+func getAnomalyState(siteID string, key anomalyKey) (*anomalySiteState, *anomalyState) {
+	anomalyStatesLock.RLock()
+	siteState, exists := anomalyStates[siteID]
+	anomalyStatesLock.RUnlock()
+
+	if !exists {
+		anomalyStatesLock.Lock()
+		siteState, exists = anomalyStates[siteID]
+		if !exists {
+			siteState = new(anomalySiteState)
+			siteState.states = make(map[anomalyKey]*anomalyState)
+			anomalyStates[siteID] = siteState
+		}
+		anomalyStatesLock.Unlock()
+	}
+
+	siteState.lock.Lock()
+
+	state, exists := siteState.states[key]
+	if !exists {
+		state = newAnomalyState()
+		siteState.states[key] = state
+	}
+
+	return siteState, state
+}
+
+type anomalyMethod struct {
+	Method           string  `json:"method"`
+	Window           int     `json:"window,omitempty"`
+	Threshold        float64 `json:"threshold,omitempty"`
+	MaxChange        float64 `json:"maxChange,omitempty"`
+	MaxChangeRatio   float64 `json:"maxChangeRatio,omitempty"`
+	MaxGapMin        float64 `json:"maxGapMin,omitempty"`
+	RelatedMonitorID int     `json:"relatedMonitorID,omitempty"`
+	MinCorrelation   float64 `json:"minCorrelation,omitempty"`
+}
+
+type anomalyProcessor struct {
+	dataprocess.BaseDataProcessor
+	Flag      string           `json:"flag"`
+	DataTypes []string         `json:"dataTypes,omitempty"`
+	Methods   []*anomalyMethod `json:"methods"`
+}
+
+func (p *anomalyProcessor) window() int {
+	result := 0
+	for _, m := range p.Methods {
+		if m.Window > result {
+			result = m.Window
+		}
+	}
+	if result < anomalyMinSamples {
+		result = anomalyMinSamples
+	}
+	return result
+}
+
+func (p *anomalyProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload) (bool, error) {
+
+	if len(p.DataTypes) > 0 {
+		dtChecked := false
+		for _, dt := range p.DataTypes {
+			if dt == entry.GetDataType() {
+				dtChecked = true
+				break
+			}
+		}
+		if !dtChecked {
+			return false, nil
+		}
+	}
+
+	if p.Flag == "" {
+		return false, e_need_anomaly_flag
+	}
+
+	if CheckFlag(FLAG_MANUAL, entry.GetFlagBit()) {
+		return false, nil
+	}
+
+	value, err := getProcessValue(entry)
+	if err != nil {
+		return false, err
+	}
+
+	dataTime := time.Time(entry.GetDataTime())
+
+	related := make(map[int]float64)
+	for _, m := range p.Methods {
+		if m.Method == ANOMALY_CORRELATION && m.RelatedMonitorID > 0 {
+			if v, exists := getRelatedValue(entry, m.RelatedMonitorID, uploader); exists {
+				related[m.RelatedMonitorID] = v
+			}
+		}
+	}
+
+	var state *anomalyState
+	if uploader.IsDryRun() {
+		//模拟处理使用本次模拟内的窗口 不影响实际检测
+		state = uploader.GetDryRunState(fmt.Sprintf("anomaly:%s:%d:%d", entry.GetDataType(), entry.GetStationID(), entry.GetMonitorID()), func() interface{} { return newAnomalyState() }).(*anomalyState)
+	} else {
+		siteState, s := getAnomalyState(siteID, anomalyKey{dataType: entry.GetDataType(), stationID: entry.GetStationID(), monitorID: entry.GetMonitorID()})
+		defer siteState.lock.Unlock()
+		state = s
+	}
+
+	if len(state.times) > 0 {
+		last := state.times[len(state.times)-1]
+		if dataTime.Before(last) {
+			//历史数据不参与滚动检测
+			return false, nil
+		}
+		if dataTime.Equal(last) {
+			state.times = state.times[:len(state.times)-1]
+			state.values = state.values[:len(state.values)-1]
+			for mid, pairs := range state.pairs {
+				if len(pairs) > 0 {
+					state.pairs[mid] = pairs[:len(pairs)-1]
+				}
+			}
+		}
+	}
+
+	isAnomaly := false
+	for _, m := range p.Methods {
+		if m.check(state, dataTime, value, related) {
+			log.Println("anomaly detected: ", siteID, m.Method, entry.GetStationID(), entry.GetMonitorID(), entry.GetDataTime(), value)
+			isAnomaly = true
+			break
+		}
+	}
+
+	window := p.window()
+	state.times = append(state.times, dataTime)
+	state.values = append(state.values, value)
+	if len(state.values) > window {
+		state.times = state.times[len(state.times)-window:]
+		state.values = state.values[len(state.values)-window:]
+	}
+	for mid, v := range related {
+		pairs := append(state.pairs[mid], [2]float64{v, value})
+		if len(pairs) > window {
+			pairs = pairs[len(pairs)-window:]
+		}
+		state.pairs[mid] = pairs
+	}
+
+	if isAnomaly {
+		if err := ChangeFlag(siteID, entry, p.Flag, -1); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+func getProcessValue(entry data.IData) (float64, error) {
+	if absctractData, ok := entry.(data.IInterval); ok {
+		return absctractData.GetAvg(), nil
+	} else if absctractData, ok := entry.(data.IRealTime); ok {
+		return absctractData.GetRtd(), nil
+	}
+
+	log.Printf("error data with unknown interface: %t", entry)
+	return 0, errors.New("未知数据接口类型")
+}
+
+func getRelatedValue(entry data.IData, monitorID int, uploader *dataprocess.Uploader) (float64, bool) {
+	if uploader == nil {
+		return 0, false
+	}
+
+	uploaded, _, lock := uploader.GetUploadCache()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	stations, exists := uploaded[entry.GetDataType()]
+	if !exists {
+		return 0, false
+	}
+	monitors, exists := stations[entry.GetStationID()]
+	if !exists {
+		return 0, false
+	}
+	times, exists := monitors[monitorID]
+	if !exists {
+		return 0, false
+	}
+	for t, d := range times {
+		if t.Equal(time.Time(entry.GetDataTime())) {
+			v, err := getProcessValue(d)
+			if err != nil {
+				return 0, false
+			}
+			return v, true
+		}
+	}
+
+	return 0, false
+}
+
+func (m *anomalyMethod) recent(state *anomalyState) []float64 {
+	if m.Window > 0 && len(state.values) > m.Window {
+		return state.values[len(state.values)-m.Window:]
+	}
+	return state.values
+}
+
+func (m *anomalyMethod) check(state *anomalyState, dataTime time.Time, value float64, related map[int]float64) bool {
+	switch m.Method {
+	case ANOMALY_RATE_OF_CHANGE:
+		if len(state.values) == 0 {
+			return false
+		}
+		prev := state.values[len(state.values)-1]
+		if m.MaxGapMin > 0 && dataTime.Sub(state.times[len(state.times)-1]).Minutes() > m.MaxGapMin {
+			return false
+		}
+		change := math.Abs(value - prev)
+		if m.MaxChange > 0 && change > m.MaxChange {
+			return true
+		}
+		if m.MaxChangeRatio > 0 && prev != 0 && change/math.Abs(prev) > m.MaxChangeRatio {
+			return true
+		}
+	case ANOMALY_ZSCORE:
+		values := m.recent(state)
+		if len(values) < anomalyMinSamples || m.Threshold <= 0 {
+			return false
+		}
+		mean, std := meanStd(values)
+		if std == 0 {
+			return false
+		}
+		return math.Abs(value-mean)/std > m.Threshold
+	case ANOMALY_HAMPEL:
+		values := m.recent(state)
+		if len(values) < anomalyMinSamples || m.Threshold <= 0 {
+			return false
+		}
+		med := median(values)
+		deviations := make([]float64, len(values))
+		for i, v := range values {
+			deviations[i] = math.Abs(v - med)
+		}
+		mad := hampelScale * median(deviations)
+		if mad == 0 {
+			return false
+		}
+		return math.Abs(value-med) > m.Threshold*mad
+	case ANOMALY_CORRELATION:
+		x, exists := related[m.RelatedMonitorID]
+		if !exists || m.Threshold <= 0 {
+			return false
+		}
+		pairs := state.pairs[m.RelatedMonitorID]
+		if m.Window > 0 && len(pairs) > m.Window {
+			pairs = pairs[len(pairs)-m.Window:]
+		}
+		if len(pairs) < anomalyMinSamples {
+			return false
+		}
+		intercept, slope, r, residualStd := regress(pairs)
+		if math.Abs(r) < m.MinCorrelation || residualStd == 0 {
+			return false
+		}
+		return math.Abs(value-(intercept+slope*x))/residualStd > m.Threshold
+	}
+
+	return false
+}
+
+func meanStd(values []float64) (mean, std float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	for _, v := range values {
+		std += (v - mean) * (v - mean)
+	}
+	std = math.Sqrt(std / float64(len(values)))
+
+	return
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+//以关联监测物为自变量做线性回归 返回截距、斜率、相关系数及残差标准差
+func regress(pairs [][2]float64) (intercept, slope, r, residualStd float64) {
+	n := float64(len(pairs))
+
+	var sumX, sumY float64
+	for _, p := range pairs {
+		sumX += p[0]
+		sumY += p[1]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var sxx, syy, sxy float64
+	for _, p := range pairs {
+		sxx += (p[0] - meanX) * (p[0] - meanX)
+		syy += (p[1] - meanY) * (p[1] - meanY)
+		sxy += (p[0] - meanX) * (p[1] - meanY)
+	}
+
+	if sxx == 0 || syy == 0 {
+		return meanY, 0, 0, 0
+	}
+
+	slope = sxy / sxx
+	intercept = meanY - slope*meanX
+	r = sxy / math.Sqrt(sxx*syy)
+
+	for _, p := range pairs {
+		residual := p[1] - (intercept + slope*p[0])
+		residualStd += residual * residual
+	}
+	residualStd = math.Sqrt(residualStd / n)
+
+	return
+}
@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeanStdMedian(t *testing.T) {
+	cases := []struct {
+		values []float64
+		mean   float64
+		std    float64
+		median float64
+	}{
+		{[]float64{5}, 5, 0, 5},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, 2, 4.5},
+		{[]float64{3, 1, 2}, 2, math.Sqrt(2.0 / 3), 2},
+	}
+
+	for _, c := range cases {
+		mean, std := meanStd(c.values)
+		if math.Abs(mean-c.mean) > 1e-9 || math.Abs(std-c.std) > 1e-9 {
+			t.Errorf("meanStd(%v) = %v, %v, expect %v, %v", c.values, mean, std, c.mean, c.std)
+		}
+		if m := median(c.values); m != c.median {
+			t.Errorf("median(%v) = %v, expect %v", c.values, m, c.median)
+		}
+	}
+
+	//不改变原顺序
+	values := []float64{3, 1, 2}
+	median(values)
+	if values[0] != 3 || values[1] != 1 || values[2] != 2 {
+		t.Error("median sorted input in place: ", values)
+	}
+}
+
+func TestRegress(t *testing.T) {
+	intercept, slope, r, residualStd := regress([][2]float64{{1, 3}, {2, 5}, {3, 7}, {4, 9}})
+	if math.Abs(intercept-1) > 1e-9 || math.Abs(slope-2) > 1e-9 || math.Abs(r-1) > 1e-9 || residualStd > 1e-9 {
+		t.Errorf("regress exact line = %v %v %v %v", intercept, slope, r, residualStd)
+	}
+
+	//自变量不变时无法回归
+	intercept, slope, r, residualStd = regress([][2]float64{{1, 3}, {1, 5}, {1, 7}})
+	if intercept != 5 || slope != 0 || r != 0 || residualStd != 0 {
+		t.Errorf("regress constant x = %v %v %v %v", intercept, slope, r, residualStd)
+	}
+}
+
+func TestAnomalyCheck(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	newState := func(values ...float64) *anomalyState {
+		state := &anomalyState{pairs: make(map[int][][2]float64)}
+		for i, v := range values {
+			state.times = append(state.times, base.Add(time.Duration(i)*time.Hour))
+			state.values = append(state.values, v)
+		}
+		return state
+	}
+	next := func(state *anomalyState) time.Time {
+		return base.Add(time.Duration(len(state.values)) * time.Hour)
+	}
+
+	correlated := newState(3, 5, 7, 9, 11)
+	for i := range correlated.values {
+		correlated.pairs[2] = append(correlated.pairs[2], [2]float64{float64(i + 1), correlated.values[i] + float64(i%2)*0.2})
+	}
+
+	cases := []struct {
+		name    string
+		method  *anomalyMethod
+		state   *anomalyState
+		value   float64
+		gap     time.Duration
+		related map[int]float64
+		expect  bool
+	}{
+		{"rate no history", &anomalyMethod{Method: ANOMALY_RATE_OF_CHANGE, MaxChange: 1}, newState(), 100, 0, nil, false},
+		{"rate within change", &anomalyMethod{Method: ANOMALY_RATE_OF_CHANGE, MaxChange: 5}, newState(10), 14, 0, nil, false},
+		{"rate exceeds change", &anomalyMethod{Method: ANOMALY_RATE_OF_CHANGE, MaxChange: 5}, newState(10), 16, 0, nil, true},
+		{"rate exceeds ratio", &anomalyMethod{Method: ANOMALY_RATE_OF_CHANGE, MaxChangeRatio: 0.5}, newState(10), 4, 0, nil, true},
+		{"rate gap too long", &anomalyMethod{Method: ANOMALY_RATE_OF_CHANGE, MaxChange: 5, MaxGapMin: 60}, newState(10), 100, 2 * time.Hour, nil, false},
+		{"zscore insufficient samples", &anomalyMethod{Method: ANOMALY_ZSCORE, Threshold: 1}, newState(1, 2), 100, 0, nil, false},
+		{"zscore constant window", &anomalyMethod{Method: ANOMALY_ZSCORE, Threshold: 1}, newState(5, 5, 5), 100, 0, nil, false},
+		{"zscore normal", &anomalyMethod{Method: ANOMALY_ZSCORE, Threshold: 3}, newState(2, 4, 4, 4, 5, 5, 7, 9), 10, 0, nil, false},
+		{"zscore outlier", &anomalyMethod{Method: ANOMALY_ZSCORE, Threshold: 3}, newState(2, 4, 4, 4, 5, 5, 7, 9), 12, 0, nil, true},
+		{"zscore window ignores old values", &anomalyMethod{Method: ANOMALY_ZSCORE, Threshold: 3, Window: 3}, newState(1000, 4, 5, 6), 9, 0, nil, true},
+		{"hampel normal", &anomalyMethod{Method: ANOMALY_HAMPEL, Threshold: 3}, newState(10, 11, 9, 10, 12), 12, 0, nil, false},
+		{"hampel outlier", &anomalyMethod{Method: ANOMALY_HAMPEL, Threshold: 3}, newState(10, 11, 9, 10, 12), 20, 0, nil, true},
+		{"correlation without related value", &anomalyMethod{Method: ANOMALY_CORRELATION, Threshold: 3, RelatedMonitorID: 2}, correlated, 100, 0, nil, false},
+		{"correlation follows related", &anomalyMethod{Method: ANOMALY_CORRELATION, Threshold: 3, RelatedMonitorID: 2, MinCorrelation: 0.9}, correlated, 13, 0, map[int]float64{2: 6}, false},
+		{"correlation deviates", &anomalyMethod{Method: ANOMALY_CORRELATION, Threshold: 3, RelatedMonitorID: 2, MinCorrelation: 0.9}, correlated, 20, 0, map[int]float64{2: 6}, true},
+		{"unknown method", &anomalyMethod{Method: "unknown"}, newState(1, 2, 3), 100, 0, nil, false},
+	}
+
+	for _, c := range cases {
+		dataTime := next(c.state).Add(c.gap)
+		if result := c.method.check(c.state, dataTime, c.value, c.related); result != c.expect {
+			t.Errorf("%s: check = %v, expect %v", c.name, result, c.expect)
+		}
+	}
+}
+
+func TestAnomalyWindow(t *testing.T) {
+	p := &anomalyProcessor{Methods: []*anomalyMethod{{Window: 2}}}
+	if w := p.window(); w != anomalyMinSamples {
+		t.Errorf("window = %d, expect at least %d", w, anomalyMinSamples)
+	}
+	p.Methods = append(p.Methods, &anomalyMethod{Window: 24})
+	if w := p.window(); w != 24 {
+		t.Errorf("window = %d, expect 24", w)
+	}
+}
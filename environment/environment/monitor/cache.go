@@ -4,6 +4,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 )
 
 var siteCache = make(map[string]*sitePool)
@@ -19,7 +20,7 @@ type sitePool struct {
 	codeIdLock      sync.RWMutex
 	codeIdMap       map[int]*MonitorCode
 	flagLimitLock   sync.RWMutex
-	flagLimitMap    map[int]map[int]map[string]*FlagLimit
+	flagLimitMap    map[int]map[int]map[string][]*FlagLimit
 }
 
 func getCacheSitePool(siteID string) *sitePool {
@@ -41,7 +42,7 @@ func getCacheSitePool(siteID string) *sitePool {
 			siteP.codeMap = make(map[int]map[string]*MonitorCode)
 			siteP.monitorCodeMap = make(map[int]map[int]*MonitorCode)
 			siteP.codeIdMap = make(map[int]*MonitorCode)
-			siteP.flagLimitMap = make(map[int]map[int]map[string]*FlagLimit)
+			siteP.flagLimitMap = make(map[int]map[int]map[string][]*FlagLimit)
 		}
 	}
 	return siteP
@@ -135,20 +136,20 @@ func (p *sitePool) loadFlagLimit(siteID string) error {
 	p.flagLimitLock.Lock()
 	defer p.flagLimitLock.Unlock()
 
-	p.flagLimitMap = make(map[int]map[int]map[string]*FlagLimit)
+	p.flagLimitMap = make(map[int]map[int]map[string][]*FlagLimit)
 
 	for _, m := range limitList {
 		stationMapping, exists := p.flagLimitMap[m.StationID]
 		if !exists {
-			stationMapping = make(map[int]map[string]*FlagLimit)
+			stationMapping = make(map[int]map[string][]*FlagLimit)
 			p.flagLimitMap[m.StationID] = stationMapping
 		}
 		monitorMapping, exists := stationMapping[m.MonitorID]
 		if !exists {
-			monitorMapping = make(map[string]*FlagLimit)
+			monitorMapping = make(map[string][]*FlagLimit)
 			stationMapping[m.MonitorID] = monitorMapping
 		}
-		monitorMapping[m.Flag] = m
+		monitorMapping[m.Flag] = append(monitorMapping[m.Flag], m)
 	}
 
 	log.Println("load monitor limit done: ", len(p.flagLimitMap))
@@ -231,11 +232,16 @@ func GetMonitor(siteID string, monitorID int) *Monitor {
 	return siteP.monitorMap[monitorID]
 }
 
-func GetFlagLimit(siteID string, stationID, monitorID int, flag string) (result *FlagLimit) {
+func GetFlagLimit(siteID string, stationID, monitorID int, flag string) *FlagLimit {
+	return GetFlagLimitAt(siteID, stationID, monitorID, flag, time.Now())
+}
+
+//同一标记可有多个版本及多条按时段生效的限值 仅取数据时间所在版本 带时段的优先于全时段的
+func GetFlagLimitAt(siteID string, stationID, monitorID int, flag string, t time.Time) (result *FlagLimit) {
 
 	defer func() {
 		if result == nil && stationID > 0 {
-			result = GetFlagLimit(siteID, 0, monitorID, flag)
+			result = GetFlagLimitAt(siteID, 0, monitorID, flag, t)
 		}
 	}()
 
@@ -248,8 +254,24 @@ func GetFlagLimit(siteID string, stationID, monitorID int, flag string) (result
 	if exists {
 		monitorMapping, exist := stationMapping[monitorID]
 		if exist {
-			if entry, exists := monitorMapping[flag]; exists {
-				result = entry
+			var fallback *FlagLimit
+			for _, entry := range monitorMapping[flag] {
+				if !entry.IsEffective(t) {
+					continue
+				}
+				if !entry.IsScheduled() {
+					if fallback == nil {
+						fallback = entry
+					}
+					continue
+				}
+				if entry.IsInEffect(t) {
+					result = entry
+					return
+				}
+			}
+			if fallback != nil {
+				result = fallback
 				return
 			}
 		}
@@ -0,0 +1,611 @@
+package monitor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/site/initialization"
+)
+
+const (
+	CALIBRATION_ZERO_SPAN   = "zeroSpan"
+	CALIBRATION_MULTI_POINT = "multiPoint"
+)
+
+const maxCalibrationDegree = 3
+
+var e_need_calibration_time = errors.New("需要校准时间")
+var e_invalid_calibration_type = errors.New("校准类型不正确")
+var e_invalid_calibration_span = errors.New("跨度标准值需不同于零点标准值且响应值不同于零点响应值")
+var e_invalid_calibration_points = errors.New("多点校准点数不足")
+var e_invalid_calibration_degree = errors.New("校准曲线阶数不正确")
+var e_calibration_curve_singular = errors.New("校准点无法拟合曲线")
+
+func init() {
+	dataprocess.Register("drift", func() dataprocess.IDataProcessor { return new(driftProcessor) })
+	dataprocess.Register("calibration", func() dataprocess.IDataProcessor { return new(calibrationProcessor) })
+	initialization.RegisterMigrations(MODULE_MONITOR, "monitor", calibrationMigration)
+}
+
+type CalibrationPoint struct {
+	Reference float64 `json:"reference"`
+	Response  float64 `json:"response"`
+}
+
+//零点/跨度核查记录仪器响应与标准值 多点校准按校准点拟合 响应值->标准值 的多项式
+type Calibration struct {
+	ID              int                 `json:"ID"`
+	StationID       int                 `json:"stationID"`
+	MonitorID       int                 `json:"monitorID"`
+	Type            string              `json:"type"`
+	CalibrationTime util.Time           `json:"calibrationTime"`
+	ZeroReference   float64             `json:"zeroReference"`
+	ZeroResponse    float64             `json:"zeroResponse"`
+	SpanReference   float64             `json:"spanReference"`
+	SpanResponse    float64             `json:"spanResponse"`
+	Points          []*CalibrationPoint `json:"points"`
+	Degree          int                 `json:"degree"`
+	Coefficients    []float64           `json:"coefficients"`
+	Remark          string              `json:"remark"`
+}
+
+const calibrationColumns = "calibration.id, calibration.station_id, calibration.monitor_id, calibration.type, calibration.calibration_time, calibration.zero_reference, calibration.zero_response, calibration.span_reference, calibration.span_response, calibration.points, calibration.degree, calibration.coefficients, calibration.remark"
+
+func calibrationTableName(siteID string) string {
+	return siteID + "_monitorcalibration"
+}
+
+//校准记录表
+var calibrationMigration = &initialization.Migration{
+	Version:     4,
+	Description: "校准记录",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_monitorcalibration (
+			id INT NOT NULL AUTO_INCREMENT,
+			station_id INT NOT NULL,
+			monitor_id INT NOT NULL,
+			type VARCHAR(32) NOT NULL,
+			calibration_time DATETIME NOT NULL,
+			zero_reference DOUBLE NOT NULL DEFAULT 0,
+			zero_response DOUBLE NOT NULL DEFAULT 0,
+			span_reference DOUBLE NOT NULL DEFAULT 0,
+			span_response DOUBLE NOT NULL DEFAULT 0,
+			points TEXT,
+			degree INT NOT NULL DEFAULT 0,
+			coefficients TEXT,
+			remark VARCHAR(255) NOT NULL DEFAULT '',
+			PRIMARY KEY (id),
+			KEY (station_id, monitor_id, type, calibration_time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+func (c *Calibration) scan(rows *sql.Rows) error {
+	var points, coefficients string
+	var calibrationTime time.Time
+	if err := rows.Scan(&c.ID, &c.StationID, &c.MonitorID, &c.Type, &calibrationTime, &c.ZeroReference, &c.ZeroResponse, &c.SpanReference, &c.SpanResponse, &points, &c.Degree, &coefficients, &c.Remark); err != nil {
+		return err
+	}
+	c.CalibrationTime = util.Time(calibrationTime)
+	if points != "" {
+		if err := json.Unmarshal([]byte(points), &c.Points); err != nil {
+			return err
+		}
+	}
+	if coefficients != "" {
+		if err := json.Unmarshal([]byte(coefficients), &c.Coefficients); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//仪器响应 = a + b * 标准值
+func (c *Calibration) responseLine() (float64, float64) {
+	b := (c.SpanResponse - c.ZeroResponse) / (c.SpanReference - c.ZeroReference)
+	return c.ZeroResponse - b*c.ZeroReference, b
+}
+
+func (c *Calibration) Correct(response float64) float64 {
+	result := 0.0
+	for i := len(c.Coefficients) - 1; i >= 0; i-- {
+		result = result*response + c.Coefficients[i]
+	}
+	return result
+}
+
+func (c *Calibration) Validate(siteID string) error {
+	if c.MonitorID <= 0 {
+		return e_need_monitor_id
+	}
+	if time.Time(c.CalibrationTime).IsZero() {
+		return e_need_calibration_time
+	}
+
+	switch c.Type {
+	case CALIBRATION_ZERO_SPAN:
+		if c.SpanReference == c.ZeroReference || c.SpanResponse == c.ZeroResponse {
+			return e_invalid_calibration_span
+		}
+		c.Points = nil
+		c.Degree = 0
+		c.Coefficients = nil
+	case CALIBRATION_MULTI_POINT:
+		if c.Degree == 0 {
+			c.Degree = 1
+		}
+		if c.Degree < 0 || c.Degree > maxCalibrationDegree {
+			return e_invalid_calibration_degree
+		}
+		if len(c.Points) <= c.Degree {
+			return e_invalid_calibration_points
+		}
+		coefficients, err := fitPolynomial(c.Points, c.Degree)
+		if err != nil {
+			return err
+		}
+		c.Coefficients = coefficients
+	default:
+		return e_invalid_calibration_type
+	}
+
+	return nil
+}
+
+func (c *Calibration) checkAuth(siteID string, actionAuth authority.ActionAuthSet) error {
+	if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{c.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
+		return err
+	} else if !filtered[c.StationID] {
+		return errors.New("无权限")
+	}
+	return nil
+}
+
+func (c *Calibration) Add(siteID string, actionAuth authority.ActionAuthSet) error {
+
+	if err := c.Validate(siteID); err != nil {
+		return err
+	}
+
+	if err := c.checkAuth(siteID, actionAuth); err != nil {
+		return err
+	}
+
+	points, _ := json.Marshal(c.Points)
+	coefficients, _ := json.Marshal(c.Coefficients)
+
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(station_id,monitor_id,type,calibration_time,zero_reference,zero_response,span_reference,span_response,points,degree,coefficients,remark)
+		VALUES
+			(?,?,?,?,?,?,?,?,?,?,?,?)
+	`, calibrationTableName(siteID)), c.StationID, c.MonitorID, c.Type, time.Time(c.CalibrationTime), c.ZeroReference, c.ZeroResponse, c.SpanReference, c.SpanResponse, string(points), c.Degree, string(coefficients), c.Remark); err != nil {
+		log.Println("error insert monitor calibration: ", err)
+		return err
+	} else if id, err := ret.LastInsertId(); err != nil {
+		log.Println("error insert monitor calibration: ", err)
+		return err
+	} else {
+		c.ID = int(id)
+	}
+
+	return nil
+}
+
+//返回修改前的记录 用于确定需重新校正的时段
+func (c *Calibration) Update(siteID string, actionAuth authority.ActionAuthSet) (*Calibration, error) {
+
+	if err := c.Validate(siteID); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkAuth(siteID, actionAuth); err != nil {
+		return nil, err
+	}
+
+	var prev *Calibration
+	var err error
+	err = datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		prev, err = getCalibrationWithTxn(siteID, txn, c.ID)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := prev.checkAuth(siteID, actionAuth); err != nil {
+			panic(err)
+		}
+
+		points, _ := json.Marshal(c.Points)
+		coefficients, _ := json.Marshal(c.Coefficients)
+
+		if _, err := txn.Exec(fmt.Sprintf(`
+			UPDATE
+				%s
+			SET
+				station_id=?,monitor_id=?,type=?,calibration_time=?,zero_reference=?,zero_response=?,span_reference=?,span_response=?,points=?,degree=?,coefficients=?,remark=?
+			WHERE
+				id = ?
+		`, calibrationTableName(siteID)), c.StationID, c.MonitorID, c.Type, time.Time(c.CalibrationTime), c.ZeroReference, c.ZeroResponse, c.SpanReference, c.SpanResponse, string(points), c.Degree, string(coefficients), c.Remark, c.ID); err != nil {
+			log.Println("error update monitor calibration: ", err)
+			panic(err)
+		}
+	})
+
+	return prev, err
+}
+
+//返回删除的记录 用于确定需重新校正的时段
+func (c *Calibration) Delete(siteID string, actionAuth authority.ActionAuthSet) (*Calibration, error) {
+
+	var prev *Calibration
+	var err error
+	err = datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		prev, err = getCalibrationWithTxn(siteID, txn, c.ID)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := prev.checkAuth(siteID, actionAuth); err != nil {
+			panic(err)
+		}
+
+		if _, err := txn.Exec(fmt.Sprintf(`
+			DELETE FROM
+				%s
+			WHERE
+				id = ?
+		`, calibrationTableName(siteID)), c.ID); err != nil {
+			log.Println("error delete monitor calibration: ", err)
+			panic(err)
+		}
+	})
+
+	return prev, err
+}
+
+func getCalibrationWithTxn(siteID string, txn *sql.Tx, ID int) (*Calibration, error) {
+	rows, err := txn.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s calibration
+		WHERE
+			calibration.id = ?
+		FOR UPDATE
+	`, calibrationColumns, calibrationTableName(siteID)), ID)
+	if err != nil {
+		log.Println("error get monitor calibration: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var c Calibration
+		if err := c.scan(rows); err != nil {
+			log.Println("error get monitor calibration: ", err)
+			return nil, err
+		}
+		return &c, nil
+	}
+
+	return nil, errors.New("校准记录不存在")
+}
+
+func GetCalibrations(siteID string, stationID, monitorID []int, calibrationType string, beginTime, endTime *time.Time) ([]*Calibration, error) {
+
+	whereStmts := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	if len(stationID) > 0 {
+		if len(stationID) == 1 {
+			whereStmts = append(whereStmts, "calibration.station_id = ?")
+			values = append(values, stationID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range stationID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("calibration.station_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if len(monitorID) > 0 {
+		if len(monitorID) == 1 {
+			whereStmts = append(whereStmts, "calibration.monitor_id = ?")
+			values = append(values, monitorID[0])
+		} else {
+			placeholder := make([]string, 0)
+			for _, id := range monitorID {
+				placeholder = append(placeholder, "?")
+				values = append(values, id)
+			}
+			whereStmts = append(whereStmts, fmt.Sprintf("calibration.monitor_id IN (%s)", strings.Join(placeholder, ",")))
+		}
+	}
+
+	if calibrationType != "" {
+		whereStmts = append(whereStmts, "calibration.type = ?")
+		values = append(values, calibrationType)
+	}
+
+	if beginTime != nil {
+		whereStmts = append(whereStmts, "calibration.calibration_time >= ?")
+		values = append(values, *beginTime)
+	}
+
+	if endTime != nil {
+		whereStmts = append(whereStmts, "calibration.calibration_time <= ?")
+		values = append(values, *endTime)
+	}
+
+	SQL := fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s calibration
+	`, calibrationColumns, calibrationTableName(siteID))
+
+	if len(whereStmts) > 0 {
+		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
+	}
+
+	SQL += "\nORDER BY calibration.calibration_time ASC, calibration.id ASC"
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
+	if err != nil {
+		log.Println("error get monitor calibration: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Calibration, 0)
+	for rows.Next() {
+		var c Calibration
+		if err := c.scan(rows); err != nil {
+			log.Println("error get monitor calibration: ", err)
+			return nil, err
+		}
+		result = append(result, &c)
+	}
+
+	return result, nil
+}
+
+//取数据时间前后最近的校准记录 before为时间不晚于t的最后一条 after为晚于t的第一条
+func getCalibrationAround(siteID string, txn *sql.Tx, calibrationType string, stationID, monitorID int, t time.Time) (before, after *Calibration, err error) {
+
+	query := func(cond, order string) (*Calibration, error) {
+		rows, err := txn.Query(fmt.Sprintf(`
+			SELECT
+				%s
+			FROM
+				%s calibration
+			WHERE
+				calibration.station_id = ? AND calibration.monitor_id = ? AND calibration.type = ? AND %s
+			ORDER BY
+				calibration.calibration_time %s, calibration.id %s
+			LIMIT 1
+		`, calibrationColumns, calibrationTableName(siteID), cond, order, order), stationID, monitorID, calibrationType, t)
+		if err != nil {
+			log.Println("error get monitor calibration: ", err)
+			return nil, err
+		}
+		defer rows.Close()
+
+		if rows.Next() {
+			var c Calibration
+			if err := c.scan(rows); err != nil {
+				log.Println("error get monitor calibration: ", err)
+				return nil, err
+			}
+			return &c, nil
+		}
+		return nil, nil
+	}
+
+	if before, err = query("calibration.calibration_time <= ?", "DESC"); err != nil {
+		return
+	}
+	after, err = query("calibration.calibration_time > ?", "ASC")
+	return
+}
+
+//校准记录变更影响的数据时段 自前一条同类记录至后一条同类记录 无后续记录时至今
+func GetCalibrationAffectedRange(siteID string, records ...*Calibration) (time.Time, time.Time, error) {
+
+	var beginTime, endTime time.Time
+
+	err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		for _, c := range records {
+			if c == nil {
+				continue
+			}
+			t := time.Time(c.CalibrationTime)
+			before, after, err := getCalibrationAround(siteID, txn, c.Type, c.StationID, c.MonitorID, t.Add(-time.Second))
+			if err != nil {
+				panic(err)
+			}
+			from := t
+			if before != nil {
+				from = time.Time(before.CalibrationTime)
+			}
+			to := time.Now()
+			for after != nil && after.ID == c.ID {
+				if _, after, err = getCalibrationAround(siteID, txn, c.Type, c.StationID, c.MonitorID, time.Time(after.CalibrationTime)); err != nil {
+					panic(err)
+				}
+			}
+			if after != nil {
+				to = time.Time(after.CalibrationTime)
+			}
+			if beginTime.IsZero() || from.Before(beginTime) {
+				beginTime = from
+			}
+			if endTime.IsZero() || to.After(endTime) {
+				endTime = to
+			}
+		}
+	}, &sql.TxOptions{ReadOnly: true})
+
+	return beginTime, endTime, err
+}
+
+//最小二乘拟合 系数按幂次升序
+func fitPolynomial(points []*CalibrationPoint, degree int) ([]float64, error) {
+	n := degree + 1
+
+	matrix := make([][]float64, n)
+	for i := range matrix {
+		matrix[i] = make([]float64, n+1)
+	}
+
+	for _, p := range points {
+		powers := make([]float64, 2*n-1)
+		powers[0] = 1
+		for i := 1; i < len(powers); i++ {
+			powers[i] = powers[i-1] * p.Response
+		}
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				matrix[i][j] += powers[i+j]
+			}
+			matrix[i][n] += powers[i] * p.Reference
+		}
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(matrix[row][col]) > math.Abs(matrix[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(matrix[pivot][col]) < 1e-12 {
+			return nil, e_calibration_curve_singular
+		}
+		matrix[col], matrix[pivot] = matrix[pivot], matrix[col]
+
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+			factor := matrix[row][col] / matrix[col][col]
+			for k := col; k <= n; k++ {
+				matrix[row][k] -= factor * matrix[col][k]
+			}
+		}
+	}
+
+	result := make([]float64, n)
+	for i := 0; i < n; i++ {
+		result[i] = matrix[i][n] / matrix[i][i]
+	}
+
+	return result, nil
+}
+
+//按前后两次零点/跨度核查结果线性插值仪器漂移 并据此还原标准值
+//ResetAfterCheck表示每次核查后仪器已调校 漂移自核查时刻由零开始累积至下次核查
+type driftProcessor struct {
+	dataprocess.BaseDataProcessor
+	ResetAfterCheck bool     `json:"resetAfterCheck"`
+	Fields          []string `json:"fields,omitempty"`
+}
+
+func (p *driftProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload) (bool, error) {
+
+	t := time.Time(entry.GetDataTime())
+
+	before, after, err := getCalibrationAround(siteID, txn, CALIBRATION_ZERO_SPAN, entry.GetStationID(), entry.GetMonitorID(), t)
+	if err != nil {
+		return false, err
+	}
+
+	a, b, ok := p.interpolate(before, after, t)
+	if !ok {
+		return false, nil
+	}
+
+	if err := modifyFields(entry, p.Fields, func(v float64) (float64, error) {
+		return (v - a) / b, nil
+	}); err != nil {
+		log.Println("error correct drift: ", siteID, entry.GetStationID(), entry.GetMonitorID(), err)
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (p *driftProcessor) interpolate(before, after *Calibration, t time.Time) (float64, float64, bool) {
+
+	if after == nil {
+		if before == nil || p.ResetAfterCheck {
+			return 0, 1, false
+		}
+		a, b := before.responseLine()
+		return a, b, true
+	}
+
+	a1, b1 := after.responseLine()
+
+	a0, b0 := 0.0, 1.0
+	if before != nil && !p.ResetAfterCheck {
+		a0, b0 = before.responseLine()
+	}
+
+	ratio := 1.0
+	if before != nil {
+		span := time.Time(after.CalibrationTime).Sub(time.Time(before.CalibrationTime))
+		if span > 0 {
+			ratio = float64(t.Sub(time.Time(before.CalibrationTime))) / float64(span)
+		}
+	}
+
+	a := a0 + (a1-a0)*ratio
+	b := b0 + (b1-b0)*ratio
+	if b == 0 {
+		return 0, 1, false
+	}
+
+	return a, b, true
+}
+
+//按数据时间前最近一次多点校准的曲线修正数值
+type calibrationProcessor struct {
+	dataprocess.BaseDataProcessor
+	Fields []string `json:"fields,omitempty"`
+}
+
+func (p *calibrationProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload) (bool, error) {
+
+	before, _, err := getCalibrationAround(siteID, txn, CALIBRATION_MULTI_POINT, entry.GetStationID(), entry.GetMonitorID(), time.Time(entry.GetDataTime()))
+	if err != nil {
+		return false, err
+	}
+
+	if before == nil || len(before.Coefficients) == 0 {
+		return false, nil
+	}
+
+	if err := modifyFields(entry, p.Fields, func(v float64) (float64, error) {
+		return before.Correct(v), nil
+	}); err != nil {
+		log.Println("error correct calibration: ", siteID, entry.GetStationID(), entry.GetMonitorID(), err)
+		return false, err
+	}
+
+	return false, nil
+}
@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"math"
+	"obsessiontech/common/util"
+	"testing"
+	"time"
+)
+
+func TestFitPolynomial(t *testing.T) {
+	points := func(f func(float64) float64, responses ...float64) []*CalibrationPoint {
+		result := make([]*CalibrationPoint, 0)
+		for _, r := range responses {
+			result = append(result, &CalibrationPoint{Reference: f(r), Response: r})
+		}
+		return result
+	}
+
+	cases := []struct {
+		name   string
+		points []*CalibrationPoint
+		degree int
+		expect []float64
+		err    error
+	}{
+		{"linear", points(func(r float64) float64 { return 1 + 2*r }, 0, 1, 2, 3), 1, []float64{1, 2}, nil},
+		{"quadratic", points(func(r float64) float64 { return 0.5 + 0.1*r*r }, 0, 1, 2, 3, 4), 2, []float64{0.5, 0, 0.1}, nil},
+		{"cubic", points(func(r float64) float64 { return 1 - r + 0.5*r*r*r }, -2, -1, 0, 1, 2), 3, []float64{1, -1, 0, 0.5}, nil},
+		{"least squares", []*CalibrationPoint{{0, 0}, {1, 1}, {3, 2}}, 1, []float64{-1.0 / 6, 1.5}, nil},
+		{"same response", points(func(r float64) float64 { return r }, 5, 5, 5), 1, nil, e_calibration_curve_singular},
+	}
+
+	for _, c := range cases {
+		coefficients, err := fitPolynomial(c.points, c.degree)
+		if err != c.err {
+			t.Errorf("%s: error %v, expect %v", c.name, err, c.err)
+			continue
+		}
+		if len(coefficients) != len(c.expect) {
+			t.Errorf("%s: coefficients %v, expect %v", c.name, coefficients, c.expect)
+			continue
+		}
+		for i := range coefficients {
+			if math.Abs(coefficients[i]-c.expect[i]) > 1e-9 {
+				t.Errorf("%s: coefficients %v, expect %v", c.name, coefficients, c.expect)
+				break
+			}
+		}
+	}
+
+	c := &Calibration{Coefficients: []float64{1, 2, 0.5}}
+	if v := c.Correct(2); v != 7 {
+		t.Errorf("Correct(2) = %v, expect 7", v)
+	}
+}
+
+func TestDriftInterpolate(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	//仪器响应 = a + b * 标准值
+	check := func(hours int, a, b float64) *Calibration {
+		return &Calibration{
+			CalibrationTime: util.Time(base.Add(time.Duration(hours) * time.Hour)),
+			ZeroReference:   0,
+			ZeroResponse:    a,
+			SpanReference:   100,
+			SpanResponse:    a + 100*b,
+		}
+	}
+
+	cases := []struct {
+		name   string
+		reset  bool
+		before *Calibration
+		after  *Calibration
+		hours  int
+		ok     bool
+		a      float64
+		b      float64
+	}{
+		{"no check", false, nil, nil, 5, false, 0, 1},
+		{"after last check", false, check(0, 1, 0.9), nil, 5, true, 1, 0.9},
+		{"after last check reset", true, check(0, 1, 0.9), nil, 5, false, 0, 1},
+		{"before first check", false, nil, check(10, 2, 0.8), 5, true, 2, 0.8},
+		{"between checks", false, check(0, 0, 1), check(10, 2, 0.8), 5, true, 1, 0.9},
+		{"between checks quarter", false, check(0, 0, 1), check(10, 2, 0.8), 2, true, 0.4, 0.96},
+		{"between checks reset", true, check(0, 5, 0.5), check(10, 2, 0.8), 5, true, 1, 0.9},
+		{"zero slope", false, check(0, 0, 1), check(10, 0, -1), 5, false, 0, 1},
+	}
+
+	for _, c := range cases {
+		p := &driftProcessor{ResetAfterCheck: c.reset}
+		a, b, ok := p.interpolate(c.before, c.after, base.Add(time.Duration(c.hours)*time.Hour))
+		if ok != c.ok || math.Abs(a-c.a) > 1e-9 || math.Abs(b-c.b) > 1e-9 {
+			t.Errorf("%s: interpolate = %v %v %v, expect %v %v %v", c.name, a, b, ok, c.a, c.b, c.ok)
+		}
+	}
+}
@@ -68,6 +68,13 @@ func (m *MonitorCode) scan(rows *sql.Rows) error {
 
 func (m *MonitorCodeKey) GetStationID() int { return m.StationID }
 
+func (m *MonitorCode) validateProcessors(siteID string) error {
+	if err := m.Processors.Validate(siteID); err != nil {
+		return err
+	}
+	return validateFormulaDependency(siteID, m)
+}
+
 func (m *MonitorCode) Add(siteID string, actionAuth authority.ActionAuthSet) error {
 
 	if m.Code == "" {
@@ -77,6 +84,10 @@ func (m *MonitorCode) Add(siteID string, actionAuth authority.ActionAuthSet) err
 		return e_need_monitor_id
 	}
 
+	if err := m.validateProcessors(siteID); err != nil {
+		return err
+	}
+
 	if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{m.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
 		return err
 	} else if !filtered[m.StationID] {
@@ -90,7 +101,7 @@ func (m *MonitorCode) Add(siteID string, actionAuth authority.ActionAuthSet) err
 	}
 	ext, _ := json.Marshal(m.Ext)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(code,monitor_id,station_id,processors,ext)
 		VALUES
@@ -117,6 +128,10 @@ func (m *MonitorCode) Update(siteID string, actionAuth authority.ActionAuthSet)
 		return e_need_monitor_id
 	}
 
+	if err := m.validateProcessors(siteID); err != nil {
+		return err
+	}
+
 	if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{m.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
 		return err
 	} else if !filtered[m.StationID] {
@@ -130,7 +145,7 @@ func (m *MonitorCode) Update(siteID string, actionAuth authority.ActionAuthSet)
 	}
 	ext, _ := json.Marshal(m.Ext)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -152,7 +167,7 @@ func (m *MonitorCode) Delete(siteID string, actionAuth authority.ActionAuthSet)
 		return errors.New("无权限")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -208,7 +223,7 @@ func GetMonitorCodes(siteID string, monitorID int, q string, stationID ...int) (
 
 	SQL += "\nORDER BY monitorCode.code ASC"
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get monitor code: ", err)
 		return nil, err
@@ -254,7 +269,7 @@ func (m *MonitorCodeTemplate) Add(siteID string) error {
 		return errors.New("请命名模版")
 	}
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name)
 		VALUES
@@ -276,7 +291,7 @@ func (m *MonitorCodeTemplate) Update(siteID string) error {
 		return errors.New("请命名模版")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -292,7 +307,7 @@ func (m *MonitorCodeTemplate) Update(siteID string) error {
 }
 func (m *MonitorCodeTemplate) Delete(siteID string) error {
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -321,7 +336,7 @@ func GetMonitorCodeTemplates(siteID string) ([]*MonitorCodeTemplate, error) {
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get monitor code template: ", err)
 		return nil, err
@@ -2,7 +2,6 @@ package monitor
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -31,6 +30,10 @@ const (
 	FLAG_PRIMARY_POLLUTANT
 
 	FLAG_PUSH
+
+	FLAG_REVIEW_INVALID
+
+	FLAG_INSUFFICIENT
 )
 
 func init() {
@@ -126,66 +129,71 @@ func (p *flagProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData
 
 	log.Println("flag processor: ", entry.GetStationID(), entry.GetMonitorID(), entry.GetDataTime())
 
-	m, err := GetModule(siteID)
+	normal, err := GetFlagByBit(siteID, FLAG_NORMAL)
 	if err != nil {
 		return false, err
 	}
 
-	normal, err := GetFlagByBit(siteID, FLAG_NORMAL)
+	if CheckFlag(FLAG_MANUAL, entry.GetFlagBit()) {
+		log.Println("manual bit set")
+		return false, nil
+	}
+
+	toFlag, err := evaluateFlag(siteID, txn, entry, func(stationID, monitorID int, flag string, t time.Time) *FlagLimit {
+		return GetFlagLimitAt(siteID, stationID, monitorID, flag, t)
+	})
 	if err != nil {
 		return false, err
 	}
 
-	var value float64
+	if toFlag == "" && normal != nil {
+		toFlag = normal.Flag
+	}
 
-	if absctractData, ok := entry.(data.IInterval); ok {
-		value = absctractData.GetAvg()
-	} else if absctractData, ok := entry.(data.IRealTime); ok {
-		value = absctractData.GetRtd()
-	} else {
-		log.Printf("error data with unknown interface: %t", entry)
-		return false, errors.New("未知数据接口类型")
+	if toFlag != "" {
+		if err := ChangeFlag(siteID, entry, toFlag, -1); err != nil {
+			return false, err
+		}
 	}
 
-	if CheckFlag(FLAG_MANUAL, entry.GetFlagBit()) {
-		log.Println("manual bit set")
-		return false, nil
+	return false, nil
+}
+
+type flagLimitGetter func(stationID, monitorID int, flag string, t time.Time) *FlagLimit
+
+//按标记顺序取第一个命中的限值标记 均未命中返回空
+func evaluateFlag(siteID string, txn *sql.Tx, entry data.IData, getFlagLimit flagLimitGetter) (string, error) {
+
+	m, err := GetModule(siteID)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := getProcessValue(entry)
+	if err != nil {
+		return "", err
 	}
 
 	for _, f := range m.Flags {
-		flagLimit := GetFlagLimit(siteID, entry.GetStationID(), entry.GetMonitorID(), f.Flag)
+		flagLimit := getFlagLimit(entry.GetStationID(), entry.GetMonitorID(), f.Flag, time.Time(entry.GetDataTime()))
 		if flagLimit != nil {
 			if CheckFlag(FLAG_DATA_INVARIANCE, f.Bits) {
 				if len(flagLimit.regionSegments) == 1 && len(flagLimit.regionSegments[0]) == 1 {
 					invarianceHour := flagLimit.regionSegments[0][0].Value
 					if isDi, err := isDataInvariance(siteID, txn, entry, invarianceHour); err != nil {
 						log.Println("error check data invariance: ", err)
-						return false, err
+						return "", err
 					} else if isDi {
-						if err := ChangeFlag(siteID, entry, f.Flag, -1); err != nil {
-							return false, err
-						}
-
-						return false, nil
+						return f.Flag, nil
 					}
 				}
 			} else if flagLimit.IsInRegion(value) {
-				if err := ChangeFlag(siteID, entry, f.Flag, -1); err != nil {
-					return false, err
-				}
-
-				return false, nil
+				return f.Flag, nil
 			}
 		}
 	}
 
-	if normal != nil {
-		if err := ChangeFlag(siteID, entry, normal.Flag, -1); err != nil {
-			return false, err
-		}
-	}
-
-	return false, nil
+	return "", nil
 }
 
 func isDataInvariance(siteID string, txn *sql.Tx, entry data.IData, invarianceHour float64) (bool, error) {
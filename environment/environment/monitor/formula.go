@@ -0,0 +1,440 @@
+package monitor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"obsessiontech/common/expression"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+)
+
+var e_need_formula_target = errors.New("需要公式目标因子")
+var e_formula_target_not_exists = errors.New("公式目标因子不存在")
+var e_formula_dependency_cycle = errors.New("公式存在循环依赖")
+
+func init() {
+	dataprocess.Register("formula", func() dataprocess.IDataProcessor { return new(formulaProcessor) })
+}
+
+//公式引用其他监测物同时刻同类型数据 变量写作 m监测物ID 或 m监测物ID.数据段 如 m12 m12.max
+//未指定数据段时取与计算目标相同的数据段
+//处理器需配置在每个被引用的因子上 引用数据到齐时计算 结果作为待上传数据进入目标因子自身的处理器 因此虚拟因子可被其他公式继续引用
+type formulaProcessor struct {
+	dataprocess.BaseDataProcessor
+	TargetMonitorCodeID int      `json:"targetMonitorCodeID"`
+	Expression          string   `json:"expression"`
+	DataTypes           []string `json:"dataTypes,omitempty"`
+}
+
+type formulaReference struct {
+	MonitorID int
+	Field     string
+}
+
+func parseFormulaVariable(variable string) (*formulaReference, error) {
+	if !strings.HasPrefix(variable, "m") {
+		return nil, fmt.Errorf("公式变量错误:%s", variable)
+	}
+
+	parts := strings.SplitN(variable[1:], ".", 2)
+
+	mid, err := strconv.Atoi(parts[0])
+	if err != nil || mid <= 0 {
+		return nil, fmt.Errorf("公式变量错误:%s", variable)
+	}
+
+	ref := &formulaReference{MonitorID: mid}
+
+	if len(parts) == 2 {
+		switch parts[1] {
+		case data.RTD:
+		case data.AVG:
+		case data.MIN:
+		case data.MAX:
+		case data.COU:
+		default:
+			return nil, fmt.Errorf("公式变量数据段错误:%s", variable)
+		}
+		ref.Field = parts[1]
+	}
+
+	return ref, nil
+}
+
+func (p *formulaProcessor) compile() (*expression.Expression, []*formulaReference, error) {
+	exp, err := expression.Compile(p.Expression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refs := make([]*formulaReference, 0)
+	for _, v := range exp.Variables() {
+		ref, err := parseFormulaVariable(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		refs = append(refs, ref)
+	}
+
+	return exp, refs, nil
+}
+
+func (p *formulaProcessor) Validate(siteID string) error {
+	if p.TargetMonitorCodeID <= 0 {
+		return e_need_formula_target
+	}
+
+	if _, _, err := p.compile(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *formulaProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload) (bool, error) {
+
+	if len(p.DataTypes) > 0 {
+		dtChecked := false
+		for _, dt := range p.DataTypes {
+			if dt == entry.GetDataType() {
+				dtChecked = true
+				break
+			}
+		}
+		if !dtChecked {
+			return false, nil
+		}
+	}
+
+	exp, refs, err := p.compile()
+	if err != nil {
+		return false, err
+	}
+
+	mc := GetMonitorCodeByID(siteID, p.TargetMonitorCodeID)
+	if mc == nil {
+		return false, e_formula_target_not_exists
+	}
+
+	uploaded, unuploaded, lock := uploader.GetUploadCache()
+
+	//查库时不持有上传缓存锁 避免阻塞同批其他数据的处理
+	sources := make(map[int]data.IData)
+	sources[entry.GetMonitorID()] = entry
+	missing := make([]int, 0)
+	lock.RLock()
+	for _, ref := range refs {
+		if _, exists := sources[ref.MonitorID]; exists {
+			continue
+		}
+		if d := findFormulaSource(entry, ref.MonitorID, unuploaded, uploaded); d != nil {
+			sources[ref.MonitorID] = d
+		} else {
+			missing = append(missing, ref.MonitorID)
+		}
+	}
+	lock.RUnlock()
+
+	for _, mid := range missing {
+		if _, exists := sources[mid]; exists {
+			continue
+		}
+		d, err := queryFormulaSource(siteID, entry, mid)
+		if err != nil {
+			return false, err
+		}
+		if d == nil {
+			log.Println("formula reference not ready: ", siteID, entry.GetStationID(), mid, entry.GetDataTime())
+			return false, nil
+		}
+		sources[mid] = d
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	target := findCachedData(unuploaded, entry.GetDataType(), entry.GetStationID(), mc.MonitorID, time.Time(entry.GetDataTime()))
+	if target == nil {
+		switch entry.GetDataType() {
+		case data.REAL_TIME:
+			target = new(data.RealTimeData)
+		case data.MINUTELY:
+			target = new(data.MinutelyData)
+		case data.HOURLY:
+			target = new(data.HourlyData)
+		case data.DAILY:
+			target = new(data.DailyData)
+		default:
+			return false, fmt.Errorf("未知数据类型:%s", entry.GetDataType())
+		}
+		target.SetStationID(entry.GetStationID())
+		target.SetDataTime(entry.GetDataTime())
+	}
+	target.SetMonitorID(mc.MonitorID)
+	target.SetMonitorCodeID(mc.ID)
+	target.SetCode(mc.Code)
+
+	evaluate := func(field string) (float64, error) {
+		return exp.Evaluate(func(variable string) (float64, error) {
+			ref, err := parseFormulaVariable(variable)
+			if err != nil {
+				return 0, err
+			}
+			if ref.Field != "" {
+				return getFormulaValue(sources[ref.MonitorID], ref.Field)
+			}
+			return getFormulaValue(sources[ref.MonitorID], field)
+		})
+	}
+
+	if rtd, ok := target.(data.IRealTime); ok {
+		v, err := evaluate(data.RTD)
+		if err != nil {
+			log.Println("error evaluate formula: ", p.Expression, err)
+			return false, err
+		}
+		rtd.SetRtd(v)
+	} else if interval, ok := target.(data.IInterval); ok {
+		setters := map[string]func(float64){
+			data.AVG: interval.SetAvg,
+			data.MIN: interval.SetMin,
+			data.MAX: interval.SetMax,
+			data.COU: interval.SetCou,
+		}
+		for field, set := range setters {
+			v, err := evaluate(field)
+			if err != nil {
+				log.Println("error evaluate formula: ", p.Expression, field, err)
+				return false, err
+			}
+			set(v)
+		}
+	}
+
+	putCachedData(unuploaded, target)
+
+	return false, nil
+}
+
+func getFormulaValue(d data.IData, field string) (float64, error) {
+	if rtd, ok := d.(data.IRealTime); ok {
+		return rtd.GetRtd(), nil
+	}
+
+	interval, ok := d.(data.IInterval)
+	if !ok {
+		return 0, errors.New("未知数据接口类型")
+	}
+
+	switch field {
+	case data.MIN:
+		return interval.GetMin(), nil
+	case data.MAX:
+		return interval.GetMax(), nil
+	case data.COU:
+		return interval.GetCou(), nil
+	}
+
+	return interval.GetAvg(), nil
+}
+
+//优先取本次待上传(含其他公式已算出的虚拟因子)及已上传数据 未找到时再查库
+func findFormulaSource(entry data.IData, monitorID int, caches ...map[string]map[int]map[int]map[time.Time]data.IData) data.IData {
+	for _, cache := range caches {
+		if d := findCachedData(cache, entry.GetDataType(), entry.GetStationID(), monitorID, time.Time(entry.GetDataTime())); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+func queryFormulaSource(siteID string, entry data.IData, monitorID int) (data.IData, error) {
+	list, err := data.GetData(siteID, entry.GetDataType(), []int{entry.GetStationID()}, []int{monitorID}, nil, nil, time.Time(entry.GetDataTime()), time.Time(entry.GetDataTime()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range list {
+		if d.GetMonitorID() == monitorID {
+			return d, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func findCachedData(cache map[string]map[int]map[int]map[time.Time]data.IData, dataType string, stationID, monitorID int, dataTime time.Time) data.IData {
+	stations, exists := cache[dataType]
+	if !exists {
+		return nil
+	}
+	monitors, exists := stations[stationID]
+	if !exists {
+		return nil
+	}
+	times, exists := monitors[monitorID]
+	if !exists {
+		return nil
+	}
+	for t, d := range times {
+		if t.Equal(dataTime) {
+			return d
+		}
+	}
+	return nil
+}
+
+func putCachedData(cache map[string]map[int]map[int]map[time.Time]data.IData, d data.IData) {
+	stations, exists := cache[d.GetDataType()]
+	if !exists {
+		stations = make(map[int]map[int]map[time.Time]data.IData)
+		cache[d.GetDataType()] = stations
+	}
+	monitors, exists := stations[d.GetStationID()]
+	if !exists {
+		monitors = make(map[int]map[time.Time]data.IData)
+		stations[d.GetStationID()] = monitors
+	}
+	times, exists := monitors[d.GetMonitorID()]
+	if !exists {
+		times = make(map[time.Time]data.IData)
+		monitors[d.GetMonitorID()] = times
+	}
+	times[time.Time(d.GetDataTime())] = d
+}
+
+//公式节点 引用数据与计算结果均为同一排放点的数据
+type formulaNode struct {
+	StationID int
+	MonitorID int
+}
+
+//检查公式依赖 目标监测物 -> 引用监测物 同一排放点内不得成环
+func validateFormulaDependency(siteID string, m *MonitorCode) error {
+
+	codes, err := GetMonitorCodes(siteID, 0, "")
+	if err != nil {
+		return err
+	}
+
+	list := make([]*MonitorCode, 0)
+	for _, c := range codes {
+		if c.ID == m.ID {
+			continue
+		}
+		list = append(list, c)
+	}
+	list = append(list, m)
+
+	dependency, err := formulaDependency(list, m)
+	if err != nil {
+		return err
+	}
+
+	if hasFormulaCycle(dependency) {
+		return e_formula_dependency_cycle
+	}
+
+	return nil
+}
+
+//公式处理器配置在被引用因子的代码上 排放点为0的代码作用于未单独配置该监测物代码的排放点
+//check的公式错误直接返回 其他代码的错误记录后跳过
+func formulaDependency(codes []*MonitorCode, check *MonitorCode) (map[formulaNode]map[formulaNode]bool, error) {
+	codeMonitor := make(map[int]int)
+	own := make(map[formulaNode]bool)
+	stations := map[int]bool{0: true}
+	for _, c := range codes {
+		codeMonitor[c.ID] = c.MonitorID
+		own[formulaNode{c.StationID, c.MonitorID}] = true
+		stations[c.StationID] = true
+	}
+
+	dependency := make(map[formulaNode]map[formulaNode]bool)
+
+	addDependency := func(c *MonitorCode) error {
+		for _, each := range c.Processors {
+			p, ok := each.(*formulaProcessor)
+			if !ok {
+				continue
+			}
+			targetMonitorID, exists := codeMonitor[p.TargetMonitorCodeID]
+			if !exists {
+				return e_formula_target_not_exists
+			}
+			_, refs, err := p.compile()
+			if err != nil {
+				return err
+			}
+			for stationID := range stations {
+				if c.StationID != 0 && stationID != c.StationID {
+					continue
+				}
+				if c.StationID == 0 && stationID != 0 && own[formulaNode{stationID, c.MonitorID}] {
+					continue
+				}
+				target := formulaNode{stationID, targetMonitorID}
+				deps, exists := dependency[target]
+				if !exists {
+					deps = make(map[formulaNode]bool)
+					dependency[target] = deps
+				}
+				for _, ref := range refs {
+					deps[formulaNode{stationID, ref.MonitorID}] = true
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, c := range codes {
+		if err := addDependency(c); err != nil {
+			if c == check {
+				return nil, err
+			}
+			log.Println("error formula dependency of monitor code: ", c.ID, err)
+		}
+	}
+
+	return dependency, nil
+}
+
+func hasFormulaCycle(dependency map[formulaNode]map[formulaNode]bool) bool {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[formulaNode]int)
+
+	var visit func(n formulaNode) bool
+	visit = func(n formulaNode) bool {
+		switch state[n] {
+		case visiting:
+			return false
+		case visited:
+			return true
+		}
+		state[n] = visiting
+		for dep := range dependency[n] {
+			if !visit(dep) {
+				return false
+			}
+		}
+		state[n] = visited
+		return true
+	}
+
+	for n := range dependency {
+		if !visit(n) {
+			return true
+		}
+	}
+
+	return false
+}
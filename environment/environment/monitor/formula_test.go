@@ -0,0 +1,55 @@
+package monitor
+
+import (
+	"testing"
+
+	"obsessiontech/environment/environment/dataprocess"
+)
+
+func TestFormulaDependencyCycle(t *testing.T) {
+	//code对应排放点监测物 其上配置以targetCode为目标 引用expression的公式
+	newCode := func(id, stationID, monitorID, targetCode int, expression string) *MonitorCode {
+		c := new(MonitorCode)
+		c.ID, c.StationID, c.MonitorID = id, stationID, monitorID
+		if targetCode > 0 {
+			c.Processors = dataprocess.DataProcessors{&formulaProcessor{TargetMonitorCodeID: targetCode, Expression: expression}}
+		}
+		return c
+	}
+
+	cases := []struct {
+		name  string
+		codes []*MonitorCode
+		cycle bool
+	}{
+		{"same station", []*MonitorCode{
+			newCode(1, 1, 1, 2, "m1+1"),
+			newCode(2, 1, 2, 1, "m2+1"),
+		}, true},
+		{"different stations", []*MonitorCode{
+			newCode(1, 1, 1, 4, "m1+1"),
+			newCode(2, 1, 2, 0, ""),
+			newCode(3, 2, 1, 0, ""),
+			newCode(4, 2, 2, 3, "m2+1"),
+		}, false},
+		{"common code", []*MonitorCode{
+			newCode(1, 0, 1, 2, "m1+1"),
+			newCode(2, 1, 2, 1, "m2+1"),
+		}, true},
+		{"common code overridden", []*MonitorCode{
+			newCode(1, 0, 1, 2, "m1+1"),
+			newCode(2, 1, 2, 1, "m2+1"),
+			newCode(3, 1, 1, 0, ""),
+		}, false},
+	}
+
+	for _, c := range cases {
+		dependency, err := formulaDependency(c.codes, c.codes[len(c.codes)-1])
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if cycle := hasFormulaCycle(dependency); cycle != c.cycle {
+			t.Errorf("%s: cycle %v, expect %v", c.name, cycle, c.cycle)
+		}
+	}
+}
@@ -2,16 +2,24 @@ package monitor
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
 	"obsessiontech/environment/authority"
 	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/site/initialization"
 	"strconv"
 	"strings"
+	"time"
 )
 
+func init() {
+	initialization.RegisterMigrations(MODULE_MONITOR, "monitor", limitScheduleMigration, limitEffectiveMigration)
+}
+
 const (
 	COMPARATOR_E  = "="
 	COMPARATOR_NE = "!="
@@ -22,12 +30,15 @@ const (
 )
 
 type FlagLimit struct {
-	ID             int        `json:"ID"`
-	StationID      int        `json:"stationID"`
-	MonitorID      int        `json:"monitorID"`
-	Flag           string     `json:"flag"`
-	Region         string     `json:"region"`
-	regionSegments []segments `json:"-"`
+	ID             int            `json:"ID"`
+	StationID      int            `json:"stationID"`
+	MonitorID      int            `json:"monitorID"`
+	Flag           string         `json:"flag"`
+	Region         string         `json:"region"`
+	Schedule       *util.Interval `json:"schedule"`
+	EffectiveFrom  util.Time      `json:"effectiveFrom"`
+	EffectiveTo    util.Time      `json:"effectiveTo"`
+	regionSegments []segments     `json:"-"`
 }
 
 func (l *FlagLimit) GetStationID() int { return l.StationID }
@@ -40,6 +51,9 @@ type segment struct {
 var e_invalid_limit = errors.New("错误限值格式")
 var e_invalid_limit_comparator = errors.New("错误限值比较符")
 var e_invalid_limit_value = errors.New("错误限值数值")
+var e_need_effective_from = errors.New("需要生效时间")
+var e_invalid_effective_time = errors.New("失效时间需晚于生效时间")
+var e_invalid_revise_time = errors.New("新版本生效时间需在原版本有效期内")
 
 type segments []*segment
 
@@ -105,18 +119,69 @@ func (c *segment) parse(input string) error {
 	return nil
 }
 
-const limitColumns = "flagLimit.id, flagLimit.monitor_id, flagLimit.station_id, flagLimit.flag, flagLimit.region"
+const limitColumns = "flagLimit.id, flagLimit.monitor_id, flagLimit.station_id, flagLimit.flag, flagLimit.region, flagLimit.schedule, flagLimit.effective_from, flagLimit.effective_to"
 
 func limitTableName(siteID string) string {
 	return siteID + "_monitorflaglimit"
 }
 
+//标记限值按时段生效 原唯一索引加入时段
+var limitScheduleMigration = &initialization.Migration{
+	Version:     1,
+	Description: "标记限值增加时段",
+	Func: func(siteID string, db *sql.DB) error {
+		table := limitTableName(siteID)
+		if err := initialization.AddColumns(db, table, "schedule VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return initialization.ReplaceUniqueKey(db, table, []string{"monitor_id", "station_id", "flag"}, "uk_schedule", "monitor_id", "station_id", "flag", "schedule")
+	},
+}
+
+//限值按生效时间分版本 原唯一索引加入生效时间
+var limitEffectiveMigration = &initialization.Migration{
+	Version:     2,
+	Description: "限值增加生效时间",
+	Func: func(siteID string, db *sql.DB) error {
+		table := limitTableName(siteID)
+		if err := initialization.AddColumns(db, table, "effective_from DATETIME NOT NULL DEFAULT '"+effectiveFromUnset+"'", "effective_to DATETIME NULL"); err != nil {
+			return err
+		}
+		if err := initialization.ReplaceUniqueKey(db, table, []string{"monitor_id", "station_id", "flag", "schedule"}, "uk_effective", "monitor_id", "station_id", "flag", "schedule", "effective_from"); err != nil {
+			return err
+		}
+
+		table = monitorLimitTableName(siteID)
+		if err := initialization.AddColumns(db, table, "effective_from DATETIME NOT NULL DEFAULT '"+effectiveFromUnset+"'", "effective_to DATETIME NULL"); err != nil {
+			return err
+		}
+		return initialization.ReplaceUniqueKey(db, table, []string{"monitor_id", "station_id"}, "uk_effective", "monitor_id", "station_id", "effective_from")
+	},
+}
+
 func (l *FlagLimit) scan(rows *sql.Rows) error {
 
-	if err := rows.Scan(&l.ID, &l.MonitorID, &l.StationID, &l.Flag, &l.Region); err != nil {
+	var schedule string
+	var effectiveFrom, effectiveTo sql.NullTime
+	if err := rows.Scan(&l.ID, &l.MonitorID, &l.StationID, &l.Flag, &l.Region, &schedule, &effectiveFrom, &effectiveTo); err != nil {
 		return err
 	}
 
+	l.EffectiveFrom = parseEffectiveFrom(effectiveFrom)
+	if effectiveTo.Valid {
+		l.EffectiveTo = util.Time(effectiveTo.Time)
+	}
+
+	if schedule != "" {
+		if err := json.Unmarshal([]byte(schedule), &l.Schedule); err != nil {
+			return err
+		}
+	}
+	if l.Schedule == nil {
+		l.Schedule = new(util.Interval)
+		l.Schedule.Init()
+	}
+
 	if err := l.parseRegionSegment(); err != nil {
 		return err
 	}
@@ -151,6 +216,26 @@ func (l *FlagLimit) parseRegionSegment() error {
 	return nil
 }
 
+func (l *FlagLimit) IsScheduled() bool {
+	return l.Schedule != nil && !l.Schedule.IsUnlimited()
+}
+
+//版本有效期 [生效时间, 失效时间) 为空则不限
+func (l *FlagLimit) IsEffective(t time.Time) bool {
+	return isEffective(l.EffectiveFrom, l.EffectiveTo, t)
+}
+
+func (l *FlagLimit) IsInEffect(t time.Time) bool {
+	if !l.IsEffective(t) {
+		return false
+	}
+	if !l.IsScheduled() {
+		return true
+	}
+	_, _, err := l.Schedule.GetInterval(t, true)
+	return err == nil
+}
+
 func (l *FlagLimit) IsInRegion(value float64) (checked bool) {
 
 	for _, s := range l.regionSegments {
@@ -198,6 +283,19 @@ func (l *FlagLimit) Validate(siteID string) error {
 		return err
 	}
 
+	if l.Schedule == nil {
+		l.Schedule = new(util.Interval)
+		if err := l.Schedule.Init(); err != nil {
+			return err
+		}
+	} else if err := l.Schedule.Validate(); err != nil {
+		return err
+	}
+
+	if err := validateEffective(l.EffectiveFrom, l.EffectiveTo); err != nil {
+		return err
+	}
+
 	if len(l.regionSegments) > 0 {
 		if CheckFlag(FLAG_DATA_INVARIANCE, flagInstance.Bits) {
 			if len(l.regionSegments) != 1 {
@@ -218,6 +316,12 @@ func (l *FlagLimit) Validate(siteID string) error {
 	return nil
 }
 
+//依次对应monitor_id,station_id,flag,region,schedule,effective_from,effective_to
+func (l *FlagLimit) columnValues() []interface{} {
+	schedule, _ := json.Marshal(l.Schedule)
+	return []interface{}{l.MonitorID, l.StationID, l.Flag, l.Region, string(schedule), effectiveFromValue(l.EffectiveFrom), nullableTime(l.EffectiveTo)}
+}
+
 func (l *FlagLimit) Add(siteID string, actionAuth authority.ActionAuthSet) error {
 
 	if err := l.Validate(siteID); err != nil {
@@ -230,14 +334,14 @@ func (l *FlagLimit) Add(siteID string, actionAuth authority.ActionAuthSet) error
 		return errors.New("无权限")
 	}
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
-			(monitor_id,station_id,flag,region)
+			(monitor_id,station_id,flag,region,schedule,effective_from,effective_to)
 		VALUES
-			(?,?,?,?)
+			(?,?,?,?,?,?,?)
 		ON DUPLICATE KEY UPDATE
-		region=VALUES(region)
-	`, limitTableName(siteID)), l.MonitorID, l.StationID, l.Flag, l.Region); err != nil {
+		region=VALUES(region),effective_to=VALUES(effective_to)
+	`, limitTableName(siteID)), l.columnValues()...); err != nil {
 		log.Println("error insert monitor flag limit: ", err)
 		return err
 	} else if id, err := ret.LastInsertId(); err != nil {
@@ -262,14 +366,14 @@ func (l *FlagLimit) Update(siteID string, actionAuth authority.ActionAuthSet) er
 		return errors.New("无权限")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
-			monitor_id=?,station_id=?,flag=?,region=?
+			monitor_id=?,station_id=?,flag=?,region=?,schedule=?,effective_from=?,effective_to=?
 		WHERE
 			id = ?
-	`, limitTableName(siteID)), l.MonitorID, l.StationID, l.Flag, l.Region, l.ID); err != nil {
+	`, limitTableName(siteID)), append(l.columnValues(), l.ID)...); err != nil {
 		log.Println("error update monitor flag limit: ", err)
 		return err
 	}
@@ -277,6 +381,74 @@ func (l *FlagLimit) Update(siteID string, actionAuth authority.ActionAuthSet) er
 	return nil
 }
 
+//以新版本替换ID对应的限值 原版本在新版本生效时失效 历史数据仍按原版本判断
+func (l *FlagLimit) Revise(siteID string, actionAuth authority.ActionAuthSet) error {
+
+	if time.Time(l.EffectiveFrom).IsZero() {
+		return e_need_effective_from
+	}
+
+	var prev *FlagLimit
+	var err error
+	err = datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		prev, err = getFlagLimitWithTxn(siteID, txn, l.ID)
+		if err != nil {
+			panic(err)
+		}
+
+		if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{prev.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
+			panic(err)
+		} else if !filtered[prev.StationID] {
+			panic(errors.New("无权限"))
+		}
+
+		if !prev.IsEffective(time.Time(l.EffectiveFrom)) || time.Time(l.EffectiveFrom).Equal(time.Time(prev.EffectiveFrom)) {
+			panic(e_invalid_revise_time)
+		}
+
+		l.StationID = prev.StationID
+		l.MonitorID = prev.MonitorID
+		l.Flag = prev.Flag
+		l.EffectiveTo = prev.EffectiveTo
+		if l.Schedule == nil {
+			l.Schedule = prev.Schedule
+		}
+
+		if err := l.Validate(siteID); err != nil {
+			panic(err)
+		}
+
+		if _, err := txn.Exec(fmt.Sprintf(`
+			UPDATE
+				%s
+			SET
+				effective_to=?
+			WHERE
+				id = ?
+		`, limitTableName(siteID)), time.Time(l.EffectiveFrom), prev.ID); err != nil {
+			log.Println("error revise monitor flag limit: ", err)
+			panic(err)
+		}
+
+		if ret, err := txn.Exec(fmt.Sprintf(`
+			INSERT INTO %s
+				(monitor_id,station_id,flag,region,schedule,effective_from,effective_to)
+			VALUES
+				(?,?,?,?,?,?,?)
+		`, limitTableName(siteID)), l.columnValues()...); err != nil {
+			log.Println("error revise monitor flag limit: ", err)
+			panic(err)
+		} else if id, err := ret.LastInsertId(); err != nil {
+			log.Println("error revise monitor flag limit: ", err)
+			panic(err)
+		} else {
+			l.ID = int(id)
+		}
+	})
+
+	return err
+}
+
 func (l *FlagLimit) Delete(siteID string, actionAuth authority.ActionAuthSet) error {
 
 	if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{l.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
@@ -285,7 +457,7 @@ func (l *FlagLimit) Delete(siteID string, actionAuth authority.ActionAuthSet) er
 		return errors.New("无权限")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -298,6 +470,34 @@ func (l *FlagLimit) Delete(siteID string, actionAuth authority.ActionAuthSet) er
 	return nil
 }
 
+func getFlagLimitWithTxn(siteID string, txn *sql.Tx, ID int) (*FlagLimit, error) {
+	rows, err := txn.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s flagLimit
+		WHERE
+			flagLimit.id = ?
+		FOR UPDATE
+	`, limitColumns, limitTableName(siteID)), ID)
+	if err != nil {
+		log.Println("error get monitor flag limit: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var l FlagLimit
+		if err := l.scan(rows); err != nil {
+			log.Println("error get monitor flag limit: ", err)
+			return nil, err
+		}
+		return &l, nil
+	}
+
+	return nil, errors.New("限值不存在")
+}
+
 func GetFlagLimits(siteID string, stationID []int, monitorID []int, flag []string) ([]*FlagLimit, error) {
 
 	whereStmts := make([]string, 0)
@@ -356,7 +556,9 @@ func GetFlagLimits(siteID string, stationID []int, monitorID []int, flag []strin
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	SQL += "\nORDER BY flagLimit.effective_from ASC, flagLimit.id ASC"
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get monitor flag limit: ", err)
 		return nil, err
@@ -377,3 +579,45 @@ func GetFlagLimits(siteID string, stationID []int, monitorID []int, flag []strin
 
 	return result, nil
 }
+
+func isEffective(effectiveFrom, effectiveTo util.Time, t time.Time) bool {
+	if from := time.Time(effectiveFrom); !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if to := time.Time(effectiveTo); !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
+
+func validateEffective(effectiveFrom, effectiveTo util.Time) error {
+	from, to := time.Time(effectiveFrom), time.Time(effectiveTo)
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return e_invalid_effective_time
+	}
+	return nil
+}
+
+//生效时间参与唯一索引 不可为NULL 未设置时以最早时间代替 否则ON DUPLICATE KEY不生效
+const effectiveFromUnset = "1000-01-01 00:00:00"
+
+func effectiveFromValue(t util.Time) interface{} {
+	if time.Time(t).IsZero() {
+		return effectiveFromUnset
+	}
+	return time.Time(t)
+}
+
+func parseEffectiveFrom(t sql.NullTime) util.Time {
+	if !t.Valid || t.Time.Year() <= 1000 {
+		return util.Time{}
+	}
+	return util.Time(t.Time)
+}
+
+func nullableTime(t util.Time) interface{} {
+	if time.Time(t).IsZero() {
+		return nil
+	}
+	return time.Time(t)
+}
@@ -0,0 +1,136 @@
+package monitor
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+)
+
+var e_evaluate_range_restricted = errors.New("实时及分钟数据的评估时间跨度最多31天")
+
+type FlagLimitEvaluation struct {
+	StationID    int       `json:"stationID"`
+	MonitorID    int       `json:"monitorID"`
+	DataTime     util.Time `json:"dataTime"`
+	Value        float64   `json:"value"`
+	Flag         string    `json:"flag"`
+	CurrentFlag  string    `json:"currentFlag"`
+	ProposedFlag string    `json:"proposedFlag"`
+}
+
+//评估拟定限值对历史数据标记的影响 仅返回按现行限值与拟定限值判断结果不同的数据 不修改数据
+func EvaluateFlagLimit(siteID, dataType string, proposed *FlagLimit, stationID []int, beginTime, endTime time.Time) ([]*FlagLimitEvaluation, error) {
+
+	if err := proposed.Validate(siteID); err != nil {
+		return nil, err
+	}
+
+	switch dataType {
+	case data.REAL_TIME:
+		fallthrough
+	case data.MINUTELY:
+		if endTime.Sub(beginTime).Hours() > 24*31 {
+			return nil, e_evaluate_range_restricted
+		}
+	}
+
+	if proposed.StationID > 0 {
+		stationID = []int{proposed.StationID}
+	}
+
+	result := make([]*FlagLimitEvaluation, 0)
+
+	if len(stationID) == 0 {
+		return result, nil
+	}
+
+	if err := LoadFlagLimit(siteID); err != nil {
+		return nil, err
+	}
+
+	normal, err := GetFlagByBit(siteID, FLAG_NORMAL)
+	if err != nil {
+		return nil, err
+	}
+
+	dataList, err := data.GetData(siteID, dataType, stationID, []int{proposed.MonitorID}, nil, nil, beginTime, endTime, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	current := func(stationID, monitorID int, flag string, t time.Time) *FlagLimit {
+		return GetFlagLimitAt(siteID, stationID, monitorID, flag, t)
+	}
+
+	proposal := func(stationID, monitorID int, flag string, t time.Time) *FlagLimit {
+		existing := GetFlagLimitAt(siteID, stationID, monitorID, flag, t)
+		if monitorID != proposed.MonitorID || flag != proposed.Flag {
+			return existing
+		}
+		if proposed.IsInEffect(t) {
+			//通用限值不覆盖排放点自身的限值
+			if proposed.StationID == 0 && existing != nil && existing.StationID == stationID && existing.ID != proposed.ID {
+				return existing
+			}
+			return proposed
+		}
+		if existing != nil && existing.ID == proposed.ID && proposed.IsEffective(t) {
+			return nil
+		}
+		return existing
+	}
+
+	err = datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		for _, d := range dataList {
+			if d.GetMonitorID() != proposed.MonitorID {
+				continue
+			}
+			if CheckFlag(FLAG_MANUAL, d.GetFlagBit()) {
+				continue
+			}
+
+			currentFlag, err := evaluateFlag(siteID, txn, d, current)
+			if err != nil {
+				panic(err)
+			}
+			proposedFlag, err := evaluateFlag(siteID, txn, d, proposal)
+			if err != nil {
+				panic(err)
+			}
+
+			if currentFlag == proposedFlag {
+				continue
+			}
+
+			if normal != nil {
+				if currentFlag == "" {
+					currentFlag = normal.Flag
+				}
+				if proposedFlag == "" {
+					proposedFlag = normal.Flag
+				}
+			}
+
+			value, _ := getProcessValue(d)
+
+			result = append(result, &FlagLimitEvaluation{
+				StationID:    d.GetStationID(),
+				MonitorID:    d.GetMonitorID(),
+				DataTime:     d.GetDataTime(),
+				Value:        value,
+				Flag:         d.GetFlag(),
+				CurrentFlag:  currentFlag,
+				ProposedFlag: proposedFlag,
+			})
+		}
+	}, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -7,10 +7,12 @@ import (
 	"log"
 	"math"
 	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
 	"obsessiontech/environment/authority"
 	"obsessiontech/environment/environment/entity"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const NOTATION_INFINITY = "∞"
@@ -23,9 +25,11 @@ type MonitorLimit struct {
 	StationID         int    `json:"stationID"`
 	Overproof         string `json:"overproof"`
 	overproofSegments []*OverproofSegment
-	TopEffective      float64 `json:"topEffective"`
-	LowerDetection    float64 `json:"lowerDetection"`
-	InvarianceHour    int     `json:"invarianceHour"`
+	TopEffective      float64   `json:"topEffective"`
+	LowerDetection    float64   `json:"lowerDetection"`
+	InvarianceHour    int       `json:"invarianceHour"`
+	EffectiveFrom     util.Time `json:"effectiveFrom"`
+	EffectiveTo       util.Time `json:"effectiveTo"`
 }
 
 type OverproofSegment struct {
@@ -33,7 +37,7 @@ type OverproofSegment struct {
 	U float64
 }
 
-const monitorLimitColumns = "monitorLimit.id, monitorLimit.monitor_id, monitorLimit.station_id, monitorLimit.overproof, monitorLimit.top_effective, monitorLimit.lower_detection, monitorLimit.invariance_hour"
+const monitorLimitColumns = "monitorLimit.id, monitorLimit.monitor_id, monitorLimit.station_id, monitorLimit.overproof, monitorLimit.top_effective, monitorLimit.lower_detection, monitorLimit.invariance_hour, monitorLimit.effective_from, monitorLimit.effective_to"
 
 func monitorLimitTableName(siteID string) string {
 	return siteID + "_monitorlimit"
@@ -41,10 +45,16 @@ func monitorLimitTableName(siteID string) string {
 
 func (m *MonitorLimit) scan(rows *sql.Rows) error {
 
-	if err := rows.Scan(&m.ID, &m.MonitorID, &m.StationID, &m.Overproof, &m.TopEffective, &m.LowerDetection, &m.InvarianceHour); err != nil {
+	var effectiveFrom, effectiveTo sql.NullTime
+	if err := rows.Scan(&m.ID, &m.MonitorID, &m.StationID, &m.Overproof, &m.TopEffective, &m.LowerDetection, &m.InvarianceHour, &effectiveFrom, &effectiveTo); err != nil {
 		return err
 	}
 
+	m.EffectiveFrom = parseEffectiveFrom(effectiveFrom)
+	if effectiveTo.Valid {
+		m.EffectiveTo = util.Time(effectiveTo.Time)
+	}
+
 	if err := m.parseOverproofSegment(); err != nil {
 		return err
 	}
@@ -108,6 +118,15 @@ func (m *MonitorLimit) IsOverproof(value float64) bool {
 
 func (m *MonitorLimit) GetStationID() int { return m.StationID }
 
+func (m *MonitorLimit) IsEffective(t time.Time) bool {
+	return isEffective(m.EffectiveFrom, m.EffectiveTo, t)
+}
+
+//依次对应monitor_id,station_id,overproof,top_effective,lower_detection,invariance_hour,effective_from,effective_to
+func (m *MonitorLimit) columnValues() []interface{} {
+	return []interface{}{m.MonitorID, m.StationID, m.Overproof, m.TopEffective, m.LowerDetection, m.InvarianceHour, effectiveFromValue(m.EffectiveFrom), nullableTime(m.EffectiveTo)}
+}
+
 func (m *MonitorLimit) Add(siteID string, actionAuth authority.ActionAuthSet) error {
 
 	if m.MonitorID <= 0 {
@@ -118,20 +137,24 @@ func (m *MonitorLimit) Add(siteID string, actionAuth authority.ActionAuthSet) er
 		return err
 	}
 
+	if err := validateEffective(m.EffectiveFrom, m.EffectiveTo); err != nil {
+		return err
+	}
+
 	if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{m.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
 		return err
 	} else if !filtered[m.StationID] {
 		return errors.New("无权限")
 	}
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
-			(monitor_id,station_id,overproof,top_effective,lower_detection,invariance_hour)
+			(monitor_id,station_id,overproof,top_effective,lower_detection,invariance_hour,effective_from,effective_to)
 		VALUES
-			(?,?,?,?,?,?)
+			(?,?,?,?,?,?,?,?)
 		ON DUPLICATE KEY UPDATE
-		 	overproof=VALUES(overproof),top_effective=VALUES(top_effective),lower_detection=VALUES(lower_detection),invariance_hour=VALUES(invariance_hour)
-	`, monitorLimitTableName(siteID)), m.MonitorID, m.StationID, m.Overproof, m.TopEffective, m.LowerDetection, m.InvarianceHour); err != nil {
+		 	overproof=VALUES(overproof),top_effective=VALUES(top_effective),lower_detection=VALUES(lower_detection),invariance_hour=VALUES(invariance_hour),effective_to=VALUES(effective_to)
+	`, monitorLimitTableName(siteID)), m.columnValues()...); err != nil {
 		log.Println("error insert monitor limit: ", err)
 		return err
 	} else if id, err := ret.LastInsertId(); err != nil {
@@ -150,6 +173,9 @@ func (m *MonitorLimit) Update(siteID string, actionAuth authority.ActionAuthSet)
 	if err := m.parseOverproofSegment(); err != nil {
 		return err
 	}
+	if err := validateEffective(m.EffectiveFrom, m.EffectiveTo); err != nil {
+		return err
+	}
 
 	if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{m.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
 		return err
@@ -157,20 +183,102 @@ func (m *MonitorLimit) Update(siteID string, actionAuth authority.ActionAuthSet)
 		return errors.New("无权限")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
-			monitor_id=?,station_id=?,overproof=?,top_effective=?,lower_detection=?,invariance_hour=?
+			monitor_id=?,station_id=?,overproof=?,top_effective=?,lower_detection=?,invariance_hour=?,effective_from=?,effective_to=?
 		WHERE
 			id=?
-	`, monitorLimitTableName(siteID)), m.MonitorID, m.StationID, m.Overproof, m.TopEffective, m.LowerDetection, m.InvarianceHour, m.ID); err != nil {
+	`, monitorLimitTableName(siteID)), append(m.columnValues(), m.ID)...); err != nil {
 		log.Println("error update monitor limit: ", err)
 		return err
 	}
 
 	return nil
 }
+//以新版本替换ID对应的限值 原版本在新版本生效时失效 历史数据仍按原版本判断
+func (m *MonitorLimit) Revise(siteID string, actionAuth authority.ActionAuthSet) error {
+
+	if time.Time(m.EffectiveFrom).IsZero() {
+		return e_need_effective_from
+	}
+	if err := m.parseOverproofSegment(); err != nil {
+		return err
+	}
+
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		rows, err := txn.Query(fmt.Sprintf(`
+			SELECT
+				%s
+			FROM
+				%s monitorLimit
+			WHERE
+				monitorLimit.id = ?
+			FOR UPDATE
+		`, monitorLimitColumns, monitorLimitTableName(siteID)), m.ID)
+		if err != nil {
+			log.Println("error get monitor limit: ", err)
+			panic(err)
+		}
+
+		var prev *MonitorLimit
+		if rows.Next() {
+			prev = new(MonitorLimit)
+			if err := prev.scan(rows); err != nil {
+				rows.Close()
+				log.Println("error get monitor limit: ", err)
+				panic(err)
+			}
+		}
+		rows.Close()
+
+		if prev == nil {
+			panic(errors.New("限值不存在"))
+		}
+
+		if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{prev.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
+			panic(err)
+		} else if !filtered[prev.StationID] {
+			panic(errors.New("无权限"))
+		}
+
+		if !prev.IsEffective(time.Time(m.EffectiveFrom)) || time.Time(m.EffectiveFrom).Equal(time.Time(prev.EffectiveFrom)) {
+			panic(e_invalid_revise_time)
+		}
+
+		m.MonitorID = prev.MonitorID
+		m.StationID = prev.StationID
+		m.EffectiveTo = prev.EffectiveTo
+
+		if _, err := txn.Exec(fmt.Sprintf(`
+			UPDATE
+				%s
+			SET
+				effective_to=?
+			WHERE
+				id=?
+		`, monitorLimitTableName(siteID)), time.Time(m.EffectiveFrom), prev.ID); err != nil {
+			log.Println("error revise monitor limit: ", err)
+			panic(err)
+		}
+
+		if ret, err := txn.Exec(fmt.Sprintf(`
+			INSERT INTO %s
+				(monitor_id,station_id,overproof,top_effective,lower_detection,invariance_hour,effective_from,effective_to)
+			VALUES
+				(?,?,?,?,?,?,?,?)
+		`, monitorLimitTableName(siteID)), m.columnValues()...); err != nil {
+			log.Println("error revise monitor limit: ", err)
+			panic(err)
+		} else if id, err := ret.LastInsertId(); err != nil {
+			log.Println("error revise monitor limit: ", err)
+			panic(err)
+		} else {
+			m.ID = int(id)
+		}
+	})
+}
 func (m *MonitorLimit) Delete(siteID string, actionAuth authority.ActionAuthSet) error {
 
 	if filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, []int{m.StationID}, entity.ACTION_ENTITY_EDIT); err != nil {
@@ -179,7 +287,7 @@ func (m *MonitorLimit) Delete(siteID string, actionAuth authority.ActionAuthSet)
 		return errors.New("无权限")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -235,7 +343,9 @@ func GetMonitorLimits(siteID string, monitorIDs []int, stationID ...int) ([]*Mon
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	SQL += "\nORDER BY monitorLimit.effective_from ASC, monitorLimit.id ASC"
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get monitor limit: ", err)
 		return nil, err
@@ -258,6 +368,33 @@ func GetMonitorLimits(siteID string, monitorIDs []int, stationID ...int) ([]*Mon
 
 }
 
+//取数据时间所在版本的限值 排放点未设置则取通用限值
+func GetMonitorLimitAt(siteID string, stationID, monitorID int, t time.Time) (*MonitorLimit, error) {
+	limits, err := GetMonitorLimits(siteID, []int{monitorID}, stationID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return monitorLimitAt(limits, stationID, t), nil
+}
+
+func monitorLimitAt(limits []*MonitorLimit, stationID int, t time.Time) *MonitorLimit {
+	var common *MonitorLimit
+	for _, l := range limits {
+		if !l.IsEffective(t) {
+			continue
+		}
+		if l.StationID == stationID {
+			return l
+		}
+		if common == nil {
+			common = l
+		}
+	}
+
+	return common
+}
+
 type MonitorLimitTemplate struct {
 	ID   int    `json:"ID"`
 	Name string `json:"name"`
@@ -282,7 +419,7 @@ func (m *MonitorLimitTemplate) Add(siteID string) error {
 		return errors.New("请命名模版")
 	}
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name)
 		VALUES
@@ -304,7 +441,7 @@ func (m *MonitorLimitTemplate) Update(siteID string) error {
 		return errors.New("请命名模版")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -320,7 +457,7 @@ func (m *MonitorLimitTemplate) Update(siteID string) error {
 }
 func (m *MonitorLimitTemplate) Delete(siteID string) error {
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -349,7 +486,7 @@ func GetMonitorLimitTemplates(siteID string) ([]*MonitorLimitTemplate, error) {
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get monitor limit template: ", err)
 		return nil, err
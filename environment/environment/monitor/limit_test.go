@@ -0,0 +1,120 @@
+package monitor
+
+import (
+	"database/sql"
+	"obsessiontech/common/util"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEffectiveFromValue(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		effectiveFrom util.Time
+		expect        interface{}
+	}{
+		{util.Time{}, effectiveFromUnset},
+		{util.Time(from), from},
+	}
+
+	for _, c := range cases {
+		if v := effectiveFromValue(c.effectiveFrom); v != c.expect {
+			t.Errorf("effectiveFromValue(%v) = %v, expect %v", time.Time(c.effectiveFrom), v, c.expect)
+		}
+	}
+
+	scanned := []struct {
+		value  sql.NullTime
+		expect time.Time
+	}{
+		{sql.NullTime{}, time.Time{}},
+		{sql.NullTime{Valid: true, Time: time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC)}, time.Time{}},
+		{sql.NullTime{Valid: true, Time: from}, from},
+	}
+
+	for _, c := range scanned {
+		if v := parseEffectiveFrom(c.value); !time.Time(v).Equal(c.expect) {
+			t.Errorf("parseEffectiveFrom(%v) = %v, expect %v", c.value, time.Time(v), c.expect)
+		}
+	}
+}
+
+//同一限值添加两次 唯一索引列取值须相同且不为NULL 才能触发ON DUPLICATE KEY
+func TestAddSameLimitTwice(t *testing.T) {
+	from := util.Time(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+
+	newFlagLimit := func(effectiveFrom util.Time) *FlagLimit {
+		l := &FlagLimit{MonitorID: 1, StationID: 2, Flag: "T", Region: "(0,10)", EffectiveFrom: effectiveFrom}
+		l.Schedule = new(util.Interval)
+		l.Schedule.Init()
+		return l
+	}
+	newMonitorLimit := func(effectiveFrom util.Time) *MonitorLimit {
+		return &MonitorLimit{MonitorID: 1, StationID: 2, Overproof: "10,+∞", EffectiveFrom: effectiveFrom}
+	}
+
+	//monitor_id,station_id,flag,schedule,effective_from
+	flagLimitKey := func(l *FlagLimit) []interface{} {
+		values := l.columnValues()
+		return []interface{}{values[0], values[1], values[2], values[4], values[5]}
+	}
+	//monitor_id,station_id,effective_from
+	monitorLimitKey := func(m *MonitorLimit) []interface{} {
+		values := m.columnValues()
+		return []interface{}{values[0], values[1], values[6]}
+	}
+
+	for _, effectiveFrom := range []util.Time{{}, from} {
+		first, second := flagLimitKey(newFlagLimit(effectiveFrom)), flagLimitKey(newFlagLimit(effectiveFrom))
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("flag limit key %v, second add %v", first, second)
+		}
+		for _, v := range first {
+			if v == nil {
+				t.Errorf("flag limit key contains NULL: %v", first)
+			}
+		}
+
+		first, second = monitorLimitKey(newMonitorLimit(effectiveFrom)), monitorLimitKey(newMonitorLimit(effectiveFrom))
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("monitor limit key %v, second add %v", first, second)
+		}
+		for _, v := range first {
+			if v == nil {
+				t.Errorf("monitor limit key contains NULL: %v", first)
+			}
+		}
+	}
+}
+
+func TestMonitorLimitAt(t *testing.T) {
+	day := func(d int) util.Time { return util.Time(time.Date(2024, 1, d, 0, 0, 0, 0, time.Local)) }
+
+	limits := []*MonitorLimit{
+		{ID: 1, StationID: 0, EffectiveFrom: day(1)},
+		{ID: 2, StationID: 2, EffectiveFrom: day(1), EffectiveTo: day(10)},
+		{ID: 3, StationID: 2, EffectiveFrom: day(10), EffectiveTo: day(20)},
+	}
+
+	for _, c := range []struct {
+		dataTime time.Time
+		expect   int
+	}{
+		{time.Time(day(5)), 2},
+		{time.Time(day(10)), 3},
+		{time.Time(day(15)), 3},
+		//排放点版本均已失效 取通用限值
+		{time.Time(day(25)), 1},
+	} {
+		l := monitorLimitAt(limits, 2, c.dataTime)
+		if l == nil || l.ID != c.expect {
+			t.Errorf("limit at %v: %v, expect %d", c.dataTime, l, c.expect)
+		}
+	}
+
+	if l := monitorLimitAt(limits, 2, time.Time(day(1)).Add(-time.Hour)); l != nil {
+		t.Errorf("limit before effective: %d, expect nil", l.ID)
+	}
+}
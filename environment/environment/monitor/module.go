@@ -14,8 +14,9 @@ const (
 )
 
 type MonitorModule struct {
-	Flags                      []*Flag            `json:"flags"`
-	EffectiveIntervalThreshold map[string]float64 `json:"effectiveIntervalThreshold"`
+	Flags                      []*Flag                  `json:"flags"`
+	EffectiveIntervalThreshold map[string]float64       `json:"effectiveIntervalThreshold"`
+	ValidityRules              map[string]*ValidityRule `json:"validityRules,omitempty"`
 }
 
 func GetModule(siteID string, flags ...bool) (*MonitorModule, error) {
@@ -69,6 +70,9 @@ func (m *MonitorModule) Save(siteID string) error {
 		if err := checkBit(FLAG_OVERPROOF, f, true); err != nil {
 			return err
 		}
+		if err := checkBit(FLAG_INSUFFICIENT, f, false); err != nil {
+			return err
+		}
 		flags[f.Flag] = f
 	}
 
@@ -78,7 +82,20 @@ func (m *MonitorModule) Save(siteID string) error {
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	for dataType, rule := range m.ValidityRules {
+		if err := rule.validate(dataType); err != nil {
+			return err
+		}
+		if rule.Flag != "" {
+			if flags[rule.Flag] == nil {
+				return errors.New("有效性规则标记未定义: " + rule.Flag)
+			}
+		} else if !flagBits[FLAG_INSUFFICIENT] {
+			return e_validity_flag_not_set
+		}
+	}
+
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_MONITOR, true)
 		if err != nil {
 			panic(err)
@@ -55,7 +55,7 @@ func (m *Monitor) Add(siteID string) error {
 		m.Ext = make(map[string]interface{})
 	}
 	ext, _ := json.Marshal(&m.Ext)
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name,type,unit,cou_unit,decimal_precision,ext)
 		VALUES
@@ -86,7 +86,7 @@ func (m *Monitor) Update(siteID string) error {
 			id=?
 	`, monitorTableName(siteID))
 
-	if _, err := datasource.GetConn().Exec(SQL, m.Name, m.Type, m.Unit, m.CouUnit, m.Precision, string(ext), m.ID); err != nil {
+	if _, err := datasource.GetSiteConn(siteID).Exec(SQL, m.Name, m.Type, m.Unit, m.CouUnit, m.Precision, string(ext), m.ID); err != nil {
 		log.Println("error update monitor: ", SQL, err)
 		return err
 	}
@@ -94,7 +94,7 @@ func (m *Monitor) Update(siteID string) error {
 	return nil
 }
 func (m *Monitor) Delete(siteID string) error {
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -167,7 +167,7 @@ func GetMonitors(siteID string, cids []int, monitorTypeBits []int, monitorID ...
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get monitor: ", err)
 		return nil, err
@@ -223,7 +223,7 @@ func GetStationMonitors(siteID string, actionAuth authority.ActionAuthSet, stati
 
 	SQL += "\nORDER BY monitor_code.code ASC"
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get station monitor: ", SQL, values, err)
 		return nil, err
@@ -321,7 +321,7 @@ func CountStationMonitor(siteID string, actionAuth authority.ActionAuthSet, moni
 		}
 	}
 
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT 
 			COUNT(DISTINCT mc.monitor_id), mc.station_id
 		FROM
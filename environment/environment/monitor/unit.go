@@ -0,0 +1,331 @@
+package monitor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+)
+
+const (
+	DIMENSION_MASS_CONCENTRATION = "massConcentration"
+	DIMENSION_VOLUME_RATIO       = "volumeRatio"
+	DIMENSION_FLOW               = "flow"
+	DIMENSION_MASS               = "mass"
+)
+
+const (
+	CONDITION_STANDARD  = "standard"
+	CONDITION_AMBIENT20 = "ambient20"
+	CONDITION_AMBIENT25 = "ambient25"
+)
+
+const molarVolumeStandard = 22.414
+const kelvinOffset = 273.15
+const pressureStandard = 101.325
+
+var e_unknown_unit = errors.New("未知单位")
+var e_unit_dimension_mismatch = errors.New("单位量纲不一致")
+var e_need_molecular_weight = errors.New("浓度与体积比换算需要分子量")
+var e_invalid_reference_condition = errors.New("参比状态不正确")
+
+func init() {
+	dataprocess.Register("unit", func() dataprocess.IDataProcessor { return new(unitProcessor) })
+}
+
+type Unit struct {
+	Symbol    string   `json:"symbol"`
+	Dimension string   `json:"dimension"`
+	Factor    float64  `json:"factor"`
+	Aliases   []string `json:"aliases,omitempty"`
+}
+
+// 同量纲单位换算到基准单位的系数 质量浓度基准mg/m³ 体积比基准ppm 流量基准m³/h 质量基准kg
+var units = []*Unit{
+	{Symbol: "mg/m³", Dimension: DIMENSION_MASS_CONCENTRATION, Factor: 1, Aliases: []string{"mg/m3", "mg/Nm3", "mg/Nm³"}},
+	{Symbol: "μg/m³", Dimension: DIMENSION_MASS_CONCENTRATION, Factor: 1e-3, Aliases: []string{"ug/m3", "µg/m³", "µg/m3", "μg/m3", "ug/m³"}},
+	{Symbol: "ng/m³", Dimension: DIMENSION_MASS_CONCENTRATION, Factor: 1e-6, Aliases: []string{"ng/m3"}},
+	{Symbol: "g/m³", Dimension: DIMENSION_MASS_CONCENTRATION, Factor: 1e3, Aliases: []string{"g/m3"}},
+	{Symbol: "ppm", Dimension: DIMENSION_VOLUME_RATIO, Factor: 1, Aliases: []string{"μmol/mol", "umol/mol"}},
+	{Symbol: "ppb", Dimension: DIMENSION_VOLUME_RATIO, Factor: 1e-3, Aliases: []string{"nmol/mol"}},
+	{Symbol: "ppt", Dimension: DIMENSION_VOLUME_RATIO, Factor: 1e-6},
+	{Symbol: "%vol", Dimension: DIMENSION_VOLUME_RATIO, Factor: 1e4, Aliases: []string{"%VOL", "vol%"}},
+	{Symbol: "m³/h", Dimension: DIMENSION_FLOW, Factor: 1, Aliases: []string{"m3/h"}},
+	{Symbol: "m³/s", Dimension: DIMENSION_FLOW, Factor: 3600, Aliases: []string{"m3/s"}},
+	{Symbol: "L/s", Dimension: DIMENSION_FLOW, Factor: 3.6, Aliases: []string{"l/s"}},
+	{Symbol: "L/min", Dimension: DIMENSION_FLOW, Factor: 0.06, Aliases: []string{"l/min"}},
+	{Symbol: "kg", Dimension: DIMENSION_MASS, Factor: 1},
+	{Symbol: "g", Dimension: DIMENSION_MASS, Factor: 1e-3},
+	{Symbol: "mg", Dimension: DIMENSION_MASS, Factor: 1e-6},
+	{Symbol: "t", Dimension: DIMENSION_MASS, Factor: 1e3, Aliases: []string{"吨"}},
+}
+
+// 常见气体分子量 g/mol 氮氧化物以NO2计
+var molecularWeights = map[string]float64{
+	"SO2":   64.066,
+	"NO":    30.006,
+	"NO2":   46.006,
+	"NOx":   46.006,
+	"CO":    28.010,
+	"CO2":   44.009,
+	"O3":    47.998,
+	"O2":    31.998,
+	"H2S":   34.081,
+	"NH3":   17.031,
+	"HCl":   36.461,
+	"HF":    20.006,
+	"Cl2":   70.906,
+	"CH4":   16.043,
+	"HCHO":  30.026,
+	"C6H6":  78.112,
+	"C7H8":  92.139,
+	"C8H10": 106.165,
+	"CS2":   76.141,
+	"Hg":    200.59,
+}
+
+type ReferenceCondition struct {
+	TemperatureC float64 `json:"temperatureC"`
+	PressureKPa  float64 `json:"pressureKPa"`
+}
+
+var referenceConditions = map[string]*ReferenceCondition{
+	CONDITION_STANDARD:  {TemperatureC: 0, PressureKPa: pressureStandard},
+	CONDITION_AMBIENT20: {TemperatureC: 20, PressureKPa: pressureStandard},
+	CONDITION_AMBIENT25: {TemperatureC: 25, PressureKPa: pressureStandard},
+}
+
+// 理想气体摩尔体积 L/mol
+func (c *ReferenceCondition) MolarVolume() float64 {
+	return molarVolumeStandard * (c.TemperatureC + kelvinOffset) / kelvinOffset * pressureStandard / c.PressureKPa
+}
+
+func GetUnits() []*Unit { return units }
+
+func GetUnit(symbol string) *Unit {
+	symbol = strings.TrimSpace(symbol)
+	for _, u := range units {
+		if u.Symbol == symbol {
+			return u
+		}
+		for _, a := range u.Aliases {
+			if a == symbol {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+func GetMolecularWeight(gas string) (float64, bool) {
+	mw, exists := molecularWeights[gas]
+	return mw, exists
+}
+
+func GetMolecularWeights() map[string]float64 { return molecularWeights }
+
+func GetReferenceConditions() map[string]*ReferenceCondition { return referenceConditions }
+
+func GetReferenceCondition(name string) *ReferenceCondition {
+	return referenceConditions[name]
+}
+
+// 质量浓度与体积比之间换算需要分子量及参比状态 condition为空时按标准状态
+func ConvertUnit(value float64, from, to string, molecularWeight float64, condition *ReferenceCondition) (float64, error) {
+	fromUnit := GetUnit(from)
+	if fromUnit == nil {
+		return 0, fmt.Errorf("%s:%s", e_unknown_unit.Error(), from)
+	}
+	toUnit := GetUnit(to)
+	if toUnit == nil {
+		return 0, fmt.Errorf("%s:%s", e_unknown_unit.Error(), to)
+	}
+
+	if fromUnit == toUnit {
+		return value, nil
+	}
+
+	base := value * fromUnit.Factor
+
+	if fromUnit.Dimension != toUnit.Dimension {
+		if condition == nil {
+			condition = referenceConditions[CONDITION_STANDARD]
+		}
+		if condition.PressureKPa <= 0 || condition.TemperatureC <= -kelvinOffset {
+			return 0, e_invalid_reference_condition
+		}
+
+		switch {
+		case fromUnit.Dimension == DIMENSION_VOLUME_RATIO && toUnit.Dimension == DIMENSION_MASS_CONCENTRATION:
+			if molecularWeight <= 0 {
+				return 0, e_need_molecular_weight
+			}
+			base = base * molecularWeight / condition.MolarVolume()
+		case fromUnit.Dimension == DIMENSION_MASS_CONCENTRATION && toUnit.Dimension == DIMENSION_VOLUME_RATIO:
+			if molecularWeight <= 0 {
+				return 0, e_need_molecular_weight
+			}
+			base = base * condition.MolarVolume() / molecularWeight
+		default:
+			return 0, e_unit_dimension_mismatch
+		}
+	}
+
+	return base / toUnit.Factor, nil
+}
+
+// 将上报数值换算为监测物配置的单位 换算前数值及单位保留在原始数据中
+type unitProcessor struct {
+	dataprocess.BaseDataProcessor
+	FromUnit           string              `json:"fromUnit"`
+	Gas                string              `json:"gas,omitempty"`
+	MolecularWeight    float64             `json:"molecularWeight,omitempty"`
+	Condition          string              `json:"condition,omitempty"`
+	ReferenceCondition *ReferenceCondition `json:"referenceCondition,omitempty"`
+	Fields             []string            `json:"fields,omitempty"`
+}
+
+func (p *unitProcessor) getMolecularWeight() float64 {
+	if p.MolecularWeight > 0 {
+		return p.MolecularWeight
+	}
+	mw, _ := GetMolecularWeight(p.Gas)
+	return mw
+}
+
+func (p *unitProcessor) getReferenceCondition() (*ReferenceCondition, error) {
+	if p.ReferenceCondition != nil {
+		return p.ReferenceCondition, nil
+	}
+	if p.Condition == "" {
+		return referenceConditions[CONDITION_STANDARD], nil
+	}
+	c := GetReferenceCondition(p.Condition)
+	if c == nil {
+		return nil, e_invalid_reference_condition
+	}
+	return c, nil
+}
+
+func (p *unitProcessor) Validate(siteID string) error {
+	if GetUnit(p.FromUnit) == nil {
+		return fmt.Errorf("%s:%s", e_unknown_unit.Error(), p.FromUnit)
+	}
+	if p.Gas != "" && p.MolecularWeight <= 0 {
+		if _, exists := GetMolecularWeight(p.Gas); !exists {
+			return fmt.Errorf("未知气体分子量:%s", p.Gas)
+		}
+	}
+	c, err := p.getReferenceCondition()
+	if err != nil {
+		return err
+	}
+	if c.PressureKPa <= 0 || c.TemperatureC <= -kelvinOffset {
+		return e_invalid_reference_condition
+	}
+	return nil
+}
+
+func (p *unitProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload) (bool, error) {
+
+	m := GetMonitor(siteID, entry.GetMonitorID())
+	if m == nil || m.Unit == "" {
+		log.Println("unit skip monitor without unit: ", siteID, entry.GetMonitorID())
+		return false, nil
+	}
+
+	if GetUnit(m.Unit) == nil {
+		log.Println("unit skip unknown monitor unit: ", siteID, entry.GetMonitorID(), m.Unit)
+		return false, nil
+	}
+
+	if GetUnit(p.FromUnit) == GetUnit(m.Unit) {
+		return false, nil
+	}
+
+	entry.RLockOriginData()
+	_, converted := entry.GetOriginData()[data.ORIGIN_UNIT]
+	entry.RUnlockOriginData()
+	if converted {
+		return false, nil
+	}
+
+	condition, err := p.getReferenceCondition()
+	if err != nil {
+		return false, err
+	}
+
+	mw := p.getMolecularWeight()
+
+	convert := func(v float64) (float64, error) {
+		return ConvertUnit(v, p.FromUnit, m.Unit, mw, condition)
+	}
+
+	if err := modifyFields(entry, p.Fields, convert); err != nil {
+		log.Println("error convert unit: ", p.FromUnit, m.Unit, err)
+		return false, err
+	}
+
+	entry.LockOriginData()
+	originData := entry.GetOriginData()
+	if originData == nil {
+		originData = make(map[string]interface{})
+	}
+	originData[data.ORIGIN_UNIT] = p.FromUnit
+	entry.SetOriginData(originData)
+	entry.UnLockOriginData()
+
+	return false, nil
+}
+
+//按数据段修正数值 原值保留在原始数据中 未指定数据段时修正rtd avg min max
+func modifyFields(entry data.IData, fields []string, modify func(float64) (float64, error)) error {
+	if len(fields) == 0 {
+		fields = []string{data.RTD, data.AVG, data.MIN, data.MAX}
+	}
+
+	for _, field := range fields {
+		var origin float64
+
+		switch field {
+		case data.RTD:
+			rtd, ok := entry.(data.IRealTime)
+			if !ok {
+				continue
+			}
+			origin = rtd.GetRtd()
+		case data.AVG, data.MIN, data.MAX, data.COU:
+			interval, ok := entry.(data.IInterval)
+			if !ok {
+				continue
+			}
+			switch field {
+			case data.AVG:
+				origin = interval.GetAvg()
+			case data.MIN:
+				origin = interval.GetMin()
+			case data.MAX:
+				origin = interval.GetMax()
+			case data.COU:
+				origin = interval.GetCou()
+			}
+		default:
+			continue
+		}
+
+		v, err := modify(origin)
+		if err != nil {
+			return err
+		}
+
+		if err := data.ModifyValue(entry, field, v, -1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvertUnit(t *testing.T) {
+	so2, _ := GetMolecularWeight("SO2")
+
+	cases := []struct {
+		value           float64
+		from            string
+		to              string
+		molecularWeight float64
+		condition       *ReferenceCondition
+		expect          float64
+		err             error
+	}{
+		{12.5, "mg/m3", "mg/m³", 0, nil, 12.5, nil},
+		{1500, "μg/m³", "mg/m³", 0, nil, 1.5, nil},
+		{1000, "ppb", "ppm", 0, nil, 1, nil},
+		{1, "%vol", "ppm", 0, nil, 10000, nil},
+		{1, "m3/s", "m³/h", 0, nil, 3600, nil},
+		{2, "t", "kg", 0, nil, 2000, nil},
+		{1, "ppm", "mg/m³", so2, nil, 64.066 / 22.414, nil},
+		{64.066 / 22.414, "mg/m³", "ppm", so2, nil, 1, nil},
+		{1, "ppm", "mg/m³", so2, GetReferenceCondition(CONDITION_AMBIENT25), 64.066 / (22.414 * 298.15 / 273.15), nil},
+		{1000, "ppb", "μg/m³", so2, nil, 1000 * 64.066 / 22.414, nil},
+		{1, "ppm", "mg/m³", 0, nil, 0, e_need_molecular_weight},
+		{1, "mg/m³", "kg", 0, nil, 0, e_unit_dimension_mismatch},
+		{1, "ppm", "mg/m³", so2, &ReferenceCondition{TemperatureC: 0}, 0, e_invalid_reference_condition},
+	}
+
+	for _, c := range cases {
+		v, err := ConvertUnit(c.value, c.from, c.to, c.molecularWeight, c.condition)
+		if err != c.err {
+			t.Errorf("ConvertUnit(%v, %s, %s) error %v, expect %v", c.value, c.from, c.to, err, c.err)
+			continue
+		}
+		if math.Abs(v-c.expect) > 1e-9*math.Max(1, math.Abs(c.expect)) {
+			t.Errorf("ConvertUnit(%v, %s, %s) = %v, expect %v", c.value, c.from, c.to, v, c.expect)
+		}
+	}
+
+	if _, err := ConvertUnit(1, "mg/L", "mg/m³", 0, nil); err == nil {
+		t.Error("unknown unit should fail")
+	}
+}
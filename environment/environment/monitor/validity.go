@@ -0,0 +1,233 @@
+package monitor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/dataprocess"
+)
+
+var e_validity_rule_not_set = errors.New("未配置有效性规则")
+var e_validity_flag_not_set = errors.New("未配置数据不足标记")
+
+func init() {
+	dataprocess.Register("validity", func() dataprocess.IDataProcessor { return new(validityProcessor) })
+}
+
+//有效性规则 参照HJ 75 小时均值需至少45分钟有效数据 日均值需至少20个有效小时
+type ValidityRule struct {
+	SourceDataType    string  `json:"sourceDataType"`
+	SourceIntervalMin float64 `json:"sourceIntervalMin,omitempty"`
+	MinEffectiveMin   float64 `json:"minEffectiveMin"`
+	Flag              string  `json:"flag,omitempty"`
+}
+
+func getValidityInterval(dataType string) time.Duration {
+	switch dataType {
+	case data.REAL_TIME:
+		return time.Minute
+	case data.MINUTELY:
+		return time.Minute * 10
+	case data.HOURLY:
+		return time.Hour
+	case data.DAILY:
+		return time.Hour * 24
+	}
+	return 0
+}
+
+func (r *ValidityRule) validate(dataType string) error {
+	switch dataType {
+	case data.HOURLY:
+		switch r.SourceDataType {
+		case data.REAL_TIME, data.MINUTELY:
+		default:
+			return fmt.Errorf("小时数据有效性来源数据类型不正确:%s", r.SourceDataType)
+		}
+	case data.DAILY:
+		switch r.SourceDataType {
+		case data.MINUTELY, data.HOURLY:
+		default:
+			return fmt.Errorf("日数据有效性来源数据类型不正确:%s", r.SourceDataType)
+		}
+	default:
+		return fmt.Errorf("仅小时及日数据可配置有效性规则:%s", dataType)
+	}
+
+	if r.SourceIntervalMin < 0 || r.MinEffectiveMin <= 0 {
+		return errors.New("有效性规则时长不正确")
+	}
+
+	if r.MinEffectiveMin > getValidityInterval(dataType).Minutes() {
+		return errors.New("有效性规则时长超出数据周期")
+	}
+
+	return nil
+}
+
+func (r *ValidityRule) sourceInterval() float64 {
+	if r.SourceIntervalMin > 0 {
+		return r.SourceIntervalMin
+	}
+	return getValidityInterval(r.SourceDataType).Minutes()
+}
+
+//数据不足时使用的标记 未指定时取具有数据不足效力的标记
+func (r *ValidityRule) getFlag(siteID string) (*Flag, error) {
+	if r.Flag != "" {
+		f, err := GetFlag(siteID, r.Flag)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, fmt.Errorf("标记未定义:%s", r.Flag)
+		}
+		return f, nil
+	}
+
+	f, err := GetFlagByBit(siteID, FLAG_INSUFFICIENT)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, e_validity_flag_not_set
+	}
+	return f, nil
+}
+
+func GetValidityRule(siteID, dataType string) (*ValidityRule, error) {
+	m, err := GetModule(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if m.ValidityRules == nil {
+		return nil, nil
+	}
+	return m.ValidityRules[dataType], nil
+}
+
+type Validity struct {
+	EffectiveMin float64 `json:"effectiveMin"`
+	RequiredMin  float64 `json:"requiredMin"`
+	Valid        bool    `json:"valid"`
+}
+
+//按有效性规则统计数据周期内有效的来源数据时长
+func EvaluateValidity(siteID string, rule *ValidityRule, entry data.IData) (*Validity, error) {
+
+	beginTime := time.Time(entry.GetDataTime())
+	endTime := beginTime.Add(getValidityInterval(entry.GetDataType()) - time.Second)
+
+	counts, err := data.CountData(siteID, rule.SourceDataType, []int{entry.GetStationID()}, []int{entry.GetMonitorID()}, []int{entry.GetMonitorCodeID()}, beginTime, endTime, nil, nil, false, false, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := 0
+	if flagCounts, ok := counts.(map[string]interface{}); ok {
+		for f, c := range flagCounts {
+			flag, err := GetFlag(siteID, f)
+			if err != nil {
+				return nil, err
+			}
+			if flag == nil || !IsEffectiveFlag(flag.Bits) {
+				continue
+			}
+			effective += c.(int)
+		}
+	}
+
+	return rule.Check(effective, getValidityInterval(entry.GetDataType())), nil
+}
+
+//按来源数据有效条数判定 有效时长不超过数据周期
+func (r *ValidityRule) Check(effectiveCount int, period time.Duration) *Validity {
+	result := &Validity{
+		EffectiveMin: math.Min(float64(effectiveCount)*r.sourceInterval(), period.Minutes()),
+		RequiredMin:  r.MinEffectiveMin,
+	}
+	result.Valid = result.EffectiveMin >= result.RequiredMin
+	return result
+}
+
+//数据不足时使用的标记
+func (r *ValidityRule) GetFlag(siteID string) (string, error) {
+	f, err := r.getFlag(siteID)
+	if err != nil {
+		return "", err
+	}
+	return f.Flag, nil
+}
+
+//有效标记且非数据不足
+func IsEffectiveFlag(bits int) bool {
+	return CheckFlag(FLAG_EFFECTIVE, bits) && !CheckFlag(FLAG_INSUFFICIENT, bits)
+}
+
+type validityProcessor struct {
+	dataprocess.BaseDataProcessor
+}
+
+func (p *validityProcessor) Validate(siteID string) error {
+	m, err := GetModule(siteID)
+	if err != nil {
+		return err
+	}
+	if len(m.ValidityRules) == 0 {
+		return e_validity_rule_not_set
+	}
+	for _, rule := range m.ValidityRules {
+		if _, err := rule.getFlag(siteID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *validityProcessor) ProcessData(siteID string, txn *sql.Tx, entry data.IData, uploader *dataprocess.Uploader, upload dataprocess.IDataUpload) (bool, error) {
+
+	rule, err := GetValidityRule(siteID, entry.GetDataType())
+	if err != nil {
+		return false, err
+	}
+	if rule == nil {
+		return false, nil
+	}
+
+	if CheckFlag(FLAG_MANUAL, entry.GetFlagBit()) {
+		return false, nil
+	}
+
+	validity, err := EvaluateValidity(siteID, rule, entry)
+	if err != nil {
+		return false, err
+	}
+
+	insufficient, err := rule.getFlag(siteID)
+	if err != nil {
+		return false, err
+	}
+
+	if !validity.Valid {
+		log.Println("validity insufficient: ", entry.GetStationID(), entry.GetMonitorID(), entry.GetDataTime(), validity.EffectiveMin, validity.RequiredMin)
+		return false, ChangeFlag(siteID, entry, insufficient.Flag, -1)
+	}
+
+	//补传数据后满足有效性 恢复正常标记
+	if entry.GetFlag() == insufficient.Flag {
+		normal, err := GetFlagByBit(siteID, FLAG_NORMAL)
+		if err != nil {
+			return false, err
+		}
+		if normal != nil {
+			return false, ChangeFlag(siteID, entry, normal.Flag, -1)
+		}
+	}
+
+	return false, nil
+}
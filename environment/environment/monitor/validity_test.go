@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"obsessiontech/environment/environment/data"
+	"testing"
+	"time"
+)
+
+func TestValidityRuleValidate(t *testing.T) {
+	cases := []struct {
+		dataType string
+		rule     *ValidityRule
+		valid    bool
+	}{
+		{data.HOURLY, &ValidityRule{SourceDataType: data.MINUTELY, MinEffectiveMin: 45}, true},
+		{data.HOURLY, &ValidityRule{SourceDataType: data.REAL_TIME, MinEffectiveMin: 45}, true},
+		{data.HOURLY, &ValidityRule{SourceDataType: data.HOURLY, MinEffectiveMin: 45}, false},
+		{data.HOURLY, &ValidityRule{SourceDataType: data.MINUTELY, MinEffectiveMin: 61}, false},
+		{data.HOURLY, &ValidityRule{SourceDataType: data.MINUTELY, MinEffectiveMin: 0}, false},
+		{data.HOURLY, &ValidityRule{SourceDataType: data.MINUTELY, SourceIntervalMin: -1, MinEffectiveMin: 45}, false},
+		{data.DAILY, &ValidityRule{SourceDataType: data.HOURLY, MinEffectiveMin: 20 * 60}, true},
+		{data.DAILY, &ValidityRule{SourceDataType: data.REAL_TIME, MinEffectiveMin: 20 * 60}, false},
+		{data.MINUTELY, &ValidityRule{SourceDataType: data.REAL_TIME, MinEffectiveMin: 5}, false},
+	}
+
+	for i, c := range cases {
+		if err := c.rule.validate(c.dataType); (err == nil) != c.valid {
+			t.Errorf("case %d: validate(%s) = %v, expect valid %v", i, c.dataType, err, c.valid)
+		}
+	}
+}
+
+//HJ 75 小时均值至少45分钟有效数据 日均值至少20个有效小时
+func TestValidityRuleCheck(t *testing.T) {
+	cases := []struct {
+		name         string
+		rule         *ValidityRule
+		count        int
+		period       time.Duration
+		effectiveMin float64
+		valid        bool
+	}{
+		{"hourly from minutely enough", &ValidityRule{SourceDataType: data.MINUTELY, MinEffectiveMin: 45}, 5, time.Hour, 50, true},
+		{"hourly from minutely insufficient", &ValidityRule{SourceDataType: data.MINUTELY, MinEffectiveMin: 45}, 4, time.Hour, 40, false},
+		{"hourly from real time boundary", &ValidityRule{SourceDataType: data.REAL_TIME, MinEffectiveMin: 45}, 45, time.Hour, 45, true},
+		{"hourly from real time insufficient", &ValidityRule{SourceDataType: data.REAL_TIME, MinEffectiveMin: 45}, 44, time.Hour, 44, false},
+		{"hourly custom source interval", &ValidityRule{SourceDataType: data.MINUTELY, SourceIntervalMin: 5, MinEffectiveMin: 45}, 9, time.Hour, 45, true},
+		{"hourly capped by period", &ValidityRule{SourceDataType: data.MINUTELY, MinEffectiveMin: 45}, 30, time.Hour, 60, true},
+		{"daily from hourly enough", &ValidityRule{SourceDataType: data.HOURLY, MinEffectiveMin: 20 * 60}, 20, 24 * time.Hour, 20 * 60, true},
+		{"daily from hourly insufficient", &ValidityRule{SourceDataType: data.HOURLY, MinEffectiveMin: 20 * 60}, 19, 24 * time.Hour, 19 * 60, false},
+		{"no data", &ValidityRule{SourceDataType: data.HOURLY, MinEffectiveMin: 20 * 60}, 0, 24 * time.Hour, 0, false},
+	}
+
+	for _, c := range cases {
+		v := c.rule.Check(c.count, c.period)
+		if v.EffectiveMin != c.effectiveMin || v.Valid != c.valid || v.RequiredMin != c.rule.MinEffectiveMin {
+			t.Errorf("%s: check = %+v, expect %v %v", c.name, v, c.effectiveMin, c.valid)
+		}
+	}
+}
+
+func TestIsEffectiveFlag(t *testing.T) {
+	cases := []struct {
+		bits   int
+		expect bool
+	}{
+		{0, false},
+		{FLAG_EFFECTIVE, true},
+		{FLAG_EFFECTIVE | FLAG_TRANSMISSION, true},
+		{FLAG_EFFECTIVE | FLAG_INSUFFICIENT, false},
+		{FLAG_INSUFFICIENT, false},
+	}
+
+	for _, c := range cases {
+		if v := IsEffectiveFlag(c.bits); v != c.expect {
+			t.Errorf("IsEffectiveFlag(%b) = %v, expect %v", c.bits, v, c.expect)
+		}
+	}
+}
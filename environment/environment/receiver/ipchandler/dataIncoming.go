@@ -3,8 +3,14 @@ package ipchandler
 import (
 	"obsessiontech/environment/environment/data"
 	"obsessiontech/environment/environment/ipcmessage"
+	"obsessiontech/environment/environment/monitor"
+	"obsessiontech/environment/environment/sink"
 )
 
+func init() {
+	monitor.RegisterAlarmEventHandler(ReportAlarmEvent)
+}
+
 func ReportData(incoming data.IData) {
 	switch incoming.(type) {
 	case (*data.RealTimeData):
@@ -21,3 +27,13 @@ func ReportData(incoming data.IData) {
 		broadcast(&convert)
 	}
 }
+
+func ReportSinkMetrics() *ipcmessage.SinkMetricsRes {
+	result := ipcmessage.SinkMetricsRes(sink.GetMetrics(Config.SiteID))
+	return &result
+}
+
+//报警事件由主进程推送订阅
+func ReportAlarmEvent(siteID string, e *monitor.AlarmEvent, entry data.IData) {
+	broadcast(ipcmessage.NewAlarmEvent(e, entry))
+}
@@ -118,6 +118,8 @@ func listen(conn *ipc.Connection) {
 				res = ReloadMonitorCode()
 			case (*ipcmessage.FlagLimitReloadReq):
 				res = ReloadFlagLimit()
+			case (*ipcmessage.SinkMetricsReq):
+				res = ReportSinkMetrics()
 			default:
 				continue
 			}
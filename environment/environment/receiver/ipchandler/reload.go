@@ -8,6 +8,7 @@ import (
 	"obsessiontech/environment/environment/ipcmessage"
 	"obsessiontech/environment/environment/monitor"
 	"obsessiontech/environment/environment/receiver/connection"
+	"obsessiontech/environment/environment/sink"
 )
 
 func ReloadModule() *ipcmessage.Ack {
@@ -24,6 +25,10 @@ func ReloadModule() *ipcmessage.Ack {
 		result = 1
 	}
 
+	if err := sink.Load(Config.SiteID); err != nil {
+		result = 1
+	}
+
 	log.Println("load module: ", result)
 
 	return &result
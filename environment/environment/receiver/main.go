@@ -19,8 +19,10 @@ import (
 	"obsessiontech/environment/environment/receiver/connection"
 	"obsessiontech/environment/environment/receiver/engine"
 	"obsessiontech/environment/environment/receiver/ipchandler"
+	"obsessiontech/environment/environment/receiver/upload"
+	"obsessiontech/environment/environment/sink"
 
-	_ "obsessiontech/environment/environment/data/operation"
+	"obsessiontech/environment/environment/data/operation"
 
 	_ "obsessiontech/environment/environment/receiver/HJ/hjt212"
 	_ "obsessiontech/environment/environment/receiver/fume"
@@ -77,6 +79,11 @@ func main() {
 		panic(err)
 	}
 
+	//数据输出异常不影响接收
+	if err := sink.Load(Config.SiteID); err != nil {
+		log.Println("error load sink: ", err)
+	}
+
 	tcpAddress, err := net.ResolveTCPAddr("tcp4", fmt.Sprintf(":%s", Config.TCPPort))
 	if err != nil {
 		log.Panic(err)
@@ -131,6 +138,9 @@ func main() {
 
 	log.Println("ipc socket host started")
 
+	go operation.StartAggregation(Config.SiteID, upload.ReceiverUpload)
+	go operation.StartRecompute(Config.SiteID)
+
 	for {
 		select {
 		case conn := <-listener:
@@ -9,9 +9,11 @@ import (
 	"strings"
 
 	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/data/operation"
 	"obsessiontech/environment/environment/dataprocess"
 	"obsessiontech/environment/environment/monitor"
 	"obsessiontech/environment/environment/receiver/ipchandler"
+	"obsessiontech/environment/environment/sink"
 )
 
 var e_save_batch = errors.New("批量数据保存失败")
@@ -51,8 +53,12 @@ func (u *upload) UploadBatchData(siteID string, uploader *dataprocess.Uploader,
 
 	for _, d := range dataset {
 		ipchandler.ReportData(d)
+		sink.Report(siteID, d)
 	}
 
+	operation.NotifyAggregation(siteID, dataset...)
+	operation.MarkLateData(siteID, operation.RECOMPUTE_SOURCE_DEVICE, dataset...)
+
 	return nil
 }
 
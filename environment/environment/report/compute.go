@@ -0,0 +1,186 @@
+package report
+
+import (
+	"sort"
+	"time"
+
+	"obsessiontech/common/util"
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+	"obsessiontech/environment/environment/stats"
+)
+
+//报表中的一张表 渲染为xlsx或html时共用
+type table struct {
+	Title  string
+	Header []string
+	Rows   [][]interface{}
+}
+
+type sectionResult struct {
+	Title  string
+	Tables []*table
+}
+
+type monitorStats struct {
+	monitorID       int
+	exceedanceHours int
+	max             float64
+	maxTime         time.Time
+	emission        float64
+	count           int
+}
+
+//统计均基于小时数据 时间区间为[beginTime, endTime)
+func computeSection(siteID string, s *Section, beginTime, endTime time.Time) (*sectionResult, error) {
+
+	result := &sectionResult{Title: s.Title, Tables: make([]*table, 0)}
+
+	stations, err := entity.GetStations(siteID, s.CategoryIDs, nil, entity.ACTIVE, "", "", s.StationIDs...)
+	if err != nil {
+		return nil, err
+	}
+	if len(stations) == 0 {
+		return result, nil
+	}
+
+	stationIDs := make([]int, 0)
+	for _, st := range stations {
+		stationIDs = append(stationIDs, st.ID)
+	}
+
+	if s.has(STATS_QUALITY) {
+		qualities, err := stats.GetStationDataQuality(siteID, authority.ActionAuthSet{{Action: entity.ACTION_ADMIN_VIEW}}, &beginTime, &endTime, stationIDs...)
+		if err != nil {
+			return nil, err
+		}
+
+		t := &table{Title: "数据传输有效率", Header: []string{"排放口", "应传输数", "传输数", "有效数", "传输率(%)", "有效率(%)", "有效传输率(%)"}}
+		for _, st := range stations {
+			q := qualities[st.ID]
+			if q == nil {
+				continue
+			}
+			t.Rows = append(t.Rows, []interface{}{st.Name, q.SlotCount, q.TransCount, q.EffectCount, percent(q.TransRate), percent(q.EffectRate), percent(q.EffectTransRate)})
+		}
+		result.Tables = append(result.Tables, t)
+	}
+
+	if s.has(STATS_EXCEEDANCE) || s.has(STATS_MAX) || s.has(STATS_EMISSION) {
+		exceedance := &table{Title: "超标小时数", Header: []string{"排放口", "监测物", "有效小时数", "超标小时数"}}
+		max := &table{Title: "最大值", Header: []string{"排放口", "监测物", "最大小时均值", "单位", "出现时间"}}
+		emission := &table{Title: "排放量", Header: []string{"排放口", "监测物", "排放量", "单位"}}
+
+		for _, st := range stations {
+			monitorStatsList, err := computeMonitorStats(siteID, st.ID, s.MonitorIDs, beginTime, endTime)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ms := range monitorStatsList {
+				m := monitor.GetMonitor(siteID, ms.monitorID)
+				if m == nil {
+					continue
+				}
+
+				exceedance.Rows = append(exceedance.Rows, []interface{}{st.Name, m.Name, ms.count, ms.exceedanceHours})
+				if ms.count > 0 {
+					max.Rows = append(max.Rows, []interface{}{st.Name, m.Name, ms.max, m.Unit, util.FormatDateTime(ms.maxTime)})
+				}
+				if m.CouUnit != "" {
+					emission.Rows = append(emission.Rows, []interface{}{st.Name, m.Name, ms.emission, m.CouUnit})
+				}
+			}
+		}
+
+		if s.has(STATS_EXCEEDANCE) {
+			result.Tables = append(result.Tables, exceedance)
+		}
+		if s.has(STATS_MAX) {
+			result.Tables = append(result.Tables, max)
+		}
+		if s.has(STATS_EMISSION) {
+			result.Tables = append(result.Tables, emission)
+		}
+	}
+
+	if s.has(STATS_GAP) {
+		vacancies, err := data.GetDataVacancy(siteID, data.HOURLY, stationIDs, beginTime, endTime)
+		if err != nil {
+			return nil, err
+		}
+
+		t := &table{Title: "数据缺失时段", Header: []string{"排放口", "开始时间", "结束时间", "缺失小时数"}}
+		for _, st := range stations {
+			for _, v := range vacancies[st.ID] {
+				//缺失起点可能回溯至周期之前
+				if v[0].Before(beginTime) {
+					v[0] = beginTime
+				}
+				hours := int(v[1].Sub(v[0]).Hours()) + 1
+				t.Rows = append(t.Rows, []interface{}{st.Name, util.FormatDateTime(v[0]), util.FormatDateTime(v[1]), hours})
+			}
+		}
+		result.Tables = append(result.Tables, t)
+	}
+
+	return result, nil
+}
+
+func computeMonitorStats(siteID string, stationID int, monitorIDs []int, beginTime, endTime time.Time) ([]*monitorStats, error) {
+
+	dataList, err := data.GetData(siteID, data.HOURLY, []int{stationID}, monitorIDs, nil, nil, beginTime, endTime.Add(-time.Second), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	statsMap := make(map[int]*monitorStats)
+	for _, id := range monitorIDs {
+		statsMap[id] = &monitorStats{monitorID: id}
+	}
+
+	for _, d := range dataList {
+		interval, ok := d.(data.IInterval)
+		if !ok {
+			continue
+		}
+
+		ms, exists := statsMap[d.GetMonitorID()]
+		if !exists {
+			ms = &monitorStats{monitorID: d.GetMonitorID()}
+			statsMap[d.GetMonitorID()] = ms
+		}
+
+		flag, err := monitor.GetFlag(siteID, d.GetFlag())
+		if err != nil {
+			return nil, err
+		}
+		if flag == nil || !monitor.IsEffectiveFlag(flag.Bits) {
+			continue
+		}
+
+		ms.count++
+		if monitor.CheckFlag(monitor.FLAG_OVERPROOF, flag.Bits) {
+			ms.exceedanceHours++
+		}
+		if ms.count == 1 || interval.GetAvg() > ms.max {
+			ms.max = interval.GetAvg()
+			ms.maxTime = time.Time(d.GetDataTime())
+		}
+		ms.emission += interval.GetCou()
+	}
+
+	result := make([]*monitorStats, 0)
+	for _, ms := range statsMap {
+		result = append(result, ms)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].monitorID < result[j].monitorID })
+
+	return result, nil
+}
+
+func percent(rate float64) float64 {
+	return float64(int(rate*10000+0.5)) / 100
+}
@@ -0,0 +1,72 @@
+package report
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"obsessiontech/common/util"
+	"obsessiontech/environment/event"
+)
+
+const EVENT_ENVIRONMENT_REPORT = "environment_report"
+
+func init() {
+	event.Register(EVENT_ENVIRONMENT_REPORT, func() event.IEvent {
+		return new(ReportEvent)
+	})
+}
+
+//MainRelateID为报表模板ID 按模板周期生成上一个完整周期的报表
+type ReportEvent struct{}
+
+func (e *ReportEvent) ValidateEvent(siteID string, eventInstance *event.Event) error {
+
+	templateID, err := strconv.Atoi(eventInstance.MainRelateID)
+	if err != nil {
+		return errors.New("需要报表模板")
+	}
+
+	if _, err := GetTemplate(siteID, templateID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (e *ReportEvent) ExecuteEvent(siteID string, txn *sql.Tx, eventInstance *event.Event) error {
+
+	templateID, err := strconv.Atoi(eventInstance.MainRelateID)
+	if err != nil {
+		return errors.New("需要报表模板")
+	}
+
+	t, err := GetTemplate(siteID, templateID)
+	if err != nil {
+		return err
+	}
+
+	beginTime, endTime := t.GetPeriod(time.Now())
+
+	r, err := Generate(siteID, txn, t, beginTime, endTime, 0)
+	if err != nil {
+		return err
+	}
+
+	pushed := Deliver(siteID, r)
+
+	eventInstance.Feedback(event.SUCCESS, map[string]interface{}{
+		"at":       util.Time(time.Now()),
+		"reportID": r.ID,
+		"files":    r.Files,
+		"pushed":   pushed,
+	})
+
+	if err := eventInstance.UpdateStatusWithTxn(siteID, txn); err != nil {
+		log.Println("error update event after generate report: ", err)
+	}
+
+	return nil
+}
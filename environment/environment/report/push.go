@@ -0,0 +1,193 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/subscription"
+	"obsessiontech/environment/push"
+	"obsessiontech/environment/user"
+	"obsessiontech/environment/wechat"
+)
+
+//订阅报表 ext中templateID为订阅的模板
+const SUBSCRIPTION_REPORT = "environment_report"
+
+func init() {
+	push.RegisterSubsciption(SUBSCRIPTION_REPORT, func(sub *push.Subscription) push.IPush {
+		p := new(ReportSubscription)
+		p.Subscription = *sub
+		return p
+	})
+}
+
+type ReportSubscription struct {
+	push.Subscription
+	Report *Report
+}
+
+func (s *ReportSubscription) GetSubscriptionType() string {
+	return s.Type
+}
+func (s *ReportSubscription) GetPushType() string {
+	return s.Push
+}
+
+//报表按计划生成 生成即推送
+func (s *ReportSubscription) ShouldPush(siteID string) error {
+	return nil
+}
+
+//推送至订阅该模板的用户 推送失败不影响报表生成
+func Deliver(siteID string, r *Report) int {
+	subs, err := push.GetSubscriptionList(siteID, "user", 0, SUBSCRIPTION_REPORT, "", map[string][]any{"templateID": {r.TemplateID}})
+	if err != nil {
+		log.Println("error get report subscriptions: ", siteID, r.ID, err)
+		return 0
+	}
+
+	var pushed int
+	for _, sub := range subs {
+		p := &ReportSubscription{Subscription: *sub, Report: r}
+		if err := push.Push(siteID, p); err != nil {
+			log.Println("error push report: ", siteID, r.ID, sub.ID, err)
+			continue
+		}
+		pushed++
+	}
+
+	return pushed
+}
+
+func (s *ReportSubscription) period() string {
+	return fmt.Sprintf("%s 至 %s", util.FormatDate(time.Time(s.Report.BeginTime)), util.FormatDate(time.Time(s.Report.EndTime)))
+}
+
+func (s *ReportSubscription) pushDetail(siteID string) (*subscription.SubscriptionModule, *subscription.PushDetail, error) {
+	m, err := subscription.GetModule(siteID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pSetting := m.PushSettings[SUBSCRIPTION_REPORT]
+	if pSetting == nil || pSetting.Trigger == nil {
+		return nil, nil, errors.New("未设置该推送")
+	}
+
+	return m, pSetting.Trigger, nil
+}
+
+//Implement AliSmsPush interface
+func (s *ReportSubscription) GetMobile(siteID string) (string, error) {
+	if s.SubscriberType != "user" {
+		return "", push.E_invalid_subsriber
+	}
+	u, err := user.GetUser(siteID, "id", s.SubscriberID)
+	if err != nil {
+		log.Println("error push report subscription: ", err, s.ID)
+		return "", err
+	}
+	if u.Mobile == "" {
+		return "", errors.New("用户未设置手机号")
+	}
+	return u.Mobile, nil
+}
+
+func (s *ReportSubscription) GetTemplateCode(siteID string) (string, error) {
+	_, detail, err := s.pushDetail(siteID)
+	if err != nil {
+		return "", err
+	}
+	if detail.SMSTemplateID == "" {
+		return "", errors.New("未设置该推送")
+	}
+	return detail.SMSTemplateID, nil
+}
+
+func (s *ReportSubscription) GetSignature(siteID string) (string, error) {
+	m, _, err := s.pushDetail(siteID)
+	if err != nil {
+		return "", err
+	}
+	if m.SMSSignature == "" {
+		return "", errors.New("未设置短信签名")
+	}
+	return m.SMSSignature, nil
+}
+
+func (s *ReportSubscription) GetSMSParam(siteID string) (map[string]string, error) {
+	if s.Report == nil {
+		return nil, e_report_not_exists
+	}
+	return map[string]string{"name": s.Report.Name, "period": s.period()}, nil
+}
+
+//Implement WxOpenTemplatePush interface
+func (s *ReportSubscription) GetPushParam(siteID string) ([]push.WxOpenTemplatePushParam, error) {
+	m, detail, err := s.pushDetail(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.SubscriberType != "user" {
+		return nil, push.E_invalid_subsriber
+	}
+	u, err := user.GetUser(siteID, "id", s.SubscriberID)
+	if err != nil {
+		return nil, err
+	}
+
+	//订阅时校验无报表
+	keywords := make([]string, 0)
+	if s.Report != nil {
+		keywords = append(keywords, s.Report.Name, s.period())
+	}
+
+	result := make([]push.WxOpenTemplatePushParam, 0)
+
+	for _, setting := range m.WxSettings {
+		templateID := detail.WxOpenTemplateIDs[setting.WxOpenAppID]
+		if templateID == "" {
+			continue
+		}
+
+		info, exists := u.WechatInfo[setting.WxOpenAppID]
+		if !exists {
+			continue
+		}
+		openID, exists := info.(map[string]interface{})["openid"]
+		if !exists {
+			openID, exists = info.(map[string]interface{})["openId"]
+		}
+		if !exists {
+			continue
+		}
+
+		accessToken, err := wechat.GetAgentAccessToken(setting.WxOpenAppID)
+		if err != nil {
+			log.Println("error get access token: ", err)
+			continue
+		}
+
+		result = append(result, push.WxOpenTemplatePushParam{
+			AccessToken: accessToken,
+			TemplateID:  templateID,
+			OpenID:      openID.(string),
+			First:       "报表已生成",
+			Keywords:    keywords,
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("未与微信账号绑定")
+	}
+
+	return result, nil
+}
+
+func (s *ReportSubscription) Delete(siteID string) error {
+	return nil
+}
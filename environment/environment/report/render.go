@@ -0,0 +1,122 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+type document struct {
+	Name         string
+	BeginTime    string
+	EndTime      string
+	GenerateTime string
+	Sections     []*sectionResult
+}
+
+func render(format string, doc *document, w io.Writer) error {
+	switch format {
+	case FORMAT_XLSX:
+		return renderXLSX(doc, w)
+	case FORMAT_HTML:
+		return renderHTML(doc, w)
+	}
+	return e_invalid_format
+}
+
+//每节一个工作表 表与表之间空一行
+func renderXLSX(doc *document, w io.Writer) error {
+	f := excelize.NewFile()
+
+	for i, s := range doc.Sections {
+		sheet := sheetName(i, s.Title)
+		if i == 0 {
+			f.SetSheetName("Sheet1", sheet)
+		} else {
+			f.NewSheet(sheet)
+		}
+
+		row := 1
+		setRow := func(cells []interface{}) error {
+			axis, err := excelize.CoordinatesToCellName(1, row)
+			if err != nil {
+				return err
+			}
+			row++
+			return f.SetSheetRow(sheet, axis, &cells)
+		}
+
+		if err := setRow([]interface{}{doc.Name, fmt.Sprintf("%s 至 %s", doc.BeginTime, doc.EndTime)}); err != nil {
+			return err
+		}
+		row++
+
+		for _, t := range s.Tables {
+			if err := setRow([]interface{}{t.Title}); err != nil {
+				return err
+			}
+			header := make([]interface{}, len(t.Header))
+			for j, h := range t.Header {
+				header[j] = h
+			}
+			if err := setRow(header); err != nil {
+				return err
+			}
+			for _, r := range t.Rows {
+				if err := setRow(r); err != nil {
+					return err
+				}
+			}
+			row++
+		}
+	}
+
+	return f.Write(w)
+}
+
+//工作表名称最长31字符且不可重复
+func sheetName(index int, title string) string {
+	name := fmt.Sprintf("%d.%s", index+1, title)
+	if utf8.RuneCountInString(name) > 31 {
+		name = string([]rune(name)[:31])
+	}
+	return name
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body{font-family:sans-serif;font-size:14px}
+table{border-collapse:collapse;margin-bottom:16px}
+th,td{border:1px solid #999;padding:4px 8px}
+th{background:#eee}
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>统计时段: {{.BeginTime}} 至 {{.EndTime}} 生成时间: {{.GenerateTime}}</p>
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+{{range .Tables}}
+<h3>{{.Title}}</h3>
+<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{else}}<tr><td colspan="{{len .Header}}">无</td></tr>
+{{end}}
+</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func renderHTML(doc *document, w io.Writer) error {
+	return htmlTemplate.Execute(w, doc)
+}
@@ -0,0 +1,273 @@
+package report
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/resource"
+)
+
+var e_report_not_exists = errors.New("报表不存在")
+var e_invalid_report_time = errors.New("报表时间不正确")
+
+//已生成的报表 文件作为站点资源存放于report目录
+type Report struct {
+	ID         int       `json:"ID"`
+	TemplateID int       `json:"templateID"`
+	Name       string    `json:"name"`
+	BeginTime  util.Time `json:"beginTime"`
+	EndTime    util.Time `json:"endTime"`
+	Files      []string  `json:"files"`
+	UID        int       `json:"UID"`
+	CreateTime util.Time `json:"createTime"`
+}
+
+const REPORT_FOLDER = "/report/"
+
+func reportTableName(siteID string) string {
+	return siteID + "_report"
+}
+
+//Files中为相对站点资源目录的资源路径 此处返回磁盘路径
+func (r *Report) FilePath(siteID, file string) string {
+	return resource.Config.ResourceFolderPath + siteID + REPORT_FOLDER + path.Base(file)
+}
+
+const reportColumns = "report.id, report.template_id, report.name, report.begin_time, report.end_time, report.files, report.uid, report.create_time"
+
+func (r *Report) scan(rows *sql.Rows) error {
+	var files string
+	var beginTime, endTime, createTime time.Time
+	if err := rows.Scan(&r.ID, &r.TemplateID, &r.Name, &beginTime, &endTime, &files, &r.UID, &createTime); err != nil {
+		return err
+	}
+	r.BeginTime = util.Time(beginTime)
+	r.EndTime = util.Time(endTime)
+	r.CreateTime = util.Time(createTime)
+	return json.Unmarshal([]byte(files), &r.Files)
+}
+
+//按模板生成报表并保存 uid为0时为定时生成
+func Generate(siteID string, txn *sql.Tx, t *Template, beginTime, endTime time.Time, uid int) (*Report, error) {
+
+	if beginTime.IsZero() || !endTime.After(beginTime) {
+		return nil, e_invalid_report_time
+	}
+
+	doc := &document{
+		Name:         t.Name,
+		BeginTime:    util.FormatDateTime(beginTime),
+		EndTime:      util.FormatDateTime(endTime),
+		GenerateTime: util.FormatDateTime(time.Now()),
+		Sections:     make([]*sectionResult, 0),
+	}
+
+	for _, s := range t.Sections {
+		result, err := computeSection(siteID, s, beginTime, endTime)
+		if err != nil {
+			log.Println("error compute report section: ", siteID, t.ID, s.Title, err)
+			return nil, err
+		}
+		doc.Sections = append(doc.Sections, result)
+	}
+
+	r := &Report{TemplateID: t.ID, Name: t.Name, BeginTime: util.Time(beginTime), EndTime: util.Time(endTime), Files: make([]string, 0), UID: uid, CreateTime: util.Time(time.Now())}
+
+	ret, err := txn.Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(template_id, name, begin_time, end_time, files, uid)
+		VALUES
+			(?, ?, ?, ?, '[]', ?)
+	`, reportTableName(siteID)), r.TemplateID, r.Name, beginTime, endTime, r.UID)
+	if err != nil {
+		log.Println("error add report: ", err)
+		return nil, err
+	}
+	id, err := ret.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	r.ID = int(id)
+
+	if err := os.MkdirAll(resource.Config.ResourceFolderPath+siteID+REPORT_FOLDER, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	for _, format := range t.Formats {
+		file := REPORT_FOLDER + fmt.Sprintf("%d_%s_%s.%s", r.ID, strings.ReplaceAll(t.Name, "/", "_"), util.FormatDate(beginTime), format)
+		r.Files = append(r.Files, file)
+		if err := r.write(siteID, file, format, doc); err != nil {
+			log.Println("error render report: ", siteID, file, err)
+			r.removeFiles(siteID)
+			return nil, err
+		}
+	}
+
+	files, _ := json.Marshal(r.Files)
+	if _, err := txn.Exec(fmt.Sprintf(`
+		UPDATE %s SET
+			files = ?
+		WHERE
+			id = ?
+	`, reportTableName(siteID)), string(files), r.ID); err != nil {
+		log.Println("error update report files: ", err)
+		r.removeFiles(siteID)
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func GenerateReport(siteID string, t *Template, beginTime, endTime time.Time, uid int) (*Report, error) {
+	var r *Report
+	if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		var err error
+		if r, err = Generate(siteID, txn, t, beginTime, endTime, uid); err != nil {
+			panic(err)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Report) write(siteID, file, format string, doc *document) error {
+	f, err := os.Create(r.FilePath(siteID, file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return render(format, doc, f)
+}
+
+func (r *Report) removeFiles(siteID string) {
+	for _, file := range r.Files {
+		os.Remove(r.FilePath(siteID, file))
+	}
+}
+
+func (r *Report) Delete(siteID string) error {
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		DELETE FROM %s WHERE id = ?
+	`, reportTableName(siteID)), r.ID)
+	if err != nil {
+		log.Println("error delete report: ", err)
+		return err
+	}
+
+	if affected, _ := ret.RowsAffected(); affected == 0 {
+		return e_report_not_exists
+	}
+
+	r.removeFiles(siteID)
+
+	return nil
+}
+
+func GetReport(siteID string, reportID int) (*Report, error) {
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s report
+		WHERE
+			report.id = ?
+	`, reportColumns, reportTableName(siteID)), reportID)
+	if err != nil {
+		log.Println("error get report: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var r Report
+		if err := r.scan(rows); err != nil {
+			log.Println("error get report: ", err)
+			return nil, err
+		}
+		return &r, nil
+	}
+
+	return nil, e_report_not_exists
+}
+
+//templateID为0时返回全部模板的报表
+func GetReports(siteID string, templateID int, beginTime, endTime *time.Time, pageNo, pageSize int) ([]*Report, int, error) {
+
+	whereStmts := []string{"1 = 1"}
+	values := make([]interface{}, 0)
+
+	if templateID > 0 {
+		whereStmts = append(whereStmts, "report.template_id = ?")
+		values = append(values, templateID)
+	}
+	if beginTime != nil {
+		whereStmts = append(whereStmts, "report.begin_time >= ?")
+		values = append(values, *beginTime)
+	}
+	if endTime != nil {
+		whereStmts = append(whereStmts, "report.begin_time < ?")
+		values = append(values, *endTime)
+	}
+
+	var total int
+	if err := datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf(`
+		SELECT
+			COUNT(1)
+		FROM
+			%s report
+		WHERE
+			%s
+	`, reportTableName(siteID), strings.Join(whereStmts, " AND ")), values...).Scan(&total); err != nil {
+		log.Println("error count reports: ", err)
+		return nil, 0, err
+	}
+
+	SQL := fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s report
+		WHERE
+			%s
+		ORDER BY
+			report.id DESC
+	`, reportColumns, reportTableName(siteID), strings.Join(whereStmts, " AND "))
+
+	if pageSize > 0 {
+		if pageNo <= 0 {
+			pageNo = 1
+		}
+		SQL += " LIMIT ?, ?"
+		values = append(values, (pageNo-1)*pageSize, pageSize)
+	}
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
+	if err != nil {
+		log.Println("error get reports: ", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := make([]*Report, 0)
+	for rows.Next() {
+		var r Report
+		if err := r.scan(rows); err != nil {
+			log.Println("error get reports: ", err)
+			return nil, 0, err
+		}
+		result = append(result, &r)
+	}
+
+	return result, total, nil
+}
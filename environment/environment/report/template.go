@@ -0,0 +1,324 @@
+package report
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"obsessiontech/common/datasource"
+	"obsessiontech/common/util"
+	"obsessiontech/environment/site/initialization"
+)
+
+func init() {
+	initialization.RegisterMigrations(MODULE_REPORT, "realtimedata", reportMigration)
+}
+
+const (
+	MODULE_REPORT = "environment_report"
+
+	ACTION_ADMIN_VIEW = "admin_view"
+	ACTION_ADMIN_EDIT = "admin_edit"
+)
+
+const (
+	PERIOD_DAILY   = "daily"
+	PERIOD_WEEKLY  = "weekly"
+	PERIOD_MONTHLY = "monthly"
+)
+
+const (
+	FORMAT_XLSX = "xlsx"
+	FORMAT_HTML = "html"
+)
+
+//统计项
+const (
+	STATS_QUALITY    = "quality"
+	STATS_EXCEEDANCE = "exceedance"
+	STATS_MAX        = "max"
+	STATS_EMISSION   = "emission"
+	STATS_GAP        = "gap"
+)
+
+var e_template_not_exists = errors.New("报表模板不存在")
+var e_need_template_name = errors.New("需要报表名称")
+var e_invalid_period = errors.New("报表周期不正确")
+var e_invalid_format = errors.New("报表格式不正确")
+var e_invalid_statistics = errors.New("统计项不正确")
+var e_need_section = errors.New("需要报表分节")
+
+//报表模板 按分节选取排放口及监测物 并指定各节的统计项
+type Template struct {
+	ID         int        `json:"ID"`
+	Name       string     `json:"name"`
+	Period     string     `json:"period"`
+	Formats    []string   `json:"formats"`
+	Sections   []*Section `json:"sections"`
+	CreateTime util.Time  `json:"createTime"`
+	UpdateTime util.Time  `json:"updateTime"`
+}
+
+//StationIDs及CategoryIDs均为空时选取全部在用排放口 MonitorIDs为空时统计有数据的全部监测物
+type Section struct {
+	Title       string   `json:"title"`
+	StationIDs  []int    `json:"stationIDs"`
+	CategoryIDs []int    `json:"categoryIDs"`
+	MonitorIDs  []int    `json:"monitorIDs"`
+	Statistics  []string `json:"statistics"`
+}
+
+func templateTableName(siteID string) string {
+	return siteID + "_reporttemplate"
+}
+
+//报表模板表及报表表
+var reportMigration = &initialization.Migration{
+	Version:     1,
+	Description: "报表模板及报表",
+	SQL: []string{`
+		CREATE TABLE IF NOT EXISTS {siteID}_reporttemplate (
+			id INT NOT NULL AUTO_INCREMENT,
+			name VARCHAR(64) NOT NULL,
+			period VARCHAR(32) NOT NULL,
+			formats TEXT,
+			sections TEXT,
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			update_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`, `
+		CREATE TABLE IF NOT EXISTS {siteID}_report (
+			id INT NOT NULL AUTO_INCREMENT,
+			template_id INT NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			begin_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			files TEXT,
+			uid INT NOT NULL DEFAULT 0,
+			create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id),
+			KEY (template_id, begin_time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`},
+}
+
+const templateColumns = "template.id, template.name, template.period, template.formats, template.sections, template.create_time, template.update_time"
+
+func (t *Template) scan(rows *sql.Rows) error {
+	var formats, sections string
+	var createTime, updateTime time.Time
+	if err := rows.Scan(&t.ID, &t.Name, &t.Period, &formats, &sections, &createTime, &updateTime); err != nil {
+		return err
+	}
+	t.CreateTime = util.Time(createTime)
+	t.UpdateTime = util.Time(updateTime)
+	if err := json.Unmarshal([]byte(formats), &t.Formats); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(sections), &t.Sections)
+}
+
+func (t *Template) validate() error {
+	if t.Name == "" {
+		return e_need_template_name
+	}
+
+	switch t.Period {
+	case PERIOD_DAILY:
+	case PERIOD_WEEKLY:
+	case PERIOD_MONTHLY:
+	default:
+		return e_invalid_period
+	}
+
+	if len(t.Formats) == 0 {
+		t.Formats = []string{FORMAT_XLSX}
+	}
+	for _, f := range t.Formats {
+		switch f {
+		case FORMAT_XLSX:
+		case FORMAT_HTML:
+		default:
+			return e_invalid_format
+		}
+	}
+
+	if len(t.Sections) == 0 {
+		return e_need_section
+	}
+	for i, s := range t.Sections {
+		if s.Title == "" {
+			s.Title = fmt.Sprintf("第%d节", i+1)
+		}
+		if len(s.Statistics) == 0 {
+			s.Statistics = []string{STATS_QUALITY, STATS_EXCEEDANCE, STATS_MAX, STATS_EMISSION, STATS_GAP}
+		}
+		for _, st := range s.Statistics {
+			switch st {
+			case STATS_QUALITY:
+			case STATS_EXCEEDANCE:
+			case STATS_MAX:
+			case STATS_EMISSION:
+			case STATS_GAP:
+			default:
+				return e_invalid_statistics
+			}
+		}
+	}
+
+	return nil
+}
+
+//返回上一个完整周期
+func (t *Template) GetPeriod(at time.Time) (time.Time, time.Time) {
+	today := util.GetDate(at)
+	switch t.Period {
+	case PERIOD_WEEKLY:
+		weekday := int(today.Weekday()+6) % 7
+		end := today.AddDate(0, 0, -weekday)
+		return end.AddDate(0, 0, -7), end
+	case PERIOD_MONTHLY:
+		end := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+		return end.AddDate(0, -1, 0), end
+	default:
+		return today.AddDate(0, 0, -1), today
+	}
+}
+
+func (s *Section) has(statistics string) bool {
+	for _, st := range s.Statistics {
+		if st == statistics {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Template) Add(siteID string) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	formats, _ := json.Marshal(t.Formats)
+	sections, _ := json.Marshal(t.Sections)
+
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		INSERT INTO %s
+			(name, period, formats, sections)
+		VALUES
+			(?, ?, ?, ?)
+	`, templateTableName(siteID)), t.Name, t.Period, string(formats), string(sections))
+	if err != nil {
+		log.Println("error add report template: ", err)
+		return err
+	}
+
+	id, err := ret.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = int(id)
+
+	return nil
+}
+
+func (t *Template) Update(siteID string) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	formats, _ := json.Marshal(t.Formats)
+	sections, _ := json.Marshal(t.Sections)
+
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		UPDATE %s SET
+			name = ?, period = ?, formats = ?, sections = ?
+		WHERE
+			id = ?
+	`, templateTableName(siteID)), t.Name, t.Period, string(formats), string(sections), t.ID)
+	if err != nil {
+		log.Println("error update report template: ", err)
+		return err
+	}
+
+	if affected, _ := ret.RowsAffected(); affected == 0 {
+		if _, err := GetTemplate(siteID, t.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Template) Delete(siteID string) error {
+	ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
+		DELETE FROM %s WHERE id = ?
+	`, templateTableName(siteID)), t.ID)
+	if err != nil {
+		log.Println("error delete report template: ", err)
+		return err
+	}
+
+	if affected, _ := ret.RowsAffected(); affected == 0 {
+		return e_template_not_exists
+	}
+
+	return nil
+}
+
+func GetTemplate(siteID string, templateID int) (*Template, error) {
+	list, err := GetTemplates(siteID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, e_template_not_exists
+	}
+	return list[0], nil
+}
+
+func GetTemplates(siteID string, templateID ...int) ([]*Template, error) {
+
+	SQL := fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s template
+	`, templateColumns, templateTableName(siteID))
+
+	values := make([]interface{}, 0)
+	if len(templateID) > 0 {
+		placeholder := make([]string, 0)
+		for _, id := range templateID {
+			placeholder = append(placeholder, "?")
+			values = append(values, id)
+		}
+		SQL += fmt.Sprintf("WHERE template.id IN (%s)", strings.Join(placeholder, ","))
+	}
+
+	SQL += " ORDER BY template.id ASC"
+
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
+	if err != nil {
+		log.Println("error get report templates: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Template, 0)
+	for rows.Next() {
+		var t Template
+		if err := t.scan(rows); err != nil {
+			log.Println("error get report templates: ", err)
+			return nil, err
+		}
+		result = append(result, &t)
+	}
+
+	return result, nil
+}
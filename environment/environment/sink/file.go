@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//按行json写入 Config.EnvironmentSinkFolder/站点/输出名称/ 下
+type fileSender struct {
+	folder string
+	param  *FileParam
+
+	file   *os.File
+	period string
+	index  int
+	size   int64
+}
+
+func newFileSender(siteID string, s *Sink) (iSender, error) {
+	folder := filepath.Join(Config.EnvironmentSinkFolder, siteID, "file", s.Name)
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+
+	param := *s.File
+	if param.Prefix == "" {
+		param.Prefix = s.Name
+	}
+	if param.RotateBy == "" {
+		param.RotateBy = "hour"
+	}
+
+	return &fileSender{folder: folder, param: &param}, nil
+}
+
+func (f *fileSender) periodOf(t time.Time) string {
+	if f.param.RotateBy == "day" {
+		return t.Format("20060102")
+	}
+	return t.Format("2006010215")
+}
+
+func (f *fileSender) rotate(period string) error {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+
+	if period != f.period {
+		f.period = period
+		f.index = 0
+	} else {
+		f.index++
+	}
+
+	name := fmt.Sprintf("%s_%s.ndjson", f.param.Prefix, period)
+	if f.index > 0 {
+		name = fmt.Sprintf("%s_%s_%d.ndjson", f.param.Prefix, period, f.index)
+	}
+
+	file, err := os.OpenFile(filepath.Join(f.folder, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.size = 0
+	if info, err := file.Stat(); err == nil {
+		f.size = info.Size()
+	}
+
+	f.clean()
+
+	return nil
+}
+
+//超出保留数量时删除最早的文件
+func (f *fileSender) clean() {
+	if f.param.MaxFiles <= 0 {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(f.folder, f.param.Prefix+"_*.ndjson"))
+	if err != nil || len(files) <= f.param.MaxFiles {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		fi, ierr := os.Stat(files[i])
+		fj, jerr := os.Stat(files[j])
+		if ierr != nil || jerr != nil {
+			return strings.Compare(files[i], files[j]) < 0
+		}
+		return fi.ModTime().Before(fj.ModTime())
+	})
+
+	for _, name := range files[:len(files)-f.param.MaxFiles] {
+		os.Remove(name)
+	}
+}
+
+func (f *fileSender) send(records []*Record) error {
+	period := f.periodOf(time.Now())
+	if f.file == nil || period != f.period {
+		if err := f.rotate(period); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(f.file)
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			continue
+		}
+		if f.param.MaxSizeMB > 0 && f.size > 0 && f.size+int64(len(line))+1 > f.param.MaxSizeMB*1024*1024 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if err := f.rotate(period); err != nil {
+				return err
+			}
+			w = bufio.NewWriter(f.file)
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		f.size += int64(len(line)) + 1
+	}
+
+	return w.Flush()
+}
+
+func (f *fileSender) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
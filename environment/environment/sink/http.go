@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type httpSender struct {
+	param  *HTTPParam
+	client *http.Client
+}
+
+func newHTTPSender(siteID string, s *Sink) (iSender, error) {
+	param := *s.HTTP
+	if param.Format == "" {
+		param.Format = "json"
+	}
+	if param.TimeoutSec <= 0 {
+		param.TimeoutSec = 10
+	}
+
+	return &httpSender{param: &param, client: &http.Client{Timeout: param.TimeoutSec * time.Second}}, nil
+}
+
+func (h *httpSender) send(records []*Record) error {
+	var body bytes.Buffer
+	contentType := "application/json"
+
+	if h.param.Format == "ndjson" {
+		contentType = "application/x-ndjson"
+		for _, r := range records {
+			line, err := json.Marshal(r)
+			if err != nil {
+				continue
+			}
+			body.Write(line)
+			body.WriteByte('\n')
+		}
+	} else {
+		if err := json.NewEncoder(&body).Encode(records); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.param.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range h.param.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("http status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (h *httpSender) close() {
+	h.client.CloseIdleConnections()
+}
@@ -0,0 +1,273 @@
+package sink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"obsessiontech/common/config"
+	"obsessiontech/common/datasource"
+	"obsessiontech/environment/environment/data"
+	"obsessiontech/environment/site"
+)
+
+const (
+	MODULE_SINK = "environment_sink"
+)
+
+const (
+	SINK_FILE = "file"
+	SINK_MQTT = "mqtt"
+	SINK_HTTP = "http"
+)
+
+var Config struct {
+	EnvironmentSinkFolder string
+}
+
+func init() {
+	config.GetConfig("config.yaml", &Config)
+
+	if Config.EnvironmentSinkFolder == "" {
+		Config.EnvironmentSinkFolder = "/tmp/envsink/"
+	}
+}
+
+var e_need_sink_name = errors.New("需要输出名称")
+var e_invalid_sink_name = errors.New("输出名称不正确")
+var e_duplicate_sink_name = errors.New("输出名称重复")
+var e_invalid_sink_type = errors.New("输出类型不正确")
+var e_need_sink_param = errors.New("需要输出参数")
+var e_invalid_data_type = errors.New("数据类型不正确")
+
+//数据输出设置 接收端入库并处理后的数据按各输出的过滤条件推送
+type SinkModule struct {
+	Sinks []*Sink `json:"sinks"`
+}
+
+//DataTypes StationIDs MonitorIDs为空时不过滤
+type Sink struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Active     bool     `json:"active"`
+	DataTypes  []string `json:"dataTypes,omitempty"`
+	StationIDs []int    `json:"stationIDs,omitempty"`
+	MonitorIDs []int    `json:"monitorIDs,omitempty"`
+
+	BufferSize       int           `json:"bufferSize"`
+	BatchSize        int           `json:"batchSize"`
+	FlushIntervalSec time.Duration `json:"flushIntervalSec"`
+	MaxSpillMB       int64         `json:"maxSpillMB"`
+
+	File *FileParam `json:"file,omitempty"`
+	MQTT *MQTTParam `json:"mqtt,omitempty"`
+	HTTP *HTTPParam `json:"http,omitempty"`
+}
+
+//按小时或天切分 超过MaxSizeMB另起文件 只保留最近MaxFiles个
+type FileParam struct {
+	Prefix    string `json:"prefix"`
+	RotateBy  string `json:"rotateBy"`
+	MaxSizeMB int64  `json:"maxSizeMB"`
+	MaxFiles  int    `json:"maxFiles"`
+}
+
+//Topic可用{siteID} {dataType} {stationID} {monitorID}占位
+type MQTTParam struct {
+	Addr         string        `json:"addr"`
+	ClientID     string        `json:"clientID"`
+	Username     string        `json:"username"`
+	Password     string        `json:"password"`
+	Topic        string        `json:"topic"`
+	QoS          byte          `json:"qos"`
+	Retain       bool          `json:"retain"`
+	KeepAliveSec time.Duration `json:"keepAliveSec"`
+}
+
+//Format为json时以数组提交 ndjson时每行一条
+type HTTPParam struct {
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Format     string            `json:"format"`
+	TimeoutSec time.Duration     `json:"timeoutSec"`
+}
+
+func GetModule(siteID string, flags ...bool) (*SinkModule, error) {
+	var m *SinkModule
+
+	_, sm, err := site.GetSiteModule(siteID, MODULE_SINK, flags...)
+	if err != nil {
+		return nil, err
+	}
+
+	paramByte, err := json.Marshal(sm.Param)
+	if err != nil {
+		log.Println("error marshal sink module param: ", err)
+		return nil, err
+	}
+
+	if err := json.Unmarshal(paramByte, &m); err != nil {
+		log.Println("error unmarshal sink module: ", err)
+		return nil, err
+	}
+
+	if m == nil {
+		m = new(SinkModule)
+	}
+	if m.Sinks == nil {
+		m.Sinks = make([]*Sink, 0)
+	}
+
+	for _, s := range m.Sinks {
+		s.setDefault()
+	}
+
+	return m, nil
+}
+
+func (m *SinkModule) Save(siteID string) error {
+
+	names := make(map[string]bool)
+	for _, s := range m.Sinks {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		if names[s.Name] {
+			return e_duplicate_sink_name
+		}
+		names[s.Name] = true
+	}
+
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_SINK, true)
+		if err != nil {
+			panic(err)
+		}
+
+		paramByte, _ := json.Marshal(&m)
+		json.Unmarshal(paramByte, &sm.Param)
+
+		if err := sm.Save(siteID, txn); err != nil {
+			panic(err)
+		}
+	})
+}
+
+func (s *Sink) setDefault() {
+	if s.BufferSize <= 0 {
+		s.BufferSize = 1000
+	}
+	if s.BatchSize <= 0 {
+		s.BatchSize = 100
+	}
+	if s.FlushIntervalSec <= 0 {
+		s.FlushIntervalSec = 5
+	}
+	if s.MaxSpillMB <= 0 {
+		s.MaxSpillMB = 100
+	}
+}
+
+func (s *Sink) validate() error {
+	if s.Name == "" {
+		return e_need_sink_name
+	}
+	//名称用作文件路径
+	if strings.ContainsAny(s.Name, `/\`) || strings.HasPrefix(s.Name, ".") {
+		return e_invalid_sink_name
+	}
+
+	for _, t := range s.DataTypes {
+		switch t {
+		case data.REAL_TIME:
+		case data.MINUTELY:
+		case data.HOURLY:
+		case data.DAILY:
+		default:
+			return e_invalid_data_type
+		}
+	}
+
+	switch s.Type {
+	case SINK_FILE:
+		if s.File == nil {
+			return e_need_sink_param
+		}
+		if strings.ContainsAny(s.File.Prefix, `/\`) || strings.HasPrefix(s.File.Prefix, ".") {
+			return errors.New("文件前缀不正确")
+		}
+		switch s.File.RotateBy {
+		case "", "hour", "day":
+		default:
+			return errors.New("文件切分方式不正确")
+		}
+	case SINK_MQTT:
+		if s.MQTT == nil || s.MQTT.Addr == "" || s.MQTT.Topic == "" {
+			return e_need_sink_param
+		}
+		if s.MQTT.QoS > 1 {
+			return errors.New("MQTT仅支持QoS 0或1")
+		}
+	case SINK_HTTP:
+		if s.HTTP == nil || s.HTTP.URL == "" {
+			return e_need_sink_param
+		}
+		if u, err := url.Parse(s.HTTP.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("请求地址不正确[%s]", s.HTTP.URL)
+		}
+		switch s.HTTP.Format {
+		case "", "json", "ndjson":
+		default:
+			return errors.New("提交格式不正确")
+		}
+	default:
+		return e_invalid_sink_type
+	}
+
+	return nil
+}
+
+func (s *Sink) match(d data.IData) bool {
+	if len(s.DataTypes) > 0 {
+		matched := false
+		for _, t := range s.DataTypes {
+			if t == d.GetDataType() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(s.StationIDs) > 0 {
+		matched := false
+		for _, id := range s.StationIDs {
+			if id == d.GetStationID() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(s.MonitorIDs) > 0 {
+		matched := false
+		for _, id := range s.MonitorIDs {
+			if id == d.GetMonitorID() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//MQTT 3.1.1 仅实现发布所需的CONNECT/PUBLISH/PUBACK
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPuback     = 0x40
+	mqttDisconnect = 0xE0
+)
+
+var e_mqtt_connack = errors.New("MQTT连接被拒绝")
+var e_mqtt_packet = errors.New("MQTT报文不正确")
+
+type mqttSender struct {
+	siteID string
+	param  *MQTTParam
+
+	conn       net.Conn
+	reader     *bufio.Reader
+	packetID   uint16
+	lastActive time.Time
+}
+
+func newMQTTSender(siteID string, s *Sink) (iSender, error) {
+	param := *s.MQTT
+	if param.ClientID == "" {
+		param.ClientID = fmt.Sprintf("envsink_%s_%s", siteID, s.Name)
+	}
+	if param.KeepAliveSec <= 0 {
+		param.KeepAliveSec = 60
+	}
+
+	return &mqttSender{siteID: siteID, param: &param}, nil
+}
+
+func (m *mqttSender) connect() error {
+	conn, err := net.DialTimeout("tcp", m.param.Addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	var flags byte = 0x02
+	payload := mqttString(m.param.ClientID)
+	if m.param.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(m.param.Username)...)
+	}
+	if m.param.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(m.param.Password)...)
+	}
+
+	body := append(mqttString("MQTT"), 0x04, flags)
+	body = appendUint16(body, uint16(m.param.KeepAliveSec))
+	body = append(body, payload...)
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttPacket(mqttConnect, body)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	header, res, err := mqttRead(reader)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if header&0xF0 != mqttConnack || len(res) != 2 {
+		conn.Close()
+		return e_mqtt_packet
+	}
+	if res[1] != 0 {
+		conn.Close()
+		return e_mqtt_connack
+	}
+
+	m.conn = conn
+	m.reader = reader
+	m.lastActive = time.Now()
+
+	return nil
+}
+
+func (m *mqttSender) topic(r *Record) string {
+	return strings.NewReplacer(
+		"{siteID}", r.SiteID,
+		"{dataType}", r.DataType,
+		"{stationID}", strconv.Itoa(r.StationID),
+		"{monitorID}", strconv.Itoa(r.MonitorID),
+	).Replace(m.param.Topic)
+}
+
+//每条记录一个消息 QoS 1时逐条等待PUBACK
+func (m *mqttSender) send(records []*Record) error {
+	//空闲超过保活时间服务端已断开 重新连接
+	if m.conn != nil && time.Since(m.lastActive) >= m.param.KeepAliveSec*time.Second {
+		m.close()
+	}
+	if m.conn == nil {
+		if err := m.connect(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range records {
+		payload, err := json.Marshal(r)
+		if err != nil {
+			continue
+		}
+
+		header := byte(mqttPublish) | m.param.QoS<<1
+		if m.param.Retain {
+			header |= 0x01
+		}
+
+		body := mqttString(m.topic(r))
+		if m.param.QoS > 0 {
+			m.packetID++
+			if m.packetID == 0 {
+				m.packetID = 1
+			}
+			body = appendUint16(body, m.packetID)
+		}
+		body = append(body, payload...)
+
+		m.conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if _, err := m.conn.Write(mqttPacket(header, body)); err != nil {
+			m.close()
+			return err
+		}
+
+		if m.param.QoS > 0 {
+			if err := m.waitPuback(m.packetID); err != nil {
+				m.close()
+				return err
+			}
+		}
+
+		m.lastActive = time.Now()
+	}
+
+	return nil
+}
+
+func (m *mqttSender) waitPuback(packetID uint16) error {
+	for {
+		header, res, err := mqttRead(m.reader)
+		if err != nil {
+			return err
+		}
+		if header&0xF0 == mqttPuback && len(res) == 2 && binary.BigEndian.Uint16(res) == packetID {
+			return nil
+		}
+	}
+}
+
+func (m *mqttSender) close() {
+	if m.conn != nil {
+		m.conn.SetDeadline(time.Now().Add(time.Second))
+		m.conn.Write([]byte{mqttDisconnect, 0})
+		m.conn.Close()
+		m.conn = nil
+		m.reader = nil
+	}
+}
+
+func mqttString(s string) []byte {
+	return append(appendUint16(nil, uint16(len(s))), s...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func mqttRead(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i >= 4 {
+			return 0, nil, e_mqtt_packet
+		}
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
@@ -0,0 +1,297 @@
+package sink
+
+import (
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/data"
+)
+
+//输出记录 按行json写入文件/溢出缓存 或批量提交
+type Record struct {
+	SiteID    string          `json:"siteID"`
+	DataType  string          `json:"dataType"`
+	StationID int             `json:"stationID"`
+	MonitorID int             `json:"monitorID"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type iSender interface {
+	send(records []*Record) error
+	close()
+}
+
+var senderFactories = map[string]func(siteID string, s *Sink) (iSender, error){
+	SINK_FILE: newFileSender,
+	SINK_MQTT: newMQTTSender,
+	SINK_HTTP: newHTTPSender,
+}
+
+//投递统计 进程内累计 重载配置时同名输出沿用
+type Metrics struct {
+	Name            string     `json:"name"`
+	Type            string     `json:"type"`
+	Received        int64      `json:"received"`
+	Delivered       int64      `json:"delivered"`
+	Failed          int64      `json:"failed"`
+	Spilled         int64      `json:"spilled"`
+	Dropped         int64      `json:"dropped"`
+	Queued          int        `json:"queued"`
+	SpillBytes      int64      `json:"spillBytes"`
+	LastError       string     `json:"lastError,omitempty"`
+	LastErrorTime   *util.Time `json:"lastErrorTime,omitempty"`
+	LastDeliverTime *util.Time `json:"lastDeliverTime,omitempty"`
+}
+
+type worker struct {
+	siteID string
+	sink   *Sink
+	sender iSender
+	queue  chan *Record
+	spill  *spill
+
+	metricsLock sync.Mutex
+	metrics     *Metrics
+
+	stop chan bool
+	done chan bool
+}
+
+var workerLock sync.RWMutex
+var workers = make(map[string]map[string]*worker)
+
+//按站点输出设置(重新)启动输出 停止的输出未投递数据写入溢出文件 下次启动时补发
+func Load(siteID string) error {
+	m, err := GetModule(siteID, false, true)
+	if err != nil {
+		return err
+	}
+
+	//建立连接及停止原输出需等待 均在锁外进行 避免阻塞上报
+	current := make(map[string]*worker)
+
+	for _, s := range m.Sinks {
+		if !s.Active {
+			continue
+		}
+
+		factory := senderFactories[s.Type]
+		if factory == nil {
+			log.Println("error sink type: ", siteID, s.Name, s.Type)
+			continue
+		}
+		sender, err := factory(siteID, s)
+		if err != nil {
+			log.Println("error start sink: ", siteID, s.Name, err)
+			continue
+		}
+
+		current[s.Name] = &worker{
+			siteID:  siteID,
+			sink:    s,
+			sender:  sender,
+			queue:   make(chan *Record, s.BufferSize),
+			spill:   &spill{path: filepath.Join(Config.EnvironmentSinkFolder, siteID, "spill", s.Name+".ndjson"), maxBytes: s.MaxSpillMB * 1024 * 1024},
+			metrics: &Metrics{Name: s.Name, Type: s.Type},
+			stop:    make(chan bool),
+			done:    make(chan bool),
+		}
+	}
+
+	previous := swapWorkers(siteID, current)
+
+	for _, w := range previous {
+		w.shutdown()
+	}
+
+	return nil
+}
+
+func swapWorkers(siteID string, current map[string]*worker) map[string]*worker {
+	workerLock.Lock()
+	defer workerLock.Unlock()
+
+	previous := workers[siteID]
+
+	for name, w := range current {
+		p := previous[name]
+		if p != nil {
+			metrics := p.getMetrics()
+			metrics.Type = w.sink.Type
+			w.metrics = metrics
+		}
+
+		//同名输出共用溢出文件 原输出停止并写完溢出后再启动
+		go func(w, p *worker) {
+			if p != nil {
+				<-p.done
+			}
+			w.run()
+		}(w, p)
+
+		log.Println("sink started: ", siteID, name, w.sink.Type)
+	}
+
+	workers[siteID] = current
+
+	return previous
+}
+
+//不阻塞上报 缓冲已满时写入溢出文件
+func Report(siteID string, d data.IData) {
+	workerLock.RLock()
+	defer workerLock.RUnlock()
+
+	var record *Record
+
+	for _, w := range workers[siteID] {
+		if !w.sink.match(d) {
+			continue
+		}
+
+		if record == nil {
+			d.RLockOriginData()
+			raw, err := json.Marshal(d)
+			d.RUnlockOriginData()
+			if err != nil {
+				log.Println("error marshal sink data: ", err)
+				return
+			}
+			record = &Record{SiteID: siteID, DataType: d.GetDataType(), StationID: d.GetStationID(), MonitorID: d.GetMonitorID(), Data: raw}
+		}
+
+		w.updateMetrics(func(m *Metrics) { m.Received++ })
+
+		select {
+		case w.queue <- record:
+		default:
+			w.spillRecords([]*Record{record})
+		}
+	}
+}
+
+func GetMetrics(siteID string) []*Metrics {
+	workerLock.RLock()
+	defer workerLock.RUnlock()
+
+	result := make([]*Metrics, 0)
+	for _, w := range workers[siteID] {
+		result = append(result, w.getMetrics())
+	}
+
+	return result
+}
+
+func (w *worker) getMetrics() *Metrics {
+	w.metricsLock.Lock()
+	defer w.metricsLock.Unlock()
+
+	metrics := *w.metrics
+	metrics.Queued = len(w.queue)
+	metrics.SpillBytes = w.spill.size()
+	return &metrics
+}
+
+func (w *worker) updateMetrics(update func(*Metrics)) {
+	w.metricsLock.Lock()
+	defer w.metricsLock.Unlock()
+	update(w.metrics)
+}
+
+func (w *worker) shutdown() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+	defer w.sender.close()
+
+	ticker := time.NewTicker(w.sink.FlushIntervalSec * time.Second)
+	defer ticker.Stop()
+
+	batch := make([]*Record, 0, w.sink.BatchSize)
+
+	for {
+		select {
+		case <-w.stop:
+			for len(w.queue) > 0 {
+				batch = append(batch, <-w.queue)
+			}
+			//停止时不再重试 直接落盘
+			w.spillRecords(batch)
+			return
+		case r := <-w.queue:
+			batch = append(batch, r)
+			if len(batch) >= w.sink.BatchSize {
+				w.deliver(batch)
+				batch = make([]*Record, 0, w.sink.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.deliver(batch)
+				batch = make([]*Record, 0, w.sink.BatchSize)
+			}
+			w.drainSpill()
+		}
+	}
+}
+
+func (w *worker) deliver(batch []*Record) bool {
+	if err := w.sender.send(batch); err != nil {
+		log.Println("error sink deliver: ", w.siteID, w.sink.Name, err)
+		now := util.Time(time.Now())
+		w.updateMetrics(func(m *Metrics) {
+			m.Failed += int64(len(batch))
+			m.LastError = err.Error()
+			m.LastErrorTime = &now
+		})
+		w.spillRecords(batch)
+		return false
+	}
+
+	now := util.Time(time.Now())
+	w.updateMetrics(func(m *Metrics) {
+		m.Delivered += int64(len(batch))
+		m.LastDeliverTime = &now
+	})
+	return true
+}
+
+func (w *worker) spillRecords(records []*Record) {
+	if len(records) == 0 {
+		return
+	}
+	spilled, dropped := w.spill.write(records)
+	w.updateMetrics(func(m *Metrics) {
+		m.Spilled += int64(spilled)
+		m.Dropped += int64(dropped)
+	})
+}
+
+//按批补发溢出数据 失败时剩余数据重新写回
+func (w *worker) drainSpill() {
+	err := w.spill.drain(w.sink.BatchSize, func(batch []*Record) error {
+		if err := w.sender.send(batch); err != nil {
+			return err
+		}
+		now := util.Time(time.Now())
+		w.updateMetrics(func(m *Metrics) {
+			m.Delivered += int64(len(batch))
+			m.LastDeliverTime = &now
+		})
+		return nil
+	})
+	if err != nil {
+		log.Println("error sink drain spill: ", w.siteID, w.sink.Name, err)
+		now := util.Time(time.Now())
+		w.updateMetrics(func(m *Metrics) {
+			m.LastError = err.Error()
+			m.LastErrorTime = &now
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//溢出文件 投递失败或缓冲已满的记录按行追加 超过上限时丢弃
+type spill struct {
+	lock     sync.Mutex
+	path     string
+	maxBytes int64
+}
+
+func (s *spill) size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (s *spill) write(records []*Record) (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.append(records)
+}
+
+func (s *spill) append(records []*Record) (written, dropped int) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		log.Println("error create sink spill folder: ", err)
+		return 0, len(records)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Println("error open sink spill: ", err)
+		return 0, len(records)
+	}
+	defer f.Close()
+
+	size := int64(0)
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	w := bufio.NewWriter(f)
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			dropped++
+			continue
+		}
+		if size+int64(len(line))+1 > s.maxBytes {
+			dropped++
+			continue
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		size += int64(len(line)) + 1
+		written++
+	}
+	if err := w.Flush(); err != nil {
+		log.Println("error write sink spill: ", err)
+	}
+
+	return
+}
+
+//取出溢出文件逐批投递 投递失败时未投递部分写回溢出文件
+func (s *spill) drain(batchSize int, deliver func([]*Record) error) error {
+	s.lock.Lock()
+	draining := s.path + ".draining"
+	if _, err := os.Stat(draining); os.IsNotExist(err) {
+		if err := os.Rename(s.path, draining); err != nil {
+			s.lock.Unlock()
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	s.lock.Unlock()
+
+	f, err := os.Open(draining)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var deliverErr error
+	rest := make([]*Record, 0)
+	batch := make([]*Record, 0, batchSize)
+
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		if deliverErr != nil {
+			rest = append(rest, &r)
+			continue
+		}
+		batch = append(batch, &r)
+		if len(batch) >= batchSize {
+			if deliverErr = deliver(batch); deliverErr != nil {
+				rest = append(rest, batch...)
+			}
+			batch = make([]*Record, 0, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		if deliverErr == nil {
+			if deliverErr = deliver(batch); deliverErr != nil {
+				rest = append(rest, batch...)
+			}
+		} else {
+			rest = append(rest, batch...)
+		}
+	}
+	f.Close()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(rest) > 0 {
+		s.append(rest)
+	}
+	os.Remove(draining)
+
+	return deliverErr
+}
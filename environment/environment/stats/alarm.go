@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"time"
+
+	"obsessiontech/environment/authority"
+	"obsessiontech/environment/environment/entity"
+	"obsessiontech/environment/environment/monitor"
+)
+
+//报警事件统计 时长按统计区间截取 未结束的事件计至区间结束
+type AlarmStats struct {
+	EventCount   int     `json:"eventCount"`
+	HitCount     int     `json:"hitCount"`
+	OngoingCount int     `json:"ongoingCount"`
+	DurationMin  float64 `json:"durationMin"`
+}
+
+//按监测点 监测物汇总
+func GetStationAlarmStats(siteID string, actionAuth authority.ActionAuthSet, beginTime, endTime *time.Time, stationID ...int) (map[int]map[int]*AlarmStats, error) {
+
+	if beginTime == nil || endTime == nil {
+		return nil, e_need_datatime
+	}
+
+	filtered, err := entity.FilterEntityStationAuth(siteID, actionAuth, stationID, entity.ACTION_ENTITY_VIEW)
+	if err != nil {
+		return nil, err
+	}
+
+	stationID = make([]int, 0)
+	for sid, ok := range filtered {
+		if ok {
+			stationID = append(stationID, sid)
+		}
+	}
+
+	if len(stationID) == 0 {
+		return make(map[int]map[int]*AlarmStats), nil
+	}
+
+	events, err := monitor.GetAlarmEvents(siteID, stationID, nil, nil, beginTime, endTime, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return summarizeAlarmEvents(events, *beginTime, *endTime), nil
+}
+
+func summarizeAlarmEvents(events []*monitor.AlarmEvent, beginTime, endTime time.Time) map[int]map[int]*AlarmStats {
+	result := make(map[int]map[int]*AlarmStats)
+
+	for _, e := range events {
+		stationStats, exists := result[e.StationID]
+		if !exists {
+			stationStats = make(map[int]*AlarmStats)
+			result[e.StationID] = stationStats
+		}
+		s, exists := stationStats[e.MonitorID]
+		if !exists {
+			s = new(AlarmStats)
+			stationStats[e.MonitorID] = s
+		}
+
+		s.EventCount++
+		s.HitCount += e.Count
+
+		eventBegin := time.Time(e.BeginTime)
+		eventEnd := time.Time(e.EndTime)
+		if !e.IsEnded() {
+			s.OngoingCount++
+			eventEnd = endTime
+			if now := time.Now(); now.Before(eventEnd) {
+				eventEnd = now
+			}
+		}
+		if eventBegin.Before(beginTime) {
+			eventBegin = beginTime
+		}
+		if eventEnd.After(endTime) {
+			eventEnd = endTime
+		}
+		if eventEnd.After(eventBegin) {
+			s.DurationMin += eventEnd.Sub(eventBegin).Minutes()
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,32 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"obsessiontech/common/util"
+	"obsessiontech/environment/environment/monitor"
+)
+
+func TestSummarizeAlarmEvents(t *testing.T) {
+	beginTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	endTime := beginTime.Add(24 * time.Hour)
+
+	events := []*monitor.AlarmEvent{
+		{StationID: 1, MonitorID: 10, Count: 3, BeginTime: util.Time(beginTime.Add(-time.Hour)), EndTime: util.Time(beginTime.Add(time.Hour))},
+		{StationID: 1, MonitorID: 10, Count: 2, BeginTime: util.Time(beginTime.Add(2 * time.Hour)), EndTime: util.Time(beginTime.Add(150 * time.Minute))},
+		{StationID: 1, MonitorID: 11, Count: 1, BeginTime: util.Time(endTime.Add(-time.Hour))},
+	}
+
+	result := summarizeAlarmEvents(events, beginTime, endTime)
+
+	s := result[1][10]
+	if s == nil || s.EventCount != 2 || s.HitCount != 5 || s.OngoingCount != 0 || s.DurationMin != 90 {
+		t.Fatalf("unexpected monitor 10 stats: %+v", s)
+	}
+
+	s = result[1][11]
+	if s == nil || s.EventCount != 1 || s.OngoingCount != 1 || s.DurationMin != 60 {
+		t.Fatalf("unexpected monitor 11 stats: %+v", s)
+	}
+}
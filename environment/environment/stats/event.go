@@ -64,13 +64,7 @@ func (e *HistoryDataQualityEvent) ExecuteEvent(siteID string, txn *sql.Tx, event
 	var saved int
 
 	for sid, dataQuality := range stationDataQualities {
-		history := new(HistoryStats)
-		history.StationID = sid
-		history.Type = HISTORY_STATS_DATA_QUALITY
-		history.IntervalType = intervalType
-		history.StatsTime = util.Time(beginTime)
-		history.Stats = make(map[string]interface{})
-		history.Stats["dataQuality"] = dataQuality
+		history := newDataQualityHistory(sid, intervalType, beginTime, dataQuality)
 
 		if isReplace {
 			if err := history.addUpdate(siteID, txn); err != nil {
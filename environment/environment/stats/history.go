@@ -75,6 +75,51 @@ func (h *HistoryStats) validate() error {
 	return nil
 }
 
+func newDataQualityHistory(stationID int, intervalType string, statsTime time.Time, dataQuality *QualityRates) *HistoryStats {
+	history := new(HistoryStats)
+	history.StationID = stationID
+	history.Type = HISTORY_STATS_DATA_QUALITY
+	history.IntervalType = intervalType
+	history.StatsTime = util.Time(statsTime)
+	history.Stats = make(map[string]interface{})
+	history.Stats["dataQuality"] = dataQuality
+	return history
+}
+
+//数据补传或修改后重新统计历史数据质量 仅统计已结束的日期
+func RecomputeHistoryDataQuality(siteID string, stationID int, beginTime, endTime time.Time) (int, error) {
+
+	today := util.GetDate(time.Now())
+	count := 0
+
+	err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		for day := util.GetDate(beginTime); day.Before(endTime) && day.Before(today); day = day.AddDate(0, 0, 1) {
+			dayBegin := day
+			dayEnd := day.AddDate(0, 0, 1)
+
+			stationDataQualities, err := GetStationDataQuality(siteID, authority.ActionAuthSet{{Action: entity.ACTION_ADMIN_VIEW}}, &dayBegin, &dayEnd, stationID)
+			if err != nil {
+				panic(err)
+			}
+
+			dataQuality, exists := stationDataQualities[stationID]
+			if !exists {
+				continue
+			}
+
+			if err := newDataQualityHistory(stationID, HISTORY_STATS_INTERVAL_DAILY, dayBegin, dataQuality).addUpdate(siteID, txn); err != nil {
+				panic(err)
+			}
+			count++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
 func (h *HistoryStats) add(siteID string, txn *sql.Tx) error {
 
 	if err := h.validate(); err != nil {
@@ -183,7 +228,7 @@ func GetHistoryStats(siteID string, actionAuth authority.ActionAuthSet, statsTyp
 			%s
 	`, historyStatsColumn, historyStatsTable(siteID), strings.Join(whereStmts, " AND "))
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get history stats: ", err)
 		return nil, err
@@ -40,7 +40,7 @@ func GetModule(siteID string, flags ...bool) (*StatsModule, error) {
 
 func (m *StatsModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_STATS, true)
 		if err != nil {
 			panic(err)
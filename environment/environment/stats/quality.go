@@ -19,6 +19,8 @@ type QualityRates struct {
 	TransCount      int     `json:"transCount"`
 	EffectCount     int     `json:"effectCount"`
 	SlotCount       int     `json:"slotCount"`
+	//有效性规则判定数据不足的数量 不计入有效
+	InsufficientCount int `json:"insufficientCount"`
 }
 
 func calculateRates(siteID string, slot int, counts map[string]int) (*QualityRates, error) {
@@ -48,9 +50,12 @@ func calculateRates(siteID string, slot int, counts map[string]int) (*QualityRat
 			if !monitor.CheckFlag(monitor.FLAG_TRANSMISSION, flag.Bits) {
 				result.SlotCount -= c
 			}
-			if monitor.CheckFlag(monitor.FLAG_EFFECTIVE, flag.Bits) {
+			if monitor.IsEffectiveFlag(flag.Bits) {
 				result.EffectCount += c
 			}
+			if monitor.CheckFlag(monitor.FLAG_INSUFFICIENT, flag.Bits) {
+				result.InsufficientCount += c
+			}
 		}
 	}
 
@@ -121,6 +126,15 @@ func calculateRates(siteID string, slot int, counts map[string]int) (*QualityRat
 }
 
 func GetStationDataQuality(siteID string, actionAuth authority.ActionAuthSet, beginDate, endDate *time.Time, stationID ...int) (map[int]*QualityRates, error) {
+	return getStationDataQuality(false, siteID, actionAuth, beginDate, endDate, stationID...)
+}
+
+//供接口查询 可读从库
+func GetStationDataQualityReadOnly(siteID string, actionAuth authority.ActionAuthSet, beginDate, endDate *time.Time, stationID ...int) (map[int]*QualityRates, error) {
+	return getStationDataQuality(true, siteID, actionAuth, beginDate, endDate, stationID...)
+}
+
+func getStationDataQuality(readOnly bool, siteID string, actionAuth authority.ActionAuthSet, beginDate, endDate *time.Time, stationID ...int) (map[int]*QualityRates, error) {
 
 	if beginDate == nil || endDate == nil {
 		return nil, e_need_datatime
@@ -148,7 +162,7 @@ func GetStationDataQuality(siteID string, actionAuth authority.ActionAuthSet, be
 		stations[s.ID] = s
 	}
 
-	stationStats, err := getStationStatistics(siteID, beginDate, endDate, stationID...)
+	stationStats, err := getStationStatistics(readOnly, siteID, beginDate, endDate, stationID...)
 	if err != nil {
 		return nil, err
 	}
@@ -186,6 +200,15 @@ func GetStationDataQuality(siteID string, actionAuth authority.ActionAuthSet, be
 }
 
 func GetMonitorDataQuality(siteID string, actionAuth authority.ActionAuthSet, stationID int, beginDate, endDate *time.Time) (map[int]*QualityRates, error) {
+	return getMonitorDataQuality(false, siteID, actionAuth, stationID, beginDate, endDate)
+}
+
+//供接口查询 可读从库
+func GetMonitorDataQualityReadOnly(siteID string, actionAuth authority.ActionAuthSet, stationID int, beginDate, endDate *time.Time) (map[int]*QualityRates, error) {
+	return getMonitorDataQuality(true, siteID, actionAuth, stationID, beginDate, endDate)
+}
+
+func getMonitorDataQuality(readOnly bool, siteID string, actionAuth authority.ActionAuthSet, stationID int, beginDate, endDate *time.Time) (map[int]*QualityRates, error) {
 
 	if beginDate == nil || endDate == nil {
 		return nil, e_need_datatime
@@ -223,7 +246,7 @@ func GetMonitorDataQuality(siteID string, actionAuth authority.ActionAuthSet, st
 		return result, nil
 	}
 
-	monitorStats, err := getMonitorStatistics(siteID, beginDate, endDate, stationID, stationMonitors[stationID]...)
+	monitorStats, err := getMonitorStatistics(readOnly, siteID, beginDate, endDate, stationID, stationMonitors[stationID]...)
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +33,7 @@ func countHourlySlot(beginDateTime, endDateTime *time.Time) int {
 	return CountSlots(data.HOURLY, beginDateTime, endDateTime)
 }
 
-func getMonitorStatistics(siteID string, beginDateTime, endDateTime *time.Time, stationID int, monitorID ...int) (map[int]map[string]int, error) {
+func getMonitorStatistics(readOnly bool, siteID string, beginDateTime, endDateTime *time.Time, stationID int, monitorID ...int) (map[int]map[string]int, error) {
 	result := make(map[int]map[string]int)
 
 	if len(monitorID) == 0 {
@@ -84,7 +84,7 @@ func getMonitorStatistics(siteID string, beginDateTime, endDateTime *time.Time,
 
 		log.Println("SQL: ", SQL, values)
 
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		rows, err := datasource.GetSiteQueryConn(siteID, readOnly).Query(SQL, values...)
 		if err != nil {
 			log.Println("error count station monitor data flag: ", SQL, values, err)
 			return nil, err
@@ -122,7 +122,7 @@ func getMonitorStatistics(siteID string, beginDateTime, endDateTime *time.Time,
 	return result, nil
 }
 
-func getStationStatistics(siteID string, beginDateTime, endDateTime *time.Time, stationID ...int) (map[int]map[string]int, error) {
+func getStationStatistics(readOnly bool, siteID string, beginDateTime, endDateTime *time.Time, stationID ...int) (map[int]map[string]int, error) {
 
 	result := make(map[int]map[string]int)
 
@@ -199,7 +199,7 @@ func getStationStatistics(siteID string, beginDateTime, endDateTime *time.Time,
 
 		log.Println("SQL: ", SQL, values)
 
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		rows, err := datasource.GetSiteQueryConn(siteID, readOnly).Query(SQL, values...)
 		if err != nil {
 			log.Println("error count station data flag: ", SQL, err)
 			return nil, err
@@ -68,7 +68,7 @@ func GetModule(siteID string) (*SubscriptionModule, error) {
 
 func (m *SubscriptionModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_SUBSCRIPTION, true)
 		if err != nil {
 			panic(err)
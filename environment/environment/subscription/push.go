@@ -109,6 +109,7 @@ const (
 	DATA_HOURLY    = "data_" + data.HOURLY
 	DATA_MINUTELY  = "data_" + data.MINUTELY
 	DATA_REAL_TIME = "data_" + data.REAL_TIME
+	MONITOR_ALARM  = "monitor_alarm"
 )
 
 func init() {
@@ -137,6 +138,11 @@ func init() {
 		p.Subscription = *sub
 		return p
 	})
+	push.RegisterSubsciption(MONITOR_ALARM, func(sub *push.Subscription) push.IPush {
+		p := new(MonitorSubscription)
+		p.Subscription = *sub
+		return p
+	})
 }
 
 type StationSubscription struct {
@@ -982,6 +988,8 @@ func (s *MonitorSubscription) GetMission(siteID string) (*mission.Mission, error
 		sm.Name = fmt.Sprintf("%s 时均数据异常", stationName)
 	case DATA_MINUTELY:
 		sm.Name = fmt.Sprintf("%s 分均数据异常", stationName)
+	case MONITOR_ALARM:
+		sm.Name = fmt.Sprintf("%s 报警", stationName)
 	}
 
 	detail := util.FormatDateTime(s.Time)
@@ -1003,7 +1011,7 @@ func (s *MonitorSubscription) GetMission(siteID string) (*mission.Mission, error
 			log.Println("error monitor not found to push: ", d.GetStationID(), d.GetMonitorID())
 			continue
 		}
-		l := monitor.GetFlagLimit(siteID, d.GetStationID(), d.GetMonitorID(), d.GetFlag())
+		l := monitor.GetFlagLimitAt(siteID, d.GetStationID(), d.GetMonitorID(), d.GetFlag(), time.Time(d.GetDataTime()))
 		if l == nil {
 			log.Println("error monitor flag limit not found to push: ", d.GetStationID(), d.GetMonitorID(), d.GetFlag())
 			continue
@@ -75,7 +75,7 @@ func AddEventEmpower(siteID string, actionAuth authority.ActionAuthSet, eventTyp
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		if err := authority.AddEmpower(siteID, "event", eventType, txn, empower, empowerID, authType); err != nil {
 			panic(err)
@@ -106,7 +106,7 @@ func DeleteEventEmpower(siteID string, actionAuth authority.ActionAuthSet, event
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := authority.DeleteEmpower(siteID, "event", eventType, txn, empower, empowerID, authType...); err != nil {
 			panic(err)
 		}
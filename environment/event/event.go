@@ -163,7 +163,7 @@ func GetEvents(siteID string, actionAuth authority.ActionAuthSet, eventType, sta
 	}
 
 	total := 0
-	if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+	if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 		log.Println("error count event: ", countSQL, values, err)
 		return nil, 0, err
 	}
@@ -181,7 +181,7 @@ func GetEvents(siteID string, actionAuth authority.ActionAuthSet, eventType, sta
 		values = append(values, (pageNo-1)*pageSize, pageSize)
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get event: ", SQL, values, err)
 		return nil, 0, err
@@ -257,7 +257,7 @@ func GetEventWithTxn(siteID string, txn *sql.Tx, forUpdate bool, eventID ...int)
 }
 
 func CloneEvent(siteID string, actionAuth authority.ActionAuthSet, eventID int) (result *Event, err error) {
-	err = datasource.Txn(func(txn *sql.Tx) {
+	err = datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		events, err := GetEventWithTxn(siteID, txn, false, eventID)
 		if err != nil {
@@ -341,7 +341,7 @@ func (e *Event) Add(siteID string, actionAuth authority.ActionAuthSet) error {
 		return err
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := e.add(siteID, txn); err != nil {
 			panic(err)
 		}
@@ -456,7 +456,7 @@ func (e *Event) UpdateStatusWithTxn(siteID string, txn *sql.Tx) error {
 
 	var err error
 	if txn == nil {
-		_, err = datasource.GetConn().Exec(SQL, values...)
+		_, err = datasource.GetSiteConn(siteID).Exec(SQL, values...)
 	} else {
 		_, err = txn.Exec(SQL, values...)
 	}
@@ -482,7 +482,7 @@ func (e *Event) Delete(siteID string, actionAuth authority.ActionAuthSet) error
 					id = ?
 			`, eventTableName(siteID))
 
-			_, err := datasource.GetConn().Exec(SQL, e.ID)
+			_, err := datasource.GetSiteConn(siteID).Exec(SQL, e.ID)
 			if err != nil {
 				log.Println("error delete event: ", err)
 				return err
@@ -149,7 +149,7 @@ func (s *Scheduler) ScheduleEvent(siteID string) error {
 		time.Until(*start),
 		func() {
 			s.Template.SubRelateID["schedulerID"] = fmt.Sprintf("%d", s.ID)
-			if err := datasource.Txn(func(txn *sql.Tx) {
+			if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 				if _, err := s.Template.clone(siteID, txn); err != nil {
 					log.Println("error clone scheduled event: ", err)
 					panic(err)
@@ -195,7 +195,7 @@ func (s *Scheduler) Add(siteID string) error {
 	schedule, _ := json.Marshal(s.Schedule)
 	template, _ := json.Marshal(s.Template)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name,schedule,template)
 		VALUES
@@ -228,7 +228,7 @@ func (s *Scheduler) Update(siteID string) error {
 	schedule, _ := json.Marshal(s.Schedule)
 	template, _ := json.Marshal(s.Template)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -247,7 +247,7 @@ func (s *Scheduler) Update(siteID string) error {
 
 func (s *Scheduler) Delete(siteID string) error {
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -257,6 +257,11 @@ func (s *Scheduler) Delete(siteID string) error {
 		return err
 	}
 
+	//非scheduler主机无计时器
+	if scheduledEventsInstance == nil {
+		return nil
+	}
+
 	sitePool := scheduledEventsInstance.getSitePool(siteID)
 	sitePool.Lock.Lock()
 	defer sitePool.Lock.Unlock()
@@ -276,7 +281,22 @@ func (s *Scheduler) Delete(siteID string) error {
 }
 
 func scanSchedulerTables() ([]string, error) {
-	rows, err := datasource.GetConn().Query(`
+	result := make([]string, 0)
+
+	//站点可能位于分库 迁移后原库残留的表不计入
+	for shard, conn := range datasource.GetShardConns() {
+		tables, err := scanShardSchedulerTables(shard, conn)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tables...)
+	}
+
+	return result, nil
+}
+
+func scanShardSchedulerTables(shard string, conn *sql.DB) ([]string, error) {
+	rows, err := conn.Query(`
 		SHOW TABLES LIKE '%_eventscheduler'
 	`)
 	if err != nil {
@@ -291,7 +311,11 @@ func scanSchedulerTables() ([]string, error) {
 		if err := rows.Scan(&table); err != nil {
 			return nil, err
 		}
-		if strings.Index(strings.TrimSuffix(table, "_eventscheduler"), "_") > 0 {
+		siteID := strings.TrimSuffix(table, "_eventscheduler")
+		if strings.Index(siteID, "_") > 0 {
+			continue
+		}
+		if datasource.GetSiteShard(siteID) != shard {
 			continue
 		}
 		result = append(result, table)
@@ -307,7 +331,7 @@ func scanSchedulers(table string) error {
 	}
 	siteID := parts[0]
 
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			%s
 		FROM
@@ -398,7 +422,7 @@ func GetSchedulers(siteID string, eventType, eventMainRelateID, q string, pageNo
 
 	var total int
 
-	if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+	if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 		log.Println("error count scheduler: ", err)
 		return nil, 0, err
 	}
@@ -415,7 +439,7 @@ func GetSchedulers(siteID string, eventType, eventMainRelateID, q string, pageNo
 		values = append(values, (pageNo-1)*pageSize, pageSize)
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get scheduler: ", SQL, values, err)
 		return nil, 0, err
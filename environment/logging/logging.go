@@ -82,7 +82,7 @@ func (l *Logging) add(siteID string) error {
 
 	payload, _ := json.Marshal(l.Payload)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(uid,module_id,source,source_id,action,payload)
 		VALUES
@@ -157,7 +157,7 @@ func GetLoggings(siteID, moduleID, source, sourceID, action string, uid int, beg
 	result := make([]*Logging, 0)
 	userMap := make(map[int]*user.UserBrief)
 
-	if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+	if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 		log.Println("error count logging: ", err)
 		return nil, nil, 0, err
 	}
@@ -172,7 +172,7 @@ func GetLoggings(siteID, moduleID, source, sourceID, action string, uid int, beg
 	SQL += "\nORDER BY logging.id DESC LIMIT ?,?"
 	values = append(values, (pageNo-1)*pageSize, pageSize)
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get logging: ", err)
 		return nil, nil, 0, err
@@ -94,7 +94,7 @@ func (m *LoggingModule) Save(siteID string) error {
 
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_LOGGING, true)
 		if err != nil {
 			panic(err)
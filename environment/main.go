@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"obsessiontech/common/random/serial"
 	"obsessiontech/environment/authority"
 	"obsessiontech/environment/role"
+	"obsessiontech/environment/site/initialization"
 	"obsessiontech/environment/user/auth"
 	"obsessiontech/environment/websocket"
 
@@ -32,7 +34,25 @@ func init() {
 	config.GetConfig("config.yaml", &Config)
 }
 
+var migrateOnly = flag.Bool("migrate", false, "执行站点表结构迁移后退出")
+var migrateDryRun = flag.Bool("dryrun", false, "与-migrate同用 仅列出待执行的迁移")
+
 func main() {
+	flag.Parse()
+
+	if *migrateOnly {
+		if err := migrateSchema(*migrateDryRun); err != nil {
+			log.Fatalln("error migrate schema: ", err)
+		}
+		return
+	}
+
+	if initialization.Config.SchemaMigrateOnStartup {
+		if err := migrateSchema(false); err != nil && err != initialization.E_migration_locked {
+			log.Fatalln("error migrate schema: ", err)
+		}
+	}
+
 	server = myHttp.GetObEngine()
 	prefix := myHttp.GetPrefix()
 	prefix = strings.Replace(prefix, "v1", ":serverVersion", 1)
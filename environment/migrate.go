@@ -22,12 +22,27 @@ import (
 	"obsessiontech/environment/environment/monitor"
 	"obsessiontech/environment/push"
 	"obsessiontech/environment/role"
+	"obsessiontech/environment/site/initialization"
 	"obsessiontech/environment/user"
 	"obsessiontech/environment/user/auth/engine"
 
 	"github.com/gin-gonic/gin"
 )
 
+//表结构迁移 启动时或以-migrate参数执行
+func migrateSchema(dryRun bool) error {
+	steps, err := initialization.Migrate(dryRun)
+	for _, s := range steps {
+		fmt.Println(s)
+	}
+	if dryRun {
+		log.Printf("schema migration dry run: %d pending", len(steps))
+	} else {
+		log.Printf("schema migration: %d applied", len(steps))
+	}
+	return err
+}
+
 func loadMigrate() {
 
 	internal.POST("migrate/:target", func(c *gin.Context) {
@@ -89,6 +104,32 @@ func loadMigrate() {
 				return
 			}
 			err = migrateTestUpload(siteID, c.Query("dataType"), stationID, monitorID, value, dataTime, strings.Split(c.Query("code"), ","))
+		case "tsdb":
+			beginTime, e := util.ParseDateTime(c.Query("beginTime"))
+			if e != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": e.Error()})
+				return
+			}
+			endTime, e := util.ParseDateTime(c.Query("endTime"))
+			if e != nil {
+				c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": e.Error()})
+				return
+			}
+			var stationID []int
+			if str, exists := c.GetQuery("stationID"); exists {
+				for _, idstr := range strings.Split(str, ",") {
+					id, e := strconv.Atoi(idstr)
+					if e != nil {
+						c.Set("json", map[string]interface{}{"retCode": 500, "retMsg": e.Error()})
+						return
+					}
+					stationID = append(stationID, id)
+				}
+			}
+			err = migrateTSDB(siteID, c.Query("dataType"), stationID, beginTime, endTime)
+		case "shard":
+			//迁移耗时 后台执行 通过GET migrate/shard查询进度
+			_, err = datasource.StartMoveSite(siteID, c.Query("shard"), c.Query("dropSource") == "true")
 		default:
 			c.AbortWithStatus(404)
 			return
@@ -101,6 +142,10 @@ func loadMigrate() {
 		}
 	})
 
+	internal.GET("migrate/shard", func(c *gin.Context) {
+		c.Set("json", map[string]interface{}{"retCode": 0, "move": datasource.GetShardMove(c.Query("siteID"))})
+	})
+
 	// projectc.POST("migrate/:target", checkAuth(site.MODULE_SITE, site.ACTION_ADMIN_EDIT_SITE), func(c *gin.Context) {
 	// 	siteID := c.GetString("site")
 	// 	var err error
@@ -151,6 +196,8 @@ func migrateEnvironmentMonitorLimit(siteID string) error {
 			fl.StationID = l.StationID
 			fl.MonitorID = l.MonitorID
 			fl.Flag = flag.Flag
+			fl.EffectiveFrom = l.EffectiveFrom
+			fl.EffectiveTo = l.EffectiveTo
 
 			limits := make([]string, 0)
 
@@ -209,6 +256,8 @@ func migrateEnvironmentMonitorLimit(siteID string) error {
 			fl.StationID = l.StationID
 			fl.MonitorID = l.MonitorID
 			fl.Flag = flag.Flag
+			fl.EffectiveFrom = l.EffectiveFrom
+			fl.EffectiveTo = l.EffectiveTo
 			fl.Region = fmt.Sprintf(">=%d", l.InvarianceHour)
 
 			log.Println("migrate: ", flag.Name, l.InvarianceHour, fl.Region)
@@ -232,6 +281,8 @@ func migrateEnvironmentMonitorLimit(siteID string) error {
 			fl.StationID = l.StationID
 			fl.MonitorID = l.MonitorID
 			fl.Flag = flag.Flag
+			fl.EffectiveFrom = l.EffectiveFrom
+			fl.EffectiveTo = l.EffectiveTo
 			fl.Region = fmt.Sprintf(">%G", l.TopEffective)
 
 			log.Println("migrate: ", flag.Name, l.TopEffective, fl.Region)
@@ -254,6 +305,8 @@ func migrateEnvironmentMonitorLimit(siteID string) error {
 			fl.StationID = l.StationID
 			fl.MonitorID = l.MonitorID
 			fl.Flag = flag.Flag
+			fl.EffectiveFrom = l.EffectiveFrom
+			fl.EffectiveTo = l.EffectiveTo
 			fl.Region = fmt.Sprintf("<%G", l.LowerDetection)
 
 			log.Println("migrate: ", flag.Name, l.LowerDetection, fl.Region)
@@ -428,7 +481,7 @@ func migrateEnvironmentEntityEmpower(siteID string, roleSeries string, authType
 }
 
 func migrateQuantity(siteID string) error {
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			id, blocks
 		FROM
@@ -457,7 +510,7 @@ func migrateQuantity(siteID string) error {
 			return err
 		}
 		if updatedBlock != "" {
-			if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+			if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 				UPDATE
 					%s_goods
 				SET
@@ -511,7 +564,7 @@ func updateChainQuantity(blockIndex int, chain map[string]interface{}) {
 }
 
 func migrateGoods(siteID string) error {
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			id, blocks
 		FROM
@@ -540,7 +593,7 @@ func migrateGoods(siteID string) error {
 			return err
 		}
 		if updatedBlock != "" {
-			if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+			if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 				UPDATE
 					%s_goods
 				SET
@@ -557,7 +610,7 @@ func migrateGoods(siteID string) error {
 }
 
 func migrateTemplate(siteID string) error {
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			id, blocks
 		FROM
@@ -586,7 +639,7 @@ func migrateTemplate(siteID string) error {
 			return err
 		}
 		if updatedBlock != "" {
-			if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+			if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 				UPDATE
 					%s_template
 				SET
@@ -654,7 +707,7 @@ func updateBlock(input string) (string, error) {
 }
 
 func migrateOrder(siteID string) error {
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			id, receipt
 		FROM
@@ -684,7 +737,7 @@ func migrateOrder(siteID string) error {
 			return err
 		}
 		if updated != "" {
-			if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+			if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 				UPDATE
 					%s_order
 				SET
@@ -759,7 +812,7 @@ func updateChainReceipt(input string) (string, error) {
 }
 
 func migratePurchase(siteID string) error {
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			id, receipt
 		FROM
@@ -788,7 +841,7 @@ func migratePurchase(siteID string) error {
 			return err
 		}
 		if updated != "" {
-			if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+			if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 				UPDATE
 					%s_purchase
 				SET
@@ -843,7 +896,7 @@ func migrateUserPassword(siteID string) error {
 		return err
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		for _, u := range users {
 			if u.IsPasswordSet {
 				u.Password, err = engine.EncryptPassword(encrypt.Base64Encrypt(u.Password))
@@ -857,7 +910,7 @@ func migrateUserPassword(siteID string) error {
 }
 
 func migrateMonitorCode(siteID string) error {
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			id, exp, minutely_generation, hourly_generation, daily_generation
 		FROM
@@ -923,7 +976,7 @@ func migrateMonitorCode(siteID string) error {
 
 		processors, _ := json.Marshal(processorList)
 
-		if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+		if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 			UPDATE
 				%s_monitorcode
 			SET
@@ -1080,3 +1133,21 @@ func migrateTestUpload(siteID string, dataType string, stationID int, monitorID
 
 	return nil
 }
+
+//历史数据写入时序库 未指定排放口时为全部排放口
+func migrateTSDB(siteID, dataType string, stationID []int, beginTime, endTime time.Time) error {
+	if len(stationID) == 0 {
+		stationList, err := entity.GetStations(siteID, nil, nil, "", "", "")
+		if err != nil {
+			return err
+		}
+		for _, s := range stationList {
+			stationID = append(stationID, s.ID)
+		}
+	}
+
+	count, err := data.BackfillTSDB(siteID, dataType, stationID, beginTime, endTime)
+	log.Printf("tsdb backfill done [%s] %s: %d", siteID, dataType, count)
+
+	return err
+}
@@ -180,7 +180,7 @@ func CountCompletes(siteID string, uid int, status []string, targetTime time.Tim
 	SQL += "\nGROUP BY missioncomplete.mission_id, missioncomplete.status"
 
 	var rows *sql.Rows
-	rows, err = datasource.GetConn().Query(SQL, values...)
+	rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 
 	if err != nil {
 		log.Println("error count mission complete: ", SQL, values, err)
@@ -258,7 +258,7 @@ func countCompletes(siteID string, txn *sql.Tx, uid int, status []string, missio
 
 	var rows *sql.Rows
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		if forUpdate {
 			SQL += "\nFOR UPDATE"
@@ -348,7 +348,7 @@ func getCompletes(siteID string, txn *sql.Tx, uid int, status []string, missions
 
 	var rows *sql.Rows
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		if forUpdate {
 			SQL += "\nFOR UPDATE"
@@ -413,7 +413,7 @@ func getComplete(siteID string, txn *sql.Tx, forUpdate bool, completeID ...int)
 	var rows *sql.Rows
 	var err error
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		if forUpdate {
 			SQL += "\nFOR UPDATE"
@@ -516,7 +516,7 @@ func (c *Complete) delete(siteID string, txn *sql.Tx) error {
 }
 
 func RevertMissionComplete(siteID string, actionAuth authority.ActionAuthSet, completeID int) error {
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := RevertMissionCompleteWithTxn(siteID, txn, actionAuth, completeID); err != nil {
 			panic(err)
 		}
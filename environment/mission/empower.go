@@ -59,7 +59,7 @@ func CheckAuth(siteID string, actionAuth authority.ActionAuthSet, missionID int,
 
 func AddMissionEmpower(siteID string, missionID int, empower string, empowerID []string, authType []string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		missions, err := getMissionsWithTxn(siteID, txn, true, "", missionID)
 		if err != nil {
@@ -82,7 +82,7 @@ func DeleteMissionEmpower(siteID string, missionID int, empower string, empowerI
 		return nil
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := authority.DeleteEmpower(siteID, "mission", fmt.Sprintf("%d", missionID), txn, empower, empowerID, authType...); err != nil {
 			panic(err)
 		}
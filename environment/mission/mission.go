@@ -172,7 +172,7 @@ func GetMissionTypes(siteID string) ([]string, error) {
 			%s mission
 	`, missionTable(siteID))
 
-	rows, err := datasource.GetConn().Query(SQL)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL)
 	if err != nil {
 		return nil, err
 	}
@@ -233,7 +233,7 @@ func getMissionsWithTxn(siteID string, txn *sql.Tx, forUpdate bool, missionType
 	var rows *sql.Rows
 	var err error
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		if forUpdate {
 			SQL += "\nFOR UPDATE"
@@ -359,7 +359,7 @@ func getMissions(siteID string, actionAuth authority.ActionAuthSet, relateID map
 	}
 
 	var total int
-	if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+	if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 		log.Println("error count mission: ", err)
 		return nil, 0, err
 	}
@@ -379,7 +379,7 @@ func getMissions(siteID string, actionAuth authority.ActionAuthSet, relateID map
 		values = append(values, (pageNo-1)*pageSize, pageSize)
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get mission: ", SQL, values, err)
 		return nil, 0, err
@@ -502,7 +502,7 @@ func CompleteMission(siteID string, actionAuth authority.ActionAuthSet, missionI
 
 	var result *Complete
 
-	if err := datasource.Txn(func(txn *sql.Tx) {
+	if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		missionList, err := getMissionsWithTxn(siteID, txn, false, "", missionID)
 		if err != nil {
@@ -782,7 +782,7 @@ func (m *Mission) Add(siteID string) error {
 	sectionInterval, _ := json.Marshal(m.Section)
 	activeInterval, _ := json.Marshal(m.Active)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(type,name,relate_id,status,description,profile,sort,prerequisites,completes,section_interval,active_interval,begin_time,end_time)
 		VALUES
@@ -818,7 +818,7 @@ func (m *Mission) update(siteID string, txn *sql.Tx) error {
 	activeInterval, _ := json.Marshal(m.Active)
 
 	if txn == nil {
-		_, err = datasource.GetConn().Exec(fmt.Sprintf(`
+		_, err = datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 			UPDATE
 				%s
 			SET
@@ -847,7 +847,7 @@ func (m *Mission) update(siteID string, txn *sql.Tx) error {
 func (m *Mission) Delete(siteID string) error {
 	table := missionTable(siteID)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -63,7 +63,7 @@ func GetModule(siteID string, flags ...bool) (*MissionModule, error) {
 
 func (m *MissionModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_MISSION, true)
 		if err != nil {
 			panic(err)
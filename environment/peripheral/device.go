@@ -50,7 +50,7 @@ func (d *Device) Add(siteID string) error {
 		d.Ext = make(map[string]interface{})
 	}
 	ext, _ := json.Marshal(d.Ext)
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name, serial, type, ext)
 		VALUES
@@ -80,7 +80,7 @@ func (d *Device) Update(siteID string) error {
 		d.Ext = make(map[string]interface{})
 	}
 	ext, _ := json.Marshal(d.Ext)
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -95,7 +95,7 @@ func (d *Device) Update(siteID string) error {
 }
 
 func (d *Device) Delete(siteID string) error {
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -148,7 +148,7 @@ func getDevice(siteID string, txn *sql.Tx, forUpdate bool, deviceID ...int) ([]*
 		}
 		rows, err = txn.Query(SQL, values...)
 	} else {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	}
 	if err != nil {
 		return nil, err
@@ -224,7 +224,7 @@ func GetDevices(siteID string, actionAuth authority.ActionAuthSet, serial, devic
 	total := 0
 
 	if pageSize != -1 {
-		if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+		if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 			log.Println("error count peripheral device: ", countSQL, values, err)
 			return nil, 0, err
 		}
@@ -243,7 +243,7 @@ func GetDevices(siteID string, actionAuth authority.ActionAuthSet, serial, devic
 		values = append(values, (pageNo-1)*pageSize, pageSize)
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get peripheral device: ", SQL, values, err)
 		return nil, 0, err
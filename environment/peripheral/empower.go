@@ -75,7 +75,7 @@ func AddDeviceEmpower(siteID string, actionAuth authority.ActionAuthSet, deviceI
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		devices, err := getDevice(siteID, txn, true, deviceID)
 		if err != nil {
@@ -115,7 +115,7 @@ func DeleteDeviceEmpower(siteID string, actionAuth authority.ActionAuthSet, devi
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := authority.DeleteEmpower(siteID, "device", fmt.Sprintf("%d", deviceID), txn, empower, empowerID, authType...); err != nil {
 			panic(err)
 		}
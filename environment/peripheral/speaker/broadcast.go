@@ -105,7 +105,7 @@ func (b *speakerBroadcast) getSitePool(siteID string) *speakerBroadcastSitePool
 }
 
 func (b *speakerBroadcast) feedback(siteID string, eventID int, eventStatus, msg string) error {
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		events, err := event.GetEventWithTxn(siteID, txn, true, eventID)
 		if err != nil {
 			panic(err)
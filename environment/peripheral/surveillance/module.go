@@ -46,7 +46,7 @@ func GetModule(siteID string) (*SurveillanceModule, error) {
 
 func (m *SurveillanceModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_SURVEILLANCE, true)
 		if err != nil {
 			panic(err)
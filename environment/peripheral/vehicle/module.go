@@ -57,7 +57,7 @@ func GetModule(siteID string) (*VehicleModule, error) {
 
 func (m *VehicleModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_VEHICLE, true)
 		if err != nil {
 			panic(err)
@@ -92,7 +92,7 @@ func GetVehicleList(siteID string, vehicleType string, status int, search string
 
 	SQL += "\nORDER BY vehicle.update_time DESC"
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get vehicle: ", err)
 		return nil, err
@@ -164,7 +164,7 @@ func (v *Vehicle) Add(siteID string) error {
 	profile, _ := json.Marshal(v.Profile)
 	ext, _ := json.Marshal(v.Ext)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(serial,type,name,status,profile,ext)
 		VALUES
@@ -192,7 +192,7 @@ func (v *Vehicle) Update(siteID string) error {
 	profile, _ := json.Marshal(v.Profile)
 	ext, _ := json.Marshal(v.Ext)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -212,7 +212,7 @@ func (v *Vehicle) Delete(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -74,7 +74,7 @@ func (s *Subscription) Add(siteID string, txn *sql.Tx) error {
 	if txn != nil {
 		ret, err = txn.Exec(SQL, s.SubscriberType, s.SubscriberID, s.Type, s.Push, string(interval), string(ext))
 	} else {
-		ret, err = datasource.GetConn().Exec(SQL, s.SubscriberType, s.SubscriberID, s.Type, s.Push, string(interval), string(ext))
+		ret, err = datasource.GetSiteConn(siteID).Exec(SQL, s.SubscriberType, s.SubscriberID, s.Type, s.Push, string(interval), string(ext))
 	}
 
 	if err != nil {
@@ -119,7 +119,7 @@ func (s *Subscription) Update(siteID string) error {
 			id= ?
 	`, subscriptionTable(siteID))
 
-	_, err = datasource.GetConn().Exec(SQL, s.SubscriberType, s.SubscriberID, s.Type, s.Push, string(interval), string(ext), s.ID)
+	_, err = datasource.GetSiteConn(siteID).Exec(SQL, s.SubscriberType, s.SubscriberID, s.Type, s.Push, string(interval), string(ext), s.ID)
 	if err != nil {
 		log.Println("error update subscription: ", err)
 		return err
@@ -130,7 +130,7 @@ func (s *Subscription) Update(siteID string) error {
 
 func (s *Subscription) Delete(siteID string) error {
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -248,7 +248,7 @@ func GetSubscriptionList(siteID string, subscriberType string, subscriberID int,
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get subscription: ", SQL, values, err)
 		return nil, err
@@ -241,7 +241,7 @@ func getRelationsWithTxn[AType RelationID, BType RelationID](siteID string, txn
 	if txn != nil {
 		rows, err = txn.Query(SQL, values...)
 	} else {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	}
 	if err != nil {
 		log.Println("error check relation: ", err)
@@ -277,7 +277,7 @@ func Count(siteID, A, B, relationType, target string, IDs []string) (int, error)
 
 	var count int
 	if A == target {
-		if err := datasource.GetConn().QueryRow(fmt.Sprintf(`
+		if err := datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf(`
 			SELECT COUNT(DISTINCT a) FROM
 				%s
 			WHERE b IN (%s) AND type = ? AND (expire = 0 || expire > UNIX_TIMESTAMP())
@@ -285,7 +285,7 @@ func Count(siteID, A, B, relationType, target string, IDs []string) (int, error)
 			return 0, err
 		}
 	} else if B == target {
-		if err := datasource.GetConn().QueryRow(fmt.Sprintf(`
+		if err := datasource.GetSiteConn(siteID).QueryRow(fmt.Sprintf(`
 			SELECT COUNT(DISTINCT b) FROM
 				%s
 			WHERE a IN (%s) AND type = ? AND (expire = 0 || expire > UNIX_TIMESTAMP())
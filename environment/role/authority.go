@@ -41,7 +41,7 @@ func (a *RoleAuthority) scan(rows *sql.Rows, appendix ...interface{}) error {
 
 func (a *RoleAuthority) add(siteID string) error {
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(role_id,module_id,action,role_type)
 		VALUES
@@ -58,7 +58,7 @@ func (a *RoleAuthority) add(siteID string) error {
 }
 
 func (a *RoleAuthority) delete(siteID string) error {
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -127,7 +127,7 @@ func GetAuthorityActions(siteID, moduleID, session, clientAgent string, uid int,
 			%s
 	`, roleAuthorityTableName(siteID), userRoleTableName(siteID), roleTable, joinSiteModule, strings.Join(whereStmts, " AND "))
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get auth action: ", SQL, values, err)
 		return nil, err
@@ -204,7 +204,7 @@ func getRoleAuthoritiesWithTxn(siteID string, txn *sql.Tx, moduleID []string, ro
 	var rows *sql.Rows
 	var err error
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		rows, err = txn.Query(SQL, values...)
 	}
@@ -284,7 +284,7 @@ func CheckUserRoleAuthority(siteID string, moduleID, action, roleType string, ui
 
 	log.Println("check user role authority: ", SQL, values)
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -356,7 +356,7 @@ func GetUserRoleAuthority(siteID string, uid ...int) (map[int]map[int][]*RoleAut
 
 	log.Println("get user role: ", SQL, values)
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -473,40 +473,12 @@ func GetGrantableAuthorities(siteID string, uid int, actionAuth authority.Action
 
 		log.Println("grant all")
 
-		whereStmts := make([]string, 0)
-		values := make([]interface{}, 0)
-
-		joinAuth, joinWhere, joinValues := site.JoinSiteModuleAuth(siteID, "module", "id")
-
-		whereStmts = append(whereStmts, joinWhere...)
-		values = append(values, joinValues...)
-
-		SQL := fmt.Sprintf(`
-			SELECT
-				%s
-			FROM
-				%s module
-			%s
-		`, site.ModuleColumns, site.ModuleTableName, joinAuth)
-
-		if len(whereStmts) > 0 {
-			SQL += "WHERE " + strings.Join(whereStmts, " AND ")
-		}
-
-		rows, err := datasource.GetConn().Query(SQL, values...)
+		modules, err := site.GetSiteModuleList(siteID)
 		if err != nil {
 			return nil, err
 		}
 
-		defer rows.Close()
-
-		for rows.Next() {
-			var m site.Module
-
-			if err := m.Scan(rows); err != nil {
-				return nil, err
-			}
-
+		for _, m := range modules {
 			for _, a := range m.Action {
 				auth := new(RoleAuthority)
 				auth.ModuleID = m.ModuleID
@@ -586,7 +558,7 @@ func (m *RoleAuthorityTemplate) Add(siteID string) error {
 		return errors.New("请命名模版")
 	}
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name)
 		VALUES
@@ -608,7 +580,7 @@ func (m *RoleAuthorityTemplate) Update(siteID string) error {
 		return errors.New("请命名模版")
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -624,7 +596,7 @@ func (m *RoleAuthorityTemplate) Update(siteID string) error {
 }
 func (m *RoleAuthorityTemplate) Delete(siteID string) error {
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -653,7 +625,7 @@ func GetRoleAuthorityTemplates(siteID string) ([]*RoleAuthorityTemplate, error)
 		SQL += "WHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get monitor code template: ", err)
 		return nil, err
@@ -111,7 +111,7 @@ func (m *PointMilestone) Add(siteID string) error {
 	}
 	milestones, _ := json.Marshal(m.Milestones)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(point_id, point, milestones)
 		VALUES
@@ -138,7 +138,7 @@ func (m *PointMilestone) Update(siteID string) error {
 	}
 	milestones, _ := json.Marshal(m.Milestones)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -157,7 +157,7 @@ func (m *PointMilestone) Delete(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -205,7 +205,7 @@ func GetPointMilestones(siteID string, pointID ...int) ([]*PointMilestone, error
 
 	SQL += "\nORDER BY milestone.point ASC"
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +66,7 @@ func GetModule(siteID string, flags ...bool) (*RoleModule, error) {
 
 func (m *RoleModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_ROLE, true)
 		if err != nil {
 			panic(err)
@@ -58,7 +58,7 @@ func (m *Point) Add(siteID string) error {
 	}
 	profile, _ := json.Marshal(m.Profile)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(name, description, profile, sort)
 		VALUES
@@ -85,7 +85,7 @@ func (m *Point) Update(siteID string) error {
 	}
 	profile, _ := json.Marshal(m.Profile)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -104,7 +104,7 @@ func (m *Point) Delete(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -240,7 +240,7 @@ func GetPoints(siteID string, pointID ...int) ([]*Point, map[int][]*PointMilesto
 
 	SQL += "\nORDER BY point.sort DESC"
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -291,7 +291,7 @@ func GetPoint(siteID string, pointID int) (*Point, error) {
 			id = ?
 	`, pointColumn, table)
 
-	rows, err := datasource.GetConn().Query(SQL, pointID)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, pointID)
 	if err != nil {
 		return nil, err
 	}
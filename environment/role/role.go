@@ -72,7 +72,7 @@ func (m *Role) Add(siteID string) error {
 	}
 	profile, _ := json.Marshal(m.Profile)
 
-	if ret, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(series, name, description, profile, sort)
 		VALUES
@@ -100,7 +100,7 @@ func (m *Role) Update(siteID string) error {
 
 	profile, _ := json.Marshal(m.Profile)
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -119,7 +119,7 @@ func (m *Role) Delete(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -232,7 +232,7 @@ func GetRoles(siteID, series string, roleID ...int) ([]*Role, error) {
 
 	SQL += "\nORDER BY role.sort DESC"
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
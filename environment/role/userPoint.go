@@ -112,7 +112,7 @@ func GetUserPoints(siteID string, uid ...int) (map[int]map[int]int, error) {
 	if err != nil {
 		return nil, err
 	}
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			%s
 		FROM
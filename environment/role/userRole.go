@@ -146,7 +146,7 @@ func GrantUserRole(siteID string, uid, roleID, expires int, actionAuth authority
 		return err
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if _, err := bindUserRole(siteID, txn, uid, roleID, expires); err != nil {
 			panic(err)
 		}
@@ -159,7 +159,7 @@ func WithdrawUserRole(siteID string, uid, roleID int, actionAuth authority.Actio
 		return err
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := unbindUserRole(siteID, txn, uid, roleID); err != nil {
 			panic(err)
 		}
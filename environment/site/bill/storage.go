@@ -16,14 +16,14 @@ func GetSiteStorage(siteID string) (int, error) {
 	var count int
 
 	var dataSize float64
-	if err := datasource.GetConn().QueryRow(`
+	if err := datasource.GetSiteConn(siteID).QueryRow(`
 		SELECT 
 			SUM(TRUNCATE((data_length + index_length) / 1024 / 1024, 2))
 		FROM 
 			information_schema.tables
 		WHERE 
-			table_schema = ? and table_name like ?;
-	`, "projectc", siteID+"_%").Scan(&dataSize); err != nil {
+			table_schema = DATABASE() and table_name like ?;
+	`, siteID+"\\_%").Scan(&dataSize); err != nil {
 		return -1, err
 	}
 
@@ -47,7 +47,7 @@ func GetModule(siteID string) (*ClientAgentModule, error) {
 
 func (m *ClientAgentModule) Save(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_CLIENTAGENT, true)
 		if err != nil {
 			panic(err)
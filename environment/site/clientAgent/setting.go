@@ -73,7 +73,7 @@ func GetSettings(siteID string, actionAuth authority.ActionAuthSet, clientAgent,
 		SQL += "\nWHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +127,7 @@ func (s *Setting) Add(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		INSERT INTO %s
 			(clientagent, setting_key, type, value, description)
 		VALUES
@@ -142,7 +142,7 @@ func (s *Setting) Update(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -155,7 +155,7 @@ func (s *Setting) Update(siteID string) error {
 	return nil
 }
 func (s *Setting) Delete(siteID string) error {
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE
@@ -195,7 +195,7 @@ func ClearSetting(siteID, clientAgent, key, settingType string) error {
 			%s
 	`, settingTableName(siteID), strings.Join(whereStmts, " AND "))
 
-	if _, err := datasource.GetConn().Exec(SQL, values...); err != nil {
+	if _, err := datasource.GetSiteConn(siteID).Exec(SQL, values...); err != nil {
 		log.Println("error clear setting: ", SQL, values, err)
 		return err
 	}
@@ -24,7 +24,7 @@ func Initialize(siteID string, txn *sql.Tx, moduleID string) error {
 	}
 
 	for _, table := range tables {
-		if err := CreateTable(siteID, txn, siteID+"_"+table, table); err != nil {
+		if err := CreateTable(siteID, txn, table, table); err != nil {
 			return err
 		}
 	}
@@ -34,6 +34,8 @@ func Initialize(siteID string, txn *sql.Tx, moduleID string) error {
 
 func CreateTable(siteID string, txn *sql.Tx, tableName, sourceTableName string) error {
 
+	created := false
+
 	var op = func(thisTxn *sql.Tx) error {
 		if exists, err := ExistsTable(siteID, thisTxn, tableName); err != nil {
 			return err
@@ -41,21 +43,29 @@ func CreateTable(siteID string, txn *sql.Tx, tableName, sourceTableName string)
 			if _, err := thisTxn.Exec(fmt.Sprintf(`CREATE TABLE %s_%s like %s_%s`, siteID, tableName, "prototype", sourceTableName)); err != nil {
 				return err
 			}
+			created = true
 		}
 
 		return nil
 	}
 
 	if txn == nil {
-		return datasource.Txn(func(t *sql.Tx) {
+		if err := datasource.SiteTxn(siteID, func(t *sql.Tx) {
 			if err := op(t); err != nil {
 				panic(err)
 			}
-		})
-	} else {
-		return op(txn)
+		}); err != nil {
+			return err
+		}
+	} else if err := op(txn); err != nil {
+		return err
 	}
 
+	if created && tableName == sourceTableName {
+		return migrateCreatedTable(siteID, tableName)
+	}
+
+	return nil
 }
 
 func ExistsTable(siteID string, txn *sql.Tx, tableName string) (bool, error) {
@@ -64,7 +74,7 @@ func ExistsTable(siteID string, txn *sql.Tx, tableName string) (bool, error) {
 	var err error
 
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(fmt.Sprintf(`SHOW TABLES LIKE '%s_%s'`, siteID, tableName))
+		rows, err = datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`SHOW TABLES LIKE '%s_%s'`, siteID, tableName))
 	} else {
 		rows, err = txn.Query(fmt.Sprintf(`SHOW TABLES LIKE '%s_%s'`, siteID, tableName))
 	}
@@ -0,0 +1,456 @@
+package initialization
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"obsessiontech/common/config"
+	"obsessiontech/common/context"
+	"obsessiontech/common/datasource"
+)
+
+var Config struct {
+	SchemaMigrateOnStartup bool
+}
+
+func init() {
+	config.GetConfig("config.yaml", &Config)
+}
+
+const (
+	SITE_PROTOTYPE = "prototype"
+
+	migration_table = "schemamigration"
+	migration_lock  = "schema_migration"
+)
+
+var e_migration_failed = errors.New("部分迁移执行失败")
+var E_migration_locked = errors.New("其他进程正在执行迁移")
+
+//站点表结构变更 按模块编号顺序执行 执行记录保存在各站点的schemamigration表
+//SQL中{siteID}替换为站点ID 与Func均需可重复执行 新建表后会对该站点补齐
+type Migration struct {
+	Version     int
+	Description string
+	SQL         []string
+	Func        func(siteID string, db *sql.DB) error
+}
+
+type moduleMigration struct {
+	baseTable  string
+	migrations []*Migration
+}
+
+var migrations = make(map[string]*moduleMigration)
+
+//baseTable为模块必有的表 站点无该表时视为未开通模块 跳过
+//同一模块可在各功能文件分别注册 按版本排序执行 版本不可重复
+func RegisterMigrations(moduleID, baseTable string, list ...*Migration) {
+	mm, exists := migrations[moduleID]
+	if !exists {
+		mm = &moduleMigration{baseTable: baseTable}
+		migrations[moduleID] = mm
+	} else if mm.baseTable != baseTable {
+		panic(fmt.Sprintf("migration base table mismatch: %s %s %s", moduleID, mm.baseTable, baseTable))
+	}
+
+	for _, m := range list {
+		if m.Version <= 0 {
+			panic(fmt.Sprintf("migration version invalid: %s %d", moduleID, m.Version))
+		}
+		for _, registered := range mm.migrations {
+			if registered.Version == m.Version {
+				panic(fmt.Sprintf("duplicate migration version: %s %d", moduleID, m.Version))
+			}
+		}
+		mm.migrations = append(mm.migrations, m)
+	}
+
+	sort.Slice(mm.migrations, func(i, j int) bool { return mm.migrations[i].Version < mm.migrations[j].Version })
+}
+
+type MigrationStep struct {
+	SiteID      string   `json:"siteID"`
+	ModuleID    string   `json:"moduleID"`
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	SQL         []string `json:"SQL,omitempty"`
+	Func        bool     `json:"func,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+func (s *MigrationStep) String() string {
+	str := fmt.Sprintf("[%s] %s #%d %s", s.SiteID, s.ModuleID, s.Version, s.Description)
+	for _, SQL := range s.SQL {
+		str += "\n\t" + strings.Join(strings.Fields(SQL), " ")
+	}
+	if s.Func {
+		str += "\n\t(func)"
+	}
+	if s.Error != "" {
+		str += "\n\terror: " + s.Error
+	}
+	return str
+}
+
+//依次迁移各库的prototype表 公共站点c及全部站点 dryRun时只返回待执行的迁移
+func Migrate(dryRun bool) ([]*MigrationStep, error) {
+	ctx, cancel := context.GetContext()
+	defer cancel()
+
+	//多个进程同时启动时只由一个执行
+	conn, err := datasource.GetConn().Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", migration_lock).Scan(&locked); err != nil {
+		return nil, err
+	}
+	if locked.Int64 != 1 {
+		return nil, E_migration_locked
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migration_lock)
+
+	moduleIDs := make([]string, 0)
+	for moduleID := range migrations {
+		moduleIDs = append(moduleIDs, moduleID)
+	}
+	sort.Strings(moduleIDs)
+
+	result := make([]*MigrationStep, 0)
+	failed := false
+
+	run := func(siteID string, db *sql.DB) {
+		steps, err := migrateSite(siteID, db, moduleIDs, dryRun)
+		result = append(result, steps...)
+		if err != nil {
+			log.Println("error migrate site: ", siteID, err)
+			failed = true
+		}
+	}
+
+	shards := datasource.GetShardConns()
+	shardNames := make([]string, 0)
+	for name := range shards {
+		shardNames = append(shardNames, name)
+	}
+	sort.Strings(shardNames)
+	for _, name := range shardNames {
+		run(SITE_PROTOTYPE, shards[name])
+	}
+
+	run(datasource.SITE_COMMON, datasource.GetConn())
+
+	siteIDs, err := getSiteIDs()
+	if err != nil {
+		return result, err
+	}
+	for _, siteID := range siteIDs {
+		if siteID == datasource.SITE_COMMON {
+			continue
+		}
+		run(siteID, datasource.GetSiteConn(siteID))
+	}
+
+	if failed {
+		return result, e_migration_failed
+	}
+	return result, nil
+}
+
+func getSiteIDs() ([]string, error) {
+	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+		SELECT
+			id
+		FROM
+			%s_site
+		ORDER BY
+			id
+	`, datasource.SITE_COMMON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var siteID string
+		if err := rows.Scan(&siteID); err != nil {
+			return nil, err
+		}
+		result = append(result, siteID)
+	}
+	return result, rows.Err()
+}
+
+func existsTable(db *sql.DB, table string) (bool, error) {
+	rows, err := db.Query("SHOW TABLES LIKE ?", strings.ReplaceAll(table, "_", `\_`))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
+}
+
+//模块执行失败时跳过该模块后续版本 继续其他模块
+func migrateSite(siteID string, db *sql.DB, moduleIDs []string, dryRun bool) ([]*MigrationStep, error) {
+	steps := make([]*MigrationStep, 0)
+
+	recorded, err := existsTable(db, siteID+"_"+migration_table)
+	if err != nil {
+		return steps, err
+	}
+	if !recorded && !dryRun {
+		if err := createMigrationTable(siteID, db); err != nil {
+			return steps, err
+		}
+		recorded = true
+	}
+
+	var failed bool
+	for _, moduleID := range moduleIDs {
+		mm := migrations[moduleID]
+
+		if exists, err := existsTable(db, siteID+"_"+mm.baseTable); err != nil {
+			return steps, err
+		} else if !exists {
+			continue
+		}
+
+		applied := make(map[int]bool)
+		if recorded {
+			if applied, err = getAppliedVersions(siteID, db, moduleID); err != nil {
+				return steps, err
+			}
+		}
+
+		for _, m := range mm.migrations {
+			if applied[m.Version] {
+				continue
+			}
+
+			step := &MigrationStep{
+				SiteID:      siteID,
+				ModuleID:    moduleID,
+				Version:     m.Version,
+				Description: m.Description,
+				Func:        m.Func != nil,
+			}
+			for _, SQL := range m.SQL {
+				step.SQL = append(step.SQL, strings.ReplaceAll(SQL, "{siteID}", siteID))
+			}
+			steps = append(steps, step)
+
+			if dryRun {
+				continue
+			}
+
+			if err := applyMigration(siteID, db, moduleID, m, step.SQL); err != nil {
+				log.Printf("error apply migration [%s] %s #%d: %v", siteID, moduleID, m.Version, err)
+				step.Error = err.Error()
+				failed = true
+				break
+			}
+
+			log.Printf("migration applied [%s] %s #%d %s", siteID, moduleID, m.Version, m.Description)
+		}
+	}
+
+	if failed {
+		return steps, e_migration_failed
+	}
+	return steps, nil
+}
+
+func createMigrationTable(siteID string, db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_%s (
+			module_id VARCHAR(64) NOT NULL,
+			version INT NOT NULL,
+			description VARCHAR(255) NOT NULL DEFAULT '',
+			applied_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (module_id, version)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8
+	`, siteID, migration_table))
+	return err
+}
+
+func getAppliedVersions(siteID string, db *sql.DB, moduleID string) (map[int]bool, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT
+			version
+		FROM
+			%s_%s
+		WHERE
+			module_id = ?
+	`, siteID, migration_table), moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		result[version] = true
+	}
+	return result, rows.Err()
+}
+
+//MySQL的DDL隐式提交 不使用事务 各步骤需可重复执行
+func applyMigration(siteID string, db *sql.DB, moduleID string, m *Migration, statements []string) error {
+	for _, SQL := range statements {
+		if _, err := db.Exec(SQL); err != nil {
+			return err
+		}
+	}
+
+	if m.Func != nil {
+		if err := m.Func(siteID, db); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`
+		INSERT IGNORE INTO %s_%s
+			(module_id, version, description)
+		VALUES
+			(?, ?, ?)
+	`, siteID, migration_table), moduleID, m.Version, m.Description)
+	return err
+}
+
+//新建的模块基础表来自prototype 补齐该模块的迁移使站点处于最新版本
+func migrateCreatedTable(siteID, tableName string) error {
+	moduleIDs := make([]string, 0)
+	for moduleID, mm := range migrations {
+		if mm.baseTable == tableName {
+			moduleIDs = append(moduleIDs, moduleID)
+		}
+	}
+	if len(moduleIDs) == 0 {
+		return nil
+	}
+	sort.Strings(moduleIDs)
+
+	_, err := migrateSite(siteID, datasource.GetSiteConn(siteID), moduleIDs, false)
+	return err
+}
+
+func ExistsColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW COLUMNS FROM `%s` LIKE ?", table), strings.ReplaceAll(column, "_", `\_`))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
+}
+
+//columns为"名称 类型"形式 已存在的列跳过
+func AddColumns(db *sql.DB, table string, columns ...string) error {
+	for _, c := range columns {
+		name := strings.Fields(c)[0]
+		if exists, err := ExistsColumn(db, table, name); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN %s", table, c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//新增name唯一索引 并删除列与oldColumns一致的原唯一索引 其他唯一索引不受影响
+//先增后删 期间唯一约束不中断
+func ReplaceUniqueKey(db *sql.DB, table string, oldColumns []string, name string, columns ...string) error {
+	keys, err := uniqueKeys(db, table)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := keys[name]; !exists {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD UNIQUE KEY `%s` (%s)", table, name, strings.Join(columns, ","))); err != nil {
+			return err
+		}
+	}
+
+	for key, keyColumns := range keys {
+		if key == name || strings.Join(keyColumns, ",") != strings.Join(oldColumns, ",") {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP INDEX `%s`", table, key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//主键以外的唯一索引及其按顺序的列
+func uniqueKeys(db *sql.DB, table string) (map[string][]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW INDEX FROM `%s` WHERE Non_unique = 0 AND Key_name != 'PRIMARY'", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	seqs := make(map[string]map[int]string)
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		var key, column string
+		var seq int
+		for i, c := range cols {
+			switch c {
+			case "Key_name":
+				key = string(values[i])
+			case "Column_name":
+				column = string(values[i])
+			case "Seq_in_index":
+				seq, _ = strconv.Atoi(string(values[i]))
+			}
+		}
+		if _, exists := seqs[key]; !exists {
+			seqs[key] = make(map[int]string)
+		}
+		seqs[key][seq] = column
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]string)
+	for key, columns := range seqs {
+		for i := 1; i <= len(columns); i++ {
+			result[key] = append(result[key], columns[i])
+		}
+	}
+
+	return result, nil
+}
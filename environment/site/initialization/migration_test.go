@@ -0,0 +1,30 @@
+package initialization
+
+import "testing"
+
+func TestRegisterMigrations(t *testing.T) {
+	RegisterMigrations("test_register", "base", &Migration{Version: 3})
+	RegisterMigrations("test_register", "base", &Migration{Version: 1}, &Migration{Version: 2})
+
+	mm := migrations["test_register"]
+	if len(mm.migrations) != 3 {
+		t.Fatalf("registered %d migrations, expect 3", len(mm.migrations))
+	}
+	for i, m := range mm.migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d version %d, expect ascending", i, m.Version)
+		}
+	}
+
+	expectPanic := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: expect panic", name)
+			}
+		}()
+		f()
+	}
+	expectPanic("duplicate version", func() { RegisterMigrations("test_register", "base", &Migration{Version: 2}) })
+	expectPanic("base table mismatch", func() { RegisterMigrations("test_register", "other", &Migration{Version: 4}) })
+	expectPanic("invalid version", func() { RegisterMigrations("test_register", "base", &Migration{Version: 0}) })
+}
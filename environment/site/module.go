@@ -162,12 +162,6 @@ func GetSiteModuleList(siteID string, moduleID ...string) ([]*Module, error) {
 	whereStmts := make([]string, 0)
 	values := make([]interface{}, 0)
 
-	joinAuth, joinWhere, joinValues := JoinSiteModuleAuth(siteID, "module", "id")
-	SQL += joinAuth
-
-	whereStmts = append(whereStmts, joinWhere...)
-	values = append(values, joinValues...)
-
 	if len(moduleID) > 0 {
 		placeHolder := make([]string, 0)
 		for _, id := range moduleID {
@@ -201,5 +195,5 @@ func GetSiteModuleList(siteID string, moduleID ...string) ([]*Module, error) {
 		result = append(result, &m)
 	}
 
-	return result, nil
+	return FilterSiteModuleAuth(siteID, result)
 }
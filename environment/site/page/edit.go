@@ -26,7 +26,7 @@ func SavePageComponents(siteID, pageID string, components []*PageComponent) (e e
 		return err
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		existsComps, err := getPageComponents(siteID, txn, true, pageID, STATUS_EDIT)
 		if err != nil {
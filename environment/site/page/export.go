@@ -63,7 +63,7 @@ func writeFile(data []byte, folder, filename string) error {
 
 func ExportSite(siteID string) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		editingPages, err := getPages(siteID, txn, true, STATUS_EDIT)
 		if err != nil {
 			panic(err)
@@ -152,7 +152,7 @@ func (m *Model) add(siteID string, txn *sql.Tx) error {
 	values := []interface{}{m.ID, m.ModuleID, m.Name, m.Type, m.Description, m.HTMLTemplatePath, m.CSSTemplatePath, m.JSTemplatePath, string(paramBytes)}
 
 	if txn == nil {
-		_, err = datasource.GetConn().Exec(SQL, values...)
+		_, err = datasource.GetSiteConn(siteID).Exec(SQL, values...)
 	} else {
 		_, err = txn.Exec(SQL, values...)
 	}
@@ -204,7 +204,7 @@ func (m *Model) AddAssembleModel(siteID string, actionAuth authority.ActionAuthS
 		m.JSTemplatePath = ""
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := m.add(siteID, txn); err != nil {
 			log.Println("error insert module model: ", err)
 			panic(err)
@@ -274,7 +274,7 @@ func (m *Model) update(siteID string, txn *sql.Tx) error {
 
 	var err error
 	if txn == nil {
-		_, err = datasource.GetConn().Exec(SQL, values...)
+		_, err = datasource.GetSiteConn(siteID).Exec(SQL, values...)
 	} else {
 		_, err = txn.Exec(SQL, values...)
 	}
@@ -330,7 +330,7 @@ func (m *Model) UpdateAssembleModel(siteID string, actionAuth authority.ActionAu
 
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		if err := m.update(siteID, txn); err != nil {
 			panic(err)
@@ -362,7 +362,7 @@ func (m *Model) UpdateAssembleModel(siteID string, actionAuth authority.ActionAu
 
 func (m *Model) Delete(siteID string, actionAuth authority.ActionAuthSet) error {
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		models, err := getModelsWithTxn(siteID, txn, true, m.ID)
 		if err != nil {
@@ -511,7 +511,7 @@ func GetSiteModels(siteID string, modelIDs ...string) (map[string]*Model, error)
 			%s
 	`, modelColumns, modelTable(siteID), join, strings.Join(whereStmts, " AND "))
 
-	rows, err := datasource.GetConn().Query(sql, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(sql, values...)
 	if err != nil {
 		log.Println("error get models: ", err)
 		return nil, err
@@ -678,7 +678,7 @@ func GetSiteModelList(siteID string, cids []int, modelType, moduleID, q string,
 		countSQL += "\nWHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+	if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 		log.Println("error count models: ", countSQL, values, err)
 		return nil, 0, err
 	}
@@ -698,7 +698,7 @@ func GetSiteModelList(siteID string, cids []int, modelType, moduleID, q string,
 		SQL += "\nLIMIT ?,?"
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get models: ", SQL, values, err)
 		return nil, 0, err
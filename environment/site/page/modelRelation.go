@@ -59,7 +59,7 @@ func (m *ModelRelation) Add(siteID string, actionAuth authority.ActionAuthSet) e
 		return e_need_param
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		models, err := getModelsWithTxn(siteID, txn, true, m.ParentModelID, m.ChildModelID)
 		if err != nil {
@@ -101,7 +101,7 @@ func (m *ModelRelation) Update(siteID string, actionAuth authority.ActionAuthSet
 		return e_need_param
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		models, err := getModelsWithTxn(siteID, txn, true, m.ParentModelID, m.ChildModelID)
 		if err != nil {
@@ -135,7 +135,7 @@ func (m *ModelRelation) Update(siteID string, actionAuth authority.ActionAuthSet
 }
 
 func (m *ModelRelation) Delete(siteID string, actionAuth authority.ActionAuthSet) error {
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		if err := m.delete(siteID, txn); err != nil {
 			panic(err)
 		}
@@ -229,7 +229,7 @@ func getModelRelations(siteID string, txn *sql.Tx, forUpdate bool, parentModelID
 	var err error
 
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		if forUpdate {
 			SQL += "\nFOR UPDATE"
@@ -303,7 +303,7 @@ func GetChildModels(siteID string, parentModelID string, cids []int, modelType,
 		countSQL += "\nWHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+	if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 		log.Println("error count child models: ", err)
 		return nil, nil, 0, err
 	}
@@ -322,7 +322,7 @@ func GetChildModels(siteID string, parentModelID string, cids []int, modelType,
 		values = append(values, (pageNo-1)*pageSize, pageSize)
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get child models: ", err)
 		return nil, nil, 0, err
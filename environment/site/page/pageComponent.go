@@ -104,7 +104,7 @@ func getPages(siteID string, txn *sql.Tx, forUpdate bool, status string) ([]temp
 	}
 
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		if forUpdate {
 			SQL += "\nFOR UPDATE"
@@ -161,7 +161,7 @@ func getPageComponents(siteID string, txn *sql.Tx, forUpdate bool, pageID, statu
 	}
 
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(SQL, values...)
+		rows, err = datasource.GetSiteConn(siteID).Query(SQL, values...)
 	} else {
 		if forUpdate {
 			SQL += "\nFOR UPDATE"
@@ -21,6 +21,7 @@ import (
 
 func init() {
 	initialization.Register(MODULE_SITE, []string{"site_module"})
+	datasource.RegisterSiteFamily(getSiteFamily)
 }
 
 const TYPE_PUBLIC = "PUBLIC"
@@ -181,6 +182,69 @@ func GetSiteSeries(siteID, status, siteType, geoType string) ([]*Site, error) {
 	return result, nil
 }
 
+//下级站点联表查询上级站点的表 分库迁移时上至顶级站点下至全部下级站点一并迁移
+func getSiteFamily(siteID string) ([]string, error) {
+	root := siteID
+	visited := map[string]bool{root: true}
+	for {
+		s, err := GetSite(root)
+		if err != nil {
+			return nil, err
+		}
+		if s.ParentSite == "" || visited[s.ParentSite] {
+			break
+		}
+		root = s.ParentSite
+		visited[root] = true
+	}
+
+	result := []string{root}
+	included := map[string]bool{root: true}
+
+	for parents := []string{root}; len(parents) > 0; {
+		placeholder := make([]string, 0)
+		values := make([]interface{}, 0)
+		for _, p := range parents {
+			placeholder = append(placeholder, "?")
+			values = append(values, p)
+		}
+
+		rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+			SELECT
+				id
+			FROM
+				c_site
+			WHERE
+				parent_site IN (%s)
+		`, strings.Join(placeholder, ",")), values...)
+		if err != nil {
+			log.Println("error get site family: ", err)
+			return nil, err
+		}
+
+		parents = make([]string, 0)
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !included[id] {
+				included[id] = true
+				result = append(result, id)
+				parents = append(parents, id)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
 func MySites(uid int, geoType string) ([]*Site, error) {
 	result := make([]*Site, 0)
 
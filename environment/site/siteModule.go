@@ -105,7 +105,7 @@ func GetSiteModules(siteID, moduleID, prefix string) ([]*SiteModule, error) {
 		SQL += "\nWHERE " + strings.Join(whereStmts, " AND ")
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -146,6 +146,7 @@ func GetSiteModule(siteID, moduleID string, flags ...bool) (string, *SiteModule,
 		}
 	}
 
+	//返回上级站点的模块 调用方会在本站点所在库联表查询 上下级站点迁移时一并迁移以保证同库
 	if len(flags) >= 1 && flags[0] {
 		thisSite, err := GetSite(siteID)
 		if err != nil {
@@ -193,7 +194,7 @@ func fetchSiteModuleFromCache(siteID, moduleID string) *SiteModule {
 
 func fetchSiteModuleFromDatasource(siteID, moduleID string) (*SiteModule, error) {
 
-	rows, err := datasource.GetConn().Query(fmt.Sprintf(`
+	rows, err := datasource.GetSiteConn(siteID).Query(fmt.Sprintf(`
 		SELECT
 			%s
 		FROM
@@ -321,3 +322,33 @@ func JoinSiteModuleAuth(siteID, joinTable, joinColumn string) (string, []string,
 
 	return joinSQL, joinWhere, joinValues
 }
+
+//模块表位于主库 站点表可能位于分库 无法联表时取出站点模块后按与JoinSiteModuleAuth相同的条件过滤
+func FilterSiteModuleAuth(siteID string, modules []*Module) ([]*Module, error) {
+	siteModules, err := GetSiteModules(siteID, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return filterSiteModuleAuth(modules, siteModules), nil
+}
+
+//站点已开通的模块需为激活状态 未开通的仅保留通配模块
+func filterSiteModuleAuth(modules []*Module, siteModules []*SiteModule) []*Module {
+	status := make(map[string]string)
+	for _, sm := range siteModules {
+		status[sm.ModuleID] = sm.Status
+	}
+
+	result := make([]*Module, 0)
+	for _, m := range modules {
+		if s, exists := status[m.ModuleID]; exists {
+			if s == STATUS_ACTIVE {
+				result = append(result, m)
+			}
+		} else if m.ModuleID == "*" {
+			result = append(result, m)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,34 @@
+package site
+
+import "testing"
+
+func TestFilterSiteModuleAuth(t *testing.T) {
+	modules := []*Module{
+		{ModuleID: "*"},
+		{ModuleID: "active"},
+		{ModuleID: "inactive"},
+		{ModuleID: "unopened"},
+	}
+	siteModules := []*SiteModule{
+		{ModuleID: "active", Status: STATUS_ACTIVE},
+		{ModuleID: "inactive", Status: STATUS_INACTIVE},
+	}
+
+	result := filterSiteModuleAuth(modules, siteModules)
+
+	expect := []string{"*", "active"}
+	if len(result) != len(expect) {
+		t.Fatalf("filtered %d modules, expect %d", len(result), len(expect))
+	}
+	for i, m := range result {
+		if m.ModuleID != expect[i] {
+			t.Errorf("module %d: %s, expect %s", i, m.ModuleID, expect[i])
+		}
+	}
+
+	//站点关闭通配模块时不再保留
+	result = filterSiteModuleAuth(modules, []*SiteModule{{ModuleID: "*", Status: STATUS_INACTIVE}})
+	if len(result) != 0 {
+		t.Errorf("filtered %d modules with wildcard inactive, expect 0", len(result))
+	}
+}
@@ -107,7 +107,7 @@ func IsLogined(siteID, clientIP, token, referer string) (int, string) {
 func Create(siteID, requestID string, toCreate *user.User) error {
 	auths := engine.GetAuthMethod(siteID)
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		if err := toCreate.Add(siteID, txn); err != nil {
 			panic(err)
@@ -149,7 +149,7 @@ func UpdateInfo(siteID, requestID string, actionAuth authority.ActionAuthSet, to
 		}
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 
 		exists, err := user.GetUserWithTxn(siteID, txn, "id", toUpdate.UserID, true)
 		if err != nil {
@@ -220,7 +220,7 @@ func Register(siteID, requestID, clientIP, method string, data []byte) (string,
 
 	if MockConfig.MockRegisterUserID > 0 {
 
-		if err := datasource.Txn(func(txn *sql.Tx) {
+		if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 			registered, err = user.GetUserWithTxn(siteID, txn, "id", MockConfig.MockRegisterUserID, false)
 			if err != nil {
 				panic(err)
@@ -249,7 +249,7 @@ func Register(siteID, requestID, clientIP, method string, data []byte) (string,
 		return "", nil, err
 	}
 
-	if err := datasource.Txn(func(txn *sql.Tx) {
+	if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		u, ret, err := auth.Register(siteID, requestID, txn, data)
 		result = ret
 		if err != nil {
@@ -309,7 +309,7 @@ func Login(siteID, requestID, clientIP, method string, data []byte) (string, map
 	var result map[string]interface{}
 
 	if MockConfig.MockLoginUserID > 0 {
-		if err := datasource.Txn(func(txn *sql.Tx) {
+		if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 			logined, err = user.GetUserWithTxn(siteID, txn, "id", MockConfig.MockLoginUserID, false)
 			if err != nil {
 				log.Println("error get user:", err)
@@ -338,7 +338,7 @@ func Login(siteID, requestID, clientIP, method string, data []byte) (string, map
 		return "", nil, err
 	}
 
-	if err := datasource.Txn(func(txn *sql.Tx) {
+	if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		u, ret, err := auth.Login(siteID, requestID, txn, data)
 		result = ret
 		if err != nil {
@@ -406,7 +406,7 @@ func Bind(siteID, method string, userID int, data []byte) (map[string]interface{
 
 	var result map[string]interface{}
 
-	if err := datasource.Txn(func(txn *sql.Tx) {
+	if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		auth, err := engine.GetAuth(siteID, method)
 		if err != nil {
 			panic(err)
@@ -440,7 +440,7 @@ func UnBind(siteID, method string, userID int, data []byte) (map[string]interfac
 
 	var result map[string]interface{}
 
-	if err := datasource.Txn(func(txn *sql.Tx) {
+	if err := datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		auth, err := engine.GetAuth(siteID, method)
 		if err != nil {
 			panic(err)
@@ -90,7 +90,7 @@ func SaveAuth(siteID, method string, paramBytes []byte) error {
 		return err
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, nameResitry[method], true)
 		if err != nil {
 			panic(err)
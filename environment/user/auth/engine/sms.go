@@ -238,7 +238,7 @@ func sendSMSCode(siteID, siteName, sign, mobile string) error {
 	param["name"] = siteName
 	param["code"] = code
 
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		stmt, err := txn.Prepare(`
 			INSERT INTO ` + siteID + `_sms_code
 				(mobile, code, status)
@@ -268,7 +268,7 @@ func verifySMSCode(siteID, mobile, code string) error {
 		return nil
 	}
 
-	if ret, err := datasource.GetConn().Exec(`
+	if ret, err := datasource.GetSiteConn(siteID).Exec(`
 		UPDATE
 			`+siteID+`_sms_code
 		SET
@@ -79,7 +79,7 @@ func GetUserModuleWithTxn(siteID string, txn *sql.Tx, forUpdate bool) (*UserModu
 }
 
 func (m *UserModule) Save(siteID string) error {
-	return datasource.Txn(func(txn *sql.Tx) {
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
 		sm, err := site.GetSiteModuleWithTxn(siteID, txn, MODULE_USER, true)
 		if err != nil {
 			panic(err)
@@ -204,7 +204,7 @@ func GetUserInfo(siteID string, uid ...int) ([]*UserInfo, error) {
 			%s
 	`, userInfoColumns, table, strings.Join(whereStmts, " AND "))
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -259,7 +259,7 @@ func GetUserBrief(siteID string, uid ...int) ([]*UserBrief, error) {
 			%s
 	`, userBriefColumns, table, strings.Join(whereStmts, " AND "))
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		return nil, err
 	}
@@ -360,7 +360,7 @@ func getUsers(siteID, match, q, status string, pageNo, pageSize int, orderBy, jo
 	}
 
 	var total int
-	if err := datasource.GetConn().QueryRow(countSQL, values...).Scan(&total); err != nil {
+	if err := datasource.GetSiteConn(siteID).QueryRow(countSQL, values...).Scan(&total); err != nil {
 		log.Println("error count user: ", countSQL, values, err)
 		return nil, -1, err
 	}
@@ -382,7 +382,7 @@ func getUsers(siteID, match, q, status string, pageNo, pageSize int, orderBy, jo
 		values = append(values, (pageNo-1)*pageSize, pageSize)
 	}
 
-	rows, err := datasource.GetConn().Query(SQL, values...)
+	rows, err := datasource.GetSiteConn(siteID).Query(SQL, values...)
 	if err != nil {
 		log.Println("error get user: ", SQL, values, err)
 		return nil, -1, err
@@ -436,7 +436,7 @@ func GetUserWithTxn(siteID string, txn *sql.Tx, uniqueColumn string, uniqueValue
 	log.Println("get unique user: ", sql, uniqueValue)
 
 	if txn == nil {
-		rows, err = datasource.GetConn().Query(sql, uniqueValue, USER_DELETE)
+		rows, err = datasource.GetSiteConn(siteID).Query(sql, uniqueValue, USER_DELETE)
 	} else {
 		rows, err = txn.Query(sql, uniqueValue, USER_DELETE)
 	}
@@ -506,7 +506,7 @@ func (user *User) Add(siteID string, txn *sql.Tx) error {
 	if txn != nil {
 		ret, err = txn.Exec(SQL, values...)
 	} else {
-		ret, err = datasource.GetConn().Exec(SQL, values...)
+		ret, err = datasource.GetSiteConn(siteID).Exec(SQL, values...)
 	}
 
 	if err != nil {
@@ -528,7 +528,7 @@ func (user *User) Activate(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -549,7 +549,7 @@ func (user *User) Deactivate(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -613,7 +613,7 @@ func (user *User) Update(siteID string, txn *sql.Tx) error {
 	if txn != nil {
 		ret, err = txn.Exec(SQL, values...)
 	} else {
-		ret, err = datasource.GetConn().Exec(SQL, values...)
+		ret, err = datasource.GetSiteConn(siteID).Exec(SQL, values...)
 	}
 
 	if err != nil {
@@ -636,7 +636,7 @@ func (user *User) UpdateLoginTime(siteID string) error {
 		return err
 	}
 
-	if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 		UPDATE
 			%s
 		SET
@@ -658,8 +658,8 @@ func (user *User) Delete(siteID string) error {
 		return err
 	}
 
-	return datasource.Txn(func(txn *sql.Tx) {
-		if _, err := datasource.GetConn().Exec(fmt.Sprintf(`
+	return datasource.SiteTxn(siteID, func(txn *sql.Tx) {
+		if _, err := datasource.GetSiteConn(siteID).Exec(fmt.Sprintf(`
 			UPDATE
 				%s
 			SET